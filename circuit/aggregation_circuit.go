@@ -0,0 +1,355 @@
+package circuit
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	fr_poseidon "github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/schema"
+	"github.com/consensys/gnark/std/algebra/emulated/sw_bn254"
+	"github.com/consensys/gnark/std/commitments/pedersen"
+	"github.com/consensys/gnark/std/hash/poseidon"
+	"github.com/consensys/gnark/std/math/emulated"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+// 递归验证BN254上groth16证明所使用的类型
+type (
+	RecursiveProof        = stdgroth16.Proof[sw_bn254.G1Affine, sw_bn254.G2Affine]
+	RecursiveVerifyingKey = stdgroth16.VerifyingKey[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl]
+	RecursiveWitness      = stdgroth16.Witness[sw_bn254.ScalarField]
+)
+
+var tVariable = reflect.TypeOf((*frontend.Variable)(nil)).Elem()
+
+// AggregatedProof 聚合电路中被递归验证的单个证明
+// 被验证的证明可以是批次证明, 也可以是下一层的聚合证明, 两者的公开输入都是
+// Poseidon(操作前账户树根, 操作后账户树根, 操作前CEX资产承诺, 操作后CEX资产承诺, 审计轮次编号, 快照时间戳),
+// 审计轮次编号和快照时间戳同时作为公开输入, 必须与聚合证明的一致
+type AggregatedProof struct {
+	BeforeAccountTreeRoot     Variable              // 操作前的账户树根
+	AfterAccountTreeRoot      Variable              // 操作后的账户树根
+	BeforeCEXAssetsCommitment Variable              // CEX资产承诺(操作前)
+	AfterCEXAssetsCommitment  Variable              // CEX资产承诺(操作后)
+	VerifyingKey              RecursiveVerifyingKey // 生成该证明的验证密钥, 必须属于允许的验证密钥集合
+	Proof                     RecursiveProof        // 被验证的证明
+	Witness                   RecursiveWitness      // 被验证证明的公开输入
+}
+
+// AggregationCircuit 递归聚合多个证明的电路
+// 电路验证每个证明, 并验证相邻证明之间账户树根和CEX资产承诺的衔接,
+// 公开输入与批次电路的格式相同, 因此聚合证明可以被上一层聚合电路继续验证
+type AggregationCircuit struct {
+	// 公开输入
+	AggregatedCommitment Variable `gnark:",public"` // 聚合承诺(公开输入)
+	RoundId              Variable `gnark:",public"` // 审计轮次编号(公开输入), 所有被聚合的证明必须属于同一轮
+	Timestamp            Variable `gnark:",public"` // 审计快照的时间戳(公开输入)
+	// 私有输入
+	BeforeAccountTreeRoot     Variable          // 第一个证明操作前的账户树根
+	AfterAccountTreeRoot      Variable          // 最后一个证明操作后的账户树根
+	BeforeCEXAssetsCommitment Variable          // 第一个证明操作前的CEX资产承诺
+	AfterCEXAssetsCommitment  Variable          // 最后一个证明操作后的CEX资产承诺
+	ProofsCount               Variable          // 实际聚合的证明数量, 其余位置为填充
+	Proofs                    []AggregatedProof // 被聚合的证明列表
+
+	allowedKeyDigests []*big.Int `gnark:"-"` // 允许的验证密钥摘要
+}
+
+// AggregationInput 生成聚合电路见证数据所需的单个证明信息
+type AggregationInput struct {
+	BeforeAccountTreeRoot     []byte               // 操作前的账户树根
+	AfterAccountTreeRoot      []byte               // 操作后的账户树根
+	BeforeCEXAssetsCommitment []byte               // CEX资产承诺(操作前)
+	AfterCEXAssetsCommitment  []byte               // CEX资产承诺(操作后)
+	Commitment                []byte               // 证明的承诺
+	RoundId                   uint64               // 审计轮次编号
+	Timestamp                 uint64               // 审计快照的时间戳
+	VerifyingKey              groth16.VerifyingKey // 验证密钥
+	Proof                     groth16.Proof        // 证明
+}
+
+// NewVerifyAggregationCircuit 创建新的验证电路实例
+func NewVerifyAggregationCircuit(commitment []byte, roundId uint64, timestamp uint64) *AggregationCircuit {
+	var v AggregationCircuit
+	v.AggregatedCommitment = commitment
+	v.RoundId = roundId
+	v.Timestamp = timestamp
+	return &v
+}
+
+// NewAggregationCircuit 创建新的聚合电路实例
+// 参数:
+//   - innerVks: 允许的被聚合证明验证密钥(例如每个资产层级的批次电路验证密钥),
+//     所有验证密钥的公开输入数量和承诺数量必须相同
+//   - proofsCount: 每个聚合证明最多聚合的证明数量
+func NewAggregationCircuit(innerVks []groth16.VerifyingKey, proofsCount int) (*AggregationCircuit, error) {
+	if len(innerVks) == 0 {
+		return nil, errors.New("at least one inner verifying key is required")
+	}
+	if proofsCount <= 0 {
+		return nil, errors.New("proofs count should be positive")
+	}
+	placeholder, err := placeholderAggregatedProof(innerVks[0])
+	if err != nil {
+		return nil, err
+	}
+	var circuit AggregationCircuit
+	circuit.AggregatedCommitment = 0
+	circuit.RoundId = 0
+	circuit.Timestamp = 0
+	circuit.BeforeAccountTreeRoot = 0
+	circuit.AfterAccountTreeRoot = 0
+	circuit.BeforeCEXAssetsCommitment = 0
+	circuit.AfterCEXAssetsCommitment = 0
+	circuit.ProofsCount = 0
+	circuit.Proofs = make([]AggregatedProof, proofsCount)
+	for i := 0; i < proofsCount; i++ {
+		circuit.Proofs[i], _ = placeholderAggregatedProof(innerVks[0])
+	}
+	circuit.allowedKeyDigests = make([]*big.Int, len(innerVks))
+	for i, vk := range innerVks {
+		p, err := placeholderAggregatedProof(vk)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.VerifyingKey.G1.K) != len(placeholder.VerifyingKey.G1.K) ||
+			!reflect.DeepEqual(p.VerifyingKey.PublicAndCommitmentCommitted, placeholder.VerifyingKey.PublicAndCommitmentCommitted) {
+			return nil, fmt.Errorf("inner verifying key %d has different shape", i)
+		}
+		digest, err := VerifyingKeyDigest(vk)
+		if err != nil {
+			return nil, err
+		}
+		circuit.allowedKeyDigests[i] = new(big.Int).SetBytes(digest)
+	}
+	return &circuit, nil
+}
+
+// placeholderAggregatedProof 根据验证密钥的结构创建用于编译的空证明
+func placeholderAggregatedProof(vk groth16.VerifyingKey) (AggregatedProof, error) {
+	tVk, ok := vk.(*groth16_bn254.VerifyingKey)
+	if !ok {
+		return AggregatedProof{}, fmt.Errorf("expected bn254 verifying key, got %T", vk)
+	}
+	nbCommitments := len(tVk.CommitmentKeys)
+	if len(tVk.G1.K) < nbCommitments+1 {
+		return AggregatedProof{}, errors.New("invalid verifying key")
+	}
+	var p AggregatedProof
+	p.BeforeAccountTreeRoot = 0
+	p.AfterAccountTreeRoot = 0
+	p.BeforeCEXAssetsCommitment = 0
+	p.AfterCEXAssetsCommitment = 0
+	p.VerifyingKey.G1.K = make([]sw_bn254.G1Affine, len(tVk.G1.K))
+	p.VerifyingKey.CommitmentKeys = make([]pedersen.VerifyingKey[sw_bn254.G2Affine], nbCommitments)
+	p.VerifyingKey.PublicAndCommitmentCommitted = tVk.PublicAndCommitmentCommitted
+	p.Proof.Commitments = make([]pedersen.Commitment[sw_bn254.G1Affine], nbCommitments)
+	p.Witness.Public = make([]emulated.Element[sw_bn254.ScalarField], len(tVk.G1.K)-nbCommitments-1)
+	return p, nil
+}
+
+// Define 实现聚合电路的约束逻辑
+// 主要验证步骤:
+// 1. 聚合承诺验证
+// 2. 每个证明的公开输入与衔接数据一致
+// 3. 每个证明的验证密钥属于允许的集合
+// 4. 递归验证每个证明
+// 5. 相邻证明的账户树根和CEX资产承诺衔接
+func (b AggregationCircuit) Define(api API) error {
+	if len(b.Proofs) == 0 {
+		return errors.New("aggregation circuit has no proof")
+	}
+	if len(b.allowedKeyDigests) == 0 {
+		return errors.New("aggregation circuit has no allowed verifying key")
+	}
+	verifier, err := stdgroth16.NewVerifier[sw_bn254.ScalarField, sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](api)
+	if err != nil {
+		return fmt.Errorf("new verifier: %w", err)
+	}
+	scalarApi, err := emulated.NewField[sw_bn254.ScalarField](api)
+	if err != nil {
+		return fmt.Errorf("new scalar field: %w", err)
+	}
+
+	// 第1步: 验证聚合承诺
+	actualAggregatedCommitment := poseidon.Poseidon(api,
+		b.BeforeAccountTreeRoot,
+		b.AfterAccountTreeRoot,
+		b.BeforeCEXAssetsCommitment,
+		b.AfterCEXAssetsCommitment,
+		b.RoundId,
+		b.Timestamp)
+	api.AssertIsEqual(b.AggregatedCommitment, actualAggregatedCommitment)
+	roundId := scalarApi.FromBits(api.ToBinary(b.RoundId)...)
+	timestamp := scalarApi.FromBits(api.ToBinary(b.Timestamp)...)
+
+	// 聚合的证明数量范围为[1, len(Proofs)]
+	api.AssertIsDifferent(b.ProofsCount, 0)
+	api.AssertIsLessOrEqual(b.ProofsCount, len(b.Proofs))
+
+	api.AssertIsEqual(b.Proofs[0].BeforeAccountTreeRoot, b.BeforeAccountTreeRoot)
+	api.AssertIsEqual(b.Proofs[0].BeforeCEXAssetsCommitment, b.BeforeCEXAssetsCommitment)
+
+	var isActive Variable = 1
+	var afterAccountTreeRoot Variable = 0
+	var afterCEXAssetsCommitment Variable = 0
+	for i := 0; i < len(b.Proofs); i++ {
+		p := b.Proofs[i]
+		if len(p.Witness.Public) != 3 {
+			return fmt.Errorf("invalid public witness size %d of proof %d", len(p.Witness.Public), i)
+		}
+
+		// 第2步: 公开输入由衔接数据计算得到, 且与聚合证明属于同一轮审计
+		commitment := poseidon.Poseidon(api,
+			p.BeforeAccountTreeRoot,
+			p.AfterAccountTreeRoot,
+			p.BeforeCEXAssetsCommitment,
+			p.AfterCEXAssetsCommitment,
+			b.RoundId,
+			b.Timestamp)
+		scalarApi.AssertIsEqual(scalarApi.FromBits(api.ToBinary(commitment)...), &p.Witness.Public[0])
+		scalarApi.AssertIsEqual(roundId, &p.Witness.Public[1])
+		scalarApi.AssertIsEqual(timestamp, &p.Witness.Public[2])
+
+		// 第3步: 验证密钥属于允许的集合
+		keyDigest, err := verifyingKeyDigest(api, &p.VerifyingKey)
+		if err != nil {
+			return err
+		}
+		var product Variable = 1
+		for _, d := range b.allowedKeyDigests {
+			product = api.Mul(product, api.Sub(keyDigest, d))
+		}
+		api.AssertIsEqual(product, 0)
+
+		// 第4步: 递归验证证明
+		err = verifier.AssertProof(p.VerifyingKey, p.Proof, p.Witness)
+		if err != nil {
+			return fmt.Errorf("assert proof %d: %w", i, err)
+		}
+
+		// 第5步: 有效证明之间需要衔接, 填充的证明不参与衔接
+		if i > 0 {
+			isActive = api.Mul(isActive, api.Sub(1, api.IsZero(api.Sub(b.ProofsCount, i))))
+			api.AssertIsEqual(api.Mul(isActive, api.Sub(p.BeforeAccountTreeRoot, b.Proofs[i-1].AfterAccountTreeRoot)), 0)
+			api.AssertIsEqual(api.Mul(isActive, api.Sub(p.BeforeCEXAssetsCommitment, b.Proofs[i-1].AfterCEXAssetsCommitment)), 0)
+		}
+		isLast := api.IsZero(api.Sub(b.ProofsCount, i+1))
+		afterAccountTreeRoot = api.Add(afterAccountTreeRoot, api.Mul(isLast, p.AfterAccountTreeRoot))
+		afterCEXAssetsCommitment = api.Add(afterCEXAssetsCommitment, api.Mul(isLast, p.AfterCEXAssetsCommitment))
+	}
+	api.AssertIsEqual(b.AfterAccountTreeRoot, afterAccountTreeRoot)
+	api.AssertIsEqual(b.AfterCEXAssetsCommitment, afterCEXAssetsCommitment)
+	return nil
+}
+
+// verifyingKeyDigest 在电路中计算验证密钥的Poseidon摘要
+func verifyingKeyDigest(api API, vk *RecursiveVerifyingKey) (Variable, error) {
+	elements := make([]Variable, 0)
+	_, err := schema.Walk(vk, tVariable, func(_ schema.LeafInfo, tValue reflect.Value) error {
+		elements = append(elements, tValue.Interface())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return poseidon.Poseidon(api, elements...), nil
+}
+
+// VerifyingKeyDigest 计算验证密钥的Poseidon摘要, 与电路中的计算方式一致
+func VerifyingKeyDigest(vk groth16.VerifyingKey) ([]byte, error) {
+	circuitVk, err := stdgroth16.ValueOfVerifyingKey[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](vk)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]*fr.Element, 0)
+	_, err = schema.Walk(&circuitVk, tVariable, func(_ schema.LeafInfo, tValue reflect.Value) error {
+		var e fr.Element
+		if _, err := e.SetInterface(tValue.Interface()); err != nil {
+			return err
+		}
+		elements = append(elements, &e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	digest := fr_poseidon.Poseidon(elements...).Bytes()
+	return digest[:], nil
+}
+
+// SetAggregationCircuitWitness 将需要聚合的证明转换为电路格式
+// 不足proofsCount个证明时使用最后一个证明填充
+// 参数:
+//   - inputs: 按批次顺序排列的证明
+//   - proofsCount: 聚合电路中证明的数量
+//
+// 返回:
+//   - witness: 转换后的电路见证数据
+//   - err: 错误信息
+func SetAggregationCircuitWitness(inputs []AggregationInput, proofsCount int) (witness *AggregationCircuit, err error) {
+	if len(inputs) == 0 || len(inputs) > proofsCount {
+		return nil, fmt.Errorf("invalid proofs number %d, it should be in [1, %d]", len(inputs), proofsCount)
+	}
+	first := inputs[0]
+	last := inputs[len(inputs)-1]
+	for i, input := range inputs {
+		if input.RoundId != first.RoundId || input.Timestamp != first.Timestamp {
+			return nil, fmt.Errorf("proof %d belongs to another round", i)
+		}
+	}
+	witness = &AggregationCircuit{
+		AggregatedCommitment: utils.ComputeBatchCommitment(first.BeforeAccountTreeRoot,
+			last.AfterAccountTreeRoot,
+			first.BeforeCEXAssetsCommitment,
+			last.AfterCEXAssetsCommitment,
+			first.RoundId,
+			first.Timestamp),
+		RoundId:                   first.RoundId,
+		Timestamp:                 first.Timestamp,
+		BeforeAccountTreeRoot:     first.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      last.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: first.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  last.AfterCEXAssetsCommitment,
+		ProofsCount:               len(inputs),
+		Proofs:                    make([]AggregatedProof, proofsCount),
+	}
+	for i := 0; i < proofsCount; i++ {
+		input := last
+		if i < len(inputs) {
+			input = inputs[i]
+		}
+		vk, err := stdgroth16.ValueOfVerifyingKey[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](input.VerifyingKey)
+		if err != nil {
+			return nil, err
+		}
+		proof, err := stdgroth16.ValueOfProof[sw_bn254.G1Affine, sw_bn254.G2Affine](input.Proof)
+		if err != nil {
+			return nil, err
+		}
+		witness.Proofs[i] = AggregatedProof{
+			BeforeAccountTreeRoot:     input.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:      input.AfterAccountTreeRoot,
+			BeforeCEXAssetsCommitment: input.BeforeCEXAssetsCommitment,
+			AfterCEXAssetsCommitment:  input.AfterCEXAssetsCommitment,
+			VerifyingKey:              vk,
+			Proof:                     proof,
+			Witness: RecursiveWitness{
+				Public: []emulated.Element[sw_bn254.ScalarField]{
+					emulated.ValueOf[sw_bn254.ScalarField](new(big.Int).SetBytes(input.Commitment)),
+					emulated.ValueOf[sw_bn254.ScalarField](new(big.Int).SetUint64(input.RoundId)),
+					emulated.ValueOf[sw_bn254.ScalarField](new(big.Int).SetUint64(input.Timestamp)),
+				},
+			},
+		}
+	}
+	return witness, nil
+}
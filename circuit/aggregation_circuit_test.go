@@ -0,0 +1,135 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	poseidon2 "github.com/consensys/gnark/std/hash/poseidon"
+	"github.com/consensys/gnark/test"
+)
+
+// innerTransitionCircuit 与批次电路公开输入格式相同的简化电路
+type innerTransitionCircuit struct {
+	Commitment                Variable `gnark:",public"`
+	RoundId                   Variable `gnark:",public"`
+	Timestamp                 Variable `gnark:",public"`
+	BeforeAccountTreeRoot     Variable
+	AfterAccountTreeRoot      Variable
+	BeforeCEXAssetsCommitment Variable
+	AfterCEXAssetsCommitment  Variable
+}
+
+func (c innerTransitionCircuit) Define(api API) error {
+	commitment := poseidon2.Poseidon(api, c.BeforeAccountTreeRoot, c.AfterAccountTreeRoot,
+		c.BeforeCEXAssetsCommitment, c.AfterCEXAssetsCommitment, c.RoundId, c.Timestamp)
+	api.AssertIsEqual(c.Commitment, commitment)
+	return nil
+}
+
+// constructInnerProofs 生成count个首尾衔接的内层证明, 证明均属于roundId轮
+func constructInnerProofs(t *testing.T, count int, roundId uint64) (groth16.VerifyingKey, []AggregationInput) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &innerTransitionCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := make([]AggregationInput, count)
+	for i := 0; i < count; i++ {
+		roots := [][]byte{big.NewInt(int64(i)).Bytes(), big.NewInt(int64(i + 1)).Bytes()}
+		cexCommitments := [][]byte{big.NewInt(int64(100 + i)).Bytes(), big.NewInt(int64(101 + i)).Bytes()}
+		commitment := utils.ComputeBatchCommitment(roots[0], roots[1], cexCommitments[0], cexCommitments[1], roundId, testTimestamp)
+		assignment := &innerTransitionCircuit{
+			Commitment:                commitment,
+			RoundId:                   roundId,
+			Timestamp:                 testTimestamp,
+			BeforeAccountTreeRoot:     roots[0],
+			AfterAccountTreeRoot:      roots[1],
+			BeforeCEXAssetsCommitment: cexCommitments[0],
+			AfterCEXAssetsCommitment:  cexCommitments[1],
+		}
+		w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := groth16.Prove(ccs, pk, w, utils.RecursionProverOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[i] = AggregationInput{
+			BeforeAccountTreeRoot:     roots[0],
+			AfterAccountTreeRoot:      roots[1],
+			BeforeCEXAssetsCommitment: cexCommitments[0],
+			AfterCEXAssetsCommitment:  cexCommitments[1],
+			Commitment:                commitment,
+			RoundId:                   roundId,
+			Timestamp:                 testTimestamp,
+			VerifyingKey:              vk,
+			Proof:                     proof,
+		}
+	}
+	return vk, inputs
+}
+
+func TestAggregationCircuit(t *testing.T) {
+	vk, inputs := constructInnerProofs(t, 2, 1)
+	aggregationCircuit, err := NewAggregationCircuit([]groth16.VerifyingKey{vk}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 1: 两个衔接的证明
+	assignment, err := SetAggregationCircuitWitness(inputs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Errorf("aggregate chained proofs failed: %s\n", err.Error())
+	}
+
+	// case 2: 一个证明, 其余位置填充
+	assignment, err = SetAggregationCircuitWitness(inputs[:1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Errorf("aggregate padded proofs failed: %s\n", err.Error())
+	}
+
+	// case 3: 证明之间没有衔接
+	assignment, err = SetAggregationCircuitWitness([]AggregationInput{inputs[1], inputs[0]}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField())
+	if err == nil {
+		t.Errorf("aggregate unchained proofs should fail\n")
+	}
+
+	// case 4: 证明属于其他轮审计
+	_, otherRoundInputs := constructInnerProofs(t, 1, 2)
+	_, err = SetAggregationCircuitWitness([]AggregationInput{inputs[0], otherRoundInputs[0]}, 2)
+	if err == nil {
+		t.Errorf("aggregate proofs of different rounds should fail\n")
+	}
+	assignment, err = SetAggregationCircuitWitness(inputs[:1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment.RoundId = 2
+	assignment.AggregatedCommitment = utils.ComputeBatchCommitment(inputs[0].BeforeAccountTreeRoot, inputs[0].AfterAccountTreeRoot,
+		inputs[0].BeforeCEXAssetsCommitment, inputs[0].AfterCEXAssetsCommitment, 2, testTimestamp)
+	err = test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField())
+	if err == nil {
+		t.Errorf("aggregate proofs with mismatched round should fail\n")
+	}
+}
@@ -12,6 +12,8 @@ import (
 type BatchCreateUserCircuit struct {
 	// 公开输入
 	BatchCommitment Variable `gnark:",public"` // 批次承诺(公开输入)
+	RoundId         Variable `gnark:",public"` // 审计轮次编号(公开输入)
+	Timestamp       Variable `gnark:",public"` // 审计快照的时间戳(公开输入)
 	// 私有输入
 	BeforeAccountTreeRoot     Variable              // 操作前的账户树根
 	AfterAccountTreeRoot      Variable              // 操作后的账户树根
@@ -22,9 +24,11 @@ type BatchCreateUserCircuit struct {
 }
 
 // NewVerifyBatchCreateUserCircuit 创建新的验证电路实例
-func NewVerifyBatchCreateUserCircuit(commitment []byte) *BatchCreateUserCircuit {
+func NewVerifyBatchCreateUserCircuit(commitment []byte, roundId uint64, timestamp uint64) *BatchCreateUserCircuit {
 	var v BatchCreateUserCircuit
 	v.BatchCommitment = commitment
+	v.RoundId = roundId
+	v.Timestamp = timestamp
 	return &v
 }
 
@@ -32,6 +36,8 @@ func NewVerifyBatchCreateUserCircuit(commitment []byte) *BatchCreateUserCircuit
 func NewBatchCreateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, batchCounts uint32) *BatchCreateUserCircuit {
 	var circuit BatchCreateUserCircuit
 	circuit.BatchCommitment = 0
+	circuit.RoundId = 0
+	circuit.Timestamp = 0
 	circuit.BeforeAccountTreeRoot = 0
 	circuit.AfterAccountTreeRoot = 0
 	circuit.BeforeCEXAssetsCommitment = 0
@@ -49,7 +55,7 @@ func NewBatchCreateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, ba
 			MarginRatios:              make([]TierRatio, utils.TierCount),
 			PortfolioMarginRatios:     make([]TierRatio, utils.TierCount),
 		}
-		for j := uint32(0); j < utils.TierCount; j++ {
+		for j := 0; j < utils.TierCount; j++ {
 			circuit.BeforeCexAssets[i].LoanRatios[j] = TierRatio{
 				BoundaryValue:    0,
 				Ratio:            0,
@@ -76,7 +82,7 @@ func NewBatchCreateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, ba
 			AssetsForUpdateCex:    make([]UserAssetMeta, allAssetCounts),
 			AccountIndex:          0,
 			AccountIdHash:         0,
-			AccountProof:          [utils.AccountTreeDepth]Variable{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			AccountProof:          newEmptyAccountProof(),
 		}
 		for j := uint32(0); j < allAssetCounts; j++ {
 			circuit.CreateUserOps[i].AssetsForUpdateCex[j].Debt = 0
@@ -111,11 +117,14 @@ func NewBatchCreateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, ba
 func (b BatchCreateUserCircuit) Define(api API) error {
 	// 第1步: 验证批次承诺
 	// 使用Poseidon哈希验证批次承诺的正确性
+	// 审计轮次编号和快照时间戳也参与计算, 否则未被约束的公开输入不会被证明绑定
 	actualBatchCommitment := poseidon.Poseidon(api,
 		b.BeforeAccountTreeRoot,     // 操作前账户树根
 		b.AfterAccountTreeRoot,      // 操作后账户树根
 		b.BeforeCEXAssetsCommitment, // 操作前CEX资产承诺
-		b.AfterCEXAssetsCommitment)  // 操作后CEX资产承诺
+		b.AfterCEXAssetsCommitment,  // 操作后CEX资产承诺
+		b.RoundId,                   // 审计轮次编号
+		b.Timestamp)                 // 审计快照的时间戳
 	api.AssertIsEqual(b.BatchCommitment, actualBatchCommitment)
 
 	// 准备CEX资产验证
@@ -372,37 +381,16 @@ func SetBatchCreateUserCircuitWitness(batchWitness *utils.BatchCreateUserWitness
 	// 初始化电路见证数据结构
 	witness = &BatchCreateUserCircuit{
 		BatchCommitment:           batchWitness.BatchCommitment,                                 // 批次承诺
+		RoundId:                   batchWitness.RoundId,                                         // 审计轮次编号
+		Timestamp:                 batchWitness.Timestamp,                                       // 审计快照的时间戳
 		BeforeAccountTreeRoot:     batchWitness.BeforeAccountTreeRoot,                           // 操作前账户树根
 		AfterAccountTreeRoot:      batchWitness.AfterAccountTreeRoot,                            // 操作后账户树根
 		BeforeCEXAssetsCommitment: batchWitness.BeforeCEXAssetsCommitment,                       // CEX资产承诺(前)
 		AfterCEXAssetsCommitment:  batchWitness.AfterCEXAssetsCommitment,                        // CEX资产承诺(后)
-		BeforeCexAssets:           make([]CexAssetInfo, len(batchWitness.BeforeCexAssets)),      // CEX资产列表
+		BeforeCexAssets:           convertCexAssets(batchWitness.BeforeCexAssets),               // CEX资产列表
 		CreateUserOps:             make([]CreateUserOperation, len(batchWitness.CreateUserOps)), // 用户创建操作列表
 	}
 
-	// 转换CEX资产数据
-	for i := 0; i < len(witness.BeforeCexAssets); i++ {
-		// 复制基本资产信息
-		witness.BeforeCexAssets[i].TotalEquity = batchWitness.BeforeCexAssets[i].TotalEquity
-		witness.BeforeCexAssets[i].TotalDebt = batchWitness.BeforeCexAssets[i].TotalDebt
-		witness.BeforeCexAssets[i].BasePrice = batchWitness.BeforeCexAssets[i].BasePrice
-		witness.BeforeCexAssets[i].LoanCollateral = batchWitness.BeforeCexAssets[i].LoanCollateral
-		witness.BeforeCexAssets[i].MarginCollateral = batchWitness.BeforeCexAssets[i].MarginCollateral
-		witness.BeforeCexAssets[i].PortfolioMarginCollateral = batchWitness.BeforeCexAssets[i].PortfolioMarginCollateral
-
-		// 复制抵押品比率配置
-		witness.BeforeCexAssets[i].LoanRatios = make([]TierRatio, len(batchWitness.BeforeCexAssets[i].LoanRatios))
-		copyTierRatios(witness.BeforeCexAssets[i].LoanRatios, batchWitness.BeforeCexAssets[i].LoanRatios[:])
-
-		witness.BeforeCexAssets[i].MarginRatios = make([]TierRatio, len(batchWitness.BeforeCexAssets[i].MarginRatios))
-		copyTierRatios(witness.BeforeCexAssets[i].MarginRatios, batchWitness.BeforeCexAssets[i].MarginRatios[:])
-
-		witness.BeforeCexAssets[i].PortfolioMarginRatios = make([]TierRatio, len(batchWitness.BeforeCexAssets[i].PortfolioMarginRatios))
-		copyTierRatios(witness.BeforeCexAssets[i].PortfolioMarginRatios, batchWitness.BeforeCexAssets[i].PortfolioMarginRatios[:])
-	}
-
-	// 获取CEX资产总数和目标资产数量
-	cexAssetsCount := len(witness.BeforeCexAssets)
 	// 根据第一个用户的非空资产数量确定目标数量
 	// 因为同一批次中所有用户的资产数量相同，其他用户可能是填充账户
 	targetCounts := utils.GetNonEmptyAssetsCountOfUser(batchWitness.CreateUserOps[0].Assets)
@@ -412,89 +400,127 @@ func SetBatchCreateUserCircuitWitness(batchWitness *utils.BatchCreateUserWitness
 		// 复制账户树根
 		witness.CreateUserOps[i].BeforeAccountTreeRoot = batchWitness.CreateUserOps[i].BeforeAccountTreeRoot
 		witness.CreateUserOps[i].AfterAccountTreeRoot = batchWitness.CreateUserOps[i].AfterAccountTreeRoot
-		witness.CreateUserOps[i].AssetsForUpdateCex = make([]UserAssetMeta, cexAssetsCount)
-
-		// 收集现有资产的键
-		existingKeys := make([]int, 0)
-		for j := 0; j < len(batchWitness.CreateUserOps[i].Assets); j++ {
-			u := batchWitness.CreateUserOps[i].Assets[j]
-			// 转换用户资产元数据
-			userAsset := UserAssetMeta{
-				Equity:                    u.Equity,
-				Debt:                      u.Debt,
-				LoanCollateral:            u.Loan,
-				MarginCollateral:          u.Margin,
-				PortfolioMarginCollateral: u.PortfolioMargin,
-			}
-
-			witness.CreateUserOps[i].AssetsForUpdateCex[j] = userAsset
+		witness.CreateUserOps[i].Assets, witness.CreateUserOps[i].AssetsForUpdateCex = convertUserAssets(batchWitness.CreateUserOps[i].Assets, targetCounts, batchWitness.BeforeCexAssets)
 
-			// 收集非空资产的索引
-			if !utils.IsAssetEmpty(&u) {
-				existingKeys = append(existingKeys, int(u.Index))
-			}
+		// 复制账户信息
+		witness.CreateUserOps[i].AccountIdHash = batchWitness.CreateUserOps[i].AccountIdHash
+		witness.CreateUserOps[i].AccountIndex = batchWitness.CreateUserOps[i].AccountIndex
+		witness.CreateUserOps[i].AccountProof, err = convertAccountProof(batchWitness.CreateUserOps[i].AccountProof)
+		if err != nil {
+			return nil, err
 		}
+	}
+	return witness, nil
+}
 
-		// 计算需要填充的资产数量
-		paddingCounts := targetCounts - len(existingKeys)
-		witness.CreateUserOps[i].Assets = make([]UserAssetInfo, targetCounts)
-		currentPaddingCounts := 0
-		currentAssetIndex := 0
-		index := 0
-
-		// 填充资产数组
-		for _, v := range existingKeys {
-			// 在实际资产之间添加填充资产
-			if currentPaddingCounts < paddingCounts {
-				for k := currentAssetIndex; k < v; k++ {
-					currentPaddingCounts += 1
-					// 添加空资产
-					witness.CreateUserOps[i].Assets[index] = UserAssetInfo{
-						AssetIndex:                     uint32(k),
-						LoanCollateralIndex:            0,
-						LoanCollateralFlag:             0,
-						MarginCollateralIndex:          0,
-						MarginCollateralFlag:           0,
-						PortfolioMarginCollateralIndex: 0,
-						PortfolioMarginCollateralFlag:  0,
-					}
-					index += 1
-					if currentPaddingCounts >= paddingCounts {
-						break
-					}
-				}
-			}
+// convertCexAssets 将CEX资产信息转换为电路格式
+func convertCexAssets(cexAssetsInfo []utils.CexAssetInfo) []CexAssetInfo {
+	cexAssets := make([]CexAssetInfo, len(cexAssetsInfo))
+	for i := 0; i < len(cexAssets); i++ {
+		// 复制基本资产信息
+		cexAssets[i].TotalEquity = cexAssetsInfo[i].TotalEquity
+		cexAssets[i].TotalDebt = cexAssetsInfo[i].TotalDebt
+		cexAssets[i].BasePrice = cexAssetsInfo[i].BasePrice
+		cexAssets[i].LoanCollateral = cexAssetsInfo[i].LoanCollateral
+		cexAssets[i].MarginCollateral = cexAssetsInfo[i].MarginCollateral
+		cexAssets[i].PortfolioMarginCollateral = cexAssetsInfo[i].PortfolioMarginCollateral
+
+		// 复制抵押品比率配置
+		cexAssets[i].LoanRatios = make([]TierRatio, len(cexAssetsInfo[i].LoanRatios))
+		copyTierRatios(cexAssets[i].LoanRatios, cexAssetsInfo[i].LoanRatios[:])
 
-			// 添加实际资产
-			var uAssetInfo UserAssetInfo
-			uAssetInfo.AssetIndex = uint32(v)
-			// 计算并设置抵押品信息
-			calcAndSetCollateralInfo(v, &uAssetInfo, &batchWitness.CreateUserOps[i].Assets[v], batchWitness.BeforeCexAssets)
-			witness.CreateUserOps[i].Assets[index] = uAssetInfo
-			index += 1
-			currentAssetIndex = v + 1
+		cexAssets[i].MarginRatios = make([]TierRatio, len(cexAssetsInfo[i].MarginRatios))
+		copyTierRatios(cexAssets[i].MarginRatios, cexAssetsInfo[i].MarginRatios[:])
+
+		cexAssets[i].PortfolioMarginRatios = make([]TierRatio, len(cexAssetsInfo[i].PortfolioMarginRatios))
+		copyTierRatios(cexAssets[i].PortfolioMarginRatios, cexAssetsInfo[i].PortfolioMarginRatios[:])
+	}
+	return cexAssets
+}
+
+// convertUserAssets 将用户资产转换为电路格式
+// 参数:
+//   - assets: 按资产索引展开的用户资产列表
+//   - targetCounts: 用户所属的资产数量分组
+//   - cexAssets: CEX资产信息, 用于计算抵押品分层
+//
+// 返回:
+//   - []UserAssetInfo: 用户资产信息列表, 非空资产之间按资产索引插入填充资产
+//   - []UserAssetMeta: 按CEX资产排列的用户资产元数据
+func convertUserAssets(assets []utils.AccountAsset, targetCounts int, cexAssets []utils.CexAssetInfo) ([]UserAssetInfo, []UserAssetMeta) {
+	assetsForUpdateCex := make([]UserAssetMeta, len(cexAssets))
+
+	// 收集现有资产的键
+	existingKeys := make([]int, 0)
+	for j := 0; j < len(assets); j++ {
+		u := assets[j]
+		// 转换用户资产元数据
+		assetsForUpdateCex[j] = UserAssetMeta{
+			Equity:                    u.Equity,
+			Debt:                      u.Debt,
+			LoanCollateral:            u.Loan,
+			MarginCollateral:          u.Margin,
+			PortfolioMarginCollateral: u.PortfolioMargin,
 		}
 
-		// 填充剩余的空资产
-		for k := index; k < targetCounts; k++ {
-			witness.CreateUserOps[i].Assets[k] = UserAssetInfo{
-				AssetIndex:                     uint32(currentAssetIndex),
-				LoanCollateralIndex:            0,
-				LoanCollateralFlag:             0,
-				MarginCollateralIndex:          0,
-				MarginCollateralFlag:           0,
-				PortfolioMarginCollateralIndex: 0,
-				PortfolioMarginCollateralFlag:  0,
+		// 收集非空资产的索引
+		if !utils.IsAssetEmpty(&u) {
+			existingKeys = append(existingKeys, int(u.Index))
+		}
+	}
+
+	// 计算需要填充的资产数量
+	paddingCounts := targetCounts - len(existingKeys)
+	userAssets := make([]UserAssetInfo, targetCounts)
+	currentPaddingCounts := 0
+	currentAssetIndex := 0
+	index := 0
+
+	// 填充资产数组
+	for _, v := range existingKeys {
+		// 在实际资产之间添加填充资产
+		if currentPaddingCounts < paddingCounts {
+			for k := currentAssetIndex; k < v; k++ {
+				currentPaddingCounts += 1
+				// 添加空资产
+				userAssets[index] = UserAssetInfo{
+					AssetIndex:                     uint32(k),
+					LoanCollateralIndex:            0,
+					LoanCollateralFlag:             0,
+					MarginCollateralIndex:          0,
+					MarginCollateralFlag:           0,
+					PortfolioMarginCollateralIndex: 0,
+					PortfolioMarginCollateralFlag:  0,
+				}
+				index += 1
+				if currentPaddingCounts >= paddingCounts {
+					break
+				}
 			}
-			currentAssetIndex += 1
 		}
 
-		// 复制账户信息
-		witness.CreateUserOps[i].AccountIdHash = batchWitness.CreateUserOps[i].AccountIdHash
-		witness.CreateUserOps[i].AccountIndex = batchWitness.CreateUserOps[i].AccountIndex
-		for j := 0; j < len(witness.CreateUserOps[i].AccountProof); j++ {
-			witness.CreateUserOps[i].AccountProof[j] = batchWitness.CreateUserOps[i].AccountProof[j]
+		// 添加实际资产
+		var uAssetInfo UserAssetInfo
+		uAssetInfo.AssetIndex = uint32(v)
+		// 计算并设置抵押品信息
+		calcAndSetCollateralInfo(v, &uAssetInfo, &assets[v], cexAssets)
+		userAssets[index] = uAssetInfo
+		index += 1
+		currentAssetIndex = v + 1
+	}
+
+	// 填充剩余的空资产
+	for k := index; k < targetCounts; k++ {
+		userAssets[k] = UserAssetInfo{
+			AssetIndex:                     uint32(currentAssetIndex),
+			LoanCollateralIndex:            0,
+			LoanCollateralFlag:             0,
+			MarginCollateralIndex:          0,
+			MarginCollateralFlag:           0,
+			PortfolioMarginCollateralIndex: 0,
+			PortfolioMarginCollateralFlag:  0,
 		}
+		currentAssetIndex += 1
 	}
-	return witness, nil
+	return userAssets, assetsForUpdateCex
 }
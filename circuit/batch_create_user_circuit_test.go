@@ -6,8 +6,6 @@ import (
 
 	// "github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"bytes"
-	"encoding/base64"
-	"encoding/gob"
 	"math/big"
 	"math/rand"
 	"time"
@@ -29,7 +27,12 @@ import (
 	"github.com/consensys/gnark/frontend/cs/scs"
 	poseidon2 "github.com/consensys/gnark/std/hash/poseidon"
 	"github.com/consensys/gnark/test/unsafekzg"
-	"github.com/klauspost/compress/s2"
+)
+
+// 测试批次所属的审计轮次编号和快照时间戳
+const (
+	testRoundId   = 1
+	testTimestamp = 1700000000
 )
 
 // ConstructR1csAndWitness - 构建R1CS约束系统和见证数据
@@ -327,7 +330,10 @@ func ConstructBatchFromFile(fileName string) (witness *BatchCreateUserCircuit) {
 	}
 	witnessData = witnessData[:n]
 
-	witnessForCircuit := utils.DecodeBatchWitness(string(witnessData[:]))
+	witnessForCircuit, err := utils.DecodeBatchWitness(string(witnessData[:]))
+	if err != nil {
+		panic(err.Error())
+	}
 	fmt.Println("ConstructBatchFromFile: witnessForCircuit", witnessForCircuit)
 	circuitWitness, _ := SetBatchCreateUserCircuitWitness(witnessForCircuit)
 	fmt.Println("ConstructBatchFromFile: circuitWitness", circuitWitness)
@@ -350,8 +356,11 @@ func ConstructValidBatch(assetsCount int, totalAssetsCount int, userOpsPerBatch
 	cexAssets := make([]utils.CexAssetInfo, totalAssetsCount)
 	for i := 0; i < totalAssetsCount; i++ {
 		u := utils.CexAssetInfo{
-			BasePrice: 1,
-			Index:     uint32(i),
+			BasePrice:             1,
+			Index:                 uint32(i),
+			LoanRatios:            make([]utils.TierRatio, utils.TierCount),
+			MarginRatios:          make([]utils.TierRatio, utils.TierCount),
+			PortfolioMarginRatios: make([]utils.TierRatio, utils.TierCount),
 		}
 		avgRatio := 100 / utils.TierCount
 		for j := 0; j < utils.TierCount; j++ {
@@ -448,26 +457,28 @@ func ConstructValidBatch(assetsCount int, totalAssetsCount int, userOpsPerBatch
 			AccountIndex:          accounts[i].AccountIndex,
 			AccountIdHash:         accounts[i].AccountId,
 		}
-		copy(batchCreateUserWit.CreateUserOps[i].AccountProof[:], accountProof[:])
+		batchCreateUserWit.CreateUserOps[i].AccountProof = accountProof
 
 	}
 
 	batchCreateUserWit.AfterAccountTreeRoot = accountTree.Root()
 	batchCreateUserWit.AfterCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(cexAssets)
-	batchCreateUserWit.BatchCommitment = poseidon.PoseidonBytes(batchCreateUserWit.BeforeAccountTreeRoot,
+	batchCreateUserWit.RoundId = testRoundId
+	batchCreateUserWit.Timestamp = testTimestamp
+	batchCreateUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchCreateUserWit.BeforeAccountTreeRoot,
 		batchCreateUserWit.AfterAccountTreeRoot,
 		batchCreateUserWit.BeforeCEXAssetsCommitment,
-		batchCreateUserWit.AfterCEXAssetsCommitment)
-	var serializeBuf bytes.Buffer
-	enc := gob.NewEncoder(&serializeBuf)
-	err = enc.Encode(batchCreateUserWit)
+		batchCreateUserWit.AfterCEXAssetsCommitment,
+		batchCreateUserWit.RoundId,
+		batchCreateUserWit.Timestamp)
+	witnessDataStr, err := utils.EncodeBatchWitness(batchCreateUserWit)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessForCircuit, err := utils.DecodeBatchWitness(witnessDataStr)
 	if err != nil {
 		panic(err.Error())
 	}
-	buf := serializeBuf.Bytes()
-	compressedBuf := s2.Encode(nil, buf)
-	witnessDataStr := base64.StdEncoding.EncodeToString(compressedBuf)
-	witnessForCircuit := utils.DecodeBatchWitness(witnessDataStr)
 	circuitWitness, _ := SetBatchCreateUserCircuitWitness(witnessForCircuit)
 	return circuitWitness
 }
@@ -0,0 +1,245 @@
+package circuit
+
+import (
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark/std/hash/poseidon"
+
+	"github.com/consensys/gnark/std/lookup/logderivlookup"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+// BatchDeleteUserCircuit 定义批量删除用户的电路结构
+// 每个操作验证账户叶子节点存在于操作前的账户树中, 然后将其重置为空叶子节点,
+// CEX资产总量减去被删除账户的资产
+type BatchDeleteUserCircuit struct {
+	// 公开输入
+	BatchCommitment Variable `gnark:",public"` // 批次承诺(公开输入)
+	RoundId         Variable `gnark:",public"` // 审计轮次编号(公开输入)
+	Timestamp       Variable `gnark:",public"` // 审计快照的时间戳(公开输入)
+	// 私有输入
+	BeforeAccountTreeRoot     Variable              // 操作前的账户树根
+	AfterAccountTreeRoot      Variable              // 操作后的账户树根
+	BeforeCEXAssetsCommitment Variable              // CEX资产承诺(操作前)
+	AfterCEXAssetsCommitment  Variable              // CEX资产承诺(操作后)
+	BeforeCexAssets           []CexAssetInfo        // CEX资产列表
+	DeleteUserOps             []DeleteUserOperation // 用户删除操作列表
+}
+
+// NewVerifyBatchDeleteUserCircuit 创建新的验证电路实例
+func NewVerifyBatchDeleteUserCircuit(commitment []byte, roundId uint64, timestamp uint64) *BatchDeleteUserCircuit {
+	var v BatchDeleteUserCircuit
+	v.BatchCommitment = commitment
+	v.RoundId = roundId
+	v.Timestamp = timestamp
+	return &v
+}
+
+// NewBatchDeleteUserCircuit 创建新的批量删除用户电路实例
+func NewBatchDeleteUserCircuit(userAssetCounts uint32, allAssetCounts uint32, batchCounts uint32) *BatchDeleteUserCircuit {
+	// 复用批量创建用户电路中CEX资产的占位数据
+	createCircuit := NewBatchCreateUserCircuit(userAssetCounts, allAssetCounts, 1)
+	var circuit BatchDeleteUserCircuit
+	circuit.BatchCommitment = 0
+	circuit.RoundId = 0
+	circuit.Timestamp = 0
+	circuit.BeforeAccountTreeRoot = 0
+	circuit.AfterAccountTreeRoot = 0
+	circuit.BeforeCEXAssetsCommitment = 0
+	circuit.AfterCEXAssetsCommitment = 0
+	circuit.BeforeCexAssets = createCircuit.BeforeCexAssets
+	circuit.DeleteUserOps = make([]DeleteUserOperation, batchCounts)
+	for i := uint32(0); i < batchCounts; i++ {
+		circuit.DeleteUserOps[i] = DeleteUserOperation{
+			BeforeAccountTreeRoot: 0,
+			AfterAccountTreeRoot:  0,
+			TotalEquity:           0,
+			TotalDebt:             0,
+			TotalCollateral:       0,
+			AssetIndexes:          make([]Variable, userAssetCounts),
+			AssetsForUpdateCex:    make([]UserAssetMeta, allAssetCounts),
+			AccountIndex:          0,
+			AccountIdHash:         0,
+			AccountProof:          newEmptyAccountProof(),
+		}
+		for j := uint32(0); j < allAssetCounts; j++ {
+			circuit.DeleteUserOps[i].AssetsForUpdateCex[j] = UserAssetMeta{0, 0, 0, 0, 0}
+		}
+		for j := uint32(0); j < userAssetCounts; j++ {
+			circuit.DeleteUserOps[i].AssetIndexes[j] = j
+		}
+	}
+	return &circuit
+}
+
+// Define 实现批量删除用户的电路约束逻辑
+// 主要验证步骤:
+// 1. 批次承诺验证
+// 2. CEX资产状态验证
+// 3. 账户叶子节点验证, 并从CEX资产中扣除账户的资产
+// 4. Merkle树更新验证: 叶子节点重置为空叶子节点
+// 5. 最终状态验证
+//
+// AccountIdHash为0的操作为填充操作, 其叶子节点必须已经是空叶子节点且资产全为0
+func (b BatchDeleteUserCircuit) Define(api API) error {
+	// 第1步: 验证批次承诺
+	actualBatchCommitment := poseidon.Poseidon(api,
+		b.BeforeAccountTreeRoot,
+		b.AfterAccountTreeRoot,
+		b.BeforeCEXAssetsCommitment,
+		b.AfterCEXAssetsCommitment,
+		b.RoundId,
+		b.Timestamp)
+	api.AssertIsEqual(b.BatchCommitment, actualBatchCommitment)
+
+	countOfCexAsset := getVariableCountOfCexAsset(b.BeforeCexAssets[0])
+	cexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	afterCexAssets := make([]CexAssetInfo, len(b.BeforeCexAssets))
+
+	r := rangecheck.New(api)
+
+	// 第2步: CEX资产状态验证
+	// 删除操作不使用价格和抵押率, 它们原样保留在操作后的CEX资产承诺中
+	for i := 0; i < len(b.BeforeCexAssets); i++ {
+		r.Check(b.BeforeCexAssets[i].TotalEquity, 64)
+		r.Check(b.BeforeCexAssets[i].TotalDebt, 64)
+		r.Check(b.BeforeCexAssets[i].BasePrice, 64)
+		r.Check(b.BeforeCexAssets[i].LoanCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].MarginCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, b.BeforeCexAssets[i], i, cexAssets)
+		afterCexAssets[i] = b.BeforeCexAssets[i]
+	}
+
+	actualCexAssetsCommitment := poseidon.Poseidon(api, cexAssets...)
+	api.AssertIsEqual(b.BeforeCEXAssetsCommitment, actualCexAssetsCommitment)
+
+	api.AssertIsEqual(b.BeforeAccountTreeRoot, b.DeleteUserOps[0].BeforeAccountTreeRoot)
+	api.AssertIsEqual(b.AfterAccountTreeRoot, b.DeleteUserOps[len(b.DeleteUserOps)-1].AfterAccountTreeRoot)
+
+	// 账户的资产索引哈希, 最后一个元素为批次承诺
+	userAssetIdHashes := make([]Variable, len(b.DeleteUserOps)+1)
+	userAssetsQueries := make([][]Variable, len(b.DeleteUserOps))
+	userAssetsResults := make([][]Variable, len(b.DeleteUserOps))
+
+	numOfAssetsFields := 6
+	for i := 0; i < len(b.DeleteUserOps); i++ {
+		accountIndexHelper := accountIdToMerkleHelper(api, b.DeleteUserOps[i].AccountIndex)
+		isPadding := api.IsZero(b.DeleteUserOps[i].AccountIdHash)
+
+		// 第3步: 验证账户叶子节点
+		assetIndexes := b.DeleteUserOps[i].AssetIndexes
+		userAssetsQueries[i], userAssetsResults[i], userAssetIdHashes[i] = lookupUserAssets(api, r, assetIndexes, b.DeleteUserOps[i].AssetsForUpdateCex)
+		flattenAssetFieldsForHash := make([]Variable, len(assetIndexes)*numOfAssetsFields)
+		for j := 0; j < len(assetIndexes); j++ {
+			flattenAssetFieldsForHash[j*numOfAssetsFields] = assetIndexes[j]
+			for k := 0; k < 5; k++ {
+				// 用户资产承诺中每3个字段打包为一个元素, 必须保证每个字段小于64位
+				r.Check(userAssetsResults[i][j*5+k], 64)
+				// 填充操作的资产必须全为0
+				api.AssertIsEqual(api.Mul(isPadding, userAssetsResults[i][j*5+k]), 0)
+				flattenAssetFieldsForHash[j*numOfAssetsFields+1+k] = userAssetsResults[i][j*5+k]
+			}
+		}
+		userAssetsCommitment := computeUserAssetsCommitment(api, flattenAssetFieldsForHash)
+		accountHash := poseidon.Poseidon(api, b.DeleteUserOps[i].AccountIdHash, b.DeleteUserOps[i].TotalEquity,
+			b.DeleteUserOps[i].TotalDebt, b.DeleteUserOps[i].TotalCollateral, userAssetsCommitment)
+		accountHash = api.Select(isPadding, EmptyAccountLeafNodeHash, accountHash)
+		verifyMerkleProof(api, b.DeleteUserOps[i].BeforeAccountTreeRoot, accountHash, b.DeleteUserOps[i].AccountProof[:], accountIndexHelper)
+
+		for j := 0; j < len(b.DeleteUserOps[i].AssetsForUpdateCex); j++ {
+			afterCexAssets[j].TotalEquity = api.Sub(afterCexAssets[j].TotalEquity, b.DeleteUserOps[i].AssetsForUpdateCex[j].Equity)
+			afterCexAssets[j].TotalDebt = api.Sub(afterCexAssets[j].TotalDebt, b.DeleteUserOps[i].AssetsForUpdateCex[j].Debt)
+			afterCexAssets[j].LoanCollateral = api.Sub(afterCexAssets[j].LoanCollateral, b.DeleteUserOps[i].AssetsForUpdateCex[j].LoanCollateral)
+			afterCexAssets[j].MarginCollateral = api.Sub(afterCexAssets[j].MarginCollateral, b.DeleteUserOps[i].AssetsForUpdateCex[j].MarginCollateral)
+			afterCexAssets[j].PortfolioMarginCollateral = api.Sub(afterCexAssets[j].PortfolioMarginCollateral, b.DeleteUserOps[i].AssetsForUpdateCex[j].PortfolioMarginCollateral)
+		}
+
+		// 第4步: 使用同一条Merkle路径将叶子节点重置为空叶子节点
+		actualAccountTreeRoot := updateMerkleProof(api, EmptyAccountLeafNodeHash, b.DeleteUserOps[i].AccountProof[:], accountIndexHelper)
+		api.AssertIsEqual(actualAccountTreeRoot, b.DeleteUserOps[i].AfterAccountTreeRoot)
+	}
+
+	// make sure user assets contain all non-zero assets of AssetsForUpdateCex
+	userAssetIdHashes[len(b.DeleteUserOps)] = b.BatchCommitment
+	randomChallenge := poseidon.Poseidon(api, userAssetIdHashes...)
+	powersOfRandomChallenge := make([]Variable, 5*len(b.BeforeCexAssets))
+	powersOfRandomChallenge[0] = randomChallenge
+	powersOfRandomChallengeLookupTable := logderivlookup.New(api)
+	powersOfRandomChallengeLookupTable.Insert(randomChallenge)
+	for i := 1; i < len(powersOfRandomChallenge); i++ {
+		powersOfRandomChallenge[i] = api.Mul(powersOfRandomChallenge[i-1], randomChallenge)
+		powersOfRandomChallengeLookupTable.Insert(powersOfRandomChallenge[i])
+	}
+
+	for i := 0; i < len(b.DeleteUserOps); i++ {
+		checkUserAssetsByRandomChallenge(api, powersOfRandomChallengeLookupTable, powersOfRandomChallenge,
+			userAssetsQueries[i], userAssetsResults[i], b.DeleteUserOps[i].AssetsForUpdateCex)
+	}
+
+	// 第5步: 验证操作后的CEX资产承诺, 范围检查保证扣除账户资产后没有下溢
+	tempAfterCexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	for j := 0; j < len(b.BeforeCexAssets); j++ {
+		r.Check(afterCexAssets[j].TotalEquity, 64)
+		r.Check(afterCexAssets[j].TotalDebt, 64)
+		r.Check(afterCexAssets[j].LoanCollateral, 64)
+		r.Check(afterCexAssets[j].MarginCollateral, 64)
+		r.Check(afterCexAssets[j].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, afterCexAssets[j], j, tempAfterCexAssets)
+	}
+
+	actualAfterCEXAssetsCommitment := poseidon.Poseidon(api, tempAfterCexAssets...)
+	api.AssertIsEqual(actualAfterCEXAssetsCommitment, b.AfterCEXAssetsCommitment)
+	for i := 0; i < len(b.DeleteUserOps)-1; i++ {
+		api.AssertIsEqual(b.DeleteUserOps[i].AfterAccountTreeRoot, b.DeleteUserOps[i+1].BeforeAccountTreeRoot)
+	}
+	return nil
+}
+
+// SetBatchDeleteUserCircuitWitness 将批量删除用户的见证数据转换为电路格式
+// 参数:
+//   - batchWitness: 批量删除用户的见证数据, 用户资产需按资产索引展开
+//
+// 返回:
+//   - witness: 转换后的电路见证数据
+//   - err: 错误信息
+func SetBatchDeleteUserCircuitWitness(batchWitness *utils.BatchDeleteUserWitness) (witness *BatchDeleteUserCircuit, err error) {
+	witness = &BatchDeleteUserCircuit{
+		BatchCommitment:           batchWitness.BatchCommitment,
+		RoundId:                   batchWitness.RoundId,
+		Timestamp:                 batchWitness.Timestamp,
+		BeforeAccountTreeRoot:     batchWitness.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      batchWitness.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: batchWitness.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  batchWitness.AfterCEXAssetsCommitment,
+		BeforeCexAssets:           convertCexAssets(batchWitness.BeforeCexAssets),
+		DeleteUserOps:             make([]DeleteUserOperation, len(batchWitness.DeleteUserOps)),
+	}
+
+	// 批次中第一个操作一定不是填充操作
+	targetCounts := utils.GetNonEmptyAssetsCountOfUser(batchWitness.DeleteUserOps[0].Assets)
+	for i := 0; i < len(witness.DeleteUserOps); i++ {
+		op := &batchWitness.DeleteUserOps[i]
+		witness.DeleteUserOps[i].BeforeAccountTreeRoot = op.BeforeAccountTreeRoot
+		witness.DeleteUserOps[i].AfterAccountTreeRoot = op.AfterAccountTreeRoot
+		witness.DeleteUserOps[i].TotalEquity = op.TotalEquity
+		witness.DeleteUserOps[i].TotalDebt = op.TotalDebt
+		witness.DeleteUserOps[i].TotalCollateral = op.TotalCollateral
+
+		assets, assetsForUpdateCex := convertUserAssets(op.Assets, targetCounts, batchWitness.BeforeCexAssets)
+		witness.DeleteUserOps[i].AssetIndexes = make([]Variable, len(assets))
+		for j := 0; j < len(assets); j++ {
+			witness.DeleteUserOps[i].AssetIndexes[j] = assets[j].AssetIndex
+		}
+		witness.DeleteUserOps[i].AssetsForUpdateCex = assetsForUpdateCex
+
+		witness.DeleteUserOps[i].AccountIdHash = op.AccountIdHash
+		witness.DeleteUserOps[i].AccountIndex = op.AccountIndex
+		witness.DeleteUserOps[i].AccountProof, err = convertAccountProof(op.AccountProof)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
@@ -0,0 +1,143 @@
+package circuit
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/test"
+)
+
+// ConstructValidDeleteBatch 构建有效的批量删除用户见证数据
+// 先将账户插入账户树, 然后删除前userOpsPerBatch-1个账户, 最后一个操作为填充操作
+func ConstructValidDeleteBatch(assetsCount int, totalAssetsCount int, userOpsPerBatch int) *utils.BatchDeleteUserWitness {
+	accountTree, err := utils.NewAccountTree("memory", "")
+	if err != nil {
+		panic(err.Error())
+	}
+	cexAssets := constructTestCexAssets(totalAssetsCount)
+	gap := totalAssetsCount / assetsCount
+	poseidonHasher := poseidon.NewPoseidon()
+
+	// 插入账户
+	accounts := make([]utils.AccountInfo, userOpsPerBatch)
+	for i := 0; i < len(accounts); i++ {
+		accounts[i] = utils.AccountInfo{
+			AccountIndex: uint32(i * 10),
+			AccountId:    make([]byte, 32),
+		}
+		rand.Read(accounts[i].AccountId)
+		accounts[i].AccountId = new(fr.Element).SetBytes(accounts[i].AccountId).Marshal()
+		constructTestAccountAssets(&accounts[i], assetsCount, gap, cexAssets)
+		err = accountTree.Set(uint64(accounts[i].AccountIndex), utils.AccountInfoToHash(&accounts[i], &poseidonHasher))
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	utils.AccumulateCexAssets(cexAssets, map[int][]utils.AccountInfo{assetsCount: accounts})
+
+	batchDeleteUserWit := &utils.BatchDeleteUserWitness{
+		BeforeAccountTreeRoot: accountTree.Root(),
+		BeforeCexAssets:       make([]utils.CexAssetInfo, totalAssetsCount),
+		DeleteUserOps:         make([]utils.DeleteUserOperation, userOpsPerBatch),
+	}
+	copy(batchDeleteUserWit.BeforeCexAssets, cexAssets)
+	batchDeleteUserWit.BeforeCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(batchDeleteUserWit.BeforeCexAssets)
+
+	// 最后一个账户不删除, 由填充操作代替
+	deletes := utils.PaddingAccountDeletes(accounts[:userOpsPerBatch-1:userOpsPerBatch-1], utils.AssetCountsTiers[0])[:userOpsPerBatch]
+	for i := 0; i < len(deletes); i++ {
+		for _, asset := range deletes[i].Assets {
+			cexAssets[asset.Index].TotalEquity -= asset.Equity
+			cexAssets[asset.Index].TotalDebt -= asset.Debt
+			cexAssets[asset.Index].LoanCollateral -= asset.Loan
+			cexAssets[asset.Index].MarginCollateral -= asset.Margin
+			cexAssets[asset.Index].PortfolioMarginCollateral -= asset.PortfolioMargin
+		}
+
+		accountBeforeRoot := accountTree.Root()
+		accountProof, err := accountTree.GetProof(uint64(deletes[i].AccountIndex))
+		if err != nil {
+			panic(err.Error())
+		}
+		err = accountTree.Set(uint64(deletes[i].AccountIndex), utils.NilAccountHash)
+		if err != nil {
+			panic(err.Error())
+		}
+		batchDeleteUserWit.DeleteUserOps[i] = utils.DeleteUserOperation{
+			BeforeAccountTreeRoot: accountBeforeRoot,
+			AfterAccountTreeRoot:  accountTree.Root(),
+			TotalEquity:           deletes[i].TotalEquity,
+			TotalDebt:             deletes[i].TotalDebt,
+			TotalCollateral:       deletes[i].TotalCollateral,
+			Assets:                deletes[i].Assets,
+			AccountIndex:          deletes[i].AccountIndex,
+			AccountIdHash:         deletes[i].AccountId,
+		}
+		batchDeleteUserWit.DeleteUserOps[i].AccountProof = accountProof
+	}
+
+	batchDeleteUserWit.AfterAccountTreeRoot = accountTree.Root()
+	batchDeleteUserWit.AfterCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(cexAssets)
+	batchDeleteUserWit.RoundId = testRoundId
+	batchDeleteUserWit.Timestamp = testTimestamp
+	batchDeleteUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchDeleteUserWit.BeforeAccountTreeRoot,
+		batchDeleteUserWit.AfterAccountTreeRoot,
+		batchDeleteUserWit.BeforeCEXAssetsCommitment,
+		batchDeleteUserWit.AfterCEXAssetsCommitment,
+		batchDeleteUserWit.RoundId,
+		batchDeleteUserWit.Timestamp)
+
+	// 经过与见证服务相同的序列化和反序列化流程
+	witnessDataStr, err := utils.EncodeBatchWitness(batchDeleteUserWit)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessForCircuit, err := utils.DecodeBatchDeleteWitness(witnessDataStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	return witnessForCircuit
+}
+
+func TestBatchDeleteUserCircuit(t *testing.T) {
+	solver.RegisterHint(IntegerDivision)
+	targetAssetCounts := 30
+	targetCircuitAssetCounts := 50
+	userOpsPerBatch := 3
+	emptyCircuit := NewBatchDeleteUserCircuit(uint32(targetCircuitAssetCounts), uint32(utils.AssetCounts), uint32(userOpsPerBatch))
+
+	// case 1: 有效的删除操作, 包含填充操作
+	batchWitness := ConstructValidDeleteBatch(targetAssetCounts, utils.AssetCounts, userOpsPerBatch)
+	assignment, err := SetBatchDeleteUserCircuitWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignment.DeleteUserOps[0].AssetIndexes) != targetCircuitAssetCounts {
+		t.Fatal("asset counts not match")
+	}
+	err = test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatalf("valid delete batch failed: %s\n", err.Error())
+	}
+
+	// case 2: 被删除账户的资产与账户树中的叶子节点不一致
+	for j := 0; j < len(batchWitness.DeleteUserOps[0].Assets); j++ {
+		if !utils.IsAssetEmpty(&batchWitness.DeleteUserOps[0].Assets[j]) {
+			batchWitness.DeleteUserOps[0].Assets[j].Equity -= 1
+			break
+		}
+	}
+	assignment, err = SetBatchDeleteUserCircuitWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("delete batch with invalid account assets should fail")
+	}
+}
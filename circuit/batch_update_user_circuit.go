@@ -0,0 +1,424 @@
+package circuit
+
+import (
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/poseidon"
+
+	"github.com/consensys/gnark/std/lookup/logderivlookup"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+// BatchUpdateUserCircuit 定义批量更新用户的电路结构
+// 与BatchCreateUserCircuit不同, 每个操作验证旧的账户叶子节点存在于操作前的账户树中,
+// 然后将其更新为新的账户叶子节点, CEX资产总量减去旧账户的资产并加上新账户的资产
+type BatchUpdateUserCircuit struct {
+	// 公开输入
+	BatchCommitment Variable `gnark:",public"` // 批次承诺(公开输入)
+	RoundId         Variable `gnark:",public"` // 审计轮次编号(公开输入)
+	Timestamp       Variable `gnark:",public"` // 审计快照的时间戳(公开输入)
+	// 私有输入
+	BeforeAccountTreeRoot     Variable              // 操作前的账户树根
+	AfterAccountTreeRoot      Variable              // 操作后的账户树根
+	BeforeCEXAssetsCommitment Variable              // CEX资产承诺(操作前)
+	AfterCEXAssetsCommitment  Variable              // CEX资产承诺(操作后)
+	BeforeCexAssets           []CexAssetInfo        // CEX资产列表
+	UpdateUserOps             []UpdateUserOperation // 用户更新操作列表
+}
+
+// NewVerifyBatchUpdateUserCircuit 创建新的验证电路实例
+func NewVerifyBatchUpdateUserCircuit(commitment []byte, roundId uint64, timestamp uint64) *BatchUpdateUserCircuit {
+	var v BatchUpdateUserCircuit
+	v.BatchCommitment = commitment
+	v.RoundId = roundId
+	v.Timestamp = timestamp
+	return &v
+}
+
+// NewBatchUpdateUserCircuit 创建新的批量更新用户电路实例
+// 同一批次中旧账户和新账户属于同一个资产数量分组
+func NewBatchUpdateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, batchCounts uint32) *BatchUpdateUserCircuit {
+	// 复用批量创建用户电路中CEX资产的占位数据
+	createCircuit := NewBatchCreateUserCircuit(userAssetCounts, allAssetCounts, 1)
+	var circuit BatchUpdateUserCircuit
+	circuit.BatchCommitment = 0
+	circuit.RoundId = 0
+	circuit.Timestamp = 0
+	circuit.BeforeAccountTreeRoot = 0
+	circuit.AfterAccountTreeRoot = 0
+	circuit.BeforeCEXAssetsCommitment = 0
+	circuit.AfterCEXAssetsCommitment = 0
+	circuit.BeforeCexAssets = createCircuit.BeforeCexAssets
+	circuit.UpdateUserOps = make([]UpdateUserOperation, batchCounts)
+	for i := uint32(0); i < batchCounts; i++ {
+		circuit.UpdateUserOps[i] = UpdateUserOperation{
+			BeforeAccountTreeRoot: 0,
+			AfterAccountTreeRoot:  0,
+			OldTotalEquity:        0,
+			OldTotalDebt:          0,
+			OldTotalCollateral:    0,
+			OldAssetIndexes:       make([]Variable, userAssetCounts),
+			OldAssetsForUpdateCex: make([]UserAssetMeta, allAssetCounts),
+			Assets:                make([]UserAssetInfo, userAssetCounts),
+			AssetsForUpdateCex:    make([]UserAssetMeta, allAssetCounts),
+			AccountIndex:          0,
+			AccountIdHash:         0,
+			AccountProof:          newEmptyAccountProof(),
+		}
+		for j := uint32(0); j < allAssetCounts; j++ {
+			circuit.UpdateUserOps[i].OldAssetsForUpdateCex[j] = UserAssetMeta{0, 0, 0, 0, 0}
+			circuit.UpdateUserOps[i].AssetsForUpdateCex[j] = UserAssetMeta{0, 0, 0, 0, 0}
+		}
+		for j := uint32(0); j < userAssetCounts; j++ {
+			circuit.UpdateUserOps[i].OldAssetIndexes[j] = j
+			circuit.UpdateUserOps[i].Assets[j] = UserAssetInfo{
+				AssetIndex:                     j,
+				LoanCollateralIndex:            0,
+				LoanCollateralFlag:             0,
+				MarginCollateralIndex:          0,
+				MarginCollateralFlag:           0,
+				PortfolioMarginCollateralIndex: 0,
+				PortfolioMarginCollateralFlag:  0,
+			}
+		}
+	}
+	return &circuit
+}
+
+// lookupUserAssets 根据用户的资产索引查询按CEX资产排列的用户资产数量
+// 同时检查用户的资产索引严格递增(即互不相同), 并计算资产索引的哈希用于生成随机挑战
+// 参数:
+//   - assetIndexes: 用户资产索引列表
+//   - assetsForUpdateCex: 按CEX资产排列的用户资产元数据
+//
+// 返回:
+//   - queries: 查询位置, 每个资产5个
+//   - results: 查询结果, 依次为Equity, Debt, LoanCollateral, MarginCollateral, PortfolioMarginCollateral
+//   - assetIdHash: 资产索引的哈希
+func lookupUserAssets(api API, r frontend.Rangechecker, assetIndexes []Variable, assetsForUpdateCex []UserAssetMeta) (queries []Variable, results []Variable, assetIdHash Variable) {
+	// construct lookup table for user assets
+	userAssetsLookupTable := logderivlookup.New(api)
+	for j := 0; j < len(assetsForUpdateCex); j++ {
+		userAssetsLookupTable.Insert(assetsForUpdateCex[j].Equity)
+		userAssetsLookupTable.Insert(assetsForUpdateCex[j].Debt)
+		userAssetsLookupTable.Insert(assetsForUpdateCex[j].LoanCollateral)
+		userAssetsLookupTable.Insert(assetsForUpdateCex[j].MarginCollateral)
+		userAssetsLookupTable.Insert(assetsForUpdateCex[j].PortfolioMarginCollateral)
+	}
+
+	// If the user assetIndex is increasing, Then all the assetIndexes are unique
+	for j := 0; j < len(assetIndexes)-1; j++ {
+		r.Check(assetIndexes[j], 16)
+		cr := api.CmpNOp(assetIndexes[j+1], assetIndexes[j], 16, true)
+		api.AssertIsEqual(cr, 1)
+	}
+
+	// one Variable can store 15 assetIds, one assetId is less than 16 bits
+	assetIdsToVariables := make([]Variable, (len(assetIndexes)+14)/15)
+	for j := 0; j < len(assetIdsToVariables); j++ {
+		var v Variable = 0
+		for p := j * 15; p < (j+1)*15 && p < len(assetIndexes); p++ {
+			v = api.Add(v, api.Mul(assetIndexes[p], utils.PowersOfSixteenBits[p%15]))
+		}
+		assetIdsToVariables[j] = v
+	}
+	assetIdHash = poseidon.Poseidon(api, assetIdsToVariables...)
+
+	queries = make([]Variable, len(assetIndexes)*5)
+	for j := 0; j < len(assetIndexes); j++ {
+		p := api.Mul(assetIndexes[j], 5)
+		for k := 0; k < 5; k++ {
+			queries[j*5+k] = api.Add(p, k)
+		}
+	}
+	results = userAssetsLookupTable.Lookup(queries...)
+	return queries, results, assetIdHash
+}
+
+// checkUserAssetsByRandomChallenge 使用随机线性组合检查按资产索引查询到的用户资产包含了assetsForUpdateCex中所有的非零资产
+func checkUserAssetsByRandomChallenge(api API, powersOfRandomChallengeLookupTable *logderivlookup.Table, powersOfRandomChallenge []Variable,
+	queries []Variable, results []Variable, assetsForUpdateCex []UserAssetMeta) {
+	powersOfRCResults := powersOfRandomChallengeLookupTable.Lookup(queries...)
+	var sumA Variable = 0
+	for j := 0; j < len(powersOfRCResults); j++ {
+		sumA = api.Add(sumA, api.Mul(powersOfRCResults[j], results[j]))
+	}
+
+	var sumB Variable = 0
+	for j := 0; j < len(assetsForUpdateCex); j++ {
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].Equity, powersOfRandomChallenge[5*j]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].Debt, powersOfRandomChallenge[5*j+1]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].LoanCollateral, powersOfRandomChallenge[5*j+2]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].MarginCollateral, powersOfRandomChallenge[5*j+3]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].PortfolioMarginCollateral, powersOfRandomChallenge[5*j+4]))
+	}
+	api.AssertIsEqual(sumA, sumB)
+}
+
+// Define 实现批量更新用户的电路约束逻辑
+// 主要验证步骤:
+// 1. 批次承诺验证
+// 2. CEX资产状态验证
+// 3. 旧账户叶子节点验证, 并从CEX资产中扣除旧账户的资产
+// 4. 新账户资产验证, 并将新账户的资产加入CEX资产
+// 5. Merkle树更新验证
+// 6. 最终状态验证
+func (b BatchUpdateUserCircuit) Define(api API) error {
+	// 第1步: 验证批次承诺
+	actualBatchCommitment := poseidon.Poseidon(api,
+		b.BeforeAccountTreeRoot,
+		b.AfterAccountTreeRoot,
+		b.BeforeCEXAssetsCommitment,
+		b.AfterCEXAssetsCommitment,
+		b.RoundId,
+		b.Timestamp)
+	api.AssertIsEqual(b.BatchCommitment, actualBatchCommitment)
+
+	countOfCexAsset := getVariableCountOfCexAsset(b.BeforeCexAssets[0])
+	cexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	afterCexAssets := make([]CexAssetInfo, len(b.BeforeCexAssets))
+
+	r := rangecheck.New(api)
+
+	// 第2步: CEX资产状态验证
+	assetPriceTable := logderivlookup.New(api)
+	for i := 0; i < len(b.BeforeCexAssets); i++ {
+		r.Check(b.BeforeCexAssets[i].TotalEquity, 64)
+		r.Check(b.BeforeCexAssets[i].TotalDebt, 64)
+		r.Check(b.BeforeCexAssets[i].BasePrice, 64)
+		r.Check(b.BeforeCexAssets[i].LoanCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].MarginCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, b.BeforeCexAssets[i], i, cexAssets)
+
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].LoanRatios)
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].MarginRatios)
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].PortfolioMarginRatios)
+
+		afterCexAssets[i] = b.BeforeCexAssets[i]
+		assetPriceTable.Insert(b.BeforeCexAssets[i].BasePrice)
+	}
+
+	actualCexAssetsCommitment := poseidon.Poseidon(api, cexAssets...)
+	api.AssertIsEqual(b.BeforeCEXAssetsCommitment, actualCexAssetsCommitment)
+
+	api.AssertIsEqual(b.BeforeAccountTreeRoot, b.UpdateUserOps[0].BeforeAccountTreeRoot)
+	api.AssertIsEqual(b.AfterAccountTreeRoot, b.UpdateUserOps[len(b.UpdateUserOps)-1].AfterAccountTreeRoot)
+
+	loanTierRatiosTable := constructLoanTierRatiosLookupTable(api, b.BeforeCexAssets)
+	marginTierRatiosTable := constructMarginTierRatiosLookupTable(api, b.BeforeCexAssets)
+	portfolioMarginTierRatiosTable := constructPortfolioTierRatiosLookupTable(api, b.BeforeCexAssets)
+
+	// 旧账户和新账户的资产索引哈希, 最后一个元素为批次承诺
+	userAssetIdHashes := make([]Variable, 2*len(b.UpdateUserOps)+1)
+
+	oldUserAssetsQueries := make([][]Variable, len(b.UpdateUserOps))
+	oldUserAssetsResults := make([][]Variable, len(b.UpdateUserOps))
+	userAssetsQueries := make([][]Variable, len(b.UpdateUserOps))
+	userAssetsResults := make([][]Variable, len(b.UpdateUserOps))
+
+	numOfAssetsFields := 6
+	for i := 0; i < len(b.UpdateUserOps); i++ {
+		accountIndexHelper := accountIdToMerkleHelper(api, b.UpdateUserOps[i].AccountIndex)
+
+		// 第3步: 验证旧账户叶子节点
+		oldAssetIndexes := b.UpdateUserOps[i].OldAssetIndexes
+		oldUserAssetsQueries[i], oldUserAssetsResults[i], userAssetIdHashes[2*i] = lookupUserAssets(api, r, oldAssetIndexes, b.UpdateUserOps[i].OldAssetsForUpdateCex)
+		oldFlattenAssetFieldsForHash := make([]Variable, len(oldAssetIndexes)*numOfAssetsFields)
+		for j := 0; j < len(oldAssetIndexes); j++ {
+			oldFlattenAssetFieldsForHash[j*numOfAssetsFields] = oldAssetIndexes[j]
+			for k := 0; k < 5; k++ {
+				// 用户资产承诺中每3个字段打包为一个元素, 必须保证每个字段小于64位
+				r.Check(oldUserAssetsResults[i][j*5+k], 64)
+				oldFlattenAssetFieldsForHash[j*numOfAssetsFields+1+k] = oldUserAssetsResults[i][j*5+k]
+			}
+		}
+		oldUserAssetsCommitment := computeUserAssetsCommitment(api, oldFlattenAssetFieldsForHash)
+		oldAccountHash := poseidon.Poseidon(api, b.UpdateUserOps[i].AccountIdHash, b.UpdateUserOps[i].OldTotalEquity,
+			b.UpdateUserOps[i].OldTotalDebt, b.UpdateUserOps[i].OldTotalCollateral, oldUserAssetsCommitment)
+		verifyMerkleProof(api, b.UpdateUserOps[i].BeforeAccountTreeRoot, oldAccountHash, b.UpdateUserOps[i].AccountProof[:], accountIndexHelper)
+
+		for j := 0; j < len(b.UpdateUserOps[i].OldAssetsForUpdateCex); j++ {
+			afterCexAssets[j].TotalEquity = api.Sub(afterCexAssets[j].TotalEquity, b.UpdateUserOps[i].OldAssetsForUpdateCex[j].Equity)
+			afterCexAssets[j].TotalDebt = api.Sub(afterCexAssets[j].TotalDebt, b.UpdateUserOps[i].OldAssetsForUpdateCex[j].Debt)
+			afterCexAssets[j].LoanCollateral = api.Sub(afterCexAssets[j].LoanCollateral, b.UpdateUserOps[i].OldAssetsForUpdateCex[j].LoanCollateral)
+			afterCexAssets[j].MarginCollateral = api.Sub(afterCexAssets[j].MarginCollateral, b.UpdateUserOps[i].OldAssetsForUpdateCex[j].MarginCollateral)
+			afterCexAssets[j].PortfolioMarginCollateral = api.Sub(afterCexAssets[j].PortfolioMarginCollateral, b.UpdateUserOps[i].OldAssetsForUpdateCex[j].PortfolioMarginCollateral)
+		}
+
+		// 第4步: 验证新账户的资产
+		userAssets := b.UpdateUserOps[i].Assets
+		assetIndexes := make([]Variable, len(userAssets))
+		assetPriceQueries := make([]Variable, len(userAssets))
+		for j := 0; j < len(userAssets); j++ {
+			assetIndexes[j] = userAssets[j].AssetIndex
+			assetPriceQueries[j] = userAssets[j].AssetIndex
+		}
+		userAssetsQueries[i], userAssetsResults[i], userAssetIdHashes[2*i+1] = lookupUserAssets(api, r, assetIndexes, b.UpdateUserOps[i].AssetsForUpdateCex)
+		assetPriceResponses := assetPriceTable.Lookup(assetPriceQueries...)
+
+		var totalUserEquity Variable = 0
+		var totalUserDebt Variable = 0
+		var totalUserCollateralRealValue Variable = 0
+		flattenAssetFieldsForHash := make([]Variable, len(userAssets)*numOfAssetsFields)
+		for j := 0; j < len(userAssets); j++ {
+			userEquity := userAssetsResults[i][j*5]
+			r.Check(userEquity, 64)
+			userDebt := userAssetsResults[i][j*5+1]
+			r.Check(userDebt, 64)
+			userLoanCollateral := userAssetsResults[i][j*5+2]
+			r.Check(userLoanCollateral, 64)
+			userMarginCollateral := userAssetsResults[i][j*5+3]
+			r.Check(userMarginCollateral, 64)
+			userPortfolioMarginCollateral := userAssetsResults[i][j*5+4]
+			r.Check(userPortfolioMarginCollateral, 64)
+
+			flattenAssetFieldsForHash[j*numOfAssetsFields] = userAssets[j].AssetIndex
+			flattenAssetFieldsForHash[j*numOfAssetsFields+1] = userEquity
+			flattenAssetFieldsForHash[j*numOfAssetsFields+2] = userDebt
+			flattenAssetFieldsForHash[j*numOfAssetsFields+3] = userLoanCollateral
+			flattenAssetFieldsForHash[j*numOfAssetsFields+4] = userMarginCollateral
+			flattenAssetFieldsForHash[j*numOfAssetsFields+5] = userPortfolioMarginCollateral
+
+			assetTotalCollateral := api.Add(userLoanCollateral, userMarginCollateral, userPortfolioMarginCollateral)
+			r.Check(assetTotalCollateral, 64)
+			api.AssertIsLessOrEqualNOp(assetTotalCollateral, userEquity, 64, true)
+
+			loanRealValue := getAndCheckTierRatiosQueryResults(api, r, loanTierRatiosTable, userAssets[j].AssetIndex,
+				userLoanCollateral,
+				userAssets[j].LoanCollateralIndex,
+				userAssets[j].LoanCollateralFlag,
+				assetPriceResponses[j],
+				3*(len(b.BeforeCexAssets[j].LoanRatios)+1))
+
+			marginRealValue := getAndCheckTierRatiosQueryResults(api, r, marginTierRatiosTable, userAssets[j].AssetIndex,
+				userMarginCollateral,
+				userAssets[j].MarginCollateralIndex,
+				userAssets[j].MarginCollateralFlag,
+				assetPriceResponses[j],
+				3*(len(b.BeforeCexAssets[j].MarginRatios)+1))
+
+			portfolioMarginRealValue := getAndCheckTierRatiosQueryResults(api, r, portfolioMarginTierRatiosTable, userAssets[j].AssetIndex,
+				userPortfolioMarginCollateral,
+				userAssets[j].PortfolioMarginCollateralIndex,
+				userAssets[j].PortfolioMarginCollateralFlag,
+				assetPriceResponses[j],
+				3*(len(b.BeforeCexAssets[j].PortfolioMarginRatios)+1))
+
+			totalUserCollateralRealValue = api.Add(totalUserCollateralRealValue, loanRealValue, marginRealValue, portfolioMarginRealValue)
+
+			totalUserEquity = api.Add(totalUserEquity, api.Mul(userEquity, assetPriceResponses[j]))
+			totalUserDebt = api.Add(totalUserDebt, api.Mul(userDebt, assetPriceResponses[j]))
+		}
+
+		for j := 0; j < len(b.UpdateUserOps[i].AssetsForUpdateCex); j++ {
+			afterCexAssets[j].TotalEquity = api.Add(afterCexAssets[j].TotalEquity, b.UpdateUserOps[i].AssetsForUpdateCex[j].Equity)
+			afterCexAssets[j].TotalDebt = api.Add(afterCexAssets[j].TotalDebt, b.UpdateUserOps[i].AssetsForUpdateCex[j].Debt)
+			afterCexAssets[j].LoanCollateral = api.Add(afterCexAssets[j].LoanCollateral, b.UpdateUserOps[i].AssetsForUpdateCex[j].LoanCollateral)
+			afterCexAssets[j].MarginCollateral = api.Add(afterCexAssets[j].MarginCollateral, b.UpdateUserOps[i].AssetsForUpdateCex[j].MarginCollateral)
+			afterCexAssets[j].PortfolioMarginCollateral = api.Add(afterCexAssets[j].PortfolioMarginCollateral, b.UpdateUserOps[i].AssetsForUpdateCex[j].PortfolioMarginCollateral)
+		}
+
+		// make sure user's total Debt is less or equal than total collateral
+		r.Check(totalUserDebt, 128)
+		r.Check(totalUserCollateralRealValue, 128)
+		api.AssertIsLessOrEqualNOp(totalUserDebt, totalUserCollateralRealValue, 128, true)
+
+		// 第5步: 使用同一条Merkle路径将旧叶子节点更新为新叶子节点
+		userAssetsCommitment := computeUserAssetsCommitment(api, flattenAssetFieldsForHash)
+		accountHash := poseidon.Poseidon(api, b.UpdateUserOps[i].AccountIdHash, totalUserEquity, totalUserDebt, totalUserCollateralRealValue, userAssetsCommitment)
+		actualAccountTreeRoot := updateMerkleProof(api, accountHash, b.UpdateUserOps[i].AccountProof[:], accountIndexHelper)
+		api.AssertIsEqual(actualAccountTreeRoot, b.UpdateUserOps[i].AfterAccountTreeRoot)
+	}
+
+	// make sure old and new user assets contain all non-zero assets of OldAssetsForUpdateCex and AssetsForUpdateCex
+	userAssetIdHashes[2*len(b.UpdateUserOps)] = b.BatchCommitment
+	randomChallenge := poseidon.Poseidon(api, userAssetIdHashes...)
+	powersOfRandomChallenge := make([]Variable, 5*len(b.BeforeCexAssets))
+	powersOfRandomChallenge[0] = randomChallenge
+	powersOfRandomChallengeLookupTable := logderivlookup.New(api)
+	powersOfRandomChallengeLookupTable.Insert(randomChallenge)
+	for i := 1; i < len(powersOfRandomChallenge); i++ {
+		powersOfRandomChallenge[i] = api.Mul(powersOfRandomChallenge[i-1], randomChallenge)
+		powersOfRandomChallengeLookupTable.Insert(powersOfRandomChallenge[i])
+	}
+
+	for i := 0; i < len(b.UpdateUserOps); i++ {
+		checkUserAssetsByRandomChallenge(api, powersOfRandomChallengeLookupTable, powersOfRandomChallenge,
+			oldUserAssetsQueries[i], oldUserAssetsResults[i], b.UpdateUserOps[i].OldAssetsForUpdateCex)
+		checkUserAssetsByRandomChallenge(api, powersOfRandomChallengeLookupTable, powersOfRandomChallenge,
+			userAssetsQueries[i], userAssetsResults[i], b.UpdateUserOps[i].AssetsForUpdateCex)
+	}
+
+	// 第6步: 验证操作后的CEX资产承诺, 范围检查保证扣除旧账户资产后没有下溢
+	tempAfterCexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	for j := 0; j < len(b.BeforeCexAssets); j++ {
+		r.Check(afterCexAssets[j].TotalEquity, 64)
+		r.Check(afterCexAssets[j].TotalDebt, 64)
+		r.Check(afterCexAssets[j].LoanCollateral, 64)
+		r.Check(afterCexAssets[j].MarginCollateral, 64)
+		r.Check(afterCexAssets[j].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, afterCexAssets[j], j, tempAfterCexAssets)
+	}
+
+	actualAfterCEXAssetsCommitment := poseidon.Poseidon(api, tempAfterCexAssets...)
+	api.AssertIsEqual(actualAfterCEXAssetsCommitment, b.AfterCEXAssetsCommitment)
+	for i := 0; i < len(b.UpdateUserOps)-1; i++ {
+		api.AssertIsEqual(b.UpdateUserOps[i].AfterAccountTreeRoot, b.UpdateUserOps[i+1].BeforeAccountTreeRoot)
+	}
+	return nil
+}
+
+// SetBatchUpdateUserCircuitWitness 将批量更新用户的见证数据转换为电路格式
+// 参数:
+//   - batchWitness: 批量更新用户的见证数据, 用户资产需按资产索引展开
+//
+// 返回:
+//   - witness: 转换后的电路见证数据
+//   - err: 错误信息
+func SetBatchUpdateUserCircuitWitness(batchWitness *utils.BatchUpdateUserWitness) (witness *BatchUpdateUserCircuit, err error) {
+	witness = &BatchUpdateUserCircuit{
+		BatchCommitment:           batchWitness.BatchCommitment,
+		RoundId:                   batchWitness.RoundId,
+		Timestamp:                 batchWitness.Timestamp,
+		BeforeAccountTreeRoot:     batchWitness.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      batchWitness.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: batchWitness.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  batchWitness.AfterCEXAssetsCommitment,
+		BeforeCexAssets:           convertCexAssets(batchWitness.BeforeCexAssets),
+		UpdateUserOps:             make([]UpdateUserOperation, len(batchWitness.UpdateUserOps)),
+	}
+
+	// 同一批次中所有新旧账户属于同一个资产数量分组, 填充操作与最后一个实际操作相同
+	targetCounts := utils.GetNonEmptyAssetsCountOfUser(batchWitness.UpdateUserOps[0].Assets)
+	oldTargetCounts := utils.GetNonEmptyAssetsCountOfUser(batchWitness.UpdateUserOps[0].OldAssets)
+	if oldTargetCounts > targetCounts {
+		targetCounts = oldTargetCounts
+	}
+	for i := 0; i < len(witness.UpdateUserOps); i++ {
+		op := &batchWitness.UpdateUserOps[i]
+		witness.UpdateUserOps[i].BeforeAccountTreeRoot = op.BeforeAccountTreeRoot
+		witness.UpdateUserOps[i].AfterAccountTreeRoot = op.AfterAccountTreeRoot
+		witness.UpdateUserOps[i].OldTotalEquity = op.OldTotalEquity
+		witness.UpdateUserOps[i].OldTotalDebt = op.OldTotalDebt
+		witness.UpdateUserOps[i].OldTotalCollateral = op.OldTotalCollateral
+
+		oldAssets, oldAssetsForUpdateCex := convertUserAssets(op.OldAssets, targetCounts, batchWitness.BeforeCexAssets)
+		witness.UpdateUserOps[i].OldAssetIndexes = make([]Variable, len(oldAssets))
+		for j := 0; j < len(oldAssets); j++ {
+			witness.UpdateUserOps[i].OldAssetIndexes[j] = oldAssets[j].AssetIndex
+		}
+		witness.UpdateUserOps[i].OldAssetsForUpdateCex = oldAssetsForUpdateCex
+		witness.UpdateUserOps[i].Assets, witness.UpdateUserOps[i].AssetsForUpdateCex = convertUserAssets(op.Assets, targetCounts, batchWitness.BeforeCexAssets)
+
+		witness.UpdateUserOps[i].AccountIdHash = op.AccountIdHash
+		witness.UpdateUserOps[i].AccountIndex = op.AccountIndex
+		witness.UpdateUserOps[i].AccountProof, err = convertAccountProof(op.AccountProof)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
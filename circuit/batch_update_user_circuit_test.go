@@ -0,0 +1,218 @@
+package circuit
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/test"
+)
+
+// constructTestCexAssets 构建测试用的CEX资产信息
+func constructTestCexAssets(totalAssetsCount int) []utils.CexAssetInfo {
+	cexAssets := make([]utils.CexAssetInfo, totalAssetsCount)
+	avgRatio := 100 / utils.TierCount
+	for i := 0; i < totalAssetsCount; i++ {
+		u := utils.CexAssetInfo{
+			BasePrice:             1,
+			Index:                 uint32(i),
+			LoanRatios:            make([]utils.TierRatio, utils.TierCount),
+			MarginRatios:          make([]utils.TierRatio, utils.TierCount),
+			PortfolioMarginRatios: make([]utils.TierRatio, utils.TierCount),
+		}
+		for j := 0; j < utils.TierCount; j++ {
+			tierRatio := utils.TierRatio{
+				BoundaryValue:    new(big.Int).SetInt64(int64(100 * (j + 1))),
+				Ratio:            uint8(100 - avgRatio*j),
+				PrecomputedValue: new(big.Int).SetInt64(0),
+			}
+			u.LoanRatios[j] = tierRatio
+			u.MarginRatios[j] = tierRatio
+			u.PortfolioMarginRatios[j] = tierRatio
+		}
+		utils.CalculatePrecomputedValue(u.LoanRatios[:])
+		utils.CalculatePrecomputedValue(u.MarginRatios[:])
+		utils.CalculatePrecomputedValue(u.PortfolioMarginRatios[:])
+		cexAssets[i] = u
+	}
+	return cexAssets
+}
+
+// constructTestAccountAssets 为账户生成随机资产并计算账户的总权益, 总债务和总抵押品价值
+func constructTestAccountAssets(account *utils.AccountInfo, assetsCount int, gap int, cexAssets []utils.CexAssetInfo) {
+	account.Assets = make([]utils.AccountAsset, assetsCount)
+	account.TotalEquity = new(big.Int).SetInt64(0)
+	account.TotalDebt = new(big.Int).SetInt64(0)
+	account.TotalCollateral = new(big.Int).SetInt64(0)
+	for j := 0; j < assetsCount; j++ {
+		asset := &account.Assets[j]
+		asset.Index = uint16(gap * j)
+		assetPrice := new(big.Int).SetUint64(cexAssets[asset.Index].BasePrice)
+		asset.Loan = uint64(rand.Intn(1000)) + 1
+		asset.Margin = uint64(rand.Intn(1000)) + 1
+		asset.PortfolioMargin = uint64(rand.Intn(1000)) + 1
+		collateralValue := utils.CalculateAssetValueForCollateral(asset.Loan, asset.Margin, asset.PortfolioMargin, &cexAssets[asset.Index])
+		account.TotalCollateral.Add(account.TotalCollateral, collateralValue)
+		collateralValue.Div(collateralValue, assetPrice)
+		asset.Debt = uint64(rand.Intn(int(collateralValue.Int64()))) + 1
+		asset.Equity = uint64(rand.Intn(1000)) + asset.Loan + asset.Margin + asset.PortfolioMargin
+		account.TotalDebt.Add(account.TotalDebt, new(big.Int).Mul(new(big.Int).SetUint64(asset.Debt), assetPrice))
+		account.TotalEquity.Add(account.TotalEquity, new(big.Int).Mul(new(big.Int).SetUint64(asset.Equity), assetPrice))
+	}
+}
+
+// ConstructValidUpdateBatch 构建有效的批量更新用户见证数据
+// 先将账户插入账户树, 然后为每个账户生成新的资产并构建更新操作
+func ConstructValidUpdateBatch(assetsCount int, totalAssetsCount int, userOpsPerBatch int) *utils.BatchUpdateUserWitness {
+	accountTree, err := utils.NewAccountTree("memory", "")
+	if err != nil {
+		panic(err.Error())
+	}
+	cexAssets := constructTestCexAssets(totalAssetsCount)
+	gap := totalAssetsCount / assetsCount
+	poseidonHasher := poseidon.NewPoseidon()
+
+	// 插入旧账户
+	oldAccounts := make([]utils.AccountInfo, userOpsPerBatch)
+	for i := 0; i < len(oldAccounts); i++ {
+		oldAccounts[i] = utils.AccountInfo{
+			AccountIndex: uint32(i * 10),
+			AccountId:    make([]byte, 32),
+		}
+		rand.Read(oldAccounts[i].AccountId)
+		oldAccounts[i].AccountId = new(fr.Element).SetBytes(oldAccounts[i].AccountId).Marshal()
+		constructTestAccountAssets(&oldAccounts[i], assetsCount, gap, cexAssets)
+		err = accountTree.Set(uint64(oldAccounts[i].AccountIndex), utils.AccountInfoToHash(&oldAccounts[i], &poseidonHasher))
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	utils.AccumulateCexAssets(cexAssets, map[int][]utils.AccountInfo{assetsCount: oldAccounts})
+
+	batchUpdateUserWit := &utils.BatchUpdateUserWitness{
+		BeforeAccountTreeRoot: accountTree.Root(),
+		BeforeCexAssets:       make([]utils.CexAssetInfo, totalAssetsCount),
+		UpdateUserOps:         make([]utils.UpdateUserOperation, userOpsPerBatch),
+	}
+	copy(batchUpdateUserWit.BeforeCexAssets, cexAssets)
+	batchUpdateUserWit.BeforeCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(batchUpdateUserWit.BeforeCexAssets)
+
+	for i := 0; i < len(oldAccounts); i++ {
+		newAccount := oldAccounts[i]
+		constructTestAccountAssets(&newAccount, assetsCount, gap, cexAssets)
+		for _, asset := range oldAccounts[i].Assets {
+			cexAssets[asset.Index].TotalEquity -= asset.Equity
+			cexAssets[asset.Index].TotalDebt -= asset.Debt
+			cexAssets[asset.Index].LoanCollateral -= asset.Loan
+			cexAssets[asset.Index].MarginCollateral -= asset.Margin
+			cexAssets[asset.Index].PortfolioMarginCollateral -= asset.PortfolioMargin
+		}
+		for _, asset := range newAccount.Assets {
+			cexAssets[asset.Index].TotalEquity += asset.Equity
+			cexAssets[asset.Index].TotalDebt += asset.Debt
+			cexAssets[asset.Index].LoanCollateral += asset.Loan
+			cexAssets[asset.Index].MarginCollateral += asset.Margin
+			cexAssets[asset.Index].PortfolioMarginCollateral += asset.PortfolioMargin
+		}
+
+		accountBeforeRoot := accountTree.Root()
+		accountProof, err := accountTree.GetProof(uint64(newAccount.AccountIndex))
+		if err != nil {
+			panic(err.Error())
+		}
+		err = accountTree.Set(uint64(newAccount.AccountIndex), utils.AccountInfoToHash(&newAccount, &poseidonHasher))
+		if err != nil {
+			panic(err.Error())
+		}
+		batchUpdateUserWit.UpdateUserOps[i] = utils.UpdateUserOperation{
+			BeforeAccountTreeRoot: accountBeforeRoot,
+			AfterAccountTreeRoot:  accountTree.Root(),
+			OldTotalEquity:        oldAccounts[i].TotalEquity,
+			OldTotalDebt:          oldAccounts[i].TotalDebt,
+			OldTotalCollateral:    oldAccounts[i].TotalCollateral,
+			OldAssets:             oldAccounts[i].Assets,
+			Assets:                newAccount.Assets,
+			AccountIndex:          newAccount.AccountIndex,
+			AccountIdHash:         newAccount.AccountId,
+		}
+		batchUpdateUserWit.UpdateUserOps[i].AccountProof = accountProof
+	}
+
+	batchUpdateUserWit.AfterAccountTreeRoot = accountTree.Root()
+	batchUpdateUserWit.AfterCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(cexAssets)
+	batchUpdateUserWit.RoundId = testRoundId
+	batchUpdateUserWit.Timestamp = testTimestamp
+	batchUpdateUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchUpdateUserWit.BeforeAccountTreeRoot,
+		batchUpdateUserWit.AfterAccountTreeRoot,
+		batchUpdateUserWit.BeforeCEXAssetsCommitment,
+		batchUpdateUserWit.AfterCEXAssetsCommitment,
+		batchUpdateUserWit.RoundId,
+		batchUpdateUserWit.Timestamp)
+
+	// 经过与见证服务相同的序列化和反序列化流程
+	witnessDataStr, err := utils.EncodeBatchWitness(batchUpdateUserWit)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessForCircuit, err := utils.DecodeBatchUpdateWitness(witnessDataStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	return witnessForCircuit
+}
+
+func TestBatchUpdateUserCircuit(t *testing.T) {
+	solver.RegisterHint(IntegerDivision)
+	targetAssetCounts := 30
+	targetCircuitAssetCounts := 50
+	userOpsPerBatch := 2
+	emptyCircuit := NewBatchUpdateUserCircuit(uint32(targetCircuitAssetCounts), uint32(utils.AssetCounts), uint32(userOpsPerBatch))
+
+	// case 1: 有效的更新操作
+	batchWitness := ConstructValidUpdateBatch(targetAssetCounts, utils.AssetCounts, userOpsPerBatch)
+	assignment, err := SetBatchUpdateUserCircuitWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignment.UpdateUserOps[0].Assets) != targetCircuitAssetCounts {
+		t.Fatal("asset counts not match")
+	}
+	err = test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatalf("valid update batch failed: %s\n", err.Error())
+	}
+
+	// case 2: 旧账户的总权益与账户树中的叶子节点不一致
+	batchWitness.UpdateUserOps[0].OldTotalEquity = new(big.Int).Add(batchWitness.UpdateUserOps[0].OldTotalEquity, big.NewInt(1))
+	assignment, err = SetBatchUpdateUserCircuitWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("update batch with invalid old account should fail")
+	}
+
+	// case 3: 公开输入的审计轮次与批次承诺不一致
+	batchWitness = ConstructValidUpdateBatch(targetAssetCounts, utils.AssetCounts, userOpsPerBatch)
+	assignment, err = SetBatchUpdateUserCircuitWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment.RoundId = testRoundId + 1
+	err = test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("update batch with mismatched round id should fail")
+	}
+
+	// 根据见证数据恢复的CEX资产需要与操作后的CEX资产承诺一致
+	recoveredCexAssets := utils.RecoverAfterCexAssetsOfUpdate(batchWitness)
+	if len(recoveredCexAssets) != utils.AssetCounts {
+		t.Fatal("recover cex assets failed")
+	}
+}
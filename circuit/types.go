@@ -1,7 +1,6 @@
 package circuit
 
 import (
-	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"github.com/consensys/gnark/frontend"
 )
 
@@ -68,11 +67,43 @@ type UserAssetMeta struct {
 // CreateUserOperation 创建用户操作
 // 定义创建用户时需要的所有信息
 type CreateUserOperation struct {
-	BeforeAccountTreeRoot Variable                         // 操作前账户树根
-	AfterAccountTreeRoot  Variable                         // 操作后账户树根
-	Assets                []UserAssetInfo                  // 用户资产信息列表
-	AssetsForUpdateCex    []UserAssetMeta                  // 用于更新CEX的资产元数据
-	AccountIndex          Variable                         // 账户索引
-	AccountIdHash         Variable                         // 账户ID哈希
-	AccountProof          [utils.AccountTreeDepth]Variable // 账户证明路径
+	BeforeAccountTreeRoot Variable        // 操作前账户树根
+	AfterAccountTreeRoot  Variable        // 操作后账户树根
+	Assets                []UserAssetInfo // 用户资产信息列表
+	AssetsForUpdateCex    []UserAssetMeta // 用于更新CEX的资产元数据
+	AccountIndex          Variable        // 账户索引
+	AccountIdHash         Variable        // 账户ID哈希
+	AccountProof          []Variable      // 账户证明路径, 长度为AccountTreeDepth
+}
+
+// UpdateUserOperation 更新用户操作
+// 定义将已有账户的叶子节点更新为新账户状态时需要的所有信息
+type UpdateUserOperation struct {
+	BeforeAccountTreeRoot Variable        // 操作前账户树根
+	AfterAccountTreeRoot  Variable        // 操作后账户树根
+	OldTotalEquity        Variable        // 旧账户总权益
+	OldTotalDebt          Variable        // 旧账户总债务
+	OldTotalCollateral    Variable        // 旧账户总抵押品价值
+	OldAssetIndexes       []Variable      // 旧账户资产索引列表
+	OldAssetsForUpdateCex []UserAssetMeta // 用于从CEX中扣除的旧资产元数据
+	Assets                []UserAssetInfo // 新账户资产信息列表
+	AssetsForUpdateCex    []UserAssetMeta // 用于更新CEX的新资产元数据
+	AccountIndex          Variable        // 账户索引
+	AccountIdHash         Variable        // 账户ID哈希
+	AccountProof          []Variable      // 账户证明路径, 长度为AccountTreeDepth
+}
+
+// DeleteUserOperation 删除用户操作
+// 定义将已有账户的叶子节点重置为空叶子节点时需要的所有信息
+type DeleteUserOperation struct {
+	BeforeAccountTreeRoot Variable        // 操作前账户树根
+	AfterAccountTreeRoot  Variable        // 操作后账户树根
+	TotalEquity           Variable        // 被删除账户总权益
+	TotalDebt             Variable        // 被删除账户总债务
+	TotalCollateral       Variable        // 被删除账户总抵押品价值
+	AssetIndexes          []Variable      // 被删除账户资产索引列表
+	AssetsForUpdateCex    []UserAssetMeta // 用于从CEX中扣除的资产元数据
+	AccountIndex          Variable        // 账户索引
+	AccountIdHash         Variable        // 账户ID哈希, 填充操作为0
+	AccountProof          []Variable      // 账户证明路径, 长度为AccountTreeDepth
 }
@@ -1,6 +1,7 @@
 package circuit
 
 import (
+	"fmt"
 	"math/big"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
@@ -9,6 +10,28 @@ import (
 	"github.com/consensys/gnark/std/lookup/logderivlookup"
 )
 
+// newEmptyAccountProof 创建长度为AccountTreeDepth的账户证明路径, 用于编译电路
+func newEmptyAccountProof() []Variable {
+	proof := make([]Variable, utils.AccountTreeDepth)
+	for i := 0; i < len(proof); i++ {
+		proof[i] = 0
+	}
+	return proof
+}
+
+// convertAccountProof 将见证数据中的账户证明路径转换为电路格式
+// 证明路径的长度必须与当前电路参数的账户树深度一致
+func convertAccountProof(proof [][]byte) ([]Variable, error) {
+	if len(proof) != utils.AccountTreeDepth {
+		return nil, fmt.Errorf("invalid account proof length %d, it should be %d", len(proof), utils.AccountTreeDepth)
+	}
+	res := make([]Variable, len(proof))
+	for i := 0; i < len(proof); i++ {
+		res[i] = proof[i]
+	}
+	return res, nil
+}
+
 func verifyMerkleProof(api API, merkleRoot Variable, node Variable, proofSet, helper []Variable) {
 	for i := 0; i < len(proofSet); i++ {
 		api.AssertIsBoolean(helper[i])
@@ -0,0 +1,19 @@
+package config
+
+// Config 聚合器配置结构
+type Config struct {
+	MysqlDataSource           string   // MySQL数据源
+	DbSuffix                  string   // 证明表后缀
+	ZkKeyName                 []string // 批次电路的密钥名称列表
+	UpdateZkKeyName           []string // 更新用户批次电路的密钥名称列表, 为空时不能聚合更新用户的批次
+	DeleteZkKeyName           []string // 删除用户批次电路的密钥名称列表, 为空时不能聚合删除用户的批次
+	AssetsCountTiers          []int    // 资产数量层级配置
+	AggregationKeyName        string   // 聚合电路的密钥名称前缀
+	BatchProofsPerAggregation int      // 第一层聚合电路聚合的批次证明数量
+	AggregationsPerRound      int      // 轮次聚合电路聚合的下一层聚合证明数量
+	RoundAggregationLevels    int      // 第一层之上的轮次聚合层数, 为0时为1, 一轮最多包含BatchProofsPerAggregation*AggregationsPerRound^RoundAggregationLevels个批次
+	RoundProofFile            string   // 输出的轮次聚合证明文件
+
+	// 电路参数文件, 为空时使用默认的电路参数, 必须与keygen和prover使用的相同
+	CircuitParamsFile string
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/aggregator/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// 聚合电路的密钥名称后缀
+const (
+	BatchAggregationKeySuffix = "_batch" // 第一层: 聚合批次证明
+	RoundAggregationKeySuffix = "_round" // 之后的每一层: 聚合下一层的聚合证明, 第2层及之后的层在后缀后加上层数
+)
+
+// LoadVerifyingKey 加载groth16验证密钥
+func LoadVerifyingKey(vkFileName string) (groth16.VerifyingKey, error) {
+	vkFile, err := os.ReadFile(vkFileName)
+	if err != nil {
+		return nil, err
+	}
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	_, err = vk.ReadFrom(bytes.NewBuffer(vkFile))
+	if err != nil {
+		return nil, err
+	}
+	return vk, nil
+}
+
+// LoadProvingParams 加载聚合电路的约束系统和证明密钥
+func LoadProvingParams(keyName string) (constraint.ConstraintSystem, groth16.ProvingKey, error) {
+	ccsFile, err := os.ReadFile(keyName + ".r1cs")
+	if err != nil {
+		return nil, nil, err
+	}
+	ccs := groth16.NewCS(ecc.BN254)
+	_, err = ccs.ReadFrom(bytes.NewBuffer(ccsFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	pkFile, err := os.ReadFile(keyName + ".pk")
+	if err != nil {
+		return nil, nil, err
+	}
+	pk := groth16.NewProvingKey(ecc.BN254)
+	_, err = pk.UnsafeReadFrom(bytes.NewBuffer(pkFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ccs, pk, nil
+}
+
+// GenerateAggregationKeys 编译聚合电路并生成密钥
+// 参数:
+//   - innerVks: 允许被聚合的证明的验证密钥
+//   - proofsCount: 每个聚合证明最多聚合的证明数量
+//   - keyName: 密钥文件名称
+//
+// 返回:
+//   - groth16.VerifyingKey: 聚合电路的验证密钥
+func GenerateAggregationKeys(innerVks []groth16.VerifyingKey, proofsCount int, keyName string) groth16.VerifyingKey {
+	aggregationCircuit, err := circuit.NewAggregationCircuit(innerVks, proofsCount)
+	if err != nil {
+		panic(err.Error())
+	}
+	startTime := time.Now()
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, aggregationCircuit, frontend.IgnoreUnconstrainedInputs())
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("aggregation r1cs generation time is ", time.Since(startTime))
+	fmt.Println("aggregation constraints number is ", ccs.GetNbConstraints())
+
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		panic(err.Error())
+	}
+	for suffix, obj := range map[string]io.WriterTo{".r1cs": ccs, ".pk": pk, ".vk": vk} {
+		f, err := os.Create(keyName + suffix)
+		if err != nil {
+			panic(err.Error())
+		}
+		n, err := obj.WriteTo(f)
+		f.Close()
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println(keyName+suffix, " size is ", n)
+	}
+	return vk
+}
+
+// ProveAggregation 为一组证明生成聚合证明
+// 参数:
+//   - ccs, pk, vk: 聚合电路的约束系统和密钥
+//   - inputs: 按批次顺序排列的被聚合证明
+//   - proofsCount: 聚合电路中证明的数量
+//   - forAggregation: 生成的聚合证明是否还需要被上一层聚合电路验证
+//
+// 返回:
+//   - circuit.AggregationInput: 聚合证明, 可以作为上一层聚合电路的输入
+//   - error: 错误信息
+func ProveAggregation(ccs constraint.ConstraintSystem, pk groth16.ProvingKey, vk groth16.VerifyingKey,
+	inputs []circuit.AggregationInput, proofsCount int, forAggregation bool) (circuit.AggregationInput, error) {
+	var result circuit.AggregationInput
+	assignment, err := circuit.SetAggregationCircuitWitness(inputs, proofsCount)
+	if err != nil {
+		return result, err
+	}
+	fullWitness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return result, err
+	}
+	publicWitness, err := fullWitness.Public()
+	if err != nil {
+		return result, err
+	}
+	var proverOpts []backend.ProverOption
+	var verifierOpts []backend.VerifierOption
+	if forAggregation {
+		proverOpts = append(proverOpts, utils.RecursionProverOptions())
+		verifierOpts = append(verifierOpts, utils.RecursionVerifierOptions())
+	}
+	proof, err := groth16.Prove(ccs, pk, fullWitness, proverOpts...)
+	if err != nil {
+		return result, err
+	}
+	err = groth16.Verify(proof, vk, publicWitness, verifierOpts...)
+	if err != nil {
+		return result, err
+	}
+	first := inputs[0]
+	last := inputs[len(inputs)-1]
+	result = circuit.AggregationInput{
+		BeforeAccountTreeRoot:     first.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      last.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: first.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  last.AfterCEXAssetsCommitment,
+		Commitment: utils.ComputeBatchCommitment(first.BeforeAccountTreeRoot,
+			last.AfterAccountTreeRoot,
+			first.BeforeCEXAssetsCommitment,
+			last.AfterCEXAssetsCommitment,
+			first.RoundId,
+			first.Timestamp),
+		RoundId:      first.RoundId,
+		Timestamp:    first.Timestamp,
+		VerifyingKey: vk,
+		Proof:        proof,
+	}
+	return result, nil
+}
+
+// DecodeBatchProof 将证明表中的批次证明转换为聚合电路的输入
+func DecodeBatchProof(row *prover.Proof, vk groth16.VerifyingKey) (circuit.AggregationInput, error) {
+	var input circuit.AggregationInput
+	var accountTreeRoots [][]byte
+	err := json.Unmarshal([]byte(row.AccountTreeRoots), &accountTreeRoots)
+	if err != nil || len(accountTreeRoots) != 2 {
+		return input, fmt.Errorf("invalid account tree roots of batch %d", row.BatchNumber)
+	}
+	var cexAssetListCommitments [][]byte
+	err = json.Unmarshal([]byte(row.CexAssetListCommitments), &cexAssetListCommitments)
+	if err != nil || len(cexAssetListCommitments) != 2 {
+		return input, fmt.Errorf("invalid cex asset list commitments of batch %d", row.BatchNumber)
+	}
+	commitment, err := base64.StdEncoding.DecodeString(row.BatchCommitment)
+	if err != nil {
+		return input, err
+	}
+	proofBytes, err := base64.StdEncoding.DecodeString(row.ProofInfo)
+	if err != nil {
+		return input, err
+	}
+	proof := groth16.NewProof(ecc.BN254)
+	_, err = proof.ReadFrom(bytes.NewBuffer(proofBytes))
+	if err != nil {
+		return input, err
+	}
+	input = circuit.AggregationInput{
+		BeforeAccountTreeRoot:     accountTreeRoots[0],
+		AfterAccountTreeRoot:      accountTreeRoots[1],
+		BeforeCEXAssetsCommitment: cexAssetListCommitments[0],
+		AfterCEXAssetsCommitment:  cexAssetListCommitments[1],
+		Commitment:                commitment,
+		RoundId:                   row.RoundId,
+		Timestamp:                 row.Timestamp,
+		VerifyingKey:              vk,
+		Proof:                     proof,
+	}
+	return input, nil
+}
+
+// roundAggregationLevels 返回配置的轮次聚合层数
+func roundAggregationLevels(aggregatorConfig *config.Config) int {
+	if aggregatorConfig.RoundAggregationLevels <= 0 {
+		return 1
+	}
+	return aggregatorConfig.RoundAggregationLevels
+}
+
+// RequiredRoundLevels 计算聚合proofsNum个批次证明需要的轮次聚合层数
+// 第一层聚合之后, 每一层将证明数量缩小AggregationsPerRound倍, 直到只剩一个证明,
+// 至少需要一层轮次聚合, 层数超过配置的RoundAggregationLevels时返回错误
+func RequiredRoundLevels(aggregatorConfig *config.Config, proofsNum int64) (int, error) {
+	maxLevels := roundAggregationLevels(aggregatorConfig)
+	if proofsNum <= 0 {
+		return 0, errors.New("no batch proofs to aggregate")
+	}
+	if aggregatorConfig.BatchProofsPerAggregation <= 0 || aggregatorConfig.AggregationsPerRound <= 1 {
+		return 0, errors.New("BatchProofsPerAggregation should be positive and AggregationsPerRound should be greater than 1")
+	}
+	count := ceilDiv(proofsNum, int64(aggregatorConfig.BatchProofsPerAggregation))
+	levels := 0
+	for levels == 0 || count > 1 {
+		count = ceilDiv(count, int64(aggregatorConfig.AggregationsPerRound))
+		levels++
+	}
+	if levels > maxLevels {
+		return 0, fmt.Errorf("the round has %d batch proofs which need %d round aggregation levels, but only %d levels are configured",
+			proofsNum, levels, maxLevels)
+	}
+	return levels, nil
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// AggregateProofs 使用keyName的聚合电路将inputs每proofsCount个聚合成一个证明
+// 参数:
+//   - keyName: 聚合电路的密钥名称
+//   - inputs: 按批次顺序排列的被聚合证明
+//   - proofsCount: 聚合电路中证明的数量
+//   - forAggregation: 生成的聚合证明是否还需要被上一层聚合电路验证
+//
+// 返回:
+//   - []circuit.AggregationInput: 按批次顺序排列的聚合证明
+//   - error: 错误信息
+func AggregateProofs(keyName string, inputs []circuit.AggregationInput, proofsCount int, forAggregation bool) ([]circuit.AggregationInput, error) {
+	ccs, pk, err := LoadProvingParams(keyName)
+	if err != nil {
+		return nil, err
+	}
+	vk, err := LoadVerifyingKey(keyName + ".vk")
+	if err != nil {
+		return nil, err
+	}
+	aggregations := make([]circuit.AggregationInput, 0, ceilDiv(int64(len(inputs)), int64(proofsCount)))
+	for start := 0; start < len(inputs); start += proofsCount {
+		end := start + proofsCount
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		startTime := time.Now()
+		aggregation, err := ProveAggregation(ccs, pk, vk, inputs[start:end], proofsCount, forAggregation)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate proofs %d-%d with %s failed: %w", start, end-1, keyName, err)
+		}
+		fmt.Println("aggregate proofs ", start, "-", end-1, " with ", keyName, " cost ", time.Since(startTime))
+		aggregations = append(aggregations, aggregation)
+	}
+	return aggregations, nil
+}
+
+// AggregateRound 将一轮的所有批次证明聚合成单个轮次证明
+// 第一层每BatchProofsPerAggregation个批次证明生成一个聚合证明,
+// 之后每一层每AggregationsPerRound个聚合证明生成一个聚合证明, 直到只剩一个证明
+// 参数:
+//   - aggregatorConfig: 聚合器配置
+//   - proofs: 按批次编号排列的批次证明
+//   - batchVks: 批次电路的验证密钥, 依次为创建用户, 更新用户和删除用户电路的验证密钥
+//
+// 返回:
+//   - *utils.RoundProof: 轮次聚合证明
+//   - error: 错误信息
+func AggregateRound(aggregatorConfig *config.Config, proofs []*prover.Proof, batchVks []groth16.VerifyingKey) (*utils.RoundProof, error) {
+	levels, err := RequiredRoundLevels(aggregatorConfig, int64(len(proofs)))
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]circuit.AggregationInput, 0, len(proofs))
+	for i, row := range proofs {
+		if row.BatchNumber != int64(i) {
+			return nil, fmt.Errorf("batch proof %d is missing", i)
+		}
+		if row.ProvingSystem != "" && row.ProvingSystem != utils.ProvingSystemGroth16 {
+			return nil, fmt.Errorf("batch proof %d is not a groth16 proof", i)
+		}
+		// 旧版本的证明表没有记录hash-to-field模式
+		if row.HashToField != "" && row.HashToField != utils.HashToFieldAggregation {
+			return nil, fmt.Errorf("batch proof %d is generated with the %s hash-to-field mode, the prover should enable ForAggregation", i, row.HashToField)
+		}
+		index := -1
+		for p := 0; p < len(aggregatorConfig.AssetsCountTiers); p++ {
+			if aggregatorConfig.AssetsCountTiers[p] == row.AssetsCount {
+				index = p
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("invalid asset counts tier %d of batch proof %d", row.AssetsCount, i)
+		}
+		if row.OpType == utils.OpTypeUpdateUser {
+			if len(aggregatorConfig.UpdateZkKeyName) == 0 {
+				return nil, fmt.Errorf("batch proof %d is an update proof but no update keys are configured", i)
+			}
+			index += len(aggregatorConfig.ZkKeyName)
+		}
+		if row.OpType == utils.OpTypeDeleteUser {
+			if len(aggregatorConfig.DeleteZkKeyName) == 0 {
+				return nil, fmt.Errorf("batch proof %d is a delete proof but no delete keys are configured", i)
+			}
+			index += len(aggregatorConfig.ZkKeyName) + len(aggregatorConfig.UpdateZkKeyName)
+		}
+		input, err := DecodeBatchProof(row, batchVks[index])
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+
+	// 第一层聚合
+	batchKeyName := aggregatorConfig.AggregationKeyName + BatchAggregationKeySuffix
+	aggregations, err := AggregateProofs(batchKeyName, inputs, aggregatorConfig.BatchProofsPerAggregation, true)
+	if err != nil {
+		return nil, err
+	}
+	runtime.GC()
+
+	// 逐层聚合, 最后一层生成的证明不再被递归验证
+	roundKeyName := aggregatorConfig.AggregationKeyName + RoundAggregationKeySuffix
+	for level := 1; level <= levels; level++ {
+		aggregations, err = AggregateProofs(utils.RoundAggregationKeyName(roundKeyName, level), aggregations,
+			aggregatorConfig.AggregationsPerRound, level < levels)
+		if err != nil {
+			return nil, err
+		}
+		runtime.GC()
+	}
+	if len(aggregations) != 1 {
+		return nil, fmt.Errorf("expect one round proof but got %d", len(aggregations))
+	}
+	roundAggregation := aggregations[0]
+
+	var buf bytes.Buffer
+	_, err = roundAggregation.Proof.WriteRawTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.RoundProof{
+		ProofInfo:                 base64.StdEncoding.EncodeToString(buf.Bytes()),
+		AggregatedCommitment:      base64.StdEncoding.EncodeToString(roundAggregation.Commitment),
+		BeforeAccountTreeRoot:     base64.StdEncoding.EncodeToString(roundAggregation.BeforeAccountTreeRoot),
+		AfterAccountTreeRoot:      base64.StdEncoding.EncodeToString(roundAggregation.AfterAccountTreeRoot),
+		BeforeCEXAssetsCommitment: base64.StdEncoding.EncodeToString(roundAggregation.BeforeCEXAssetsCommitment),
+		AfterCEXAssetsCommitment:  base64.StdEncoding.EncodeToString(roundAggregation.AfterCEXAssetsCommitment),
+		RoundId:                   roundAggregation.RoundId,
+		Timestamp:                 roundAggregation.Timestamp,
+		BatchCount:                int64(len(proofs)),
+		AggregationLevels:         levels,
+	}, nil
+}
+
+// main 函数实现了两种模式:
+// 1. 密钥生成模式(-keygen): 根据批次电路的验证密钥生成第一层和每一层轮次聚合电路的密钥
+// 2. 聚合模式: 从证明表读取一轮的所有批次证明并逐层聚合成单个轮次聚合证明
+func main() {
+	aggregatorConfig := &config.Config{}
+	content, err := ioutil.ReadFile("config/config.json")
+	if err != nil {
+		panic(err.Error())
+	}
+	err = json.Unmarshal(content, aggregatorConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(aggregatorConfig.AssetsCountTiers) != len(aggregatorConfig.ZkKeyName) {
+		panic("asset tiers and asset tier names should have the same length")
+	}
+	if len(aggregatorConfig.UpdateZkKeyName) != 0 && len(aggregatorConfig.AssetsCountTiers) != len(aggregatorConfig.UpdateZkKeyName) {
+		panic("asset tiers and update asset tier names should have the same length")
+	}
+	if len(aggregatorConfig.DeleteZkKeyName) != 0 && len(aggregatorConfig.AssetsCountTiers) != len(aggregatorConfig.DeleteZkKeyName) {
+		panic("asset tiers and delete asset tier names should have the same length")
+	}
+	// 加载电路参数, 批次电路的密钥必须由当前的电路参数生成
+	err = utils.InitCircuitParams(aggregatorConfig.CircuitParamsFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	for _, keyNames := range [][]string{aggregatorConfig.ZkKeyName, aggregatorConfig.UpdateZkKeyName, aggregatorConfig.DeleteZkKeyName} {
+		for _, keyName := range keyNames {
+			if err = utils.CheckKeyNameCircuitParams(keyName); err != nil {
+				panic(err.Error())
+			}
+		}
+	}
+	for _, tier := range aggregatorConfig.AssetsCountTiers {
+		if _, ok := utils.BatchCreateUserOpsCountsTiers[tier]; !ok {
+			panic(fmt.Sprintf("assets count tier %d is not in the circuit params", tier))
+		}
+	}
+
+	keygen := flag.Bool("keygen", false, "generate keys of aggregation circuits")
+	remotePasswdConfig := flag.String("remote_password_config", "", "fetch password from aws secretsmanager")
+	flag.Parse()
+
+	go func() {
+		for {
+			time.Sleep(time.Second * 10)
+			runtime.GC()
+		}
+	}()
+
+	// 加载每个资产层级的批次电路验证密钥, 依次为创建用户, 更新用户和删除用户电路的验证密钥
+	zkKeyNames := append(append([]string{}, aggregatorConfig.ZkKeyName...), aggregatorConfig.UpdateZkKeyName...)
+	zkKeyNames = append(zkKeyNames, aggregatorConfig.DeleteZkKeyName...)
+	batchVks := make([]groth16.VerifyingKey, len(zkKeyNames))
+	for i := 0; i < len(zkKeyNames); i++ {
+		batchVks[i], err = LoadVerifyingKey(zkKeyNames[i] + ".vk")
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *keygen {
+		// 每一层聚合电路只允许下一层聚合电路的验证密钥
+		vk := GenerateAggregationKeys(batchVks, aggregatorConfig.BatchProofsPerAggregation,
+			aggregatorConfig.AggregationKeyName+BatchAggregationKeySuffix)
+		roundKeyName := aggregatorConfig.AggregationKeyName + RoundAggregationKeySuffix
+		for level := 1; level <= roundAggregationLevels(aggregatorConfig); level++ {
+			vk = GenerateAggregationKeys([]groth16.VerifyingKey{vk}, aggregatorConfig.AggregationsPerRound,
+				utils.RoundAggregationKeyName(roundKeyName, level))
+		}
+		fmt.Println("aggregation keys generation finished...")
+		return
+	}
+
+	if *remotePasswdConfig != "" {
+		s, err := utils.GetMysqlSource(aggregatorConfig.MysqlDataSource, *remotePasswdConfig)
+		if err != nil {
+			panic(err.Error())
+		}
+		aggregatorConfig.MysqlDataSource = s
+	}
+	db, err := utils.OpenDatabase(aggregatorConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	proofModel := prover.NewProofModel(db, aggregatorConfig.DbSuffix)
+	proofsNum := proofModel.GetProofNumber()
+	// 在读取证明之前检查层数是否足够
+	_, err = RequiredRoundLevels(aggregatorConfig, proofsNum)
+	if err != nil {
+		fmt.Println("aggregate round proof failed: ", err.Error())
+		os.Exit(1)
+	}
+	proofs, err := proofModel.GetProofsBetween(0, proofsNum-1)
+	if err != nil {
+		panic(err.Error())
+	}
+	if int64(len(proofs)) != proofsNum {
+		fmt.Println("aggregate round proof failed: some batch proofs are missing")
+		os.Exit(1)
+	}
+
+	roundProof, err := AggregateRound(aggregatorConfig, proofs, batchVks)
+	if err != nil {
+		fmt.Println("aggregate round proof failed: ", err.Error())
+		os.Exit(1)
+	}
+	roundProofBytes, err := json.MarshalIndent(roundProof, "", "  ")
+	if err != nil {
+		panic(err.Error())
+	}
+	err = os.WriteFile(aggregatorConfig.RoundProofFile, roundProofBytes, 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+	accountTreeRoot, _ := base64.StdEncoding.DecodeString(roundProof.AfterAccountTreeRoot)
+	fmt.Printf("round proof of %d batches with %d round aggregation levels is written to %s, account tree root is %x\n",
+		proofsNum, roundProof.AggregationLevels, aggregatorConfig.RoundProofFile, accountTreeRoot)
+}
@@ -3,6 +3,7 @@ package config
 type Config struct {
 	MysqlDataSource string
 	DbSuffix        string
+	CircuitParamsFile string
 	TreeDB          struct {
 		Driver string
 		Option struct {
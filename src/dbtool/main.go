@@ -1,22 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/dbtool/config"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
 	"github.com/redis/go-redis/v9"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -40,6 +45,12 @@ func main() {
 	queryWitnessData := flag.Int("query_witness_data", -1, "query witness data by height")
 	queryAccountData := flag.Int("query_account_data", -1, "query account data by index")
 	pushTaskToRedis := flag.Bool("push_task_to_redis", false, "push task to redis")
+	exportCalldata := flag.String("export_calldata", "", "export calldata of solidity verifier for all batch proofs to file")
+	exportProofs := flag.String("export_proofs", "", "export all batch proofs to file in json lines format, or as a signed bundle if bundle_key is set")
+	bundleKey := flag.String("bundle_key", "", "file of the hex encoded ed25519 private key seed used to sign the proof bundle")
+	migrateWitness := flag.Bool("migrate_witness", false, "migrate legacy gob encoded witness data to the current witness format")
+	migrateRoundId := flag.Uint64("round_id", 0, "round id used by migrate_witness for legacy witness without round id")
+	migrateTimestamp := flag.Uint64("timestamp", 0, "snapshot timestamp used by migrate_witness for legacy witness without round id")
 
 	flag.Parse()
 
@@ -53,6 +64,12 @@ func main() {
 		},
 	)
 
+	// 解码见证数据时检查见证数据头部中的电路参数
+	err = utils.InitCircuitParams(dbtoolConfig.CircuitParamsFile)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	if *remotePasswdConfig != "" {
 		s, err := utils.GetMysqlSource(dbtoolConfig.MysqlDataSource, *remotePasswdConfig)
 		if err != nil {
@@ -61,7 +78,7 @@ func main() {
 		dbtoolConfig.MysqlDataSource = s
 	}
 	if *deleteAllData {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource))
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource)
 		if err != nil {
 			panic(err.Error())
 		}
@@ -98,7 +115,14 @@ func main() {
 		fmt.Println("redis data drop successfully")
 	}
 
-	if *deleteAllData || *onlyFlushKvrocks {
+	if (*deleteAllData || *onlyFlushKvrocks) && dbtoolConfig.TreeDB.Driver == utils.TreeDBDriverLevelDB {
+		// leveldb存储直接删除数据目录
+		err = os.RemoveAll(dbtoolConfig.TreeDB.Option.Addr)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println("leveldb data drop successfully")
+	} else if *deleteAllData || *onlyFlushKvrocks {
 		client := redis.NewClient(&redis.Options{
 			Addr:            dbtoolConfig.TreeDB.Option.Addr,
 			PoolSize:        500,
@@ -115,7 +139,7 @@ func main() {
 	}
 
 	if *checkProverStatus {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource), &gorm.Config{
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
 			Logger: newLogger,
 		})
 		if err != nil {
@@ -132,12 +156,12 @@ func main() {
 		if err != nil {
 			proofCounts = 0
 		}
-		fmt.Printf("Total witness item %d, Published item %d, Pending item %d, Finished item %d\n", witnessCounts[0], witnessCounts[1], witnessCounts[2], witnessCounts[3])
+		fmt.Printf("Total witness item %d, Published item %d, Pending item %d, Finished item %d, Failed item %d\n", witnessCounts[0], witnessCounts[1], witnessCounts[2], witnessCounts[3], witnessCounts[4])
 		fmt.Println(witnessCounts[0] - proofCounts)
 	}
 
 	if *queryCexAssetsConfig {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource))
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource)
 		if err != nil {
 			panic(err.Error())
 		}
@@ -146,11 +170,10 @@ func main() {
 		if err != nil {
 			panic(err.Error())
 		}
-		witness := utils.DecodeBatchWitness(latestWitness.WitnessData)
-		if witness == nil {
-			panic("decode invalid witness data")
+		cexAssetsInfo, err := utils.RecoverAfterCexAssetsByOpType(latestWitness.OpType, latestWitness.WitnessData)
+		if err != nil {
+			panic("decode invalid witness data: " + err.Error())
 		}
-		cexAssetsInfo := utils.RecoverAfterCexAssets(witness)
 		var newAssetsInfo []utils.CexAssetInfo
 		for i := 0; i < len(cexAssetsInfo); i++ {
 			if cexAssetsInfo[i].BasePrice != 0 {
@@ -162,7 +185,7 @@ func main() {
 	}
 
 	if *queryWitnessData != -1 {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource), &gorm.Config{
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
 			Logger: newLogger,
 		})
 		if err != nil {
@@ -178,7 +201,7 @@ func main() {
 	}
 
 	if *queryAccountData != -1 {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource), &gorm.Config{
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
 			Logger: newLogger,
 		})
 		if err != nil {
@@ -194,7 +217,7 @@ func main() {
 	}
 
 	if *pushTaskToRedis {
-		db, err := gorm.Open(mysql.Open(dbtoolConfig.MysqlDataSource), &gorm.Config{
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
 			Logger: newLogger,
 		})
 		if err != nil {
@@ -204,7 +227,7 @@ func main() {
 		limit := 1024
 		offset := 0
 		witessStatusList := []int64{witness.StatusPublished}
-		taskQueueName := "por_batch_task_queue_" + dbtoolConfig.DbSuffix
+		taskQueueName := prover.TaskQueueName(dbtoolConfig.DbSuffix)
 		ctx := context.Background()
 		redisCli := redis.NewClient(&redis.Options{
 			Addr: dbtoolConfig.Redis.Host,
@@ -234,4 +257,146 @@ func main() {
 		}
 		fmt.Println("push task to redis successfully")
 	}
+
+	if *exportCalldata != "" {
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
+			Logger: newLogger,
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+		proofModel := prover.NewProofModel(db, dbtoolConfig.DbSuffix)
+		proofCounts, err := proofModel.GetRowCounts()
+		if err != nil {
+			panic(err.Error())
+		}
+		calldataList := make([]*utils.SolidityCalldata, 0, proofCounts)
+		limit := int64(1024)
+		for start := int64(0); start < proofCounts; start += limit {
+			proofs, err := proofModel.GetProofsBetween(start, start+limit-1)
+			if err != nil {
+				panic(err.Error())
+			}
+			for _, p := range proofs {
+				// 旧版本的证明表没有记录hash-to-field模式
+				if p.HashToField != "" && p.HashToField != utils.HashToFieldSolidity {
+					panic(fmt.Sprintf("batch proof %d is generated with the %s hash-to-field mode, the prover should enable ForSolidity", p.BatchNumber, p.HashToField))
+				}
+				calldata, err := utils.NewSolidityCalldata(p.ProvingSystem, p.ProofInfo, p.BatchCommitment, p.RoundId, p.Timestamp)
+				if err != nil {
+					fmt.Println("convert proof to calldata failed: ", p.BatchNumber)
+					panic(err.Error())
+				}
+				calldata.BatchNumber = p.BatchNumber
+				calldataList = append(calldataList, calldata)
+			}
+		}
+		if int64(len(calldataList)) != proofCounts {
+			panic(fmt.Sprintf("batch proofs are not continuous, expect %d but got %d", proofCounts, len(calldataList)))
+		}
+		calldataBytes, err := json.MarshalIndent(calldataList, "", "  ")
+		if err != nil {
+			panic(err.Error())
+		}
+		err = os.WriteFile(*exportCalldata, calldataBytes, 0644)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("export calldata of %d batch proofs successfully\n", len(calldataList))
+	}
+
+	if *exportProofs != "" {
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
+			Logger: newLogger,
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+		proofs, err := verifier.LoadBatchProofsFromDb(prover.NewProofModel(db, dbtoolConfig.DbSuffix))
+		if err != nil {
+			panic(err.Error())
+		}
+		var content []byte
+		if *bundleKey == "" {
+			var buf bytes.Buffer
+			for _, p := range proofs {
+				line, err := json.Marshal(p)
+				if err != nil {
+					panic(err.Error())
+				}
+				buf.Write(line)
+				buf.WriteByte('\n')
+			}
+			content = buf.Bytes()
+		} else {
+			if len(proofs) == 0 {
+				panic("there is no batch proof to bundle")
+			}
+			keyContent, err := os.ReadFile(*bundleKey)
+			if err != nil {
+				panic(err.Error())
+			}
+			seed, err := hex.DecodeString(strings.TrimSpace(string(keyContent)))
+			if err != nil || len(seed) != ed25519.SeedSize {
+				panic("invalid bundle key")
+			}
+			bundle := &verifier.ProofBundle{RoundId: proofs[0].RoundId, Timestamp: proofs[0].Timestamp, Proofs: proofs}
+			err = verifier.SignProofBundle(bundle, ed25519.NewKeyFromSeed(seed))
+			if err != nil {
+				panic(err.Error())
+			}
+			content, err = json.Marshal(bundle)
+			if err != nil {
+				panic(err.Error())
+			}
+			fmt.Println("the bundle public key is", bundle.PublicKey)
+		}
+		err = os.WriteFile(*exportProofs, content, 0644)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("export %d batch proofs successfully\n", len(proofs))
+	}
+
+	if *migrateWitness {
+		db, err := utils.OpenDatabase(dbtoolConfig.MysqlDataSource, &gorm.Config{
+			Logger: newLogger,
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+		witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+		latestHeight, err := witnessModel.GetLatestBatchWitnessHeight()
+		if err != nil {
+			panic(err.Error())
+		}
+		// 逐个批次迁移, 已经是当前格式的见证数据保持不变, 中断后可以重新执行
+		migratedCount := 0
+		for height := int64(0); height <= latestHeight; height++ {
+			w, err := witnessModel.GetBatchWitnessByHeight(height)
+			if err != nil {
+				fmt.Println("get witness failed: ", height)
+				panic(err.Error())
+			}
+			_, err = utils.DecodeWitnessHeader(w.WitnessData)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, utils.ErrLegacyWitnessFormat) {
+				fmt.Println("decode witness header failed: ", height)
+				panic(err.Error())
+			}
+			witnessData, err := utils.MigrateLegacyWitnessData(w.OpType, w.WitnessData, *migrateRoundId, *migrateTimestamp)
+			if err != nil {
+				fmt.Println("migrate witness failed: ", height)
+				panic(err.Error())
+			}
+			err = witnessModel.UpdateBatchWitnessData(height, witnessData)
+			if err != nil {
+				panic(err.Error())
+			}
+			migratedCount++
+		}
+		fmt.Printf("migrate %d of %d witness successfully\n", migratedCount, latestHeight+1)
+	}
 }
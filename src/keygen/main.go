@@ -1,25 +1,115 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"github.com/consensys/gnark-crypto/ecc"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
 
 	"runtime"
 	"time"
 
 	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/test/unsafekzg"
 
 	"strconv"
 
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
 )
 
+// loadKzgSrs 加载PLONK使用的KZG SRS
+// 从文件读取规范形式的SRS并计算拉格朗日形式, unsafeTestSrs为true时生成仅用于测试的不安全SRS,
+// 不安全SRS的toxic waste是已知的, 用它生成的密钥可以伪造证明
+func loadKzgSrs(ccs constraint.ConstraintSystem, srsFile string, unsafeTestSrs bool) (kzg.SRS, kzg.SRS, error) {
+	if unsafeTestSrs {
+		fmt.Println("WARNING: generate unsafe kzg srs, the keys can be used to forge proofs and are only for testing")
+		return unsafekzg.NewSRS(ccs)
+	}
+	if srsFile == "" {
+		return nil, nil, errors.New("srs file is required by plonk setup")
+	}
+	f, err := os.Open(srsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var srs kzg_bn254.SRS
+	_, err = srs.ReadFrom(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	sizeCanonical, sizeLagrange := plonk.SRSSize(ccs)
+	if len(srs.Pk.G1) < sizeCanonical {
+		return nil, nil, fmt.Errorf("srs size %d is less than required size %d", len(srs.Pk.G1), sizeCanonical)
+	}
+	srs.Pk.G1 = srs.Pk.G1[:sizeCanonical]
+	lagrangeG1, err := kzg_bn254.ToLagrangeG1(srs.Pk.G1[:sizeLagrange])
+	if err != nil {
+		return nil, nil, err
+	}
+	srsLagrange := &kzg_bn254.SRS{Vk: srs.Vk}
+	srsLagrange.Pk.G1 = lagrangeG1
+	return &srs, srsLagrange, nil
+}
+
+// setup 根据证明系统生成证明密钥和验证密钥
+func setup(provingSystem string, ccs constraint.ConstraintSystem, srsFile string, unsafeTestSrs bool) (utils.SnarkProvingKey, utils.SnarkVerifyingKey, error) {
+	if provingSystem == utils.ProvingSystemPlonk {
+		srs, srsLagrange, err := loadKzgSrs(ccs, srsFile, unsafeTestSrs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return plonk.Setup(ccs, srs, srsLagrange)
+	}
+	return groth16.Setup(ccs)
+}
+
 func main() {
+	provingSystemFlag := flag.String("proving_system", utils.ProvingSystemGroth16, "proving system used to generate keys: groth16 or plonk")
+	srsFile := flag.String("srs", "", "kzg srs file in canonical form used by plonk setup, required by plonk")
+	unsafeTestSrs := flag.Bool("unsafe_test_srs", false, "generate an unsafe kzg srs for plonk setup instead of -srs, the keys are only for testing")
+	circuitType := flag.String("circuit", "create", "circuit to generate keys for: create, update or delete")
+	circuitParamsFile := flag.String("circuit_params", "", "circuit params file, use the default circuit params if empty")
+	flag.Parse()
+	// 电路参数必须在选择批次层级之前设置
+	if err := utils.InitCircuitParams(*circuitParamsFile); err != nil {
+		panic(err)
+	}
+	fmt.Println("circuit params digest is", utils.GetCircuitParams().Digest())
+	var opsCountsTiers map[int]int
+	switch *circuitType {
+	case "create":
+		opsCountsTiers = utils.BatchCreateUserOpsCountsTiers
+	case "update":
+		opsCountsTiers = utils.BatchUpdateUserOpsCountsTiers
+	case "delete":
+		opsCountsTiers = utils.BatchDeleteUserOpsCountsTiers
+	default:
+		panic("unsupported circuit type: " + *circuitType)
+	}
+
+	provingSystem, err := utils.ParseProvingSystem(*provingSystemFlag)
+	if err != nil {
+		panic(err)
+	}
+	// plonk的密钥必须由可信的SRS生成, 在编译电路之前检查
+	if provingSystem == utils.ProvingSystemPlonk {
+		if *srsFile == "" && !*unsafeTestSrs {
+			panic("plonk setup requires -srs, or -unsafe_test_srs for testing")
+		}
+		if *srsFile != "" && *unsafeTestSrs {
+			panic("-srs and -unsafe_test_srs can not be used together")
+		}
+	}
+
 	// 启动一个后台协程定期执行垃圾回收
 	go func() {
 		for {
@@ -29,25 +119,34 @@ func main() {
 	}()
 
 	// 遍历不同用户组的配置(50种资产700用户/组, 500种资产92用户/组)
-	for k, v := range utils.BatchCreateUserOpsCountsTiers {
+	// 更新和删除用户电路的每批次用户数量见BatchUpdateUserOpsCountsTiers和BatchDeleteUserOpsCountsTiers
+	for k, v := range opsCountsTiers {
 		// 为每个用户组创建新的电路
 		// k: 资产数量(50/500)
 		// v: 每批次用户数量(700/92)
-		circuit := circuit.NewBatchCreateUserCircuit(
-			uint32(k),         // 资产数量
-			utils.AssetCounts, // 总资产类型数量
-			uint32(v),         // 批次用户数量
-		)
+		var circuitToCompile frontend.Circuit
+		switch *circuitType {
+		case "update":
+			circuitToCompile = circuit.NewBatchUpdateUserCircuit(uint32(k), uint32(utils.AssetCounts), uint32(v))
+		case "delete":
+			circuitToCompile = circuit.NewBatchDeleteUserCircuit(uint32(k), uint32(utils.AssetCounts), uint32(v))
+		default:
+			circuitToCompile = circuit.NewBatchCreateUserCircuit(
+				uint32(k),                 // 资产数量
+				uint32(utils.AssetCounts), // 总资产类型数量
+				uint32(v),                 // 批次用户数量
+			)
+		}
 
 		// 记录开始时间
 		startTime := time.Now()
 
-		// 编译电路生成R1CS约束系统
+		// 编译电路生成约束系统(groth16为R1CS, plonk为SparseR1CS)
 		oR1cs, err := frontend.Compile(
-			ecc.BN254.ScalarField(),              // 使用BN254曲线的标量域
-			r1cs.NewBuilder,                      // 使用R1CS构建器
-			circuit,                              // 电路实例
-			frontend.IgnoreUnconstrainedInputs(), // 忽略未约束的输入
+			ecc.BN254.ScalarField(),                // 使用BN254曲线的标量域
+			utils.NewCircuitBuilder(provingSystem), // 证明系统对应的构建器
+			circuitToCompile,                       // 电路实例
+			frontend.IgnoreUnconstrainedInputs(),   // 忽略未约束的输入
 		)
 		if err != nil {
 			panic(err)
@@ -55,13 +154,18 @@ func main() {
 
 		// 计算并打印编译耗时
 		endTime := time.Now()
-		fmt.Println("R1CS generation time is ", endTime.Sub(startTime))
+		fmt.Println(provingSystem, "constraint system generation time is ", endTime.Sub(startTime))
 
 		// 打印约束数量
-		fmt.Println("batch create user constraints number is ", oR1cs.GetNbConstraints())
+		fmt.Println("batch", *circuitType, "user constraints number is ", oR1cs.GetNbConstraints())
 
-		// 生成密钥文件名称 (例如: "zkpor50_700")
+		// 生成密钥文件名称 (例如: "zkpor50_700", 更新和删除用户电路为"zkpor50_350_update"和"zkpor50_700_delete", plonk再加上"_plonk")
+		// 非默认的电路参数再加上电路参数摘要, 例如"zkpor50_700_cp1a2b3c4d"
 		zkKeyName := "zkpor" + strconv.FormatInt(int64(k), 10) + "_" + strconv.FormatInt(int64(v), 10)
+		if *circuitType != "create" {
+			zkKeyName += "_" + *circuitType
+		}
+		zkKeyName = utils.ZkKeyName(zkKeyName+utils.GetCircuitParams().KeyNameSuffix(), provingSystem)
 
 		// 创建证明密钥文件(.pk)
 		pkFile, err := os.Create(zkKeyName + ".pk")
@@ -70,7 +174,7 @@ func main() {
 		}
 
 		// 生成证明密钥和验证密钥
-		pk, vk, err := groth16.Setup(oR1cs)
+		pk, vk, err := setup(provingSystem, oR1cs, *srsFile, *unsafeTestSrs)
 		if err != nil {
 			panic(err)
 		}
@@ -95,14 +199,29 @@ func main() {
 		}
 		fmt.Println("vk size is ", n)
 
-		// 创建R1CS约束系统文件(.r1cs)
-		r1csFile, _ := os.Create(zkKeyName + ".r1cs")
+		// 导出用于链上验证的solidity合约(.sol), 只能验证开启ForSolidity的prover生成的证明
+		solFile, err := os.Create(zkKeyName + ".sol")
+		if err != nil {
+			panic(err)
+		}
+		err = vk.ExportSolidity(solFile)
+		if err != nil {
+			panic(err)
+		}
+		solFile.Close()
+
+		// 创建约束系统文件(.r1cs或.scs)
+		r1csFile, err := os.Create(zkKeyName + utils.ConstraintSystemFileSuffix(provingSystem))
+		if err != nil {
+			panic(err)
+		}
 
-		// 写入R1CS约束系统
+		// 写入约束系统
 		n, err = oR1cs.WriteTo(r1csFile)
+		r1csFile.Close()
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println("r1cs size is ", n)
+		fmt.Println("constraint system size is ", n)
 	}
 }
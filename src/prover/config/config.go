@@ -7,6 +7,30 @@ type Config struct {
 		Host     	string
 		Password  	string
 	}
-	ZkKeyName []string
+	ZkKeyName        []string
+	UpdateZkKeyName  []string // 更新用户电路的密钥名称, 与AssetsCountTiers一一对应, 只在增量审计时需要
+	DeleteZkKeyName  []string // 删除用户电路的密钥名称, 与AssetsCountTiers一一对应, 只在增量审计时需要
 	AssetsCountTiers []int
+	ProvingSystem    string
+	ForAggregation   bool
+	ForSolidity      bool // 生成可被keygen导出的solidity合约验证的证明, 不能与ForAggregation同时开启
+	// 电路参数文件, 为空时使用默认的电路参数, 必须与keygen和witness使用的相同
+	CircuitParamsFile string
+	// 常驻模式: 任务队列为空时不退出, 继续等待witness服务生成的新批次, 也可以通过-daemon开启
+	Daemon bool
+	// 常驻内存的电路参数数量(默认1), 处理不同资产数量层级的批次时不需要反复加载密钥
+	ResidentKeys int
+	// 通过mmap读取密钥文件, 加载时文件内容不复制到堆内存; 解析后的密钥仍在堆内存中, 不减少常驻内存
+	MmapKeyFiles bool
+	// 启动时加载配置中所有的密钥并常驻内存
+	PreloadKeys bool
+	// 证明服务(-serve)的监听地址, 默认为:8080, 和最多等待的任务数量(默认16)
+	HttpListenAddr string
+	MaxPendingJobs int
+	// 任务队列类型: redis(默认), sql或memory, sql和memory不需要Redis
+	TaskQueue string
+	// 任务租约: prover标识(默认为主机名-进程号), 租约时长(秒, 默认600)和每个批次最多尝试的次数(默认3)
+	ProverId     string
+	LeaseSeconds int64
+	MaxRetries   int64
 }
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/config"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
@@ -28,10 +36,17 @@ func main() {
 	if len(proverConfig.AssetsCountTiers) != len(proverConfig.ZkKeyName) {
 		panic("asset tiers and asset tier names should have the same length")
 	}
+	// 加载电路参数, 密钥和见证数据必须使用相同的电路参数生成
+	err = utils.InitCircuitParams(proverConfig.CircuitParamsFile)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	// 3. 解析命令行参数
 	remotePasswdConfig := flag.String("remote_password_config", "", "fetch password from aws secretsmanager")
 	rerun := flag.Bool("rerun", false, "flag which indicates rerun proof generation")
+	daemon := flag.Bool("daemon", false, "keep waiting for new witness when the task queue is empty")
+	serve := flag.Bool("serve", false, "run the HTTP proving service instead of proving the witness table")
 	flag.Parse()
 
 	// 4. 处理远程密码配置
@@ -44,7 +59,60 @@ func main() {
 		proverConfig.MysqlDataSource = s
 	}
 
+	if *daemon {
+		proverConfig.Daemon = true
+	}
+
 	// 5. 创建并运行证明生成器
+	// 收到SIGINT或SIGTERM后处理完当前的批次再退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *serve {
+		runProvingService(ctx, proverConfig)
+		return
+	}
 	prover := prover.NewProver(proverConfig)
-	prover.Run(*rerun)
+	err = prover.Run(ctx, *rerun)
+	if err != nil {
+		fmt.Println("prover exit with error: ", err.Error())
+		stop()
+		os.Exit(1)
+	}
+}
+
+// runProvingService 运行HTTP证明服务, 不读取见证表
+// ctx取消后不再接收新的请求, 处理完当前的任务再退出
+func runProvingService(ctx context.Context, proverConfig *config.Config) {
+	p := prover.NewStandaloneProver(proverConfig)
+	if p.PreloadKeys {
+		err := p.PreloadSnarkParams()
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	service := prover.NewProvingService(p, proverConfig.MaxPendingJobs, prover.DefaultJobResultTTL)
+	addr := proverConfig.HttpListenAddr
+	if addr == "" {
+		addr = ":8080"
+	}
+	server := &http.Server{Addr: addr, Handler: service.Handler()}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.Run(ctx)
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	fmt.Println("proving service listen on ", addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err.Error())
+	}
+	<-done
+	fmt.Println("proving service is stopped")
 }
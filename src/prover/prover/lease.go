@@ -0,0 +1,160 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+// 租约的默认配置
+const (
+	DefaultLeaseDuration = 10 * time.Minute // 默认租约时长
+	DefaultMaxRetries    = 3                // 默认每个批次最多尝试的次数
+)
+
+// ErrFatal 与批次无关且无法恢复的失败, 例如密钥文件无法加载
+// prover释放当前批次的租约(不计入重试次数)后退出, 其他错误都可以重试
+var ErrFatal = errors.New("fatal prover error")
+
+// ErrSaveProof 证明无法保存, 通常是数据库暂时不可用
+// prover释放当前批次的租约(不计入重试次数), 等待一段时间后继续处理下一个批次
+var ErrSaveProof = errors.New("save proof failed")
+
+// leaseExpiredAt 从当前时间开始计算的租约过期时间(unix秒)
+func (p *Prover) leaseExpiredAt() int64 {
+	return time.Now().Add(p.LeaseDuration).Unix()
+}
+
+// keepBatchWitnessLease 启动后台续约, 每LeaseDuration/3续约一次
+// 返回停止续约的函数, 批次处理结束后必须调用
+func (p *Prover) keepBatchWitnessLease(height int64) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(p.LeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := p.witnessModel.RenewBatchWitnessLease(height, p.ProverId, p.leaseExpiredAt())
+				if errors.Is(err, witness.ErrLeaseLost) {
+					// 批次已被其他prover回收, 当前的证明仍会保存, 已存在时跳过
+					fmt.Printf("lease of witness %d is lost\n", height)
+					return
+				}
+				if err != nil {
+					fmt.Printf("renew lease of witness %d failed: %s\n", height, err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// failBatchWitness 记录批次的一次失败, 批次重新发布时加入任务队列
+func (p *Prover) failBatchWitness(height int64) {
+	status, err := p.witnessModel.FailBatchWitness(height, p.ProverId, p.MaxRetries)
+	if err != nil {
+		fmt.Printf("release lease of witness %d failed: %s\n", height, err.Error())
+		return
+	}
+	if status == witness.StatusFailed {
+		fmt.Printf("witness %d failed %d times, mark it as failed\n", height, p.MaxRetries)
+		return
+	}
+	p.pushTasks([]int64{height})
+}
+
+// finishBatchWitness 证明已经保存, 将批次标记为已完成
+// 正常模式下只有租约的持有者可以标记, 租约已被回收时由新的持有者在发现证明已存在后标记
+func (p *Prover) finishBatchWitness(height int64, rerun bool) {
+	var err error
+	if rerun {
+		err = p.witnessModel.UpdateBatchWitnessStatus(&witness.BatchWitness{Height: height}, witness.StatusFinished)
+	} else {
+		err = p.witnessModel.FinishBatchWitness(height, p.ProverId)
+	}
+	if errors.Is(err, witness.ErrLeaseLost) {
+		fmt.Printf("lease of witness %d is lost, the new lease owner will finish it\n", height)
+		return
+	}
+	if err != nil {
+		fmt.Println("update witness error:", err.Error())
+	}
+}
+
+// releaseBatchWitness 释放批次的租约并重新加入任务队列, 不计入重试次数
+func (p *Prover) releaseBatchWitness(height int64) {
+	err := p.witnessModel.ReleaseBatchWitnessLease(height, p.ProverId)
+	if err != nil {
+		fmt.Printf("release lease of witness %d failed: %s\n", height, err.Error())
+		return
+	}
+	p.pushTasks([]int64{height})
+}
+
+// requeueExpiredBatchWitness 回收租约过期的批次并重新加入任务队列
+func (p *Prover) requeueExpiredBatchWitness() {
+	requeued, failed, err := p.witnessModel.RequeueExpiredBatchWitness(time.Now().Unix(), p.MaxRetries)
+	if err != nil {
+		fmt.Println("requeue expired witness failed: ", err.Error())
+	}
+	for _, height := range failed {
+		fmt.Printf("lease of witness %d expired %d times, mark it as failed\n", height, p.MaxRetries)
+	}
+	if len(requeued) > 0 {
+		fmt.Printf("requeue %d witness whose lease expired: %v\n", len(requeued), requeued)
+		p.pushTasks(requeued)
+	}
+}
+
+// hasReceivedBatchWitness 是否还有其他prover正在处理的批次
+func (p *Prover) hasReceivedBatchWitness() bool {
+	counts, err := p.witnessModel.GetRowCounts()
+	if err != nil {
+		fmt.Println("get witness row counts failed: ", err.Error())
+		return false
+	}
+	return counts[2] > 0
+}
+
+// reloadTaskQueue 常驻模式下为没有外部生产者的任务队列加载新发布的批次
+func (p *Prover) reloadTaskQueue() {
+	queue, ok := p.taskQueue.(ReloadableTaskQueue)
+	if !ok {
+		return
+	}
+	if err := queue.Reload(); err != nil {
+		fmt.Println("reload task queue failed: ", err.Error())
+	}
+}
+
+// pushTasks 将批次高度加入任务队列
+func (p *Prover) pushTasks(heights []int64) {
+	err := p.taskQueue.Push(heights)
+	if err != nil {
+		// 批次已经是已发布状态, 可以通过dbtool -push_task_to_redis重新加入任务队列
+		fmt.Printf("push witness %v to task queue failed: %s\n", heights, err.Error())
+	}
+}
+
+// sleep 等待d时长, ctx取消时提前返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
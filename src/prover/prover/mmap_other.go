@@ -0,0 +1,14 @@
+//go:build !unix
+
+package prover
+
+import "os"
+
+// mmapFile 不支持mmap的平台上直接读入内存
+func mmapFile(name string) (data []byte, release func() error, err error) {
+	data, err = os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
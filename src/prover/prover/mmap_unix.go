@@ -0,0 +1,29 @@
+//go:build unix
+
+package prover
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile 以只读方式将文件映射到内存, 使用结束后必须调用release解除映射
+func mmapFile(name string) (data []byte, release func() error, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	data, err = syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
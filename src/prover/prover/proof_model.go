@@ -38,6 +38,11 @@ type (
 		AccountTreeRoots        string // 账户树根列表
 		BatchCommitment         string // 批次承诺
 		AssetsCount             int    // 资产数量
+		ProvingSystem           string // 生成该证明的证明系统(groth16/plonk)
+		HashToField             string // 生成该证明的hash-to-field模式(default/aggregation/solidity), 旧版本的证明表为空
+		OpType                  int64  // 批次的操作类型(创建用户/更新用户/删除用户)
+		RoundId                 uint64 // 审计轮次编号
+		Timestamp               uint64 // 审计快照的时间戳(unix秒)
 		BatchNumber             int64  `gorm:"index:idx_number,unique"` // 批次号(唯一索引)
 	}
 )
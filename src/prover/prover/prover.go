@@ -8,8 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"runtime"
-	"strconv"
 	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
@@ -17,36 +15,49 @@ import (
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/constraint/solver"
 	"github.com/consensys/gnark/frontend"
 	"github.com/redis/go-redis/v9"
-
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 )
 
 // Prover 结构体定义了零知识证明生成器
 type Prover struct {
 	witnessModel witness.WitnessModel // 见证数据模型
 	proofModel   ProofModel           // 证明数据模型
-	redisCli     *redis.Client        // Redis客户端
-
-	VerifyingKey     groth16.VerifyingKey        // 验证密钥
-	ProvingKey       groth16.ProvingKey          // 证明密钥
-	SessionName      []string                    // 会话名称列表
-	AssetsCountTiers []int                       // 资产数量层级
-	R1cs             constraint.ConstraintSystem // 约束系统
-
-	CurrentSnarkParamsInUse int    // 当前使用的SNARK参数
-	TaskQueueName           string // 任务队列名称
+	taskQueue    TaskQueue            // 任务队列
+	fetchTimeout time.Duration        // 从任务队列领取批次的最长等待时间, 也是ctx取消后最长的退出延迟
+
+	VerifyingKey      utils.SnarkVerifyingKey     // 验证密钥
+	ProvingKey        utils.SnarkProvingKey       // 证明密钥
+	SessionName       []string                    // 会话名称列表
+	UpdateSessionName []string                    // 更新用户电路的会话名称列表
+	DeleteSessionName []string                    // 删除用户电路的会话名称列表
+	AssetsCountTiers  []int                       // 资产数量层级
+	R1cs              constraint.ConstraintSystem // 约束系统
+	ProvingSystem     string                      // 证明系统(groth16/plonk)
+	HashToField       string                      // 证明的hash-to-field模式, 由配置的ForAggregation和ForSolidity决定
+
+	CurrentSnarkParamsInUse int // 当前使用的SNARK参数
+	CurrentOpTypeInUse      int // 当前使用的SNARK参数对应的操作类型
+
+	ResidentKeys   int            // 常驻内存的电路参数数量, 默认为1
+	MmapKeyFiles   bool           // 通过mmap读取参数文件, 只减少加载时的临时内存
+	PreloadKeys    bool           // 启动时加载配置中所有电路的参数
+	residentParams []*snarkParams // 常驻内存的电路参数, 最近使用的在前
+
+	ProverId      string        // prover标识, 作为租约的持有者
+	LeaseDuration time.Duration // 租约时长, 每LeaseDuration/3续约一次
+	MaxRetries    int64         // 每个批次最多尝试的次数, 用完后标记为失败
+	Daemon        bool          // 常驻模式, 任务队列为空时继续等待新的批次
 }
 
 // NewProver 创建新的证明生成器实例
 func NewProver(config *config.Config) *Prover {
+	prover := NewStandaloneProver(config)
+
 	// 初始化数据库连接
-	db, err := gorm.Open(mysql.Open(config.MysqlDataSource))
+	db, err := utils.OpenDatabase(config.MysqlDataSource)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -56,17 +67,91 @@ func NewProver(config *config.Config) *Prover {
 		Addr:     config.Redis.Host,
 		Password: config.Redis.Password,
 	})
-	taskQueueName := "por_batch_task_queue_" + config.DbSuffix
+	// Redis队列只由dbtool -push_task_to_redis填充, 常驻模式下无法得到witness服务新发布的批次
+	if config.Daemon && (config.TaskQueue == "" || config.TaskQueue == TaskQueueRedis) {
+		panic("the redis task queue is only filled by dbtool -push_task_to_redis, use the sql or memory task queue in daemon mode")
+	}
+	witnessModel := witness.NewWitnessModel(db, config.DbSuffix)
+	taskQueue, err := NewTaskQueue(config.TaskQueue, witnessModel, redisCli, config.DbSuffix)
+	if err != nil {
+		panic(err.Error())
+	}
+	prover.witnessModel = witnessModel
+	prover.proofModel = NewProofModel(db, config.DbSuffix)
+	prover.taskQueue = taskQueue
+	return prover
+}
+
+// NewStandaloneProver 创建不连接数据库和任务队列的证明生成器
+// 只能通过GenerateAndVerifyProof等方法直接生成证明, 用于证明服务
+func NewStandaloneProver(config *config.Config) *Prover {
+	provingSystem, err := utils.ParseProvingSystem(config.ProvingSystem)
+	if err != nil {
+		panic(err.Error())
+	}
+	if config.ForAggregation && provingSystem != utils.ProvingSystemGroth16 {
+		panic("only groth16 proofs can be aggregated")
+	}
+	if config.ForAggregation && config.ForSolidity {
+		panic("ForAggregation and ForSolidity can not be enabled at the same time")
+	}
+	// 密钥必须由当前的电路参数生成, 资产数量层级必须是当前电路参数中的层级
+	for _, keyNames := range [][]string{config.ZkKeyName, config.UpdateZkKeyName, config.DeleteZkKeyName} {
+		for _, keyName := range keyNames {
+			if err := utils.CheckKeyNameCircuitParams(keyName); err != nil {
+				panic(err.Error())
+			}
+		}
+	}
+	for _, tier := range config.AssetsCountTiers {
+		if _, ok := utils.BatchCreateUserOpsCountsTiers[tier]; !ok {
+			panic(fmt.Sprintf("assets count tier %d is not in the circuit params", tier))
+		}
+	}
+
+	proverId := config.ProverId
+	if proverId == "" {
+		hostname, _ := os.Hostname()
+		proverId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	leaseDuration := time.Duration(config.LeaseSeconds) * time.Second
+	if leaseDuration <= 0 {
+		leaseDuration = DefaultLeaseDuration
+	}
+	maxRetries := config.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	// 预加载时所有电路的参数都常驻内存
+	residentKeys := config.ResidentKeys
+	if config.PreloadKeys {
+		keysCount := len(config.ZkKeyName) + len(config.UpdateZkKeyName) + len(config.DeleteZkKeyName)
+		if residentKeys < keysCount {
+			residentKeys = keysCount
+		}
+	}
+	if residentKeys <= 0 {
+		residentKeys = 1
+	}
 
 	// 创建Prover实例
 	prover := Prover{
-		witnessModel:            witness.NewWitnessModel(db, config.DbSuffix),
-		proofModel:              NewProofModel(db, config.DbSuffix),
-		redisCli:                redisCli,
+		fetchTimeout:            10 * time.Second,
 		SessionName:             config.ZkKeyName,
+		UpdateSessionName:       config.UpdateZkKeyName,
+		DeleteSessionName:       config.DeleteZkKeyName,
 		AssetsCountTiers:        config.AssetsCountTiers,
+		ProvingSystem:           provingSystem,
+		HashToField:             utils.HashToFieldMode(config.ForAggregation, config.ForSolidity),
 		CurrentSnarkParamsInUse: 0,
-		TaskQueueName:           taskQueueName,
+		ProverId:                proverId,
+		LeaseDuration:           leaseDuration,
+		MaxRetries:              maxRetries,
+		Daemon:                  config.Daemon,
+		ResidentKeys:            residentKeys,
+		MmapKeyFiles:            config.MmapKeyFiles,
+		PreloadKeys:             config.PreloadKeys,
 	}
 
 	// std.RegisterHints()
@@ -74,36 +159,15 @@ func NewProver(config *config.Config) *Prover {
 	return &prover
 }
 
-// fetchTasksByRedis 从Redis队列获取任务
-func (p *Prover) fetchTasksByRedis() (int, error) {
-	var ctx = context.Background()
-	// 阻塞式获取任务，超时时间10秒
-	batchHeightStr, err := p.redisCli.BRPop(ctx, 10*time.Second, p.TaskQueueName).Result()
-	if err != nil {
-		return -1, err
-	}
-
-	batchHeight, err := strconv.Atoi(batchHeightStr[1])
-	if err != nil {
-		return -1, err
-	}
-	return batchHeight, nil
-}
-
 // FetchBatchWitness 获取批次见证数据
+// 领取批次时获得租约, 批次已被领取或已完成时返回DbErrNotFound, 队列为空时返回ErrTaskQueueEmpty
 func (p *Prover) FetchBatchWitness() ([]*witness.BatchWitness, error) {
-	// 从Redis获取任务
-	batchHeight, err := p.fetchTasksByRedis()
-	if err != nil {
-		return nil, err
-	}
-
-	// Fetch unproved block witness.
-	blockWitnesses, err := p.witnessModel.GetAndUpdateBatchesWitnessByHeight(batchHeight, witness.StatusPublished, witness.StatusReceived)
+	// 从任务队列领取批次, 最多等待fetchTimeout, 优先领取已加载密钥的批次
+	blockWitness, err := p.taskQueue.Fetch(p.ProverId, p.leaseExpiredAt(), p.residentTiers(), p.fetchTimeout)
 	if err != nil {
 		return nil, err
 	}
-	return blockWitnesses, nil
+	return []*witness.BatchWitness{blockWitness}, nil
 }
 
 func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
@@ -120,34 +184,61 @@ func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
 }
 
 // Run 运行证明生成器主循环
+// ctx取消后处理完当前的批次再返回; 非常驻模式下所有批次都结束后返回
 // 参数:
+//   - ctx: 用于停止prover, 例如收到SIGTERM信号时取消
 //   - flag: 是否重新运行标志
-func (p *Prover) Run(flag bool) {
-	// 创建证明表
+//
+// 返回:
+//   - error: 导致prover退出的错误, 正常结束和ctx取消时返回nil
+func (p *Prover) Run(ctx context.Context, flag bool) error {
+	// 创建证明表, 并为之前版本创建的见证表增加租约字段
 	p.proofModel.CreateProofTable()
+	err := p.witnessModel.CreateBatchWitnessTable()
+	if err != nil {
+		return fmt.Errorf("migrate witness table failed: %w", err)
+	}
+	if p.PreloadKeys {
+		err = p.PreloadSnarkParams()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFatal, err)
+		}
+	}
 
 	// 主循环
-	for {
+	for ctx.Err() == nil {
 		var batchWitnesses []*witness.BatchWitness
-		var err error
 
 		// 根据运行模式获取见证数据
 		if !flag {
-			// 正常模式：从Redis队列获取任务
+			// 正常模式：先回收租约过期的批次, 再从任务队列获取任务
+			p.requeueExpiredBatchWitness()
 			batchWitnesses, err = p.FetchBatchWitness()
 			if errors.Is(err, utils.DbErrNotFound) {
-				fmt.Println("there is no published status witness in db, so quit")
-				fmt.Println("prover run finish...")
-				return
+				// 队列中的任务可能已被其他prover领取或回收后重复加入队列
+				fmt.Println("the witness is not in published status, skip it")
+				sleep(ctx, time.Second)
+				continue
 			}
-			if errors.Is(err, redis.Nil) {
+			if errors.Is(err, ErrTaskQueueEmpty) {
+				// 常驻模式下witness服务可能还在生成批次, 重新加载新发布的批次后继续等待
+				if p.Daemon {
+					p.reloadTaskQueue()
+					continue
+				}
+				// 其他prover持有的批次租约过期后会重新加入队列, 所有批次都结束后才退出
+				if p.hasReceivedBatchWitness() {
+					fmt.Println("There is no task left in task queue, wait for the received witness")
+					continue
+				}
 				fmt.Println("There is no task left in task queue")
 				fmt.Println("prover run finish...")
-				return
+				return nil
 			}
 			if err != nil {
+				// 数据库或任务队列暂时不可用, 稍后重试
 				fmt.Println("get batch witness failed: ", err.Error())
-				time.Sleep(10 * time.Second)
+				sleep(ctx, 10*time.Second)
 				continue
 			}
 		} else {
@@ -156,243 +247,277 @@ func (p *Prover) Run(flag bool) {
 			if errors.Is(err, utils.DbErrNotFound) {
 				fmt.Println("there is no received status witness in db, so quit")
 				fmt.Println("prover rerun finish...")
-				return
+				return nil
 			}
 			if err != nil {
-				fmt.Println("something wrong happened, err is ", err.Error())
-				return
+				return fmt.Errorf("fetch witness for rerun failed: %w", err)
 			}
 		}
 
-		// 处理每个批次的见证数据
+		// 处理每个批次的见证数据, ctx取消时也会处理完已领取的批次
 		for _, batchWitness := range batchWitnesses {
-			// 解码见证数据
-			witnessForCircuit := utils.DecodeBatchWitness(batchWitness.WitnessData)
-
-			// 准备CEX资产列表承诺和账户树根
-			cexAssetListCommitments := make([][]byte, 2)
-			cexAssetListCommitments[0] = witnessForCircuit.BeforeCEXAssetsCommitment
-			cexAssetListCommitments[1] = witnessForCircuit.AfterCEXAssetsCommitment
-			accountTreeRoots := make([][]byte, 2)
-			accountTreeRoots[0] = witnessForCircuit.BeforeAccountTreeRoot
-			accountTreeRoots[1] = witnessForCircuit.AfterAccountTreeRoot
-			cexAssetListCommitmentsSerial, err := json.Marshal(cexAssetListCommitments)
-			if err != nil {
-				fmt.Println("marshal cex asset list failed: ", err.Error())
-				return
+			// 正常模式下持续续约, 直到批次处理结束
+			stopLease := func() {}
+			if !flag {
+				stopLease = p.keepBatchWitnessLease(batchWitness.Height)
 			}
-			accountTreeRootsSerial, err := json.Marshal(accountTreeRoots)
-			if err != nil {
-				fmt.Println("marshal account tree root failed: ", err.Error())
-				return
-			}
-
-			// 生成和验证证明
-			proof, assetsCount, err := p.GenerateAndVerifyProof(witnessForCircuit, batchWitness.Height)
-			if err != nil {
-				fmt.Println("generate and verify proof error:", err.Error())
-				return
-			}
-
-			// 序列化证明数据
-			var buf bytes.Buffer
-			_, err = proof.WriteRawTo(&buf)
-			if err != nil {
-				fmt.Println("proof serialize failed")
-				return
-			}
-			proofBytes := buf.Bytes()
-			//formateProof, _ := FormatProof(proof, witnessForCircuit.BatchCommitment)
-			//proofBytes, err := json.Marshal(formateProof)
-			//if err != nil {
-			//	fmt.Println("marshal batch proof failed: ", err.Error())
-			//	return
-			//}
-
-			// Check the existence of block proof.
-			_, err = p.proofModel.GetProofByBatchNumber(batchWitness.Height)
+			err = p.proveBatchWitness(batchWitness, flag)
+			stopLease()
 			if err == nil {
-				fmt.Printf("blockProof of height %d exists\n", batchWitness.Height)
-				err = p.witnessModel.UpdateBatchWitnessStatus(batchWitness, witness.StatusFinished)
-				if err != nil {
-					fmt.Println("update witness error:", err.Error())
-				}
 				continue
 			}
-
-			var row = &Proof{
-				ProofInfo:               base64.StdEncoding.EncodeToString(proofBytes),
-				BatchNumber:             batchWitness.Height,
-				CexAssetListCommitments: string(cexAssetListCommitmentsSerial),
-				AccountTreeRoots:        string(accountTreeRootsSerial),
-				BatchCommitment:         base64.StdEncoding.EncodeToString(witnessForCircuit.BatchCommitment),
-				AssetsCount:             assetsCount,
+			if flag {
+				return err
 			}
-			err = p.proofModel.CreateProof(row)
-			if err != nil {
-				fmt.Printf("create blockProof of height %d failed\n", batchWitness.Height)
-				return
+			if errors.Is(err, ErrFatal) {
+				// 与批次无关的失败, 批次交给其他prover
+				p.releaseBatchWitness(batchWitness.Height)
+				return err
 			}
-			err = p.witnessModel.UpdateBatchWitnessStatus(batchWitness, witness.StatusFinished)
-			if err != nil {
-				fmt.Println("update witness error:", err.Error())
+			if errors.Is(err, ErrSaveProof) {
+				// 数据库暂时不可用, 批次重新加入队列, 稍后重试
+				fmt.Println(err.Error())
+				p.releaseBatchWitness(batchWitness.Height)
+				sleep(ctx, 10*time.Second)
+				continue
 			}
+			// 证明生成失败, 释放租约后重试或标记为失败
+			fmt.Printf("prove witness of height %d failed: %s\n", batchWitness.Height, err.Error())
+			p.failBatchWitness(batchWitness.Height)
 		}
 	}
+	fmt.Println("prover is stopped")
+	return nil
 }
 
-func (p *Prover) GenerateAndVerifyProof(
-	batchWitness *utils.BatchCreateUserWitness,
-	batchNumber int64,
-) (proof groth16.Proof, assetsCount int, err error) {
-	startTime := time.Now().UnixMilli()
-	fmt.Println("begin to generate proof for batch: ", batchNumber)
-	circuitWitness, _ := circuit.SetBatchCreateUserCircuitWitness(batchWitness)
-	// Lazy load r1cs, proving key and verifying key.
-	p.LoadSnarkParamsOnce(len(circuitWitness.CreateUserOps[0].Assets))
-	verifyWitness := circuit.NewVerifyBatchCreateUserCircuit(batchWitness.BatchCommitment)
-	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
-	if err != nil {
-		return proof, 0, err
+// proveBatchWitness 为一个批次生成证明并保存
+// 密钥无法加载时返回ErrFatal, 证明保存失败时返回ErrSaveProof, 其他错误与批次有关, 可以重试
+// 参数:
+//   - batchWitness: 批次见证数据
+//   - rerun: 是否为重新运行模式, 重新运行模式下没有租约
+func (p *Prover) proveBatchWitness(batchWitness *witness.BatchWitness, rerun bool) (err error) {
+	// 解码见证数据, 生成和验证证明
+	var states batchStates
+	var proof utils.SnarkProof
+	var assetsCount int
+	if batchWitness.OpType == utils.OpTypeUpdateUser {
+		var witnessForCircuit *utils.BatchUpdateUserWitness
+		witnessForCircuit, err = utils.DecodeBatchUpdateWitness(batchWitness.WitnessData)
+		if err != nil {
+			return fmt.Errorf("decode batch witness failed: %w", err)
+		}
+		states = batchStates{
+			BatchCommitment:           witnessForCircuit.BatchCommitment,
+			BeforeAccountTreeRoot:     witnessForCircuit.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:      witnessForCircuit.AfterAccountTreeRoot,
+			BeforeCEXAssetsCommitment: witnessForCircuit.BeforeCEXAssetsCommitment,
+			AfterCEXAssetsCommitment:  witnessForCircuit.AfterCEXAssetsCommitment,
+			RoundId:                   witnessForCircuit.RoundId,
+			Timestamp:                 witnessForCircuit.Timestamp,
+		}
+		proof, assetsCount, err = p.GenerateAndVerifyUpdateProof(witnessForCircuit, batchWitness.Height)
+	} else if batchWitness.OpType == utils.OpTypeDeleteUser {
+		var witnessForCircuit *utils.BatchDeleteUserWitness
+		witnessForCircuit, err = utils.DecodeBatchDeleteWitness(batchWitness.WitnessData)
+		if err != nil {
+			return fmt.Errorf("decode batch witness failed: %w", err)
+		}
+		states = batchStates{
+			BatchCommitment:           witnessForCircuit.BatchCommitment,
+			BeforeAccountTreeRoot:     witnessForCircuit.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:      witnessForCircuit.AfterAccountTreeRoot,
+			BeforeCEXAssetsCommitment: witnessForCircuit.BeforeCEXAssetsCommitment,
+			AfterCEXAssetsCommitment:  witnessForCircuit.AfterCEXAssetsCommitment,
+			RoundId:                   witnessForCircuit.RoundId,
+			Timestamp:                 witnessForCircuit.Timestamp,
+		}
+		proof, assetsCount, err = p.GenerateAndVerifyDeleteProof(witnessForCircuit, batchWitness.Height)
+	} else {
+		var witnessForCircuit *utils.BatchCreateUserWitness
+		witnessForCircuit, err = utils.DecodeBatchWitness(batchWitness.WitnessData)
+		if err != nil {
+			return fmt.Errorf("decode batch witness failed: %w", err)
+		}
+		states = batchStates{
+			BatchCommitment:           witnessForCircuit.BatchCommitment,
+			BeforeAccountTreeRoot:     witnessForCircuit.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:      witnessForCircuit.AfterAccountTreeRoot,
+			BeforeCEXAssetsCommitment: witnessForCircuit.BeforeCEXAssetsCommitment,
+			AfterCEXAssetsCommitment:  witnessForCircuit.AfterCEXAssetsCommitment,
+			RoundId:                   witnessForCircuit.RoundId,
+			Timestamp:                 witnessForCircuit.Timestamp,
+		}
+		proof, assetsCount, err = p.GenerateAndVerifyProof(witnessForCircuit, batchWitness.Height)
 	}
-
-	vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
-	if err != nil {
-		return proof, 0, err
+	if errors.Is(err, ErrFatal) {
+		return err
 	}
-	proof, err = groth16.Prove(p.R1cs, p.ProvingKey, witness)
 	if err != nil {
-		return proof, 0, err
+		return fmt.Errorf("generate and verify proof error: %w", err)
 	}
-	endTime := time.Now().UnixMilli()
-	fmt.Println("proof generation cost ", endTime-startTime, " ms")
 
-	err = groth16.Verify(proof, p.VerifyingKey, vWitness)
+	row, err := newProofRow(batchWitness.Height, batchWitness.OpType, p.ProvingSystem, p.HashToField, &states, proof, assetsCount)
 	if err != nil {
-		return proof, 0, err
+		return err
 	}
-	endTime2 := time.Now().UnixMilli()
-	fmt.Println("proof verification cost ", endTime2-endTime, " ms")
-	return proof, len(circuitWitness.CreateUserOps[0].Assets), nil
+	return p.saveProof(row, rerun)
 }
 
-// LoadSnarkParamsOnce 加载SNARK参数(仅加载一次)
-// 该函数负责加载零知识证明所需的密钥和约束系统
-// 包括：R1CS约束系统、证明密钥(pk)和验证密钥(vk)
-//
-// 参数:
-//   - targerAssetsCount: 目标资产数量，用于选择对应的参数文件
-//
-// 工作流程:
-// 1. 检查是否需要重新加载
-// 2. 查找对应的参数文件
-// 3. 加载R1CS约束系统
-// 4. 加载证明密钥
-// 5. 加载验证密钥
-func (p *Prover) LoadSnarkParamsOnce(targerAssetsCount int) {
-	// 1. 检查是否需要重新加载
-	// 如果当前已加载的参数与目标资产数量相同，则直接返回
-	if targerAssetsCount == p.CurrentSnarkParamsInUse {
-		return
-	}
-
-	// 2. 查找对应的参数文件索引
-	index := -1
-	for i, v := range p.AssetsCountTiers {
-		if targerAssetsCount == v {
-			index = i
-			break
-		}
+// saveProof 保存批次的证明并将批次标记为已完成
+// 租约过期后可能有两个prover为同一批次生成证明, 证明已经存在时视为已完成
+// 保存失败并且证明不存在时返回ErrSaveProof
+func (p *Prover) saveProof(row *Proof, rerun bool) error {
+	// Check the existence of block proof.
+	_, err := p.proofModel.GetProofByBatchNumber(row.BatchNumber)
+	if err == nil {
+		fmt.Printf("blockProof of height %d exists\n", row.BatchNumber)
+		p.finishBatchWitness(row.BatchNumber, rerun)
+		return nil
 	}
-	if index == -1 {
-		panic("the assets count is not in the config file")
-	}
-
-	// 3. 加载R1CS约束系统
-	s := time.Now()
-	fmt.Println("begin loading r1cs of ", targerAssetsCount, " assets")
 
-	// 创建加载完成通知通道
-	loadR1csChan := make(chan bool)
-
-	// 启动GC协程，定期清理内存
-	go func() {
-		for {
-			select {
-			case <-loadR1csChan:
-				fmt.Println("load r1cs finished...... quit")
-				return
-			case <-time.After(time.Second * 10):
-				runtime.GC() // 每10秒执行一次GC
-			}
+	err = p.proofModel.CreateProof(row)
+	if err != nil {
+		// 两个prover同时保存时, 后保存的违反批次号的唯一索引
+		if _, getErr := p.proofModel.GetProofByBatchNumber(row.BatchNumber); getErr == nil {
+			fmt.Printf("blockProof of height %d is created by another prover\n", row.BatchNumber)
+			p.finishBatchWitness(row.BatchNumber, rerun)
+			return nil
 		}
-	}()
-
-	// 初始化R1CS约束系统
-	p.R1cs = groth16.NewCS(ecc.BN254)
+		return fmt.Errorf("%w: create blockProof of height %d failed: %w", ErrSaveProof, row.BatchNumber, err)
+	}
+	p.finishBatchWitness(row.BatchNumber, rerun)
+	return nil
+}
 
-	// 读取R1CS文件
-	r1csFromFile, err := os.ReadFile(p.SessionName[index] + ".r1cs")
+// newProofRow 序列化证明和批次的状态承诺, 生成证明表中的一行
+func newProofRow(height int64, opType int64, provingSystem string, hashToField string, states *batchStates, proof utils.SnarkProof, assetsCount int) (*Proof, error) {
+	// 准备CEX资产列表承诺和账户树根
+	cexAssetListCommitments := make([][]byte, 2)
+	cexAssetListCommitments[0] = states.BeforeCEXAssetsCommitment
+	cexAssetListCommitments[1] = states.AfterCEXAssetsCommitment
+	accountTreeRoots := make([][]byte, 2)
+	accountTreeRoots[0] = states.BeforeAccountTreeRoot
+	accountTreeRoots[1] = states.AfterAccountTreeRoot
+	cexAssetListCommitmentsSerial, err := json.Marshal(cexAssetListCommitments)
 	if err != nil {
-		panic("r1cs file load error..." + err.Error())
+		return nil, fmt.Errorf("marshal cex asset list failed: %w", err)
 	}
-
-	// 解析R1CS数据
-	buf := bytes.NewBuffer(r1csFromFile)
-	n, err := p.R1cs.ReadFrom(buf)
+	accountTreeRootsSerial, err := json.Marshal(accountTreeRoots)
 	if err != nil {
-		panic("r1cs read error..." + err.Error())
+		return nil, fmt.Errorf("marshal account tree root failed: %w", err)
 	}
-	fmt.Println("r1cs read size is ", n)
 
-	// 通知R1CS加载完成
-	loadR1csChan <- true
-	runtime.GC()
-	et := time.Now()
-	fmt.Println("finish loading r1cs.... the time cost is ", et.Sub(s))
+	// 序列化证明数据
+	var buf bytes.Buffer
+	_, err = proof.WriteRawTo(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("proof serialize failed: %w", err)
+	}
+	return &Proof{
+		ProofInfo:               base64.StdEncoding.EncodeToString(buf.Bytes()),
+		BatchNumber:             height,
+		CexAssetListCommitments: string(cexAssetListCommitmentsSerial),
+		AccountTreeRoots:        string(accountTreeRootsSerial),
+		BatchCommitment:         base64.StdEncoding.EncodeToString(states.BatchCommitment),
+		AssetsCount:             assetsCount,
+		ProvingSystem:           provingSystem,
+		HashToField:             hashToField,
+		OpType:                  opType,
+		RoundId:                 states.RoundId,
+		Timestamp:               states.Timestamp,
+	}, nil
+}
 
-	// 4. 加载证明密钥(Proving Key)
-	fmt.Println("begin loading proving key of ", targerAssetsCount, " assets")
-	s = time.Now()
+// batchStates 批次见证数据中需要和证明一起保存的状态承诺
+type batchStates struct {
+	BatchCommitment           []byte
+	BeforeAccountTreeRoot     []byte
+	AfterAccountTreeRoot      []byte
+	BeforeCEXAssetsCommitment []byte
+	AfterCEXAssetsCommitment  []byte
+	RoundId                   uint64
+	Timestamp                 uint64
+}
 
-	// 读取证明密钥文件
-	pkFromFile, err := os.ReadFile(p.SessionName[index] + ".pk")
+// GenerateAndVerifyProof 为批量创建用户的见证数据生成并验证证明
+func (p *Prover) GenerateAndVerifyProof(
+	batchWitness *utils.BatchCreateUserWitness,
+	batchNumber int64,
+) (proof utils.SnarkProof, assetsCount int, err error) {
+	fmt.Println("begin to generate proof for batch: ", batchNumber)
+	circuitWitness, err := circuit.SetBatchCreateUserCircuitWitness(batchWitness)
 	if err != nil {
-		panic("provingKey file load error:" + err.Error())
+		return proof, 0, err
 	}
+	assetsCount = len(circuitWitness.CreateUserOps[0].Assets)
+	verifyWitness := circuit.NewVerifyBatchCreateUserCircuit(batchWitness.BatchCommitment, batchWitness.RoundId, batchWitness.Timestamp)
+	proof, err = p.proveAndVerify(utils.OpTypeCreateUser, assetsCount, circuitWitness, verifyWitness)
+	return proof, assetsCount, err
+}
 
-	// 解析证明密钥数据
-	buf = bytes.NewBuffer(pkFromFile)
-	p.ProvingKey = groth16.NewProvingKey(ecc.BN254)
-	n, err = p.ProvingKey.UnsafeReadFrom(buf)
+// GenerateAndVerifyUpdateProof 为批量更新用户的见证数据生成并验证证明
+func (p *Prover) GenerateAndVerifyUpdateProof(
+	batchWitness *utils.BatchUpdateUserWitness,
+	batchNumber int64,
+) (proof utils.SnarkProof, assetsCount int, err error) {
+	fmt.Println("begin to generate update proof for batch: ", batchNumber)
+	circuitWitness, err := circuit.SetBatchUpdateUserCircuitWitness(batchWitness)
 	if err != nil {
-		panic("provingKey loading error:" + err.Error())
+		return proof, 0, err
 	}
-	fmt.Println("proving key read size is ", n)
-	et = time.Now()
-	fmt.Println("finish loading proving key... the time cost is ", et.Sub(s))
+	assetsCount = len(circuitWitness.UpdateUserOps[0].Assets)
+	verifyWitness := circuit.NewVerifyBatchUpdateUserCircuit(batchWitness.BatchCommitment, batchWitness.RoundId, batchWitness.Timestamp)
+	proof, err = p.proveAndVerify(utils.OpTypeUpdateUser, assetsCount, circuitWitness, verifyWitness)
+	return proof, assetsCount, err
+}
 
-	// 5. 加载验证密钥(Verifying Key)
-	fmt.Println("begin loading verifying key of ", targerAssetsCount, " assets")
-	s = time.Now()
+// GenerateAndVerifyDeleteProof 为批量删除用户的见证数据生成并验证证明
+func (p *Prover) GenerateAndVerifyDeleteProof(
+	batchWitness *utils.BatchDeleteUserWitness,
+	batchNumber int64,
+) (proof utils.SnarkProof, assetsCount int, err error) {
+	fmt.Println("begin to generate delete proof for batch: ", batchNumber)
+	circuitWitness, err := circuit.SetBatchDeleteUserCircuitWitness(batchWitness)
+	if err != nil {
+		return proof, 0, err
+	}
+	assetsCount = len(circuitWitness.DeleteUserOps[0].AssetIndexes)
+	verifyWitness := circuit.NewVerifyBatchDeleteUserCircuit(batchWitness.BatchCommitment, batchWitness.RoundId, batchWitness.Timestamp)
+	proof, err = p.proveAndVerify(utils.OpTypeDeleteUser, assetsCount, circuitWitness, verifyWitness)
+	return proof, assetsCount, err
+}
 
-	// 读取验证密钥文件
-	vkFromFile, err := os.ReadFile(p.SessionName[index] + ".vk")
+// proveAndVerify 加载操作类型和资产数量对应的SNARK参数, 生成并验证证明
+func (p *Prover) proveAndVerify(opType int, assetsCount int, circuitWitness frontend.Circuit, verifyWitness frontend.Circuit) (proof utils.SnarkProof, err error) {
+	startTime := time.Now().UnixMilli()
+	// Lazy load r1cs, proving key and verifying key.
+	err = p.LoadSnarkParamsOnce(opType, assetsCount)
 	if err != nil {
-		panic("verifyingKey file load error:" + err.Error())
+		return proof, fmt.Errorf("%w: %w", ErrFatal, err)
+	}
+	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
+	if err != nil {
+		return proof, err
 	}
 
-	// 解析验证密钥数据
-	buf = bytes.NewBuffer(vkFromFile)
-	p.VerifyingKey = groth16.NewVerifyingKey(ecc.BN254)
-	n, err = p.VerifyingKey.ReadFrom(buf)
+	vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
 	if err != nil {
-		panic("verifyingKey loading error:" + err.Error())
+		return proof, err
 	}
-	fmt.Println("verifying key read size is ", n)
-	et = time.Now()
-	fmt.Println("finish loading verifying key.. the time cost is ", et.Sub(s))
+	proverOpts := utils.HashToFieldProverOptions(p.HashToField, p.ProvingSystem)
+	verifierOpts := utils.HashToFieldVerifierOptions(p.HashToField, p.ProvingSystem)
+	proof, err = utils.SnarkProve(p.ProvingSystem, p.R1cs, p.ProvingKey, witness, proverOpts...)
+	if err != nil {
+		return proof, err
+	}
+	endTime := time.Now().UnixMilli()
+	fmt.Println("proof generation cost ", endTime-startTime, " ms")
 
-	// 更新当前使用的参数
-	p.CurrentSnarkParamsInUse = targerAssetsCount
+	err = utils.SnarkVerify(p.ProvingSystem, proof, p.VerifyingKey, vWitness, verifierOpts...)
+	if err != nil {
+		return proof, err
+	}
+	endTime2 := time.Now().UnixMilli()
+	fmt.Println("proof verification cost ", endTime2-endTime, " ms")
+	return proof, nil
 }
@@ -191,7 +191,7 @@ func TestMockProver(t *testing.T) {
 					fmt.Printf("线程 #%d: 共处理 %d 条数据\n", index, processedCount)
 					return
 				}
-				if errors.Is(err, redis.Nil) {
+				if errors.Is(err, ErrTaskQueueEmpty) {
 					fmt.Printf("线程 #%d: 任务队列为空，退出\n", index)
 					fmt.Printf("线程 #%d: 共处理 %d 条数据\n", index, processedCount)
 					return
@@ -0,0 +1,376 @@
+package prover
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+// 证明任务的状态
+const (
+	ProofJobQueued   = "queued"   // 等待生成证明
+	ProofJobProving  = "proving"  // 正在生成证明
+	ProofJobFinished = "finished" // 证明已生成并通过验证
+	ProofJobFailed   = "failed"   // 证明生成失败, 原因见Error
+)
+
+// 证明服务的默认配置
+const (
+	DefaultMaxPendingJobs = 16        // 默认最多等待的任务数量
+	DefaultJobResultTTL   = time.Hour // 默认结束的任务保留的时长
+	maxWitnessBodySize    = 256 << 20 // 提交的见证数据的最大字节数
+)
+
+var (
+	// ErrTooManyPendingJobs 等待中的任务已达到上限
+	ErrTooManyPendingJobs = errors.New("too many pending proof jobs")
+	// ErrUnsupportedAssetsCount 批次的资产数量层级没有配置密钥
+	ErrUnsupportedAssetsCount = errors.New("assets count tier is not supported by this prover")
+)
+
+// SubmitProofRequest 提交证明任务的请求
+type SubmitProofRequest struct {
+	Witness string // utils.EncodeBatchWitness编码的BatchCreateUserWitness, 与见证表中witness_data的格式相同
+}
+
+// ProofJob 证明任务
+type ProofJob struct {
+	Id          string
+	Status      string
+	AssetsCount int    // 批次的资产数量层级, 决定使用的密钥
+	Error       string `json:",omitempty"`
+	SubmittedAt int64  // 提交时间(unix秒)
+	FinishedAt  int64  `json:",omitempty"` // 结束时间(unix秒)
+
+	witness *utils.BatchCreateUserWitness
+	result  *ProofResult
+}
+
+// ProofResult 证明任务的结果, 字段与证明表相同
+type ProofResult struct {
+	ProofInfo               string // 证明(base64编码)
+	CexAssetListCommitments string // CEX资产列表承诺
+	AccountTreeRoots        string // 账户树根列表
+	BatchCommitment         string // 批次承诺
+	AssetsCount             int    // 资产数量
+	ProvingSystem           string // 生成该证明的证明系统(groth16/plonk)
+	HashToField             string // 生成该证明的hash-to-field模式(default/aggregation/solidity)
+	OpType                  int64  // 批次的操作类型
+	RoundId                 uint64 // 审计轮次编号
+	Timestamp               uint64 // 审计快照的时间戳(unix秒)
+}
+
+// ServiceHealth 证明服务的状态, 网关可以根据LoadedKeys将任务路由到已加载对应密钥的prover
+type ServiceHealth struct {
+	ProvingSystem    string
+	HashToField      string
+	AssetsCountTiers []int               // 支持的资产数量层级
+	LoadedKeys       []witness.BatchTier // 已加载的密钥, 最近使用的在前
+	PendingJobs      int                 // 等待中的任务数量
+	Proving          bool                // 是否正在生成证明
+}
+
+// ProvingService 通过HTTP接收批量创建用户的见证数据并生成证明
+// 同一时间只生成一个证明, 等待中的任务优先选择密钥已加载的资产数量层级
+type ProvingService struct {
+	prover         *Prover
+	maxPendingJobs int
+	resultTTL      time.Duration
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	jobs       map[string]*ProofJob
+	pending    []*ProofJob
+	proving    bool
+	loadedKeys []witness.BatchTier
+}
+
+// NewProvingService 创建证明服务
+// 参数:
+//   - prover: 证明生成器, 只能由证明服务使用
+//   - maxPendingJobs: 最多等待的任务数量, 小于等于0时使用DefaultMaxPendingJobs
+//   - resultTTL: 结束的任务保留的时长, 小于等于0时使用DefaultJobResultTTL
+func NewProvingService(prover *Prover, maxPendingJobs int, resultTTL time.Duration) *ProvingService {
+	if maxPendingJobs <= 0 {
+		maxPendingJobs = DefaultMaxPendingJobs
+	}
+	if resultTTL <= 0 {
+		resultTTL = DefaultJobResultTTL
+	}
+	s := &ProvingService{
+		prover:         prover,
+		maxPendingJobs: maxPendingJobs,
+		resultTTL:      resultTTL,
+		jobs:           make(map[string]*ProofJob),
+		loadedKeys:     prover.residentTiers(),
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Handler 返回证明服务的HTTP接口
+//   - POST /v1/proofs: 提交任务, 请求体为SubmitProofRequest, 返回ProofJob
+//   - GET /v1/proofs/{id}: 查询任务状态, 返回ProofJob
+//   - GET /v1/proofs/{id}/proof: 获取证明, 返回ProofResult, 任务未结束或失败时返回409
+//   - GET /healthz: 返回ServiceHealth
+func (s *ProvingService) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/proofs", s.handleSubmit)
+	mux.HandleFunc("GET /v1/proofs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /v1/proofs/{id}/proof", s.handleFetch)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	return mux
+}
+
+// Submit 解码见证数据并加入等待队列
+// 返回:
+//   - *ProofJob: 任务的状态
+//   - error: 见证数据无法解码时返回解码错误, 资产数量层级没有配置密钥时返回ErrUnsupportedAssetsCount,
+//     等待的任务过多时返回ErrTooManyPendingJobs
+func (s *ProvingService) Submit(witnessData string) (*ProofJob, error) {
+	batchWitness, err := utils.DecodeBatchWitness(witnessData)
+	if err != nil {
+		return nil, err
+	}
+	if len(batchWitness.CreateUserOps) == 0 {
+		return nil, fmt.Errorf("%w: the batch has no create user op", utils.ErrMalformedWitness)
+	}
+	// 与GenerateAndVerifyProof使用相同的资产数量层级
+	assetsCount := utils.GetNonEmptyAssetsCountOfUser(batchWitness.CreateUserOps[0].Assets)
+	supported := false
+	for i, tier := range s.prover.AssetsCountTiers {
+		if tier == assetsCount && i < len(s.prover.SessionName) {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedAssetsCount, assetsCount)
+	}
+	id, err := newProofJobId()
+	if err != nil {
+		return nil, err
+	}
+	job := &ProofJob{
+		Id:          id,
+		Status:      ProofJobQueued,
+		AssetsCount: assetsCount,
+		SubmittedAt: time.Now().Unix(),
+		witness:     batchWitness,
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removeExpiredJobs()
+	if len(s.pending) >= s.maxPendingJobs {
+		return nil, ErrTooManyPendingJobs
+	}
+	s.jobs[id] = job
+	s.pending = append(s.pending, job)
+	s.cond.Broadcast()
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Run 逐个处理等待中的任务, ctx取消后处理完当前的任务再返回
+func (s *ProvingService) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		s.lock.Lock()
+		s.lock.Unlock()
+		s.cond.Broadcast()
+	})
+	defer stop()
+	for {
+		s.lock.Lock()
+		for len(s.pending) == 0 && ctx.Err() == nil {
+			s.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			s.lock.Unlock()
+			return
+		}
+		job := s.nextJob()
+		job.Status = ProofJobProving
+		s.proving = true
+		s.lock.Unlock()
+
+		result, err := s.prove(job)
+
+		s.lock.Lock()
+		s.proving = false
+		s.loadedKeys = s.prover.residentTiers()
+		job.FinishedAt = time.Now().Unix()
+		job.witness = nil
+		if err != nil {
+			fmt.Printf("proof job %s failed: %s\n", job.Id, err.Error())
+			job.Status = ProofJobFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = ProofJobFinished
+			job.result = result
+		}
+		s.lock.Unlock()
+	}
+}
+
+// nextJob 从等待队列取出下一个任务, 优先选择密钥已加载的资产数量层级, 需要持有锁
+func (s *ProvingService) nextJob() *ProofJob {
+	index := 0
+	for _, tier := range s.loadedKeys {
+		if tier.OpType != utils.OpTypeCreateUser {
+			continue
+		}
+		found := false
+		for i, job := range s.pending {
+			if int64(job.AssetsCount) == tier.AssetsCount {
+				index = i
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	job := s.pending[index]
+	s.pending = append(s.pending[:index], s.pending[index+1:]...)
+	return job
+}
+
+// prove 生成并验证证明, 提交的见证数据导致的panic作为任务的错误返回, 不影响其他任务
+func (s *ProvingService) prove(job *ProofJob) (result *ProofResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("prove panic: %v", r)
+		}
+	}()
+	proof, assetsCount, err := s.prover.GenerateAndVerifyProof(job.witness, 0)
+	if err != nil {
+		return nil, err
+	}
+	states := batchStates{
+		BatchCommitment:           job.witness.BatchCommitment,
+		BeforeAccountTreeRoot:     job.witness.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      job.witness.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: job.witness.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  job.witness.AfterCEXAssetsCommitment,
+		RoundId:                   job.witness.RoundId,
+		Timestamp:                 job.witness.Timestamp,
+	}
+	row, err := newProofRow(0, utils.OpTypeCreateUser, s.prover.ProvingSystem, s.prover.HashToField, &states, proof, assetsCount)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofResult{
+		ProofInfo:               row.ProofInfo,
+		CexAssetListCommitments: row.CexAssetListCommitments,
+		AccountTreeRoots:        row.AccountTreeRoots,
+		BatchCommitment:         row.BatchCommitment,
+		AssetsCount:             row.AssetsCount,
+		ProvingSystem:           row.ProvingSystem,
+		HashToField:             row.HashToField,
+		OpType:                  row.OpType,
+		RoundId:                 row.RoundId,
+		Timestamp:               row.Timestamp,
+	}, nil
+}
+
+// removeExpiredJobs 删除结束超过resultTTL的任务, 需要持有锁
+func (s *ProvingService) removeExpiredJobs() {
+	expiredAt := time.Now().Add(-s.resultTTL).Unix()
+	for id, job := range s.jobs {
+		if job.FinishedAt != 0 && job.FinishedAt < expiredAt {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// Health 返回证明服务的状态
+func (s *ProvingService) Health() ServiceHealth {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return ServiceHealth{
+		ProvingSystem:    s.prover.ProvingSystem,
+		HashToField:      s.prover.HashToField,
+		AssetsCountTiers: s.prover.AssetsCountTiers,
+		LoadedKeys:       append([]witness.BatchTier{}, s.loadedKeys...),
+		PendingJobs:      len(s.pending),
+		Proving:          s.proving,
+	}
+}
+
+func (s *ProvingService) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var request SubmitProofRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWitnessBodySize)).Decode(&request)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	job, err := s.Submit(request.Witness)
+	if errors.Is(err, ErrTooManyPendingJobs) {
+		utils.WriteJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusAccepted, job)
+}
+
+func (s *ProvingService) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	var snapshot ProofJob
+	if ok {
+		snapshot = *job
+	}
+	s.lock.Unlock()
+	if !ok {
+		utils.WriteJSONError(w, http.StatusNotFound, errors.New("proof job not found"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, &snapshot)
+}
+
+func (s *ProvingService) handleFetch(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	var snapshot ProofJob
+	if ok {
+		snapshot = *job
+	}
+	s.lock.Unlock()
+	if !ok {
+		utils.WriteJSONError(w, http.StatusNotFound, errors.New("proof job not found"))
+		return
+	}
+	if snapshot.Status != ProofJobFinished {
+		utils.WriteJSON(w, http.StatusConflict, &snapshot)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, snapshot.result)
+}
+
+func (s *ProvingService) handleHealth(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, s.Health())
+}
+
+// newProofJobId 生成随机的任务标识
+func newProofJobId() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
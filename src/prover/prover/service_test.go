@@ -0,0 +1,81 @@
+package prover
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+// TestProvingService 测试证明服务的接口和按已加载密钥选择任务
+func TestProvingService(t *testing.T) {
+	p := &Prover{
+		ProvingSystem:    utils.ProvingSystemGroth16,
+		AssetsCountTiers: []int{50, 350},
+		SessionName:      []string{"zkpor50", "zkpor350"},
+		ResidentKeys:     1,
+	}
+	service := NewProvingService(p, 2, 0)
+	server := httptest.NewServer(service.Handler())
+	defer server.Close()
+
+	// 无法解码的见证数据
+	resp, err := http.Post(server.URL+"/v1/proofs", "application/json", strings.NewReader(`{"Witness": "invalid"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expect status %d but got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// 不存在的任务
+	for _, path := range []string{"/v1/proofs/unknown", "/v1/proofs/unknown/proof"} {
+		resp, err = http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expect status %d but got %d for %s", http.StatusNotFound, resp.StatusCode, path)
+		}
+	}
+
+	// 任务未结束时不能获取证明
+	service.jobs["queued"] = &ProofJob{Id: "queued", Status: ProofJobQueued, AssetsCount: 50}
+	resp, err = http.Get(server.URL + "/v1/proofs/queued/proof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expect status %d but got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	// 优先选择密钥已加载的资产数量层级, 其次按提交顺序
+	service.pending = []*ProofJob{{Id: "a", AssetsCount: 50}, {Id: "b", AssetsCount: 350}, {Id: "c", AssetsCount: 350}}
+	service.loadedKeys = []witness.BatchTier{{OpType: utils.OpTypeCreateUser, AssetsCount: 350}}
+	for _, id := range []string{"b", "c", "a"} {
+		job := service.nextJob()
+		if job.Id != id {
+			t.Fatalf("expect job %s but got %s", id, job.Id)
+		}
+	}
+
+	resp, err = http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health ServiceHealth
+	if err = json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health.ProvingSystem != utils.ProvingSystemGroth16 || len(health.AssetsCountTiers) != 2 || len(health.LoadedKeys) != 1 || health.LoadedKeys[0].AssetsCount != 350 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+}
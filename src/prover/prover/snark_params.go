@@ -0,0 +1,203 @@
+package prover
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/consensys/gnark/constraint"
+)
+
+// snarkParams 一个电路的约束系统, 证明密钥和验证密钥
+type snarkParams struct {
+	tier         witness.BatchTier
+	r1cs         constraint.ConstraintSystem
+	provingKey   utils.SnarkProvingKey
+	verifyingKey utils.SnarkVerifyingKey
+}
+
+// LoadSnarkParamsOnce 加载SNARK参数(仅加载一次)
+// 最近使用的ResidentKeys个电路的参数常驻内存, 切换到常驻的电路时不需要重新加载
+// 加载新的电路前淘汰最久未使用的电路, 避免同时持有超过ResidentKeys个电路的参数
+//
+// 参数:
+//   - opType: 操作类型(创建用户/更新用户/删除用户)，用于选择对应电路的参数文件
+//   - targerAssetsCount: 目标资产数量，用于选择对应的参数文件
+//
+// 返回:
+//   - error: 参数文件不存在或无法解析时返回错误, 之后的调用会重新加载
+func (p *Prover) LoadSnarkParamsOnce(opType int, targerAssetsCount int) error {
+	// 1. 检查是否需要重新加载
+	// 如果当前已加载的参数与目标操作类型和资产数量相同，则直接返回
+	if targerAssetsCount == p.CurrentSnarkParamsInUse && opType == p.CurrentOpTypeInUse {
+		return nil
+	}
+	tier := witness.BatchTier{OpType: int64(opType), AssetsCount: int64(targerAssetsCount)}
+	params := p.residentSnarkParams(tier)
+	if params == nil {
+		// 2. 淘汰最久未使用的参数, 当前使用的参数也可能被淘汰
+		p.R1cs, p.ProvingKey, p.VerifyingKey = nil, nil, nil
+		p.CurrentSnarkParamsInUse = 0
+		if len(p.residentParams) >= p.ResidentKeys {
+			for _, evicted := range p.residentParams[p.ResidentKeys-1:] {
+				fmt.Println("evict snark params of op type ", evicted.tier.OpType, " and ", evicted.tier.AssetsCount, " assets")
+			}
+			p.residentParams = p.residentParams[:p.ResidentKeys-1]
+			runtime.GC()
+		}
+
+		// 3. 加载参数文件
+		var err error
+		params, err = p.loadSnarkParams(tier)
+		if err != nil {
+			return err
+		}
+		p.residentParams = append([]*snarkParams{params}, p.residentParams...)
+	}
+
+	// 更新当前使用的参数
+	p.R1cs = params.r1cs
+	p.ProvingKey = params.provingKey
+	p.VerifyingKey = params.verifyingKey
+	p.CurrentSnarkParamsInUse = targerAssetsCount
+	p.CurrentOpTypeInUse = opType
+	return nil
+}
+
+// PreloadSnarkParams 加载配置中所有电路的参数, 启动时发现缺失或损坏的密钥文件
+func (p *Prover) PreloadSnarkParams() error {
+	sessionNames := map[int][]string{
+		utils.OpTypeCreateUser: p.SessionName,
+		utils.OpTypeUpdateUser: p.UpdateSessionName,
+		utils.OpTypeDeleteUser: p.DeleteSessionName,
+	}
+	for _, opType := range []int{utils.OpTypeCreateUser, utils.OpTypeUpdateUser, utils.OpTypeDeleteUser} {
+		for i := 0; i < len(sessionNames[opType]) && i < len(p.AssetsCountTiers); i++ {
+			err := p.LoadSnarkParamsOnce(opType, p.AssetsCountTiers[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// residentSnarkParams 返回常驻内存的参数并将其标记为最近使用, 不存在时返回nil
+func (p *Prover) residentSnarkParams(tier witness.BatchTier) *snarkParams {
+	for i, params := range p.residentParams {
+		if params.tier == tier {
+			copy(p.residentParams[1:i+1], p.residentParams[:i])
+			p.residentParams[0] = params
+			return params
+		}
+	}
+	return nil
+}
+
+// residentTiers 返回参数常驻内存的电路, 最近使用的在前, 用于优先领取这些电路的批次
+func (p *Prover) residentTiers() []witness.BatchTier {
+	tiers := make([]witness.BatchTier, len(p.residentParams))
+	for i, params := range p.residentParams {
+		tiers[i] = params.tier
+	}
+	return tiers
+}
+
+// loadSnarkParams 从文件加载一个电路的约束系统(groth16为R1CS, plonk为SparseR1CS), 证明密钥和验证密钥
+func (p *Prover) loadSnarkParams(tier witness.BatchTier) (*snarkParams, error) {
+	sessionName := p.SessionName
+	if tier.OpType == utils.OpTypeUpdateUser {
+		sessionName = p.UpdateSessionName
+	} else if tier.OpType == utils.OpTypeDeleteUser {
+		sessionName = p.DeleteSessionName
+	}
+
+	// 查找对应的参数文件索引
+	index := -1
+	for i, v := range p.AssetsCountTiers {
+		if int(tier.AssetsCount) == v {
+			index = i
+			break
+		}
+	}
+	if index == -1 || index >= len(sessionName) {
+		return nil, fmt.Errorf("the assets count %d is not in the config file", tier.AssetsCount)
+	}
+	params := &snarkParams{
+		tier:         tier,
+		r1cs:         utils.NewConstraintSystem(p.ProvingSystem),
+		provingKey:   utils.NewSnarkProvingKey(p.ProvingSystem),
+		verifyingKey: utils.NewSnarkVerifyingKey(p.ProvingSystem),
+	}
+
+	// 加载R1CS约束系统
+	s := time.Now()
+	fmt.Println("begin loading r1cs of ", tier.AssetsCount, " assets")
+	n, err := p.readSnarkParamsFile(sessionName[index]+utils.ConstraintSystemFileSuffix(p.ProvingSystem), params.r1cs.ReadFrom)
+	if err != nil {
+		return nil, fmt.Errorf("r1cs loading error: %w", err)
+	}
+	fmt.Println("r1cs read size is ", n)
+	runtime.GC()
+	fmt.Println("finish loading r1cs.... the time cost is ", time.Since(s))
+
+	// 加载证明密钥(Proving Key)
+	fmt.Println("begin loading proving key of ", tier.AssetsCount, " assets")
+	s = time.Now()
+	n, err = p.readSnarkParamsFile(sessionName[index]+".pk", params.provingKey.UnsafeReadFrom)
+	if err != nil {
+		return nil, fmt.Errorf("provingKey loading error: %w", err)
+	}
+	fmt.Println("proving key read size is ", n)
+	fmt.Println("finish loading proving key... the time cost is ", time.Since(s))
+
+	// 加载验证密钥(Verifying Key)
+	fmt.Println("begin loading verifying key of ", tier.AssetsCount, " assets")
+	s = time.Now()
+	n, err = p.readSnarkParamsFile(sessionName[index]+".vk", params.verifyingKey.ReadFrom)
+	if err != nil {
+		return nil, fmt.Errorf("verifyingKey loading error: %w", err)
+	}
+	fmt.Println("verifying key read size is ", n)
+	fmt.Println("finish loading verifying key.. the time cost is ", time.Since(s))
+	return params, nil
+}
+
+// readSnarkParamsFile 读取参数文件并用read解析
+// MmapKeyFiles开启时将文件映射到内存, 避免读入与文件同样大小的临时缓冲区, 解析结束后解除映射;
+// 解析出的约束系统和密钥总是复制到堆内存, 所以常驻内存与不开启时相同, 只降低加载时的内存峰值
+// 否则读入堆内存, 并在读取期间定期执行GC
+func (p *Prover) readSnarkParamsFile(name string, read func(r io.Reader) (int64, error)) (int64, error) {
+	if p.MmapKeyFiles {
+		data, release, err := mmapFile(name)
+		if err != nil {
+			return 0, err
+		}
+		defer release()
+		return read(bytes.NewReader(data))
+	}
+
+	// 启动GC协程，定期清理内存
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Second * 10):
+				runtime.GC() // 每10秒执行一次GC
+			}
+		}
+	}()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return read(bytes.NewBuffer(data))
+}
@@ -0,0 +1,92 @@
+package prover
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestResidentSnarkParams 测试常驻内存的电路参数按最近使用排序, 加载新的电路前淘汰最久未使用的电路,
+// 以及任务队列按该顺序优先领取批次
+func TestResidentSnarkParams(t *testing.T) {
+	create50 := witness.BatchTier{OpType: utils.OpTypeCreateUser, AssetsCount: 50}
+	create350 := witness.BatchTier{OpType: utils.OpTypeCreateUser, AssetsCount: 350}
+	update50 := witness.BatchTier{OpType: utils.OpTypeUpdateUser, AssetsCount: 50}
+	// 没有配置更新用户电路的密钥, 加载update50总是失败, 不需要密钥文件
+	p := &Prover{
+		ProvingSystem:    utils.ProvingSystemGroth16,
+		AssetsCountTiers: []int{50, 350},
+		SessionName:      []string{"zkpor50", "zkpor350"},
+		ResidentKeys:     2,
+		residentParams:   []*snarkParams{{tier: create50}, {tier: create350}},
+	}
+
+	// 切换到常驻的电路不需要加载, 并成为最近使用的电路
+	if err := p.LoadSnarkParamsOnce(utils.OpTypeCreateUser, 350); err != nil {
+		t.Fatal(err)
+	}
+	if tiers := p.residentTiers(); !reflect.DeepEqual(tiers, []witness.BatchTier{create350, create50}) {
+		t.Fatalf("unexpected resident tiers %v", tiers)
+	}
+	if p.CurrentSnarkParamsInUse != 350 || p.CurrentOpTypeInUse != utils.OpTypeCreateUser {
+		t.Fatalf("expect the params of 350 assets in use but got %d of op type %d", p.CurrentSnarkParamsInUse, p.CurrentOpTypeInUse)
+	}
+
+	// 任务队列优先领取最近使用的电路的批次
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	witnesses := []witness.BatchWitness{
+		{Height: 0, AssetsCount: 50, Status: witness.StatusPublished},
+		{Height: 1, AssetsCount: 350, Status: witness.StatusPublished},
+	}
+	if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+		t.Fatal(err)
+	}
+	taskQueue, err := NewTaskQueue(TaskQueueMemory, witnessModel, nil, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := taskQueue.Fetch("prover", 100, p.residentTiers(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Height != 1 {
+		t.Fatalf("fetch batch %d, expect 1", w.Height)
+	}
+
+	// 加载新的电路前淘汰最久未使用的电路, 加载失败时当前使用的参数也被清空
+	if err = p.LoadSnarkParamsOnce(int(update50.OpType), int(update50.AssetsCount)); err == nil {
+		t.Fatal("expect loading the params of update user without keys to fail")
+	}
+	if tiers := p.residentTiers(); !reflect.DeepEqual(tiers, []witness.BatchTier{create350}) {
+		t.Fatalf("unexpected resident tiers %v after evicting", tiers)
+	}
+	if p.CurrentSnarkParamsInUse != 0 || p.ProvingKey != nil {
+		t.Fatal("expect no params in use after loading failed")
+	}
+	if err = p.LoadSnarkParamsOnce(utils.OpTypeCreateUser, 350); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只常驻一个电路时, 加载新的电路前淘汰所有的电路
+	p.ResidentKeys = 1
+	if err = p.LoadSnarkParamsOnce(int(update50.OpType), int(update50.AssetsCount)); err == nil {
+		t.Fatal("expect loading the params of update user without keys to fail")
+	}
+	if tiers := p.residentTiers(); len(tiers) != 0 {
+		t.Fatalf("unexpected resident tiers %v after evicting", tiers)
+	}
+}
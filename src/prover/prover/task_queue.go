@@ -0,0 +1,255 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/redis/go-redis/v9"
+)
+
+// 任务队列类型
+const (
+	TaskQueueRedis  = "redis"  // Redis列表, 由dbtool -push_task_to_redis填充, 默认
+	TaskQueueSql    = "sql"    // 直接以见证表中已发布的批次作为队列, 只需要数据库
+	TaskQueueMemory = "memory" // 进程内队列, 启动时和常驻模式下队列为空时加载已发布的批次, 用于单个prover进程和测试
+)
+
+var (
+	// ErrTaskQueueEmpty 在超时时间内没有取到任务
+	ErrTaskQueueEmpty = errors.New("task queue is empty")
+	// ErrUnknownTaskQueue 未知的任务队列类型
+	ErrUnknownTaskQueue = errors.New("unknown task queue type")
+)
+
+// TaskQueue 证明任务队列
+// Fetch取出一个批次并为owner获得租约, 队列中的批次已被领取或已完成时返回DbErrNotFound
+// preferred是prover已加载密钥的电路, 支持的队列优先返回这些电路的批次, 减少密钥的切换
+type TaskQueue interface {
+	Push(heights []int64) error                                                                                               // 将已发布的批次加入队列
+	Fetch(owner string, expiredAt int64, preferred []witness.BatchTier, timeout time.Duration) (*witness.BatchWitness, error) // 领取一个批次
+}
+
+// ReloadableTaskQueue 没有外部生产者的任务队列, 常驻模式下通过Reload领取witness服务新发布的批次
+type ReloadableTaskQueue interface {
+	TaskQueue
+	Reload() error // 加载见证表中不在队列中的已发布批次
+}
+
+// TaskQueueName 返回Redis任务队列的名称
+func TaskQueueName(dbSuffix string) string {
+	return "por_batch_task_queue_" + dbSuffix
+}
+
+// NewTaskQueue 根据类型创建任务队列
+// 参数:
+//   - queueType: 任务队列类型, 为空时使用Redis
+//   - witnessModel: 见证数据模型, 用于领取批次
+//   - redisCli: Redis客户端, 只在Redis队列中使用
+//   - dbSuffix: 表名后缀, 用于Redis队列名称
+//
+// 返回:
+//   - TaskQueue: 任务队列
+//   - error: 错误信息, 未知的类型返回ErrUnknownTaskQueue
+func NewTaskQueue(queueType string, witnessModel witness.WitnessModel, redisCli *redis.Client, dbSuffix string) (TaskQueue, error) {
+	switch queueType {
+	case "", TaskQueueRedis:
+		return NewRedisTaskQueue(redisCli, TaskQueueName(dbSuffix), witnessModel), nil
+	case TaskQueueSql:
+		return NewSqlTaskQueue(witnessModel), nil
+	case TaskQueueMemory:
+		queue := NewMemoryTaskQueue(witnessModel)
+		// 进程内队列没有外部的生产者, 启动时加载所有已发布的批次
+		if err := queue.Reload(); err != nil {
+			return nil, err
+		}
+		return queue, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTaskQueue, queueType)
+	}
+}
+
+// redisTaskQueue 基于Redis列表的任务队列, LPush加入, BRPop取出
+// 按加入的顺序领取, 不支持按电路分组; dbtool按高度顺序加入, 同一电路的批次本身是连续的
+type redisTaskQueue struct {
+	redisCli     *redis.Client
+	name         string
+	witnessModel witness.WitnessModel
+}
+
+// NewRedisTaskQueue 创建Redis任务队列
+func NewRedisTaskQueue(redisCli *redis.Client, name string, witnessModel witness.WitnessModel) TaskQueue {
+	return &redisTaskQueue{
+		redisCli:     redisCli,
+		name:         name,
+		witnessModel: witnessModel,
+	}
+}
+
+func (q *redisTaskQueue) Push(heights []int64) error {
+	ctx := context.Background()
+	redisPipe := q.redisCli.Pipeline()
+	for _, height := range heights {
+		redisPipe.LPush(ctx, q.name, height)
+	}
+	_, err := redisPipe.Exec(ctx)
+	return err
+}
+
+func (q *redisTaskQueue) Fetch(owner string, expiredAt int64, preferred []witness.BatchTier, timeout time.Duration) (*witness.BatchWitness, error) {
+	// 阻塞式获取任务
+	batchHeightStr, err := q.redisCli.BRPop(context.Background(), timeout, q.name).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrTaskQueueEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	batchHeight, err := strconv.ParseInt(batchHeightStr[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return q.witnessModel.AcquireBatchWitnessLease(batchHeight, owner, expiredAt)
+}
+
+// sqlTaskQueue 以见证表中已发布的批次作为任务队列
+// 已发布的批次本身就在队列中, 因此Push不需要做任何事情
+type sqlTaskQueue struct {
+	witnessModel witness.WitnessModel
+	pollInterval time.Duration
+}
+
+// NewSqlTaskQueue 创建基于见证表的任务队列
+func NewSqlTaskQueue(witnessModel witness.WitnessModel) TaskQueue {
+	return &sqlTaskQueue{
+		witnessModel: witnessModel,
+		pollInterval: time.Second,
+	}
+}
+
+func (q *sqlTaskQueue) Push(heights []int64) error {
+	return nil
+}
+
+func (q *sqlTaskQueue) Fetch(owner string, expiredAt int64, preferred []witness.BatchTier, timeout time.Duration) (*witness.BatchWitness, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		w, err := q.witnessModel.AcquireNextBatchWitnessLease(owner, expiredAt, preferred)
+		if err != utils.DbErrNotFound {
+			return w, err
+		}
+		if time.Now().Add(q.pollInterval).After(deadline) {
+			return nil, ErrTaskQueueEmpty
+		}
+		time.Sleep(q.pollInterval)
+	}
+}
+
+// memoryTaskQueue 进程内的任务队列, 先进先出, 优先领取preferred中的电路
+type memoryTaskQueue struct {
+	witnessModel witness.WitnessModel
+	lock         sync.Mutex
+	cond         *sync.Cond
+	heights      []int64
+	tiers        map[int64]witness.BatchTier
+}
+
+// NewMemoryTaskQueue 创建进程内任务队列
+func NewMemoryTaskQueue(witnessModel witness.WitnessModel) ReloadableTaskQueue {
+	q := &memoryTaskQueue{
+		witnessModel: witnessModel,
+		tiers:        make(map[int64]witness.BatchTier),
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+func (q *memoryTaskQueue) Push(heights []int64) error {
+	tiers, err := q.witnessModel.GetBatchTiersByHeights(heights)
+	if err != nil {
+		return err
+	}
+	q.lock.Lock()
+	q.heights = append(q.heights, heights...)
+	for height, tier := range tiers {
+		q.tiers[height] = tier
+	}
+	q.lock.Unlock()
+	q.cond.Broadcast()
+	return nil
+}
+
+// Reload 加载见证表中不在队列中的已发布批次
+// 队列中的批次都在tiers中, 已领取的批次不再是已发布状态, 所以不会重复加入
+func (q *memoryTaskQueue) Reload() error {
+	limit := 1024
+	offset := 0
+	for {
+		heights, err := q.witnessModel.GetAllBatchHeightsByStatus(witness.StatusPublished, limit, offset)
+		if err == utils.DbErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += len(heights)
+		newHeights := make([]int64, 0, len(heights))
+		q.lock.Lock()
+		for _, height := range heights {
+			if _, ok := q.tiers[height]; !ok {
+				newHeights = append(newHeights, height)
+			}
+		}
+		q.lock.Unlock()
+		if len(newHeights) == 0 {
+			continue
+		}
+		if err = q.Push(newHeights); err != nil {
+			return err
+		}
+	}
+}
+
+func (q *memoryTaskQueue) Fetch(owner string, expiredAt int64, preferred []witness.BatchTier, timeout time.Duration) (*witness.BatchWitness, error) {
+	// sync.Cond不支持超时, 超时后广播唤醒所有等待者
+	// 广播前先获取锁, 保证等待者已经进入Wait, 不会错过唤醒
+	timer := time.AfterFunc(timeout, func() {
+		q.lock.Lock()
+		q.lock.Unlock()
+		q.cond.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	q.lock.Lock()
+	for len(q.heights) == 0 {
+		if !time.Now().Before(deadline) {
+			q.lock.Unlock()
+			return nil, ErrTaskQueueEmpty
+		}
+		q.cond.Wait()
+	}
+	index := q.preferredIndex(preferred)
+	height := q.heights[index]
+	q.heights = append(q.heights[:index], q.heights[index+1:]...)
+	delete(q.tiers, height)
+	q.lock.Unlock()
+	return q.witnessModel.AcquireBatchWitnessLease(height, owner, expiredAt)
+}
+
+// preferredIndex 返回按preferred顺序第一个匹配的批次在队列中的位置, 都不匹配时返回队首
+func (q *memoryTaskQueue) preferredIndex(preferred []witness.BatchTier) int {
+	for _, tier := range preferred {
+		for i, height := range q.heights {
+			if q.tiers[height] == tier {
+				return i
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,350 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestTaskQueue 测试不依赖Redis的任务队列: 每个已发布的批次只被领取一次, 队列为空时返回ErrTaskQueueEmpty
+func TestTaskQueue(t *testing.T) {
+	for _, queueType := range []string{TaskQueueSql, TaskQueueMemory} {
+		t.Run(queueType, func(t *testing.T) {
+			db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+				&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			witnessModel := witness.NewWitnessModel(db, "0")
+			if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+				t.Fatal(err)
+			}
+			batchCount := 6
+			witnesses := make([]witness.BatchWitness, batchCount)
+			for i := 0; i < batchCount; i++ {
+				witnesses[i] = witness.BatchWitness{Height: int64(i), Status: witness.StatusPublished}
+			}
+			if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+				t.Fatal(err)
+			}
+			taskQueue, err := NewTaskQueue(queueType, witnessModel, nil, "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 多个prover并发领取, 直到队列为空
+			var wg sync.WaitGroup
+			var lock sync.Mutex
+			received := make(map[int64]int)
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						w, err := taskQueue.Fetch("prover", 100, nil, 100*time.Millisecond)
+						if errors.Is(err, ErrTaskQueueEmpty) {
+							return
+						}
+						if err == utils.DbErrNotFound {
+							continue
+						}
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						lock.Lock()
+						received[w.Height]++
+						lock.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if len(received) != batchCount {
+				t.Fatalf("%d batches are received, expect %d", len(received), batchCount)
+			}
+			for height, count := range received {
+				if count != 1 {
+					t.Fatalf("batch %d is received %d times", height, count)
+				}
+			}
+
+			// 失败后重新发布的批次可以再次领取
+			if _, err = witnessModel.FailBatchWitness(2, "prover", DefaultMaxRetries); err != nil {
+				t.Fatal(err)
+			}
+			if err = taskQueue.Push([]int64{2}); err != nil {
+				t.Fatal(err)
+			}
+			w, err := taskQueue.Fetch("prover", 100, nil, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Height != 2 || w.RetryCount != 1 {
+				t.Fatalf("unexpected requeued witness %+v", w)
+			}
+		})
+	}
+
+	if _, err := NewTaskQueue("kafka", nil, nil, "0"); !errors.Is(err, ErrUnknownTaskQueue) {
+		t.Fatalf("expect ErrUnknownTaskQueue but got %v", err)
+	}
+}
+
+// TestTaskQueuePreferredTier 测试SQL和进程内队列优先返回已加载密钥的电路的批次
+func TestTaskQueuePreferredTier(t *testing.T) {
+	for _, queueType := range []string{TaskQueueSql, TaskQueueMemory} {
+		t.Run(queueType, func(t *testing.T) {
+			db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+				&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			witnessModel := witness.NewWitnessModel(db, "0")
+			if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+				t.Fatal(err)
+			}
+			// 资产数量层级交替的批次
+			witnesses := make([]witness.BatchWitness, 6)
+			for i := 0; i < len(witnesses); i++ {
+				witnesses[i] = witness.BatchWitness{Height: int64(i), AssetsCount: int64(50 + 300*(i%2)), Status: witness.StatusPublished}
+			}
+			if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+				t.Fatal(err)
+			}
+			taskQueue, err := NewTaskQueue(queueType, witnessModel, nil, "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			preferred := []witness.BatchTier{{OpType: utils.OpTypeCreateUser, AssetsCount: 350}}
+			expected := []int64{1, 3, 5, 0, 2, 4}
+			for _, height := range expected {
+				w, err := taskQueue.Fetch("prover", 100, preferred, time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if w.Height != height {
+					t.Fatalf("fetch batch %d, expect %d", w.Height, height)
+				}
+			}
+		})
+	}
+}
+
+// TestMemoryTaskQueueReload 测试进程内队列为空后可以加载新发布的批次, 已在队列中或已领取的批次不会重复加入
+func TestMemoryTaskQueueReload(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 0, Status: witness.StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	taskQueue := NewMemoryTaskQueue(witnessModel)
+	if err = taskQueue.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = taskQueue.Fetch("prover", 100, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = taskQueue.Fetch("prover", 100, nil, 100*time.Millisecond); !errors.Is(err, ErrTaskQueueEmpty) {
+		t.Fatalf("expect ErrTaskQueueEmpty but got %v", err)
+	}
+
+	// witness服务在prover启动后发布的批次
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{
+		{Height: 1, Status: witness.StatusPublished},
+		{Height: 2, Status: witness.StatusPublished},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = taskQueue.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, height := range []int64{1, 2} {
+		w, err := taskQueue.Fetch("prover", 100, nil, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Height != height {
+			t.Fatalf("fetch batch %d, expect %d", w.Height, height)
+		}
+	}
+	if _, err = taskQueue.Fetch("prover", 100, nil, 100*time.Millisecond); !errors.Is(err, ErrTaskQueueEmpty) {
+		t.Fatalf("expect ErrTaskQueueEmpty but got %v", err)
+	}
+}
+
+// TestProverRunRetry 测试无法解码的批次按重试次数标记为失败后prover正常退出, 常驻模式下ctx取消后退出
+func TestProverRunRetry(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 0, WitnessData: "invalid", Status: witness.StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	taskQueue, err := NewTaskQueue(TaskQueueMemory, witnessModel, nil, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Prover{
+		witnessModel:  witnessModel,
+		proofModel:    NewProofModel(db, "0"),
+		taskQueue:     taskQueue,
+		fetchTimeout:  100 * time.Millisecond,
+		ProverId:      "prover",
+		LeaseDuration: DefaultLeaseDuration,
+		MaxRetries:    2,
+	}
+	if err = p.Run(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := witnessModel.GetBatchWitnessByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != witness.StatusFailed || w.RetryCount != 2 {
+		t.Fatalf("unexpected failed witness %+v", w)
+	}
+
+	// 常驻模式下队列为空时继续等待, 直到ctx取消
+	p.Daemon = true
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = p.Run(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("prover in daemon mode returns before ctx is canceled")
+	}
+}
+
+// racingProofModel 在保存证明之前先保存另一个prover的证明, 模拟两个prover同时保存同一批次
+type racingProofModel struct {
+	ProofModel
+	other *Proof
+}
+
+func (m *racingProofModel) CreateProof(row *Proof) error {
+	if err := m.ProofModel.CreateProof(m.other); err != nil {
+		return err
+	}
+	return m.ProofModel.CreateProof(row)
+}
+
+// TestProverSaveProofRace 测试租约过期后两个prover保存同一批次的证明, 后保存的不会退出, 批次由租约的持有者标记为已完成
+func TestProverSaveProofRace(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 0, Status: witness.StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	proofModel := NewProofModel(db, "0")
+	if err = proofModel.CreateProofTable(); err != nil {
+		t.Fatal(err)
+	}
+	// prover-a的租约过期后批次被prover-b领取
+	if _, err = witnessModel.AcquireBatchWitnessLease(0, "prover-a", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = witnessModel.RequeueExpiredBatchWitness(200, DefaultMaxRetries); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = witnessModel.AcquireBatchWitnessLease(0, "prover-b", 300); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Prover{witnessModel: witnessModel, ProverId: "prover-a",
+		proofModel: &racingProofModel{proofModel, &Proof{BatchNumber: 0, ProofInfo: "b"}}}
+	if err = a.saveProof(&Proof{BatchNumber: 0, ProofInfo: "a"}, false); err != nil {
+		t.Fatalf("expect the existing proof to be treated as proven but got %v", err)
+	}
+	w, err := witnessModel.GetBatchWitnessByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != witness.StatusReceived || w.LeaseOwner != "prover-b" {
+		t.Fatalf("expect the witness to be kept by the lease owner but got %+v", w)
+	}
+
+	b := &Prover{witnessModel: witnessModel, proofModel: proofModel, ProverId: "prover-b"}
+	if err = b.saveProof(&Proof{BatchNumber: 0, ProofInfo: "b"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = witnessModel.GetBatchWitnessByHeight(0); err != nil || w.Status != witness.StatusFinished {
+		t.Fatalf("expect the witness to be finished by the lease owner but got %+v %v", w, err)
+	}
+}
+
+// failingProofModel 模拟数据库暂时不可用, 证明无法保存
+type failingProofModel struct {
+	ProofModel
+}
+
+func (m *failingProofModel) CreateProof(row *Proof) error {
+	return errors.New("connection refused")
+}
+
+// TestProverSaveProofFailure 测试证明保存失败时返回可以重试的错误, 而不是ErrFatal
+func TestProverSaveProofFailure(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 0, Status: witness.StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	proofModel := NewProofModel(db, "0")
+	if err = proofModel.CreateProofTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = witnessModel.AcquireBatchWitnessLease(0, "prover-a", time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Prover{witnessModel: witnessModel, proofModel: &failingProofModel{proofModel}, ProverId: "prover-a"}
+	err = p.saveProof(&Proof{BatchNumber: 0, ProofInfo: "a"}, false)
+	if !errors.Is(err, ErrSaveProof) || errors.Is(err, ErrFatal) {
+		t.Fatalf("expect a retryable save proof error but got %v", err)
+	}
+	w, err := witnessModel.GetBatchWitnessByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != witness.StatusReceived {
+		t.Fatalf("expect the witness not to be finished but got %+v", w)
+	}
+}
@@ -4,7 +4,32 @@ type Config struct {
 	MysqlDataSource string
 	UserDataFile    string
 	DbSuffix        string
-	TreeDB          struct {
+	// 电路参数文件, 为空时使用默认的电路参数, 必须与witness使用的相同
+	CircuitParamsFile string
+	// 账户盐值密钥文件和审计轮次编号, 必须与witness使用的相同, 盐值会写入用户证明, 轮次编号还用于计算账户查询键
+	AccountSaltKeyFile string
+	RoundId            uint64
+	// 账户索引置换种子文件, 必须与witness使用的相同, 生成用户证明时检查种子承诺与witness保存的相同
+	ShuffleSeedFile string
+	// 增量审计: 上一轮审计的用户数据目录和DbSuffix, 必须与witness使用的相同
+	BaseUserDataFile string
+	BaseDbSuffix     string
+	// 生成用户证明的线程数(默认为CPU核数)和每个事务写入的用户证明数量(默认1000)
+	WorkersNum     int
+	WriteBatchSize int
+	// 用户证明查询服务(-serve)的监听地址, 默认为:8081
+	HttpListenAddr string
+	// 查询服务缓存的用户证明数量(默认100000), 每个客户端IP每秒的请求数量(默认10)和突发请求数量(默认20)
+	CacheSize int
+	RateLimit float64
+	RateBurst int
+	// 受信任的反向代理写入客户端IP的请求头(例如X-Forwarded-For), 为空时按连接的对端地址限流
+	// 只能在服务只能通过该代理访问时设置, 否则客户端可以伪造请求头绕过限流
+	TrustedProxyHeader string
+	// 静态导出(-export)的分片前缀长度(账户查询键的hex字符数, 默认2, 最大3)和是否gzip压缩分片
+	ExportPrefixLength int
+	ExportGzip         bool
+	TreeDB             struct {
 		Driver string
 		Option struct {
 			Addr string
@@ -1,46 +1,102 @@
 package main
 
 import (
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
+	"syscall"
 	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/config"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/userproof"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
 	bsmt "github.com/bnb-chain/zkbnb-smt"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-// HandleUserData 处理用户数据，解析用户数据集
+// HandleUserData 处理用户数据，解析用户数据集并按与witness相同的方式分配账户索引
+// 增量审计时从上一轮witness保存的账户索引表读取上一轮的账户索引, 再通过PrepareRoundAccounts分配本轮的账户索引,
+// 并检查结果与本轮witness保存的账户索引信息一致
 // 参数:
 //   - userProofConfig: 用户证明配置
+//   - db: witness保存账户索引的数据库
 //
 // 返回:
-//   - map[int][]utils.AccountInfo: 按资产数量分组的用户账户信息
-func HandleUserData(userProofConfig *config.Config) map[int][]utils.AccountInfo {
+//   - map[int][]utils.AccountInfo: 按资产数量分组的用户账户信息, 账户索引为账户树中的索引
+func HandleUserData(userProofConfig *config.Config, db *gorm.DB) map[int][]utils.AccountInfo {
 	startTime := time.Now().UnixMilli()
 	// 解析用户数据集
 	accounts, _, err := utils.ParseUserDataSet(userProofConfig.UserDataFile)
 	if err != nil {
 		panic(err.Error())
 	}
+	if userProofConfig.AccountSaltKeyFile != "" && userProofConfig.BaseUserDataFile != "" {
+		panic("the salted round should be a full round, AccountSaltKeyFile and BaseUserDataFile can not be set together")
+	}
+	utils.SaltAccounts(accounts, loadAccountSaltKey(userProofConfig), userProofConfig.RoundId)
+
+	var baseAccounts map[int][]utils.AccountInfo
+	baseNextAccountIndex := 0
+	if userProofConfig.BaseUserDataFile != "" {
+		baseAccounts, _, baseNextAccountIndex, err = witness.LoadBaseAccounts(db, userProofConfig.BaseUserDataFile,
+			userProofConfig.BaseDbSuffix)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	shuffleSeed := loadShuffleSeed(userProofConfig)
+	round := utils.PrepareRoundAccounts(baseAccounts, baseNextAccountIndex, accounts, shuffleSeed)
+	// 索引范围和置换种子必须与witness保存的相同, 否则账户索引与账户树不一致
+	accountRound, err := witness.NewAccountIndexModel(db, userProofConfig.DbSuffix).GetAccountRound()
+	if err != nil {
+		panic("the account indexes of current round are not saved by witness: " + err.Error())
+	}
+	if !accountRound.SameIndexes(witness.NewAccountRound(accounts, round, shuffleSeed)) {
+		panic("the account indexes are different from witness, check the base round and shuffle seed config")
+	}
 
 	endTime := time.Now().UnixMilli()
 	fmt.Println("handle user data cost ", endTime-startTime, " ms")
 	return accounts
 }
 
+// loadAccountSaltKey 读取与witness相同的账户盐值密钥, 没有配置密钥时返回nil
+func loadAccountSaltKey(userProofConfig *config.Config) []byte {
+	if userProofConfig.AccountSaltKeyFile == "" {
+		return nil
+	}
+	saltKey, err := utils.LoadAccountSaltKey(userProofConfig.AccountSaltKeyFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	return saltKey
+}
+
+// loadShuffleSeed 读取与witness相同的账户索引置换种子, 没有配置种子文件时返回nil
+func loadShuffleSeed(userProofConfig *config.Config) []byte {
+	if userProofConfig.ShuffleSeedFile == "" {
+		return nil
+	}
+	shuffleSeed, err := utils.LoadShuffleSeed(userProofConfig.ShuffleSeedFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	return shuffleSeed
+}
+
 // AccountLeave 账户叶子节点结构
 type AccountLeave struct {
 	hash  []byte // 账户哈希值
@@ -48,9 +104,13 @@ type AccountLeave struct {
 }
 
 // ComputeAccountRootHash 计算账户树根哈希
+// 只支持从空账户树开始的完整审计, 增量审计的账户树还包含之前轮次的填充账户
 // 参数:
 //   - userProofConfig: 用户证明配置
 func ComputeAccountRootHash(userProofConfig *config.Config) {
+	if userProofConfig.BaseUserDataFile != "" {
+		panic("the memory tree only supports the full round")
+	}
 	// 1. 创建内存账户树
 	accountTree, err := utils.NewAccountTree("memory", "")
 	fmt.Printf("empty accountTree root is %x\n", accountTree.Root())
@@ -63,26 +123,20 @@ func ComputeAccountRootHash(userProofConfig *config.Config) {
 	if err != nil {
 		panic(err.Error())
 	}
+	utils.SaltAccounts(accounts, loadAccountSaltKey(userProofConfig), userProofConfig.RoundId)
 
-	// 3. 计算总账户数并填充数据
+	// 3. 与witness相同地填充账户并分配账户索引
 	startTime := time.Now().UnixMilli()
-	totalAccountCount := 0
-	for _, account := range accounts {
-		totalAccountCount += len(account)
-	}
-	paddingStartIndex := totalAccountCount
+	round := utils.PrepareRoundAccounts(nil, 0, accounts, loadShuffleSeed(userProofConfig))
 
-	// 4. 按资产数量分组处理
+	// 4. 按资产数量分组的顺序并行计算账户哈希
 	keys := make([]int, 0)
-	for k := range accounts {
+	for k := range round.Creates {
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
-
-	// 5. 并行计算账户哈希
 	for _, key := range keys {
-		account := accounts[key]
-		paddingStartIndex, account = utils.PaddingAccounts(account, key, paddingStartIndex)
+		account := round.Creates[key]
 		totalOpsNumber := len(account)
 		fmt.Println("the asset counts of user is ", key, "total ops number is ", totalOpsNumber)
 
@@ -167,6 +221,8 @@ func main() {
 	// 命令行参数解析
 	memoryTreeFlag := flag.Bool("memory_tree", false, "construct memory merkle tree")
 	remotePasswdConfig := flag.String("remote_password_config", "", "fetch password from aws secretsmanager")
+	serve := flag.Bool("serve", false, "run the read-only HTTP service to look up user proofs")
+	exportDir := flag.String("export", "", "export the userproof table to sharded static files in the directory")
 	flag.Parse()
 
 	// 加载配置文件
@@ -189,17 +245,50 @@ func main() {
 		userProofConfig.MysqlDataSource = s
 	}
 
+	// 加载电路参数, 账户树深度和资产数量分组必须与witness一致
+	err = utils.InitCircuitParams(userProofConfig.CircuitParamsFile)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// 查询服务模式, 只读取已经生成的用户证明
+	if *serve {
+		runLookupService(userProofConfig)
+		return
+	}
+
+	// 导出模式, 只读取已经生成的用户证明
+	if *exportDir != "" {
+		exportUserProofs(userProofConfig, *exportDir)
+		return
+	}
+
 	// 如果是内存树模式，只计算根哈希后返回
 	if *memoryTreeFlag {
 		ComputeAccountRootHash(userProofConfig)
 		return
 	}
 
-	// 创建账户树和处理用户数据
-	accountTree, err := utils.NewAccountTree(userProofConfig.TreeDB.Driver, userProofConfig.TreeDB.Option.Addr)
-	accountsMap := HandleUserData(userProofConfig)
+	// 打开账户树存储, 每个线程在同一个存储上使用一个账户树, 账户树不能被多个线程同时读取
+	workersNum := userProofConfig.WorkersNum
+	if workersNum <= 0 {
+		workersNum = runtime.NumCPU()
+	}
+	treeDB, err := utils.OpenTreeDB(userProofConfig.TreeDB.Driver, userProofConfig.TreeDB.Option.Addr)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer treeDB.Close()
+	accountTrees := make([]bsmt.SparseMerkleTree, workersNum)
+	for i := range accountTrees {
+		accountTrees[i], err = utils.NewAccountTreeWithDB(treeDB)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	accountsMap := HandleUserData(userProofConfig, openDatabase(userProofConfig))
 
-	// 统计账户信息
+	// 统计账户信息, 按资产数量分组的顺序生成用户证明
 	totalAccountCounts := 0
 	accountAssetKeys := make([]int, 0)
 	for k, accounts := range accountsMap {
@@ -208,205 +297,93 @@ func main() {
 		fmt.Println("the asset counts of user is ", k, "total ops number is ", len(accounts))
 	}
 	sort.Ints(accountAssetKeys)
+	accountGroups := make([][]utils.AccountInfo, len(accountAssetKeys))
+	for i, k := range accountAssetKeys {
+		accountGroups[i] = accountsMap[k]
+	}
 	fmt.Println("total accounts num", totalAccountCounts)
 
-	// 初始化数据库表
+	// 初始化数据库表, 从已经写入的用户证明数量继续
 	userProofModel := OpenUserProofTable(userProofConfig)
 	currentAccountCounts, err := userProofModel.GetUserCounts()
 	if err != nil && err != utils.DbErrNotFound {
 		panic(err.Error())
 	}
-	totalCounts := currentAccountCounts
-
-	// 获取账户树根哈希
-	accountTreeRoot := hex.EncodeToString(accountTree.Root())
-
-	// 创建通道
-	jobs := make(chan Job, 1000)                 // 任务通道
-	nums := make(chan int, 1)                    // 计数通道
-	results := make(chan *model.UserProof, 1000) // 结果通道
-
-	// 启动工作线程
-	for i := 0; i < 1; i++ {
-		go worker(jobs, results, nums, accountTreeRoot)
-	}
-
-	// 启动数据库写入线程
-	quit := make(chan int, 1)
-	for i := 0; i < 1; i++ {
-		go WriteDB(results, userProofModel, quit, currentAccountCounts)
-	}
-
-	// 处理每个资产组的账户
-	prevAccountCounts := 0
-	for _, k := range accountAssetKeys {
-		accounts := accountsMap[k]
-		// 跳过已处理的账户
-		if currentAccountCounts >= len(accounts)+prevAccountCounts {
-			prevAccountCounts = len(accounts) + prevAccountCounts
-			continue
-		}
-
-		// 为每个账户生成证明
-		for i := currentAccountCounts - prevAccountCounts; i < len(accounts); i++ {
-			// 获取账户叶子节点和证明
-			leaf, err := accountTree.Get(uint64(accounts[i].AccountIndex), nil)
-			if err != nil {
-				panic(err.Error())
-			}
-			proof, err := accountTree.GetProof(uint64(accounts[i].AccountIndex))
-			if err != nil {
-				panic(err.Error())
-			}
-			// 发送任务
-			jobs <- Job{
-				account: &accounts[i],
-				proof:   proof,
-				leaf:    leaf,
-			}
-		}
-		prevAccountCounts += len(accounts)
-		currentAccountCounts = prevAccountCounts
-	}
+	fmt.Println("resume from ", currentAccountCounts, "user proofs, workers num ", workersNum)
 
-	// 关闭任务通道并等待处理完成
-	close(jobs)
-	for i := 0; i < 1; i++ {
-		num := <-nums
-		totalCounts += num
-		fmt.Println("totalCounts", totalCounts)
+	startTime := time.Now()
+	written, err := userproof.GenerateUserProofs(accountGroups, userProofConfig.RoundId, currentAccountCounts, accountTrees,
+		userProofModel, userProofConfig.WriteBatchSize)
+	totalCounts := currentAccountCounts + written
+	fmt.Println("totalCounts", totalCounts, "cost", time.Since(startTime))
+	if err != nil {
+		panic(err.Error())
 	}
 
 	// 验证处理数量
-	expectedTotalCounts := 0
-	for _, accounts := range accountsMap {
-		expectedTotalCounts += len(accounts)
-	}
-	if totalCounts != expectedTotalCounts {
-		fmt.Println("totalCounts actual:expected", totalCounts, expectedTotalCounts)
+	if totalCounts != totalAccountCounts {
+		fmt.Println("totalCounts actual:expected", totalCounts, totalAccountCounts)
 		panic("mismatch num")
 	}
-
-	// 关闭结果通道并等待写入完成
-	close(results)
-	for i := 0; i < 1; i++ {
-		<-quit
-	}
 	fmt.Println("userproof service run finished...")
 }
 
-// WriteDB 将用户证明写入数据库
-// 参数:
-//   - results: 用户证明结果通道
-//   - userProofModel: 用户证明数据模型
-//   - quit: 退出通道
-//   - currentAccountCounts: 当前账户数量
-func WriteDB(results <-chan *model.UserProof, userProofModel model.UserProofModel, quit chan<- int, currentAccountCounts int) {
-	index := 0
-	proofs := make([]model.UserProof, 100) // 批量写入缓冲
-	num := int(currentAccountCounts)
-
-	// 处理每个证明结果
-	for proof := range results {
-		proofs[index] = *proof
-		index += 1
-		// 每100个写入一次数据库
-		if index%100 == 0 {
-			error := userProofModel.CreateUserProofs(proofs)
-			if error != nil {
-				panic(error.Error())
-			}
-			num += 100
-			if num%100000 == 0 {
-				fmt.Println("write ", num, "proof to db")
-			}
-			index = 0
-		}
+// runLookupService 运行只读的用户证明查询服务, 收到SIGINT或SIGTERM后退出
+func runLookupService(userProofConfig *config.Config) {
+	db := openDatabase(userProofConfig)
+	userProofModel := model.NewUserProofModel(db, userProofConfig.DbSuffix)
+	meta, err := userproof.LoadRoundMetadata(witness.NewWitnessModel(db, userProofConfig.DbSuffix),
+		witness.NewAccountIndexModel(db, userProofConfig.DbSuffix), userProofModel)
+	if err != nil {
+		panic(err.Error())
 	}
-
-	// 处理剩余的证明
-	proofs = proofs[:index]
-	if index > 0 {
-		fmt.Println("write ", len(proofs), "proofs to db")
-		userProofModel.CreateUserProofs(proofs)
-		num += index
+	service, err := userproof.NewUserProofService(userProofModel, meta,
+		userProofConfig.CacheSize, userProofConfig.RateLimit, userProofConfig.RateBurst, userProofConfig.TrustedProxyHeader)
+	if err != nil {
+		panic(err.Error())
 	}
-	fmt.Println("total write ", num)
-	quit <- 0
-}
-
-// Job 用户证明任务结构
-type Job struct {
-	account *utils.AccountInfo // 账户信息
-	proof   [][]byte           // Merkle证明
-	leaf    []byte             // 叶子节点哈希
-}
-
-// worker 处理用户证明任务的工作线程
-// 参数:
-//   - jobs: 任务通道
-//   - results: 结果通道
-//   - nums: 计数通道
-//   - root: 树根哈希
-func worker(jobs <-chan Job, results chan<- *model.UserProof, nums chan<- int, root string) {
-	num := 0
-	for job := range jobs {
-		userProof := ConvertAccount(job.account, job.leaf, job.proof, root)
-		results <- userProof
-		num += 1
+	addr := userProofConfig.HttpListenAddr
+	if addr == "" {
+		addr = ":8081"
 	}
-	nums <- num
+	server := &http.Server{Addr: addr, Handler: service.Handler()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	fmt.Printf("user proof lookup service of round %d (root %s) listen on %s\n", meta.RoundId, meta.Root, addr)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err.Error())
+	}
+	fmt.Println("user proof lookup service is stopped")
 }
 
-// ConvertAccount 将账户信息转换为用户证明
-// 参数:
-//   - account: 账户信息
-//   - leafHash: 叶子节点哈希
-//   - proof: Merkle证明
-//   - root: 树根哈希
-//
-// 返回:
-//   - *model.UserProof: 用户证明
-func ConvertAccount(account *utils.AccountInfo, leafHash []byte, proof [][]byte, root string) *model.UserProof {
-	var userProof model.UserProof
-	var userConfig model.UserConfig
-	userProof.AccountIndex = account.AccountIndex
-	userProof.AccountId = hex.EncodeToString(account.AccountId)
-	userProof.AccountLeafHash = hex.EncodeToString(leafHash)
-	proofSerial, err := json.Marshal(proof)
-	userProof.Proof = string(proofSerial)
-	assets, err := json.Marshal(account.Assets)
+// exportUserProofs 将用户证明表导出为按账户查询键前缀分片的静态文件, 最后写入清单
+func exportUserProofs(userProofConfig *config.Config, dir string) {
+	db := openDatabase(userProofConfig)
+	userProofModel := model.NewUserProofModel(db, userProofConfig.DbSuffix)
+	meta, err := userproof.LoadRoundMetadata(witness.NewWitnessModel(db, userProofConfig.DbSuffix),
+		witness.NewAccountIndexModel(db, userProofConfig.DbSuffix), userProofModel)
 	if err != nil {
 		panic(err.Error())
 	}
-	userProof.Assets = string(assets)
-	userProof.TotalDebt = account.TotalDebt.String()
-	userProof.TotalEquity = account.TotalEquity.String()
-	userProof.TotalCollateral = account.TotalCollateral.String()
-
-	userConfig.AccountIndex = account.AccountIndex
-	userConfig.AccountIdHash = hex.EncodeToString(account.AccountId)
-	userConfig.Proof = proof
-	userConfig.Root = root
-	userConfig.Assets = account.Assets
-	userConfig.TotalDebt = account.TotalDebt
-	userConfig.TotalEquity = account.TotalEquity
-	userConfig.TotalCollateral = account.TotalCollateral
-	configSerial, err := json.Marshal(userConfig)
+	startTime := time.Now()
+	manifest, err := userproof.ExportUserProofs(userProofModel, meta, dir,
+		userProofConfig.ExportPrefixLength, userProofConfig.ExportGzip)
 	if err != nil {
 		panic(err.Error())
 	}
-	userProof.Config = string(configSerial)
-	return &userProof
+	fmt.Println("export ", manifest.UserCount, "user proofs to ", len(manifest.Shards), "shards in ", dir, "cost", time.Since(startTime))
 }
 
-// OpenUserProofTable 打开用户证明表
-// 参数:
-//   - userConfig: 用户配置
-//
-// 返回:
-//   - model.UserProofModel: 用户证明数据模型
-func OpenUserProofTable(userConfig *config.Config) model.UserProofModel {
+// openDatabase 打开用户证明所在的数据库
+func openDatabase(userConfig *config.Config) *gorm.DB {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		logger.Config{
@@ -416,13 +393,23 @@ func OpenUserProofTable(userConfig *config.Config) model.UserProofModel {
 			Colorful:                  false,            // Disable color
 		},
 	)
-	db, err := gorm.Open(mysql.Open(userConfig.MysqlDataSource), &gorm.Config{
+	db, err := utils.OpenDatabase(userConfig.MysqlDataSource, &gorm.Config{
 		Logger: newLogger,
 	})
 	if err != nil {
 		panic(err.Error())
 	}
-	userProofTable := model.NewUserProofModel(db, userConfig.DbSuffix)
+	return db
+}
+
+// OpenUserProofTable 打开用户证明表
+// 参数:
+//   - userConfig: 用户配置
+//
+// 返回:
+//   - model.UserProofModel: 用户证明数据模型
+func OpenUserProofTable(userConfig *config.Config) model.UserProofModel {
+	userProofTable := model.NewUserProofModel(openDatabase(userConfig), userConfig.DbSuffix)
 	userProofTable.CreateUserProofTable()
 	return userProofTable
 }
@@ -10,16 +10,24 @@ import (
 // 表名前缀
 const TableNamePreifx = "userproof"
 
+// 每条INSERT语句写入的用户证明数量, 避免超过数据库的参数数量限制
+const insertBatchSize = 500
+
+// 静态导出的清单文件名
+const ExportManifestFile = "manifest.json"
+
 type (
 	// UserProofModel 用户证明数据模型接口
 	UserProofModel interface {
-		CreateUserProofTable() error                       // 创建用户证明表
-		DropUserProofTable() error                         // 删除用户证明表
-		CreateUserProofs(rows []UserProof) error           // 批量创建用户证明
-		GetUserProofByIndex(id uint32) (*UserProof, error) // 通过账户索引获取用户证明
-		GetUserProofById(id string) (*UserProof, error)    // 通过账户ID获取用户证明
-		GetLatestAccountIndex() (uint32, error)            // 获取最新账户索引
-		GetUserCounts() (int, error)                       // 获取用户总数
+		CreateUserProofTable() error                                        // 创建用户证明表
+		DropUserProofTable() error                                          // 删除用户证明表
+		CreateUserProofs(rows []UserProof) error                            // 在一个事务中批量创建用户证明
+		GetUserProofByIndex(id uint32) (*UserProof, error)                  // 通过账户索引获取用户证明
+		GetUserProofById(id string) (*UserProof, error)                     // 通过账户ID获取用户证明
+		GetUserProofByLookupKey(lookupKey string) (*UserProof, error)       // 通过账户查询键获取用户证明
+		GetUserProofsBetween(start uint32, end uint32) ([]UserProof, error) // 获取账户索引在指定范围内的用户证明
+		GetLatestAccountIndex() (uint32, error)                             // 获取最新账户索引
+		GetUserCounts() (int, error)                                        // 获取用户总数
 	}
 
 	defaultUserProofModel struct {
@@ -29,7 +37,8 @@ type (
 
 	UserProof struct {
 		AccountIndex    uint32 `gorm:"index:idx_int,unique"` // 账户索引(唯一索引)
-		AccountId       string `gorm:"index:idx_str,unique"` // 账户ID(唯一索引)
+		AccountId       string `gorm:"index:idx_str,unique"` // 账户ID(唯一索引, hex编码, 不加盐)
+		LookupKey       string `gorm:"index:idx_lookup_key"` // 账户查询键(hex编码), 与UserConfig.LookupKey相同, 见utils.ComputeAccountLookupKey, 旧版本生成的用户证明为空
 		AccountLeafHash string // 账户叶子节点哈希
 		TotalEquity     string // 总权益
 		TotalDebt       string // 总债务
@@ -41,7 +50,9 @@ type (
 
 	UserConfig struct {
 		AccountIndex    uint32               // 账户索引
-		AccountIdHash   string               // 账户ID哈希
+		AccountIdHash   string               // 账户ID哈希, 有盐值时为Poseidon(AccountId, Salt)
+		LookupKey       string               // 账户查询键(hex编码), 查询服务和静态导出使用, 不在叶子节点中
+		Salt            string               // 本轮审计的盐值(hex编码), 没有盐值时为空
 		TotalEquity     *big.Int             // 总权益
 		TotalDebt       *big.Int             // 总债务
 		TotalCollateral *big.Int             // 总抵押品
@@ -49,6 +60,29 @@ type (
 		Root            string               // Merkle树根
 		Proof           [][]byte             // Merkle证明
 	}
+
+	// ExportManifest 静态导出的清单, 在所有分片写完后写入
+	ExportManifest struct {
+		Root         string // 账户树根(hex编码)
+		RoundId      uint64
+		Timestamp    uint64
+		PrefixLength int    // 分片使用的账户查询键前缀长度(hex字符数)
+		Compression  string // 分片的压缩方式, 为空或gzip
+		UserCount    int
+		Shards       []ExportShard // 按前缀排序, 没有用户的前缀没有分片
+		// 账户索引置换种子的承诺(hex编码)和置换的索引范围[CreateStartIndex, NextAccountIndex), 与RoundMetadata相同
+		ShuffleSeedCommitment string
+		CreateStartIndex      int64
+		NextAccountIndex      int64
+	}
+
+	// ExportShard 一个分片文件, 每行一个user_config.json格式的用户证明
+	ExportShard struct {
+		Prefix    string
+		File      string // 相对于导出目录的文件名
+		UserCount int
+		Sha256    string // 分片文件(压缩后)的sha256哈希
+	}
 )
 
 // TableName 获取表名
@@ -80,18 +114,19 @@ func (m *defaultUserProofModel) DropUserProofTable() error {
 	return m.DB.Migrator().DropTable(m.table)
 }
 
-// CreateUserProofs 批量创建用户证明
+// CreateUserProofs 在一个事务中批量创建用户证明, 失败时不写入任何用户证明
 // 参数:
 //   - rows: 用户证明数组
 //
 // 返回:
 //   - error: 错误信息
 func (m *defaultUserProofModel) CreateUserProofs(rows []UserProof) error {
-	dbTx := m.DB.Table(m.table).Create(rows)
-	if dbTx.Error != nil {
-		return dbTx.Error
+	if len(rows) == 0 {
+		return nil
 	}
-	return nil
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.Table(m.table).CreateInBatches(rows, insertBatchSize).Error
+	})
 }
 
 // GetUserProofByIndex 通过账户索引获取用户证明
@@ -114,7 +149,7 @@ func (m *defaultUserProofModel) GetUserProofByIndex(id uint32) (userproof *UserP
 
 // GetUserProofById 通过账户ID获取用户证明
 // 参数:
-//   - id: 账户ID
+//   - id: hex编码的账户ID
 //
 // 返回:
 //   - *UserProof: 用户证明
@@ -130,6 +165,44 @@ func (m *defaultUserProofModel) GetUserProofById(id string) (userproof *UserProo
 	return userproof, nil
 }
 
+// GetUserProofByLookupKey 通过账户查询键获取用户证明
+// 参数:
+//   - lookupKey: hex编码的账户查询键
+//
+// 返回:
+//   - *UserProof: 用户证明
+//   - error: 错误信息
+func (m *defaultUserProofModel) GetUserProofByLookupKey(lookupKey string) (userproof *UserProof, err error) {
+	userproof = &UserProof{}
+	dbTx := m.DB.Table(m.table).Where("lookup_key = ?", lookupKey).Find(userproof)
+	if dbTx.Error != nil {
+		return nil, dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return userproof, nil
+}
+
+// GetUserProofsBetween 获取账户索引在指定范围内的用户证明
+// 参数:
+//   - start: 起始账户索引
+//   - end: 结束账户索引(包含)
+//
+// 返回:
+//   - []UserProof: 按账户索引排序的用户证明
+//   - error: 错误信息
+func (m *defaultUserProofModel) GetUserProofsBetween(start uint32, end uint32) (userproofs []UserProof, err error) {
+	dbTx := m.DB.Table(m.table).Where("account_index >= ? AND account_index <= ?", start, end).
+		Order("account_index").
+		Find(&userproofs)
+	if dbTx.Error != nil {
+		return nil, dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return userproofs, nil
+}
+
 // GetLatestAccountIndex 获取最新账户索引
 // 返回:
 //   - uint32: 最新账户索引
@@ -0,0 +1,162 @@
+package userproof
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+// 静态导出的默认配置
+const (
+	DefaultExportPrefixLength = 2 // 默认按账户查询键的前2个hex字符分片, 共256个分片
+	MaxExportPrefixLength     = 3 // 所有分片文件同时打开, 最多4096个分片, 不超过常见的文件描述符上限
+)
+
+// exportShardWriter 写入一个分片文件并计算其哈希
+type exportShardWriter struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	w      io.Writer
+	hasher hash.Hash
+	shard  model.ExportShard
+}
+
+func (s *exportShardWriter) close() error {
+	var err error
+	if s.gzip != nil {
+		err = s.gzip.Close()
+	}
+	return errors.Join(err, s.file.Close())
+}
+
+// ExportUserProofs 将用户证明表中的用户证明按账户查询键的前缀导出为静态分片文件
+// 分片文件为JSON lines格式, 可以直接用于verifier -users, 其中的一行可以保存为user_config.json用于verifier -user
+// 参数:
+//   - userProofModel: 用户证明表
+//   - meta: 用户证明所属的审计轮次, 账户树根必须与用户证明相同
+//   - dir: 导出目录, 不存在时创建
+//   - prefixLength: 前缀长度(hex字符数), 小于等于0时使用DefaultExportPrefixLength
+//   - compress: 是否使用gzip压缩分片文件
+//
+// 返回:
+//   - *model.ExportManifest: 写入dir/manifest.json的清单
+//   - error: 错误信息
+func ExportUserProofs(userProofModel model.UserProofModel, meta *RoundMetadata, dir string, prefixLength int, compress bool) (*model.ExportManifest, error) {
+	if prefixLength <= 0 {
+		prefixLength = DefaultExportPrefixLength
+	}
+	if prefixLength > MaxExportPrefixLength {
+		return nil, fmt.Errorf("the prefix length %d is larger than %d", prefixLength, MaxExportPrefixLength)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &model.ExportManifest{
+		Root:                  meta.Root,
+		RoundId:               meta.RoundId,
+		Timestamp:             meta.Timestamp,
+		PrefixLength:          prefixLength,
+		ShuffleSeedCommitment: meta.ShuffleSeedCommitment,
+		CreateStartIndex:      meta.CreateStartIndex,
+		NextAccountIndex:      meta.NextAccountIndex,
+	}
+	suffix := ".jsonl"
+	if compress {
+		manifest.Compression = "gzip"
+		suffix = ".jsonl.gz"
+	}
+
+	shards := make(map[string]*exportShardWriter)
+	defer func() {
+		for _, s := range shards {
+			s.close()
+		}
+	}()
+	writeUserProof := func(row *model.UserProof) error {
+		var userConfig model.UserConfig
+		err := json.Unmarshal([]byte(row.Config), &userConfig)
+		if err != nil {
+			return fmt.Errorf("decode user proof of account %d failed: %w", row.AccountIndex, err)
+		}
+		if userConfig.Root != meta.Root {
+			return fmt.Errorf("the user proof of account %d is against root %s instead of %s", row.AccountIndex, userConfig.Root, meta.Root)
+		}
+		lookupKey := strings.ToLower(userConfig.LookupKey)
+		if len(lookupKey) < prefixLength {
+			return fmt.Errorf("the lookup key %q of account %d is too short", lookupKey, row.AccountIndex)
+		}
+		prefix := lookupKey[:prefixLength]
+		s, ok := shards[prefix]
+		if !ok {
+			s = &exportShardWriter{shard: model.ExportShard{Prefix: prefix, File: prefix + suffix}, hasher: sha256.New()}
+			s.file, err = os.Create(filepath.Join(dir, s.shard.File))
+			if err != nil {
+				return err
+			}
+			s.w = io.MultiWriter(s.file, s.hasher)
+			if compress {
+				s.gzip = gzip.NewWriter(s.w)
+				s.w = s.gzip
+			}
+			shards[prefix] = s
+		}
+		_, err = s.w.Write(append([]byte(row.Config), '\n'))
+		if err != nil {
+			return err
+		}
+		s.shard.UserCount++
+		manifest.UserCount++
+		return nil
+	}
+
+	latestIndex, err := userProofModel.GetLatestAccountIndex()
+	if err != nil && !errors.Is(err, utils.DbErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		limit := uint64(1024)
+		for start := uint64(0); start <= uint64(latestIndex); start += limit {
+			end := start + limit - 1
+			if end > uint64(latestIndex) {
+				end = uint64(latestIndex)
+			}
+			rows, err := userProofModel.GetUserProofsBetween(uint32(start), uint32(end))
+			if err != nil && !errors.Is(err, utils.DbErrNotFound) {
+				return nil, err
+			}
+			for i := range rows {
+				if err = writeUserProof(&rows[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for prefix, s := range shards {
+		delete(shards, prefix)
+		if err = s.close(); err != nil {
+			return nil, err
+		}
+		s.shard.Sha256 = hex.EncodeToString(s.hasher.Sum(nil))
+		manifest.Shards = append(manifest.Shards, s.shard)
+	}
+	sort.Slice(manifest.Shards, func(i, j int) bool { return manifest.Shards[i].Prefix < manifest.Shards[j].Prefix })
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dir, model.ExportManifestFile), content, 0644)
+}
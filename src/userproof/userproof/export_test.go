@@ -0,0 +1,113 @@
+package userproof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
+)
+
+// TestExportUserProofs 测试导出的分片可以用verifier验证, 且清单中的哈希与分片一致
+func TestExportUserProofs(t *testing.T) {
+	accountGroups, trees, userProofModel := newTestAccounts(t)
+	_, err := GenerateUserProofs(accountGroups, testRoundId, 0, trees, userProofModel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按查询键的前缀计算每个分片的用户数量
+	expectedShards := make(map[string]int)
+	for _, accounts := range accountGroups {
+		for _, account := range accounts {
+			lookupKey := hex.EncodeToString(utils.ComputeAccountLookupKey(testRoundId, account.AccountId))
+			expectedShards[lookupKey[:DefaultExportPrefixLength]]++
+		}
+	}
+	meta := &RoundMetadata{Root: hex.EncodeToString(trees[0].Root()), RoundId: 7, ShuffleSeedCommitment: "ab", NextAccountIndex: 1400}
+
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		manifest, err := ExportUserProofs(userProofModel, meta, dir, 0, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.UserCount != 13 || manifest.PrefixLength != DefaultExportPrefixLength || manifest.RoundId != 7 || manifest.ShuffleSeedCommitment != "ab" || manifest.NextAccountIndex != 1400 {
+			t.Fatalf("unexpected manifest %+v", manifest)
+		}
+		if len(manifest.Shards) != len(expectedShards) {
+			t.Fatalf("unexpected shards %+v", manifest.Shards)
+		}
+		if _, err = os.Stat(filepath.Join(dir, model.ExportManifestFile)); err != nil {
+			t.Fatal(err)
+		}
+		userCount := 0
+		for _, shard := range manifest.Shards {
+			if expectedShards[shard.Prefix] != shard.UserCount {
+				t.Fatalf("expect %d users in shard %s but got %d", expectedShards[shard.Prefix], shard.Prefix, shard.UserCount)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, shard.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := sha256.Sum256(content)
+			if hex.EncodeToString(hash[:]) != shard.Sha256 {
+				t.Fatalf("the hash of shard %s is different from the manifest", shard.File)
+			}
+			reader, err := verifier.NewUserProofJsonlReader(filepath.Join(dir, shard.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := verifier.VerifyUserProofs(reader, manifest.Root, 2)
+			reader.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Passed() || result.UserCount != shard.UserCount {
+				t.Fatalf("verify shard %s failed: %d users, failures %v", shard.File, result.UserCount, result.Failures)
+			}
+			userCount += result.UserCount
+		}
+		if userCount != manifest.UserCount {
+			t.Fatalf("expect %d users in shards but got %d", manifest.UserCount, userCount)
+		}
+
+		// verifier -users按清单检查并依次验证所有分片
+		loaded, err := verifier.LoadExportManifest(dir, meta.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := verifier.VerifyUserProofs(verifier.NewUserProofManifestReader(dir, loaded), loaded.Root, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Passed() || result.UserCount != manifest.UserCount {
+			t.Fatalf("verify export failed: %d users, failures %v", result.UserCount, result.Failures)
+		}
+		if _, err = verifier.LoadExportManifest(dir, "00"); !errors.Is(err, verifier.ErrCommitmentMismatch) {
+			t.Fatalf("expect ErrCommitmentMismatch with a different root but got %v", err)
+		}
+		// 修改后的分片与清单中的哈希不同
+		shardFile := filepath.Join(dir, manifest.Shards[0].File)
+		content, err := os.ReadFile(shardFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(shardFile, append(content, content...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = verifier.LoadExportManifest(dir, ""); !errors.Is(err, verifier.ErrCommitmentMismatch) {
+			t.Fatalf("expect ErrCommitmentMismatch with a modified shard but got %v", err)
+		}
+	}
+
+	// 用户证明的账户树根必须与清单相同
+	meta.Root = "00"
+	if _, err = ExportUserProofs(userProofModel, meta, t.TempDir(), 0, false); err == nil {
+		t.Fatal("expect the export to fail with a different root")
+	}
+}
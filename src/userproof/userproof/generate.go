@@ -0,0 +1,214 @@
+package userproof
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// DefaultWriteBatchSize 默认每个事务写入的用户证明数量
+const DefaultWriteBatchSize = 1000
+
+// GenerateUserProofs 并行生成用户证明, 并按账户顺序分批写入用户证明表
+// 每批用户证明在一个事务中写入, 且只有之前的用户证明都写入后才会写入,
+// 所以中断后用户证明表中的用户证明数量(GetUserCounts)就是下次开始的位置
+// 参数:
+//   - accountGroups: 按写入顺序排列的账户分组, 写入顺序为分组的顺序, 分组内为数组的顺序
+//   - roundId: 审计轮次编号, 用于计算账户查询键
+//   - start: 已经写入的用户证明数量, 跳过前start个账户
+//   - trees: 每个线程使用的账户树, 必须是同一个存储上的同一个版本, 见utils.NewAccountTreeWithDB
+//   - userProofModel: 用户证明表
+//   - batchSize: 每个事务写入的用户证明数量, 小于等于0时使用DefaultWriteBatchSize
+//
+// 返回:
+//   - int: 本次写入的用户证明数量
+//   - error: 读取账户树失败, 账户树中的叶子节点与账户不一致或写入用户证明表失败时返回错误, 之前的批次已经写入
+func GenerateUserProofs(accountGroups [][]utils.AccountInfo, roundId uint64, start int, trees []bsmt.SparseMerkleTree,
+	userProofModel model.UserProofModel, batchSize int) (int, error) {
+	if len(trees) == 0 {
+		return 0, errors.New("there is no account tree to read proofs from")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultWriteBatchSize
+	}
+	root := hex.EncodeToString(trees[0].Root())
+
+	type job struct {
+		seq     int
+		account *utils.AccountInfo
+	}
+	type result struct {
+		seq   int
+		proof *model.UserProof
+		err   error
+	}
+	jobs := make(chan job, len(trees)*16)
+	results := make(chan result, len(trees)*16)
+	// 限制已生成但还没有写入的用户证明数量, 某个线程较慢时其他线程不会无限制地领先
+	window := make(chan struct{}, 2*batchSize+len(trees)*32)
+	// 写入失败后停止分发账户
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for _, accounts := range accountGroups {
+			if seq+len(accounts) <= start {
+				seq += len(accounts)
+				continue
+			}
+			for i := range accounts {
+				if seq >= start {
+					select {
+					case window <- struct{}{}:
+					case <-stop:
+						return
+					}
+					jobs <- job{seq: seq, account: &accounts[i]}
+				}
+				seq++
+			}
+		}
+	}()
+
+	workersDone := make(chan struct{})
+	for _, tree := range trees {
+		go func(tree bsmt.SparseMerkleTree) {
+			defer func() { workersDone <- struct{}{} }()
+			hasher := poseidon.NewPoseidon()
+			for j := range jobs {
+				proof, err := generateUserProof(tree, &hasher, j.account, roundId, root)
+				results <- result{seq: j.seq, proof: proof, err: err}
+			}
+		}(tree)
+	}
+	go func() {
+		for range trees {
+			<-workersDone
+		}
+		close(results)
+	}()
+
+	var err error
+	fail := func(e error) {
+		if err == nil {
+			err = e
+			close(stop)
+		}
+	}
+	written := 0
+	batch := make([]model.UserProof, 0, batchSize)
+	flush := func() {
+		if e := userProofModel.CreateUserProofs(batch); e != nil {
+			fail(fmt.Errorf("write user proofs from %d failed: %w", start+written, e))
+			return
+		}
+		written += len(batch)
+		for range batch {
+			<-window
+		}
+		batch = batch[:0]
+	}
+	// 线程完成的顺序不确定, 先缓存不连续的用户证明
+	pending := make(map[int]*model.UserProof)
+	next := start
+	for r := range results {
+		if err != nil {
+			continue
+		}
+		if r.err != nil {
+			fail(r.err)
+			continue
+		}
+		pending[r.seq] = r.proof
+		for proof, ok := pending[next]; ok && err == nil; proof, ok = pending[next] {
+			delete(pending, next)
+			batch = append(batch, *proof)
+			next++
+			if len(batch) == batchSize {
+				flush()
+			}
+		}
+	}
+	if err == nil && len(batch) > 0 {
+		flush()
+	}
+	return written, err
+}
+
+// generateUserProof 从账户树读取账户的叶子节点和Merkle证明, 生成用户证明
+// 叶子节点必须是账户的哈希, 否则账户索引与witness分配的索引不一致, 生成的用户证明无效
+func generateUserProof(tree bsmt.SparseMerkleTree, hasher *hash.Hash, account *utils.AccountInfo, roundId uint64, root string) (*model.UserProof, error) {
+	leaf, err := tree.Get(uint64(account.AccountIndex), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get leaf of account %d failed: %w", account.AccountIndex, err)
+	}
+	if expect := utils.AccountInfoToHash(account, hasher); !bytes.Equal(leaf, expect) {
+		return nil, fmt.Errorf("the leaf of account %d is %x instead of the account hash %x", account.AccountIndex, leaf, expect)
+	}
+	proof, err := tree.GetProof(uint64(account.AccountIndex))
+	if err != nil {
+		return nil, fmt.Errorf("get proof of account %d failed: %w", account.AccountIndex, err)
+	}
+	return ConvertAccount(account, leaf, proof, roundId, root)
+}
+
+// ConvertAccount 将账户信息转换为用户证明
+// 参数:
+//   - account: 账户信息
+//   - leafHash: 叶子节点哈希
+//   - proof: Merkle证明
+//   - roundId: 审计轮次编号, 用于计算账户查询键
+//   - root: 树根哈希
+//
+// 返回:
+//   - *model.UserProof: 用户证明
+//   - error: 错误信息
+func ConvertAccount(account *utils.AccountInfo, leafHash []byte, proof [][]byte, roundId uint64, root string) (*model.UserProof, error) {
+	var userProof model.UserProof
+	var userConfig model.UserConfig
+	userProof.AccountIndex = account.AccountIndex
+	accountIdHash := hex.EncodeToString(utils.ComputeAccountIdHash(account.AccountId, account.Salt))
+	lookupKey := hex.EncodeToString(utils.ComputeAccountLookupKey(roundId, account.AccountId))
+	userProof.AccountId = hex.EncodeToString(account.AccountId)
+	userProof.LookupKey = lookupKey
+	userProof.AccountLeafHash = hex.EncodeToString(leafHash)
+	proofSerial, err := json.Marshal(proof)
+	if err != nil {
+		return nil, err
+	}
+	userProof.Proof = string(proofSerial)
+	assets, err := json.Marshal(account.Assets)
+	if err != nil {
+		return nil, err
+	}
+	userProof.Assets = string(assets)
+	userProof.TotalDebt = account.TotalDebt.String()
+	userProof.TotalEquity = account.TotalEquity.String()
+	userProof.TotalCollateral = account.TotalCollateral.String()
+
+	userConfig.AccountIndex = account.AccountIndex
+	userConfig.AccountIdHash = accountIdHash
+	userConfig.LookupKey = lookupKey
+	userConfig.Salt = hex.EncodeToString(account.Salt)
+	userConfig.Proof = proof
+	userConfig.Root = root
+	userConfig.Assets = account.Assets
+	userConfig.TotalDebt = account.TotalDebt
+	userConfig.TotalEquity = account.TotalEquity
+	userConfig.TotalCollateral = account.TotalCollateral
+	configSerial, err := json.Marshal(userConfig)
+	if err != nil {
+		return nil, err
+	}
+	userProof.Config = string(configSerial)
+	return &userProof, nil
+}
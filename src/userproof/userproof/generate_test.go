@@ -0,0 +1,143 @@
+package userproof
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/bnb-chain/zkbnb-smt/database/memory"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testRoundId 测试用户证明的审计轮次编号, 与newTestRound中的见证数据相同
+const testRoundId = 7
+
+// failingUserProofModel 写入指定数量的批次后返回错误, 用于模拟中断
+type failingUserProofModel struct {
+	model.UserProofModel
+	batches int
+}
+
+func (m *failingUserProofModel) CreateUserProofs(rows []model.UserProof) error {
+	if m.batches == 0 {
+		return errors.New("interrupted")
+	}
+	m.batches--
+	return m.UserProofModel.CreateUserProofs(rows)
+}
+
+// newTestAccounts 创建两个资产数量分组的账户和每个线程一个的账户树, 账户索引与分组内的顺序无关
+func newTestAccounts(t *testing.T) ([][]utils.AccountInfo, []bsmt.SparseMerkleTree, model.UserProofModel) {
+	err := utils.InitCircuitParams("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userProofModel := model.NewUserProofModel(db, "0")
+	userProofModel.CreateUserProofTable()
+
+	accountGroups := [][]utils.AccountInfo{make([]utils.AccountInfo, 7), make([]utils.AccountInfo, 6)}
+	treeDB := memory.NewMemoryDB()
+	writer, err := utils.NewAccountTreeWithDB(treeDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := poseidon.NewPoseidon()
+	accountIndex := uint32(12)
+	for _, accounts := range accountGroups {
+		for i := range accounts {
+			accounts[i] = utils.AccountInfo{
+				AccountIndex:    accountIndex,
+				AccountId:       big.NewInt(int64(accountIndex) + 1000).FillBytes(make([]byte, 32)),
+				TotalEquity:     big.NewInt(int64(accountIndex)),
+				TotalDebt:       big.NewInt(0),
+				TotalCollateral: big.NewInt(0),
+				Assets:          []utils.AccountAsset{{Index: 1, Equity: uint64(accountIndex)}},
+			}
+			if err = writer.Set(uint64(accountIndex), utils.AccountInfoToHash(&accounts[i], &hasher)); err != nil {
+				t.Fatal(err)
+			}
+			accountIndex--
+		}
+	}
+	if _, err = writer.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	trees := make([]bsmt.SparseMerkleTree, 3)
+	for i := range trees {
+		if trees[i], err = utils.NewAccountTreeWithDB(treeDB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return accountGroups, trees, userProofModel
+}
+
+// TestGenerateUserProofs 测试并行生成用户证明, 按顺序写入和中断后继续
+func TestGenerateUserProofs(t *testing.T) {
+	accountGroups, trees, userProofModel := newTestAccounts(t)
+	// 写入两批之后中断
+	written, err := GenerateUserProofs(accountGroups, testRoundId, 0, trees, &failingUserProofModel{userProofModel, 2}, 3)
+	if err == nil || written != 6 {
+		t.Fatalf("expect to be interrupted after 6 user proofs but got %d: %v", written, err)
+	}
+	counts, err := userProofModel.GetUserCounts()
+	if err != nil || counts != 6 {
+		t.Fatalf("expect 6 user proofs in table but got %d: %v", counts, err)
+	}
+	// 前6个账户的索引为12到7
+	if _, err = userProofModel.GetUserProofByIndex(6); !errors.Is(err, utils.DbErrNotFound) {
+		t.Fatal("expect the user proofs to be written in order")
+	}
+
+	written, err = GenerateUserProofs(accountGroups, testRoundId, counts, trees, userProofModel, 3)
+	if err != nil || written != 7 {
+		t.Fatalf("expect 7 more user proofs but got %d: %v", written, err)
+	}
+	rows, err := userProofModel.GetUserProofsBetween(0, 12)
+	if err != nil || len(rows) != 13 {
+		t.Fatalf("expect 13 user proofs but got %d: %v", len(rows), err)
+	}
+	root := trees[0].Root()
+	for _, row := range rows {
+		var userConfig model.UserConfig
+		if err = json.Unmarshal([]byte(row.Config), &userConfig); err != nil {
+			t.Fatal(err)
+		}
+		// 保存不加盐的账户ID, 以及按账户ID和轮次编号计算的查询键
+		accountId := big.NewInt(int64(row.AccountIndex) + 1000).FillBytes(make([]byte, 32))
+		if row.AccountId != hex.EncodeToString(accountId) {
+			t.Fatalf("unexpected account id of account %d", row.AccountIndex)
+		}
+		if row.LookupKey != hex.EncodeToString(utils.ComputeAccountLookupKey(testRoundId, accountId)) || userConfig.LookupKey != row.LookupKey {
+			t.Fatalf("unexpected lookup key of account %d", row.AccountIndex)
+		}
+		found, err := userProofModel.GetUserProofByLookupKey(row.LookupKey)
+		if err != nil || found.AccountIndex != row.AccountIndex {
+			t.Fatalf("expect to find account %d by lookup key but got %v", row.AccountIndex, err)
+		}
+		leaf, _ := hex.DecodeString(row.AccountLeafHash)
+		if userConfig.Root != hex.EncodeToString(root) || !utils.VerifyMerkleProof(root, row.AccountIndex, userConfig.Proof, leaf) {
+			t.Fatalf("invalid user proof of account %d", row.AccountIndex)
+		}
+	}
+
+	// 账户索引与账户树不一致时不能生成用户证明
+	accountGroups, trees, userProofModel = newTestAccounts(t)
+	accountGroups[0][0].AccountIndex, accountGroups[0][1].AccountIndex = accountGroups[0][1].AccountIndex, accountGroups[0][0].AccountIndex
+	written, err = GenerateUserProofs(accountGroups, testRoundId, 0, trees, userProofModel, 3)
+	if err == nil || written != 0 {
+		t.Fatalf("expect the mismatched leaf to be rejected but got %d user proofs: %v", written, err)
+	}
+}
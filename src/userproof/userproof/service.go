@@ -0,0 +1,356 @@
+package userproof
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// 用户证明查询服务的默认配置
+const (
+	DefaultCacheSize = 100000 // 默认缓存的用户证明数量
+	DefaultRateLimit = 10     // 默认每个客户端每秒的请求数量
+	DefaultRateBurst = 20     // 默认每个客户端的突发请求数量
+	// 用户证明在一轮审计中不会变化, 允许浏览器和CDN缓存
+	proofCacheControl = "public, max-age=3600"
+)
+
+// OpenAPI 用户证明查询服务的OpenAPI描述
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+var lookupKeyRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// CexAsset CEX资产列表中的一个资产
+type CexAsset struct {
+	Index     uint32
+	Symbol    string
+	BasePrice uint64
+}
+
+// RoundMetadata 用户证明所属的审计轮次
+type RoundMetadata struct {
+	Root      string     // 账户树根(hex编码)
+	RoundId   uint64     // 审计轮次编号
+	Timestamp uint64     // 审计快照的时间戳(unix秒)
+	CexAssets []CexAsset // CEX资产列表, 只包括有价格的资产
+	// 账户索引置换种子的承诺SHA256(seed)(hex编码), 没有置换账户索引时为空, 见utils.ComputeShuffleSeedCommitment
+	ShuffleSeedCommitment string
+	// 本轮置换的账户索引范围[CreateStartIndex, NextAccountIndex), 公开种子后用于重新计算置换
+	CreateStartIndex int64
+	NextAccountIndex int64
+}
+
+// UserAsset 用户的一个资产
+type UserAsset struct {
+	Index           uint16
+	Symbol          string // 资产符号, CEX资产列表中没有该资产时为空
+	BasePrice       uint64
+	Equity          uint64
+	Debt            uint64
+	Loan            uint64
+	Margin          uint64
+	PortfolioMargin uint64
+}
+
+// UserProofBundle 用户的包含证明
+type UserProofBundle struct {
+	LookupKey    string // 账户查询键(hex编码), 见utils.ComputeAccountLookupKey
+	AccountIndex uint32
+	LeafHash     string // 账户叶子节点哈希(hex编码)
+	Root         string // 账户树根(hex编码)
+	RoundId      uint64
+	Timestamp    uint64
+	UserConfig   json.RawMessage // user_config.json格式的用户证明, 可以直接用于verifier -user
+	Assets       []UserAsset     // 用户资产明细
+}
+
+// LoadRoundMetadata 从最新的见证数据中读取审计轮次和CEX资产列表, 从witness保存的账户索引信息中读取置换种子承诺和索引范围,
+// 从用户证明表中读取账户树根
+func LoadRoundMetadata(witnessModel witness.WitnessModel, accountIndexModel witness.AccountIndexModel, userProofModel model.UserProofModel) (*RoundMetadata, error) {
+	latestWitness, err := witnessModel.GetLatestBatchWitness()
+	if err != nil {
+		return nil, fmt.Errorf("get latest batch witness failed: %w", err)
+	}
+	meta := &RoundMetadata{}
+	var cexAssetsInfo []utils.CexAssetInfo
+	switch latestWitness.OpType {
+	case utils.OpTypeUpdateUser:
+		w, err := utils.DecodeBatchUpdateWitness(latestWitness.WitnessData)
+		if err != nil {
+			return nil, err
+		}
+		meta.RoundId, meta.Timestamp = w.RoundId, w.Timestamp
+		cexAssetsInfo = utils.RecoverAfterCexAssetsOfUpdate(w)
+	case utils.OpTypeDeleteUser:
+		w, err := utils.DecodeBatchDeleteWitness(latestWitness.WitnessData)
+		if err != nil {
+			return nil, err
+		}
+		meta.RoundId, meta.Timestamp = w.RoundId, w.Timestamp
+		cexAssetsInfo = utils.RecoverAfterCexAssetsOfDelete(w)
+	default:
+		w, err := utils.DecodeBatchWitness(latestWitness.WitnessData)
+		if err != nil {
+			return nil, err
+		}
+		meta.RoundId, meta.Timestamp = w.RoundId, w.Timestamp
+		cexAssetsInfo = utils.RecoverAfterCexAssets(w)
+	}
+	for _, info := range cexAssetsInfo {
+		if info.BasePrice != 0 {
+			meta.CexAssets = append(meta.CexAssets, CexAsset{Index: info.Index, Symbol: info.Symbol, BasePrice: info.BasePrice})
+		}
+	}
+
+	accountRound, err := accountIndexModel.GetAccountRound()
+	if err != nil {
+		return nil, fmt.Errorf("get account indexes of the round failed: %w", err)
+	}
+	meta.ShuffleSeedCommitment = accountRound.ShuffleSeedCommitment
+	meta.CreateStartIndex = accountRound.CreateStartIndex
+	meta.NextAccountIndex = accountRound.NextAccountIndex
+
+	latestIndex, err := userProofModel.GetLatestAccountIndex()
+	if err != nil {
+		return nil, fmt.Errorf("get latest user proof failed: %w", err)
+	}
+	row, err := userProofModel.GetUserProofByIndex(latestIndex)
+	if err != nil {
+		return nil, err
+	}
+	var userConfig model.UserConfig
+	err = json.Unmarshal([]byte(row.Config), &userConfig)
+	if err != nil {
+		return nil, err
+	}
+	meta.Root = userConfig.Root
+	return meta, nil
+}
+
+// UserProofService 通过HTTP按账户查询键查询用户的包含证明, 只读
+// 查询过的用户证明缓存在内存中, 每个客户端按IP限流
+type UserProofService struct {
+	userProofModel model.UserProofModel
+	meta           *RoundMetadata
+	symbols        map[uint32]CexAsset
+	cache          *lru.Cache
+	limiter        *rateLimiter
+	proxyHeader    string
+}
+
+// NewUserProofService 创建用户证明查询服务
+// 参数:
+//   - userProofModel: 用户证明表
+//   - meta: 用户证明所属的审计轮次
+//   - cacheSize: 缓存的用户证明数量, 小于等于0时使用DefaultCacheSize
+//   - rateLimit: 每个客户端每秒的请求数量, 小于等于0时使用DefaultRateLimit
+//   - rateBurst: 每个客户端的突发请求数量, 小于等于0时使用DefaultRateBurst
+//   - trustedProxyHeader: 受信任的反向代理写入客户端IP的请求头(例如X-Forwarded-For), 为空时按连接的对端地址限流
+func NewUserProofService(userProofModel model.UserProofModel, meta *RoundMetadata, cacheSize int, rateLimit float64, rateBurst int,
+	trustedProxyHeader string) (*UserProofService, error) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	if rateBurst <= 0 {
+		rateBurst = DefaultRateBurst
+	}
+	cache, err := lru.New(cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	s := &UserProofService{
+		userProofModel: userProofModel,
+		meta:           meta,
+		symbols:        make(map[uint32]CexAsset, len(meta.CexAssets)),
+		cache:          cache,
+		limiter:        newRateLimiter(rateLimit, rateBurst),
+		proxyHeader:    trustedProxyHeader,
+	}
+	for _, asset := range meta.CexAssets {
+		s.symbols[asset.Index] = asset
+	}
+	return s, nil
+}
+
+// Handler 返回用户证明查询服务的HTTP接口
+//   - GET /v1/users/{lookupKey}/proof: 返回UserProofBundle, lookupKey为hex编码的账户查询键
+//   - GET /v1/round: 返回RoundMetadata
+//   - GET /openapi.json: 返回OpenAPI描述
+//   - GET /healthz: 服务可用时返回200
+func (s *UserProofService) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/users/{lookupKey}/proof", s.handleProof)
+	mux.HandleFunc("GET /v1/round", s.handleRound)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(OpenAPI)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, http.StatusOK, map[string]string{"Status": "ok"})
+	})
+	return s.limitRate(mux)
+}
+
+// GetUserProofBundle 按账户查询键查询用户的包含证明
+// 返回:
+//   - *UserProofBundle: 用户的包含证明
+//   - error: 账户不存在时返回utils.DbErrNotFound
+func (s *UserProofService) GetUserProofBundle(lookupKey string) (*UserProofBundle, error) {
+	if bundle, ok := s.cache.Get(lookupKey); ok {
+		return bundle.(*UserProofBundle), nil
+	}
+	row, err := s.userProofModel.GetUserProofByLookupKey(lookupKey)
+	if err != nil {
+		return nil, err
+	}
+	var accountAssets []utils.AccountAsset
+	err = json.Unmarshal([]byte(row.Assets), &accountAssets)
+	if err != nil {
+		return nil, fmt.Errorf("decode assets of account %s failed: %w", lookupKey, err)
+	}
+	bundle := &UserProofBundle{
+		LookupKey:    row.LookupKey,
+		AccountIndex: row.AccountIndex,
+		LeafHash:     row.AccountLeafHash,
+		Root:         s.meta.Root,
+		RoundId:      s.meta.RoundId,
+		Timestamp:    s.meta.Timestamp,
+		UserConfig:   json.RawMessage(row.Config),
+		Assets:       make([]UserAsset, len(accountAssets)),
+	}
+	for i, asset := range accountAssets {
+		cexAsset := s.symbols[uint32(asset.Index)]
+		bundle.Assets[i] = UserAsset{
+			Index:           asset.Index,
+			Symbol:          cexAsset.Symbol,
+			BasePrice:       cexAsset.BasePrice,
+			Equity:          asset.Equity,
+			Debt:            asset.Debt,
+			Loan:            asset.Loan,
+			Margin:          asset.Margin,
+			PortfolioMargin: asset.PortfolioMargin,
+		}
+	}
+	s.cache.Add(lookupKey, bundle)
+	return bundle, nil
+}
+
+func (s *UserProofService) handleProof(w http.ResponseWriter, r *http.Request) {
+	lookupKey := r.PathValue("lookupKey")
+	if !lookupKeyRegexp.MatchString(lookupKey) {
+		utils.WriteJSONError(w, http.StatusBadRequest, errors.New("the lookup key should be 32 bytes lower case hex"))
+		return
+	}
+	bundle, err := s.GetUserProofBundle(lookupKey)
+	if errors.Is(err, utils.DbErrNotFound) {
+		utils.WriteJSONError(w, http.StatusNotFound, errors.New("account not found"))
+		return
+	}
+	if err != nil {
+		fmt.Println("get user proof failed: ", lookupKey, err.Error())
+		utils.WriteJSONError(w, http.StatusInternalServerError, errors.New("get user proof failed"))
+		return
+	}
+	w.Header().Set("Cache-Control", proofCacheControl)
+	utils.WriteJSON(w, http.StatusOK, bundle)
+}
+
+func (s *UserProofService) handleRound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", proofCacheControl)
+	utils.WriteJSON(w, http.StatusOK, s.meta)
+}
+
+// clientIP 返回限流使用的客户端IP
+// 服务部署在反向代理之后时, 所有请求的对端地址都是代理, 需要从代理写入的请求头中读取客户端IP
+// 请求头中有多个地址时使用最后一个, 即受信任的代理看到的对端地址, 客户端自己填写的地址在它之前
+func (s *UserProofService) clientIP(r *http.Request) string {
+	if s.proxyHeader != "" {
+		values := strings.Split(r.Header.Get(s.proxyHeader), ",")
+		if client := strings.TrimSpace(values[len(values)-1]); client != "" {
+			return client
+		}
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	return client
+}
+
+// limitRate 按客户端IP限流, 超过限制时返回429
+func (s *UserProofService) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.limiter.Allow(s.clientIP(r), time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			utils.WriteJSONError(w, http.StatusTooManyRequests, errors.New("too many requests"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter 每个客户端一个令牌桶
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock        sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow 消耗客户端的一个令牌, 没有令牌时返回false
+func (l *rateLimiter) Allow(client string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// 定期清理已经装满的令牌桶, 避免客户端过多时占用内存
+	if now.Sub(l.lastCleanup) > time.Minute {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,245 @@
+package userproof
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestRound 创建包含两个用户证明, 一个见证数据和账户索引信息的数据库
+func newTestRound(t *testing.T) (witness.WitnessModel, witness.AccountIndexModel, model.UserProofModel) {
+	err := utils.InitCircuitParams("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有用户的批次, 操作后的CEX资产与操作前相同
+	cexAssets := []utils.CexAssetInfo{
+		{Symbol: "btc", Index: 0, BasePrice: 2000000000000},
+		{Symbol: "eth", Index: 1, BasePrice: 150000000000},
+		{Symbol: "reserved", Index: 2},
+	}
+	hasher := poseidon.NewPoseidon()
+	for i := range cexAssets {
+		cexAssets[i].LoanRatios = utils.PaddingTierRatios([]utils.TierRatio{})
+		cexAssets[i].MarginRatios = utils.PaddingTierRatios([]utils.TierRatio{})
+		cexAssets[i].PortfolioMarginRatios = utils.PaddingTierRatios([]utils.TierRatio{})
+		for _, commitment := range utils.ConvertAssetInfoToBytes(cexAssets[i]) {
+			hasher.Write(commitment)
+		}
+	}
+	data, err := utils.EncodeBatchWitness(&utils.BatchCreateUserWitness{
+		RoundId:                  7,
+		Timestamp:                1700000000,
+		BeforeCexAssets:          cexAssets,
+		AfterCEXAssetsCommitment: hasher.Sum(nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := witness.NewWitnessModel(db, "0")
+	witnessModel.CreateBatchWitnessTable()
+	err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 0, WitnessData: data, OpType: utils.OpTypeCreateUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户证明表中的账户索引不在账户索引表中, 只保存账户索引信息
+	accountIndexModel := witness.NewAccountIndexModel(db, "0")
+	accountIndexModel.CreateAccountIndexTable()
+	err = accountIndexModel.SaveAccountIndexes(nil, &witness.AccountRound{NextAccountIndex: 2, ShuffleSeedCommitment: strings.Repeat("ef", 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userProofModel := model.NewUserProofModel(db, "0")
+	userProofModel.CreateUserProofTable()
+	rows := make([]model.UserProof, 2)
+	for i := range rows {
+		assets := []utils.AccountAsset{{Index: 0, Equity: 10}, {Index: 3, Equity: uint64(20 + i), Debt: 1}}
+		assetsJson, _ := json.Marshal(assets)
+		lookupKey := strings.Repeat(hex.EncodeToString([]byte{byte(i)}), 32)
+		configJson, _ := json.Marshal(model.UserConfig{
+			AccountIndex:    uint32(i),
+			AccountIdHash:   strings.Repeat(hex.EncodeToString([]byte{byte(i + 0x10)}), 32),
+			LookupKey:       lookupKey,
+			TotalEquity:     big.NewInt(30),
+			TotalDebt:       big.NewInt(1),
+			TotalCollateral: big.NewInt(0),
+			Assets:          assets,
+			Root:            strings.Repeat("ab", 32),
+			Proof:           [][]byte{{1}, {2}},
+		})
+		rows[i] = model.UserProof{
+			AccountIndex:    uint32(i),
+			AccountId:       strings.Repeat(hex.EncodeToString([]byte{byte(i + 0x20)}), 32),
+			LookupKey:       lookupKey,
+			AccountLeafHash: strings.Repeat("cd", 32),
+			Assets:          string(assetsJson),
+			Config:          string(configJson),
+		}
+	}
+	err = userProofModel.CreateUserProofs(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return witnessModel, accountIndexModel, userProofModel
+}
+
+// TestUserProofService 测试用户证明查询服务的接口, 缓存和限流
+func TestUserProofService(t *testing.T) {
+	witnessModel, accountIndexModel, userProofModel := newTestRound(t)
+	meta, err := LoadRoundMetadata(witnessModel, accountIndexModel, userProofModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.RoundId != 7 || meta.Timestamp != 1700000000 || meta.Root != strings.Repeat("ab", 32) ||
+		meta.ShuffleSeedCommitment != strings.Repeat("ef", 32) || meta.NextAccountIndex != 2 {
+		t.Fatalf("unexpected round metadata %+v", meta)
+	}
+	// 没有价格的资产不在CEX资产列表中
+	if len(meta.CexAssets) != 2 || meta.CexAssets[1].Symbol != "eth" {
+		t.Fatalf("unexpected cex assets %+v", meta.CexAssets)
+	}
+
+	service, err := NewUserProofService(userProofModel, meta, 16, 1000, 1000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(service.Handler())
+	defer server.Close()
+
+	lookupKey := strings.Repeat("01", 32)
+	resp, err := http.Get(server.URL + "/v1/users/" + lookupKey + "/proof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle UserProofBundle
+	err = json.NewDecoder(resp.Body).Decode(&bundle)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") == "" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	if bundle.LookupKey != lookupKey || bundle.AccountIndex != 1 || bundle.RoundId != 7 || bundle.Root != meta.Root || bundle.LeafHash != strings.Repeat("cd", 32) {
+		t.Fatalf("unexpected bundle %+v", bundle)
+	}
+	if len(bundle.Assets) != 2 || bundle.Assets[0].Symbol != "btc" || bundle.Assets[0].BasePrice != 2000000000000 ||
+		bundle.Assets[1].Symbol != "" || bundle.Assets[1].Equity != 21 {
+		t.Fatalf("unexpected assets %+v", bundle.Assets)
+	}
+	var userConfig model.UserConfig
+	err = json.Unmarshal(bundle.UserConfig, &userConfig)
+	if err != nil || userConfig.LookupKey != lookupKey {
+		t.Fatalf("unexpected user config %s", string(bundle.UserConfig))
+	}
+	if service.cache.Len() != 1 {
+		t.Fatalf("expect the bundle to be cached")
+	}
+
+	for path, status := range map[string]int{
+		"/v1/users/" + strings.Repeat("02", 32) + "/proof": http.StatusNotFound,
+		"/v1/users/" + strings.Repeat("11", 32) + "/proof": http.StatusNotFound,
+		"/v1/users/" + strings.Repeat("AB", 32) + "/proof": http.StatusBadRequest,
+		"/v1/users/invalid/proof":                          http.StatusBadRequest,
+		"/v1/round":                                        http.StatusOK,
+		"/healthz":                                         http.StatusOK,
+		"/openapi.json":                                    http.StatusOK,
+	} {
+		resp, err = http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("expect status %d but got %d for %s", status, resp.StatusCode, path)
+		}
+	}
+	var openAPI map[string]interface{}
+	if err = json.Unmarshal(OpenAPI, &openAPI); err != nil {
+		t.Fatalf("invalid openapi description: %s", err.Error())
+	}
+
+	// 超过突发请求数量后返回429
+	service, err = NewUserProofService(userProofModel, meta, 16, 0.001, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := httptest.NewServer(service.Handler())
+	defer limited.Close()
+	for i, status := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp, err = http.Get(limited.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("expect status %d but got %d for request %d", status, resp.StatusCode, i)
+		}
+	}
+	// 反向代理之后按代理写入的请求头中最后一个地址限流
+	service, err = NewUserProofService(userProofModel, meta, 16, 0.001, 1, "X-Forwarded-For")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxied := httptest.NewServer(service.Handler())
+	defer proxied.Close()
+	for i, forwardedFor := range []string{"1.1.1.1", "1.1.1.1", "1.1.1.1, 2.2.2.2", "3.3.3.3, 2.2.2.2"} {
+		req, err := http.NewRequest(http.MethodGet, proxied.URL+"/healthz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		status := http.StatusOK
+		if i%2 == 1 {
+			status = http.StatusTooManyRequests
+		}
+		if resp.StatusCode != status {
+			t.Fatalf("expect status %d but got %d for request %d", status, resp.StatusCode, i)
+		}
+	}
+}
+
+// TestRateLimiter 测试令牌桶的补充和清理
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(1, 2)
+	now := time.Now()
+	if !limiter.Allow("a", now) || !limiter.Allow("a", now) || limiter.Allow("a", now) {
+		t.Fatal("expect 2 requests to be allowed at once")
+	}
+	if !limiter.Allow("b", now) {
+		t.Fatal("expect clients to be limited separately")
+	}
+	if !limiter.Allow("a", now.Add(time.Second)) || limiter.Allow("a", now.Add(time.Second)) {
+		t.Fatal("expect 1 token per second")
+	}
+	limiter.Allow("c", now.Add(2*time.Minute))
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("expect the full buckets to be removed but got %d", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// AccountSaltKeySize 账户盐值密钥的最小字节数
+const AccountSaltKeySize = 32
+
+// LoadAccountSaltKey 读取hex编码的账户盐值密钥文件
+// 密钥只由CEX持有, witness和userproof必须使用同一个密钥, 泄露后可以从盐值关联同一账户在不同轮次的叶子节点
+func LoadAccountSaltKey(fileName string) ([]byte, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) < AccountSaltKeySize {
+		return nil, errors.New("the account salt key should be at least 32 bytes hex")
+	}
+	return key, nil
+}
+
+// ComputeAccountSalt 计算账户在一轮审计中的盐值
+// 盐值为HMAC-SHA256(key, roundId || accountId)对应的域元素, 每轮审计每个账户不同, witness和userproof可以分别计算出相同的盐值
+func ComputeAccountSalt(key []byte, roundId uint64, accountId []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	var round [8]byte
+	binary.BigEndian.PutUint64(round[:], roundId)
+	mac.Write(round[:])
+	mac.Write(accountId)
+	return new(fr.Element).SetBytes(mac.Sum(nil)).Marshal()
+}
+
+// ParseAccountId 解析用户数据中hex编码的32字节账户ID, 返回与ReadUserDataFromCsvFile相同的域元素编码
+func ParseAccountId(accountId string) ([]byte, error) {
+	b, err := hex.DecodeString(accountId)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("the account id should be 32 bytes hex")
+	}
+	return new(fr.Element).SetBytes(b).Marshal(), nil
+}
+
+// ComputeAccountIdHash 计算账户在账户树叶子节点中的ID
+// 没有盐值时为账户ID本身, 与之前的版本兼容; 有盐值时为Poseidon(AccountId, Salt), 不同轮次的叶子节点无法关联
+func ComputeAccountIdHash(accountId []byte, salt []byte) []byte {
+	if len(salt) == 0 {
+		return accountId
+	}
+	return poseidon.PoseidonBytes(accountId, salt)
+}
+
+// ComputeAccountLookupKey 计算查询用户证明时使用的账户查询键, 即SHA256(roundId || accountId)
+// 用户只知道自己的账户ID, 无法计算加盐的账户ID哈希, 所以查询服务和静态导出使用查询键;
+// 查询键包含审计轮次编号, 不同轮次导出的用户证明同样无法关联
+// 参数:
+//   - roundId: 审计轮次编号
+//   - accountId: 账户ID, 与ParseAccountId的编码相同
+func ComputeAccountLookupKey(roundId uint64, accountId []byte) []byte {
+	var round [8]byte
+	binary.BigEndian.PutUint64(round[:], roundId)
+	key := sha256.Sum256(append(round[:], accountId...))
+	return key[:]
+}
+
+// SaltAccounts 为所有账户设置本轮审计的盐值
+// 参数:
+//   - accounts: 按资产数量分组的账户
+//   - key: 账户盐值密钥, 为空时不设置盐值
+//   - roundId: 审计轮次编号
+func SaltAccounts(accounts map[int][]AccountInfo, key []byte, roundId uint64) {
+	if len(key) == 0 {
+		return
+	}
+	for _, v := range accounts {
+		for i := range v {
+			v[i].Salt = ComputeAccountSalt(key, roundId, v[i].AccountId)
+		}
+	}
+}
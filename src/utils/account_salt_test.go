@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// TestAccountSalt 测试盐值在每轮审计和每个账户不同, 以及盐值改变账户叶子节点
+func TestAccountSalt(t *testing.T) {
+	if err := InitCircuitParams(""); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, AccountSaltKeySize)
+	accountId, err := ParseAccountId("01" + strings.Repeat("00", 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := ComputeAccountSalt(key, 1, accountId)
+	if !bytes.Equal(salt, ComputeAccountSalt(key, 1, accountId)) {
+		t.Fatal("expect the salt to be deterministic")
+	}
+	if bytes.Equal(salt, ComputeAccountSalt(key, 2, accountId)) || bytes.Equal(salt, ComputeAccountSalt(key, 1, make([]byte, 32))) {
+		t.Fatal("expect different salts for different rounds and accounts")
+	}
+	if !bytes.Equal(ComputeAccountIdHash(accountId, nil), accountId) || bytes.Equal(ComputeAccountIdHash(accountId, salt), accountId) {
+		t.Fatal("expect the account id hash to be the account id only without salt")
+	}
+	if _, err = ParseAccountId("01"); err == nil {
+		t.Fatal("expect short account id to be rejected")
+	}
+
+	newAccounts := func() map[int][]AccountInfo {
+		accounts := make([]AccountInfo, 2)
+		for i := range accounts {
+			accounts[i] = AccountInfo{
+				AccountIndex:    uint32(i),
+				AccountId:       big.NewInt(int64(i + 1)).FillBytes(make([]byte, 32)),
+				TotalEquity:     big.NewInt(10),
+				TotalDebt:       big.NewInt(0),
+				TotalCollateral: big.NewInt(0),
+				Assets:          []AccountAsset{{Index: 0, Equity: 10}},
+			}
+		}
+		return map[int][]AccountInfo{AssetCountsTiers[0]: accounts}
+	}
+	hasher := poseidon.NewPoseidon()
+	base := newAccounts()
+	unsalted := AccountInfoToHash(&base[AssetCountsTiers[0]][0], &hasher)
+	SaltAccounts(base, key, 1)
+	if bytes.Equal(unsalted, AccountInfoToHash(&base[AssetCountsTiers[0]][0], &hasher)) {
+		t.Fatal("expect the salt to change the account leaf")
+	}
+}
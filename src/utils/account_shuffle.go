@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strings"
+)
+
+// ShuffleSeedSize 账户索引置换种子的最小字节数
+const ShuffleSeedSize = 32
+
+// shuffleKeyLabel 从种子派生ChaCha8密钥时使用的标签, 密钥与种子承诺不同, 公开承诺不会泄露置换
+const shuffleKeyLabel = "zkpos account index shuffle"
+
+// LoadShuffleSeed 读取hex编码的账户索引置换种子文件
+// 每轮审计应使用新的随机种子, 审计结束前种子只由CEX持有
+func LoadShuffleSeed(fileName string) ([]byte, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(seed) < ShuffleSeedSize {
+		return nil, errors.New("the shuffle seed should be at least 32 bytes hex")
+	}
+	return seed, nil
+}
+
+// ComputeShuffleSeedCommitment 计算与审计轮次一起公布的种子承诺, 即SHA256(seed)
+// 公开种子后任何人都可以检查种子与承诺一致, 并重新计算置换
+func ComputeShuffleSeedCommitment(seed []byte) []byte {
+	commitment := sha256.Sum256(seed)
+	return commitment[:]
+}
+
+// NewAccountIndexPermutation 根据种子生成[0, count)的确定性随机置换
+// 使用Fisher-Yates洗牌, 随机数来自以HMAC-SHA256(seed, "zkpos account index shuffle")为密钥的ChaCha8,
+// 并通过拒绝采样得到均匀分布的随机数, 所以相同的种子和数量在任何实现中得到相同的置换
+// 参数:
+//   - seed: 置换种子
+//   - count: 置换的索引数量
+//
+// 返回:
+//   - []uint32: 置换, 第i个元素为原索引i置换后的索引
+func NewAccountIndexPermutation(seed []byte, count int) []uint32 {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(shuffleKeyLabel))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	rng := rand.NewChaCha8(key)
+
+	permutation := make([]uint32, count)
+	for i := range permutation {
+		permutation[i] = uint32(i)
+	}
+	for i := count - 1; i > 0; i-- {
+		j := uniformUint64(rng, uint64(i+1))
+		permutation[i], permutation[j] = permutation[j], permutation[i]
+	}
+	return permutation
+}
+
+// uniformUint64 返回[0, n)中均匀分布的随机数, 丢弃最后不足n个的随机数以避免取模偏差
+func uniformUint64(rng *rand.ChaCha8, n uint64) uint64 {
+	limit := math.MaxUint64 - (math.MaxUint64%n+1)%n
+	for {
+		v := rng.Uint64()
+		if v <= limit {
+			return v % n
+		}
+	}
+}
+
+// PaddedAccountsCount 计算每个资产数量分组填充到批次大小的整数倍后的账户总数
+// 账户已经填充时结果不变
+func PaddedAccountsCount(accounts map[int][]AccountInfo) int {
+	count := 0
+	for k, v := range accounts {
+		opsPerBatch := BatchCreateUserOpsCountsTiers[k]
+		count += (len(v) + opsPerBatch - 1) / opsPerBatch * opsPerBatch
+	}
+	return count
+}
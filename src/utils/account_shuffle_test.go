@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// TestAccountIndexPermutation 测试置换是确定的, 并且是[0, count)的一个置换
+func TestAccountIndexPermutation(t *testing.T) {
+	seed := bytes.Repeat([]byte{3}, ShuffleSeedSize)
+	permutation := NewAccountIndexPermutation(seed, 1000)
+	if len(permutation) != 1000 {
+		t.Fatalf("expect 1000 indexes but got %d", len(permutation))
+	}
+	seen := make([]bool, len(permutation))
+	fixed := 0
+	for i, v := range permutation {
+		if v >= uint32(len(permutation)) || seen[v] {
+			t.Fatalf("index %d is out of range or repeated", v)
+		}
+		seen[v] = true
+		if v == uint32(i) {
+			fixed++
+		}
+	}
+	if fixed > 10 {
+		t.Fatalf("expect the indexes to be shuffled but %d are unchanged", fixed)
+	}
+	again := NewAccountIndexPermutation(seed, 1000)
+	other := NewAccountIndexPermutation(bytes.Repeat([]byte{4}, ShuffleSeedSize), 1000)
+	sameAsOther := true
+	for i := range permutation {
+		if permutation[i] != again[i] {
+			t.Fatal("expect the permutation to be deterministic")
+		}
+		sameAsOther = sameAsOther && permutation[i] == other[i]
+	}
+	if sameAsOther {
+		t.Fatal("expect different permutations for different seeds")
+	}
+	// 承诺为种子的SHA256
+	if hex.EncodeToString(ComputeShuffleSeedCommitment([]byte("abc"))) != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatal("unexpected shuffle seed commitment")
+	}
+}
+
+// TestPrepareRoundAccounts 测试连续的增量审计中账户保持上一轮的索引, 新建账户和填充账户在新的索引范围内交错分布
+func TestPrepareRoundAccounts(t *testing.T) {
+	if err := InitCircuitParams(""); err != nil {
+		t.Fatal(err)
+	}
+	newAccount := func(id int, index int, equity int64) AccountInfo {
+		return AccountInfo{
+			AccountIndex:    uint32(index),
+			AccountId:       big.NewInt(int64(id)).FillBytes(make([]byte, 32)),
+			TotalEquity:     big.NewInt(equity),
+			TotalDebt:       big.NewInt(0),
+			TotalCollateral: big.NewInt(0),
+		}
+	}
+	cloneAccounts := func(accounts map[int][]AccountInfo) map[int][]AccountInfo {
+		cloned := make(map[int][]AccountInfo)
+		for k, v := range accounts {
+			cloned[k] = append([]AccountInfo(nil), v...)
+		}
+		return cloned
+	}
+	indexOf := func(accounts map[int][]AccountInfo, id int) uint32 {
+		for _, v := range accounts {
+			for _, account := range v {
+				if account.AccountId[31] == byte(id) {
+					return account.AccountIndex
+				}
+			}
+		}
+		t.Fatalf("account %d not found", id)
+		return 0
+	}
+	// 检查新建账户和填充账户的索引是[start, next)的一个排列, 并且current中的账户索引与新建账户一致
+	checkCreates := func(round *RoundAccounts, current map[int][]AccountInfo) {
+		seen := make(map[uint32]bool)
+		for _, v := range round.Creates {
+			for _, account := range v {
+				if account.AccountIndex < uint32(round.CreateStartIndex) || account.AccountIndex >= uint32(round.NextAccountIndex) || seen[account.AccountIndex] {
+					t.Fatalf("index %d is out of range or repeated", account.AccountIndex)
+				}
+				seen[account.AccountIndex] = true
+				if len(account.AccountId) > 0 && indexOf(current, int(account.AccountId[31])) != account.AccountIndex {
+					t.Fatal("expect the same index of the created account in current round")
+				}
+			}
+		}
+		if len(seen) != round.NextAccountIndex-round.CreateStartIndex {
+			t.Fatal("expect all indexes of the round to be created")
+		}
+	}
+	tiers := AssetCountsTiers[:2]
+
+	// 第一轮: 完整审计, 账户索引为用户数据中的顺序
+	current := make(map[int][]AccountInfo)
+	for i := 0; i < 8; i++ {
+		current[tiers[i%2]] = append(current[tiers[i%2]], newAccount(i+1, i, 1))
+	}
+	round := PrepareRoundAccounts(nil, 0, current, bytes.Repeat([]byte{5}, ShuffleSeedSize))
+	if round.CreateStartIndex != 0 || round.NextAccountIndex != PaddedAccountsCount(current) {
+		t.Fatal("unexpected index range of the full round")
+	}
+	checkCreates(round, current)
+	realAfterPadding := false
+	for _, v := range current {
+		for _, account := range v {
+			realAfterPadding = realAfterPadding || account.AccountIndex >= 8
+		}
+	}
+	if !realAfterPadding {
+		t.Fatal("expect the padding accounts to be interleaved with the real accounts")
+	}
+
+	// 第二轮: 增量审计, 更新账户1, 删除账户2, 新建账户9
+	base := current
+	current = cloneAccounts(base)
+	current[tiers[0]][0] = newAccount(1, 0, 2)
+	current[tiers[1]] = current[tiers[1]][1:]
+	current[tiers[0]] = append(current[tiers[0]], newAccount(9, 0, 1))
+	baseNext := round.NextAccountIndex
+	round = PrepareRoundAccounts(base, baseNext, current, bytes.Repeat([]byte{6}, ShuffleSeedSize))
+	if round.CreateStartIndex != baseNext || len(round.Updates[tiers[0]]) != 1 || len(round.Deletes[tiers[1]]) != 1 {
+		t.Fatal("unexpected operations of the incremental round")
+	}
+	if indexOf(current, 1) != indexOf(base, 1) || indexOf(current, 3) != indexOf(base, 3) {
+		t.Fatal("expect the accounts of base round to keep their indexes")
+	}
+	checkCreates(round, current)
+
+	// 第三轮: 以增量审计为上一轮, 第二轮新建的账户保持第二轮分配的索引
+	base = current
+	current = cloneAccounts(base)
+	current[tiers[1]] = append(current[tiers[1]], newAccount(10, 0, 1))
+	baseNext = round.NextAccountIndex
+	round = PrepareRoundAccounts(base, baseNext, current, nil)
+	if indexOf(current, 9) != indexOf(base, 9) || indexOf(current, 10) != uint32(baseNext) {
+		t.Fatal("expect the accounts created in the incremental round to keep their indexes")
+	}
+	if len(round.Updates) != 0 || len(round.Deletes) != 0 {
+		t.Fatal("expect only create operations")
+	}
+	checkCreates(round, current)
+}
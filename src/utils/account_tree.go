@@ -1,6 +1,10 @@
+//go:build !js
+
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"hash"
 	"time"
 
@@ -11,23 +15,50 @@ import (
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
 )
 
-var (
-	NilAccountHash []byte
+var ErrUnknownTreeDBDriver = errors.New("unknown account tree db driver")
+
+// 账户树存储驱动
+const (
+	TreeDBDriverMemory  = "memory"  // 内存存储, 用于测试
+	TreeDBDriverRedis   = "redis"   // redis/kvrocks存储, 用于生产
+	TreeDBDriverLevelDB = "leveldb" // 嵌入式的leveldb存储, 用于本地运行, 不需要kvrocks服务
 )
 
 // NewAccountTree 创建新的账户Merkle树
+// 参数:
+//   - driver: 存储驱动, 见TreeDBDriverMemory, TreeDBDriverRedis和TreeDBDriverLevelDB
+//   - addr: redis驱动为kvrocks服务地址, leveldb驱动为数据目录, memory驱动忽略该参数
+//
+// 返回:
+//   - accountTree: 账户Merkle树
+//   - err: 错误信息, 未知的驱动返回ErrUnknownTreeDBDriver
 func NewAccountTree(driver string, addr string) (accountTree bsmt.SparseMerkleTree, err error) {
-	// 创建Poseidon哈希函数池
-	hasher := bsmt.NewHasherPool(func() hash.Hash {
-		return poseidon.NewPoseidon()
-	})
+	db, err := OpenTreeDB(driver, addr)
+	if err != nil {
+		return nil, err
+	}
+	accountTree, err = NewAccountTreeWithDB(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return accountTree, nil
+}
 
-	// 根据驱动类型选择数据库
-	var db database.TreeDB
-	if driver == "memory" {
+// OpenTreeDB 打开账户树的存储
+// 参数:
+//   - driver: 存储驱动, 见TreeDBDriverMemory, TreeDBDriverRedis和TreeDBDriverLevelDB
+//   - addr: redis驱动为kvrocks服务地址, leveldb驱动为数据目录, memory驱动忽略该参数
+//
+// 返回:
+//   - database.TreeDB: 账户树的存储, 可以被多个账户树共享
+//   - error: 错误信息, 未知的驱动返回ErrUnknownTreeDBDriver
+func OpenTreeDB(driver string, addr string) (db database.TreeDB, err error) {
+	switch driver {
+	case TreeDBDriverMemory:
 		//  内存存储,用于测试
 		db = memory.NewMemoryDB()
-	} else if driver == "redis" {
+	case TreeDBDriverRedis:
 		//  redis存储,用于生产
 		redisOption := &redis.RedisConfig{}
 		redisOption.Addr = addr
@@ -44,41 +75,28 @@ func NewAccountTree(driver string, addr string) (accountTree bsmt.SparseMerkleTr
 		if err != nil {
 			return nil, err
 		}
+	case TreeDBDriverLevelDB:
+		//  leveldb存储,数据保存在本地目录
+		if addr == "" {
+			return nil, fmt.Errorf("the data directory of %s driver is empty", driver)
+		}
+		db, err = newLevelDB(addr)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTreeDBDriver, driver)
 	}
-
-	// 创建稀疏Merkle树
-	accountTree, err = bsmt.NewBNBSparseMerkleTree(hasher, db, AccountTreeDepth, NilAccountHash)
-	if err != nil {
-		return nil, err
-	}
-	return accountTree, nil
+	return db, nil
 }
 
-// VerifyMerkleProof 验证Merkle证明
-func VerifyMerkleProof(root []byte, accountIndex uint32, proof [][]byte, node []byte) bool {
-	// 检查证明长度是否正确
-	if len(proof) != AccountTreeDepth {
-		return false
-	}
-	// 创建Poseidon哈希函数
-	hasher := poseidon.NewPoseidon()
-	// 遍历证明路径
-	for i := 0; i < AccountTreeDepth; i++ {
-		// 检查当前位是否为0
-		bit := accountIndex & (1 << i)
-		if bit == 0 {
-			hasher.Write(node)
-			hasher.Write(proof[i])
-		} else {
-			hasher.Write(proof[i])
-			hasher.Write(node)
-		}
-		node = hasher.Sum(nil)
-		hasher.Reset()
-	}
-	// 检查计算的节点是否等于根节点
-	if string(node) != string(root) {
-		return false
-	}
-	return true
+// NewAccountTreeWithDB 在已打开的存储上创建账户Merkle树, 从存储中加载最新版本
+// 同一个账户树不能并发读取, 需要并发读取时每个线程在同一个存储上创建一个账户树
+func NewAccountTreeWithDB(db database.TreeDB) (bsmt.SparseMerkleTree, error) {
+	// 创建Poseidon哈希函数池
+	hasher := bsmt.NewHasherPool(func() hash.Hash {
+		return poseidon.NewPoseidon()
+	})
+	// 创建稀疏Merkle树
+	return bsmt.NewBNBSparseMerkleTree(hasher, db, uint8(AccountTreeDepth), NilAccountHash)
 }
@@ -0,0 +1,19 @@
+//go:build !js
+
+package utils
+
+import (
+	"github.com/bnb-chain/zkbnb-smt/database"
+	"github.com/bnb-chain/zkbnb-smt/database/leveldb"
+)
+
+// leveldb的缓存大小(MB)和文件句柄数量
+const (
+	levelDBCache   = 1024
+	levelDBHandles = 512
+)
+
+// newLevelDB 打开leveldb存储
+func newLevelDB(dir string) (database.TreeDB, error) {
+	return leveldb.New(dir, levelDBCache, levelDBHandles, false)
+}
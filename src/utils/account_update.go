@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// PaddingDeleteAccountIndex 填充删除操作使用的账户索引
+// 即账户树的最后一个叶子节点, 账户数量远小于2^AccountTreeDepth, 该叶子节点始终为空
+var PaddingDeleteAccountIndex = uint32(1<<AccountTreeDepth - 1)
+
+// DecodeBatchUpdateWitness 解码批量更新用户的见证数据
+// 参数:
+//   - data: EncodeBatchWitness编码的见证数据
+//
+// 返回:
+//   - *BatchUpdateUserWitness: 见证数据, 用户资产列表展开为AssetCounts个资产
+//   - error: 错误信息, 见witness_codec.go中定义的错误
+func DecodeBatchUpdateWitness(data string) (*BatchUpdateUserWitness, error) {
+	witnessForCircuit, err := decodeBatchUpdateUserWitness(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(witnessForCircuit.UpdateUserOps); i++ {
+		witnessForCircuit.UpdateUserOps[i].OldAssets = expandUserAssets(witnessForCircuit.UpdateUserOps[i].OldAssets)
+		witnessForCircuit.UpdateUserOps[i].Assets = expandUserAssets(witnessForCircuit.UpdateUserOps[i].Assets)
+	}
+	return witnessForCircuit, nil
+}
+
+// RecoverAfterCexAssetsOfUpdate 根据批量更新用户的见证数据恢复操作后的CEX资产状态
+// 参数:
+//   - witness: 解码后的批量更新用户见证数据
+func RecoverAfterCexAssetsOfUpdate(witness *BatchUpdateUserWitness) []CexAssetInfo {
+	cexAssets := witness.BeforeCexAssets
+	for i := 0; i < len(witness.UpdateUserOps); i++ {
+		for j := 0; j < len(witness.UpdateUserOps[i].OldAssets); j++ {
+			asset := &witness.UpdateUserOps[i].OldAssets[j]
+			cexAssets[asset.Index].TotalEquity = SafeSub(cexAssets[asset.Index].TotalEquity, asset.Equity)
+			cexAssets[asset.Index].TotalDebt = SafeSub(cexAssets[asset.Index].TotalDebt, asset.Debt)
+			cexAssets[asset.Index].LoanCollateral = SafeSub(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+			cexAssets[asset.Index].MarginCollateral = SafeSub(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+			cexAssets[asset.Index].PortfolioMarginCollateral = SafeSub(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+		}
+		for j := 0; j < len(witness.UpdateUserOps[i].Assets); j++ {
+			asset := &witness.UpdateUserOps[i].Assets[j]
+			cexAssets[asset.Index].TotalEquity = SafeAdd(cexAssets[asset.Index].TotalEquity, asset.Equity)
+			cexAssets[asset.Index].TotalDebt = SafeAdd(cexAssets[asset.Index].TotalDebt, asset.Debt)
+			cexAssets[asset.Index].LoanCollateral = SafeAdd(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+			cexAssets[asset.Index].MarginCollateral = SafeAdd(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+			cexAssets[asset.Index].PortfolioMarginCollateral = SafeAdd(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+		}
+	}
+	checkAfterCexAssetsCommitment(cexAssets, witness.AfterCEXAssetsCommitment)
+	return cexAssets
+}
+
+// DecodeBatchDeleteWitness 解码批量删除用户的见证数据
+// 参数:
+//   - data: EncodeBatchWitness编码的见证数据
+//
+// 返回:
+//   - *BatchDeleteUserWitness: 见证数据, 用户资产列表展开为AssetCounts个资产
+//   - error: 错误信息, 见witness_codec.go中定义的错误
+func DecodeBatchDeleteWitness(data string) (*BatchDeleteUserWitness, error) {
+	witnessForCircuit, err := decodeBatchDeleteUserWitness(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(witnessForCircuit.DeleteUserOps); i++ {
+		witnessForCircuit.DeleteUserOps[i].Assets = expandUserAssets(witnessForCircuit.DeleteUserOps[i].Assets)
+	}
+	return witnessForCircuit, nil
+}
+
+// RecoverAfterCexAssetsOfDelete 根据批量删除用户的见证数据恢复操作后的CEX资产状态
+// 参数:
+//   - witness: 解码后的批量删除用户见证数据
+func RecoverAfterCexAssetsOfDelete(witness *BatchDeleteUserWitness) []CexAssetInfo {
+	cexAssets := witness.BeforeCexAssets
+	for i := 0; i < len(witness.DeleteUserOps); i++ {
+		for j := 0; j < len(witness.DeleteUserOps[i].Assets); j++ {
+			asset := &witness.DeleteUserOps[i].Assets[j]
+			cexAssets[asset.Index].TotalEquity = SafeSub(cexAssets[asset.Index].TotalEquity, asset.Equity)
+			cexAssets[asset.Index].TotalDebt = SafeSub(cexAssets[asset.Index].TotalDebt, asset.Debt)
+			cexAssets[asset.Index].LoanCollateral = SafeSub(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+			cexAssets[asset.Index].MarginCollateral = SafeSub(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+			cexAssets[asset.Index].PortfolioMarginCollateral = SafeSub(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+		}
+	}
+	checkAfterCexAssetsCommitment(cexAssets, witness.AfterCEXAssetsCommitment)
+	return cexAssets
+}
+
+// RecoverAfterCexAssetsByOpType 根据批次的操作类型解码见证数据并恢复操作后的CEX资产状态
+// 参数:
+//   - opType: 批次的操作类型
+//   - data: EncodeBatchWitness编码的见证数据
+//
+// 返回:
+//   - []CexAssetInfo: 操作后的CEX资产状态
+//   - error: 见证数据的解码错误
+func RecoverAfterCexAssetsByOpType(opType int64, data string) ([]CexAssetInfo, error) {
+	switch opType {
+	case OpTypeUpdateUser:
+		witness, err := DecodeBatchUpdateWitness(data)
+		if err != nil {
+			return nil, err
+		}
+		return RecoverAfterCexAssetsOfUpdate(witness), nil
+	case OpTypeDeleteUser:
+		witness, err := DecodeBatchDeleteWitness(data)
+		if err != nil {
+			return nil, err
+		}
+		return RecoverAfterCexAssetsOfDelete(witness), nil
+	default:
+		witness, err := DecodeBatchWitness(data)
+		if err != nil {
+			return nil, err
+		}
+		return RecoverAfterCexAssets(witness), nil
+	}
+}
+
+// checkAfterCexAssetsCommitment sanity check: 恢复的CEX资产状态必须与见证数据中操作后的CEX资产承诺一致
+func checkAfterCexAssetsCommitment(cexAssets []CexAssetInfo, afterCexAssetsCommitment []byte) {
+	hasher := poseidon.NewPoseidon()
+	for i := 0; i < len(cexAssets); i++ {
+		commitments := ConvertAssetInfoToBytes(cexAssets[i])
+		for j := 0; j < len(commitments); j++ {
+			hasher.Write(commitments[j])
+		}
+	}
+	cexCommitment := hasher.Sum(nil)
+	if string(cexCommitment) != string(afterCexAssetsCommitment) {
+		panic("after cex commitment verify failed")
+	}
+}
+
+// AccumulateCexAssets 将账户的资产累加到CEX资产总量中
+// 用于增量审计时根据上一轮的用户数据恢复账户树对应的CEX资产总量
+// 参数:
+//   - cexAssets: CEX资产信息, 原地累加
+//   - accounts: 按资产数量分组的账户信息
+func AccumulateCexAssets(cexAssets []CexAssetInfo, accounts map[int][]AccountInfo) {
+	for _, v := range accounts {
+		for i := 0; i < len(v); i++ {
+			for _, asset := range v[i].Assets {
+				cexAssets[asset.Index].TotalEquity = SafeAdd(cexAssets[asset.Index].TotalEquity, asset.Equity)
+				cexAssets[asset.Index].TotalDebt = SafeAdd(cexAssets[asset.Index].TotalDebt, asset.Debt)
+				cexAssets[asset.Index].LoanCollateral = SafeAdd(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+				cexAssets[asset.Index].MarginCollateral = SafeAdd(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+				cexAssets[asset.Index].PortfolioMarginCollateral = SafeAdd(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+			}
+		}
+	}
+}
+
+// isAccountInfoEqual 判断两个账户的叶子节点数据是否相同
+func isAccountInfoEqual(a *AccountInfo, b *AccountInfo) bool {
+	if a.TotalEquity.Cmp(b.TotalEquity) != 0 || a.TotalDebt.Cmp(b.TotalDebt) != 0 ||
+		a.TotalCollateral.Cmp(b.TotalCollateral) != 0 || len(a.Assets) != len(b.Assets) {
+		return false
+	}
+	for i := 0; i < len(a.Assets); i++ {
+		if a.Assets[i] != b.Assets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffUserDataSet 比较上一轮和本轮的用户数据, 生成增量审计需要的操作
+// 上一轮的账户保持原有的账户索引, 本轮新增的账户从上一轮账户树已使用的索引(包括填充账户)之后开始分配索引
+// 资产数量分组发生变化的账户先从原索引删除, 再作为新账户在新的索引创建
+// 参数:
+//   - base: 上一轮审计的用户数据, 账户索引为账户树中的索引, 即当前账户树中的账户
+//   - baseNextAccountIndex: 上一轮审计结束后账户树已使用的索引数量, 见AccountRound.NextAccountIndex
+//   - current: 本轮审计的用户数据, 原地设置为分配的账户索引
+//
+// 返回:
+//   - map[int][]AccountInfo: 需要创建的新账户(按资产数量分组)
+//   - map[int][]AccountUpdate: 需要更新的账户(按资产数量分组)
+//   - map[int][]AccountInfo: 需要删除的账户(按资产数量分组), 为账户树中当前的账户信息
+//   - int: 已分配的账户索引总数, 即填充账户的起始索引
+func DiffUserDataSet(base map[int][]AccountInfo, baseNextAccountIndex int, current map[int][]AccountInfo) (map[int][]AccountInfo, map[int][]AccountUpdate, map[int][]AccountInfo, int) {
+	type baseAccount struct {
+		account   *AccountInfo
+		assetKey  int
+		processed bool
+	}
+	baseAccounts := make(map[string]*baseAccount)
+	nextAccountIndex := baseNextAccountIndex
+	for k, v := range base {
+		for i := 0; i < len(v); i++ {
+			baseAccounts[string(v[i].AccountId)] = &baseAccount{account: &v[i], assetKey: k}
+		}
+	}
+
+	creates := make(map[int][]AccountInfo)
+	updates := make(map[int][]AccountUpdate)
+	deletes := make(map[int][]AccountInfo)
+	for _, k := range AssetCountsTiers {
+		for i := range current[k] {
+			account := &current[k][i]
+			b, ok := baseAccounts[string(account.AccountId)]
+			if ok {
+				b.processed = true
+			}
+			if ok && b.assetKey != k {
+				fmt.Printf("the asset counts tier of account %x changed from %d to %d\n", account.AccountId, b.assetKey, k)
+				deletes[b.assetKey] = append(deletes[b.assetKey], *b.account)
+				ok = false
+			}
+			if !ok {
+				account.AccountIndex = uint32(nextAccountIndex)
+				nextAccountIndex += 1
+				creates[k] = append(creates[k], *account)
+				continue
+			}
+			account.AccountIndex = b.account.AccountIndex
+			if isAccountInfoEqual(b.account, account) {
+				continue
+			}
+			updates[k] = append(updates[k], AccountUpdate{Old: *b.account, New: *account})
+		}
+	}
+	// 按账户树中的顺序遍历, 保证每次生成的删除操作顺序相同
+	for _, k := range AssetCountsTiers {
+		for i := 0; i < len(base[k]); i++ {
+			if !baseAccounts[string(base[k][i].AccountId)].processed {
+				deletes[k] = append(deletes[k], base[k][i])
+			}
+		}
+	}
+	return creates, updates, deletes, nextAccountIndex
+}
+
+// RoundAccounts 一轮审计需要生成的账户操作
+// witness和userproof都通过PrepareRoundAccounts得到, 所以用户证明使用的账户索引与账户树一致
+type RoundAccounts struct {
+	Creates          map[int][]AccountInfo   // 需要创建的账户(按资产数量分组), 已填充到批次大小的整数倍
+	Updates          map[int][]AccountUpdate // 需要更新的账户(按资产数量分组), 只在增量审计时不为空
+	Deletes          map[int][]AccountInfo   // 需要删除的账户(按资产数量分组), 只在增量审计时不为空
+	CreateStartIndex int                     // 新建账户和填充账户的第一个索引, 即上一轮审计结束后已使用的索引数量
+	NextAccountIndex int                     // 本轮审计结束后已使用的索引数量(包括填充账户), 下一轮新建账户从该索引开始
+}
+
+// PrepareRoundAccounts 分配本轮审计的账户索引并生成账户操作
+// 新建账户从CreateStartIndex开始按资产数量分组的顺序分配索引, 然后每个分组的填充账户依次分配索引;
+// 配置了置换种子时, 新建账户和填充账户的索引再通过NewAccountIndexPermutation一起置换, 填充账户与新建账户交错分布
+// 参数:
+//   - base: 上一轮审计的账户, 账户索引为账户树中的索引, 见AccountIndexModel; 为nil时为从空账户树开始的完整审计
+//   - baseNextAccountIndex: 上一轮审计结束后已使用的索引数量, 完整审计为0
+//   - current: 本轮审计的账户, 完整审计时账户索引为用户数据中的顺序; 原地设置为最终的账户索引
+//   - shuffleSeed: 账户索引置换种子, 为空时不置换
+//
+// 返回:
+//   - *RoundAccounts: 本轮审计的账户操作
+func PrepareRoundAccounts(base map[int][]AccountInfo, baseNextAccountIndex int, current map[int][]AccountInfo, shuffleSeed []byte) *RoundAccounts {
+	round := &RoundAccounts{CreateStartIndex: baseNextAccountIndex}
+	if base == nil {
+		// 完整审计: 所有账户都是新建账户, 与current共用同一个数组
+		round.Creates = make(map[int][]AccountInfo, len(current))
+		round.Updates = make(map[int][]AccountUpdate)
+		round.Deletes = make(map[int][]AccountInfo)
+		round.NextAccountIndex = baseNextAccountIndex
+		for k, v := range current {
+			round.Creates[k] = v
+			round.NextAccountIndex += len(v)
+		}
+	} else {
+		round.Creates, round.Updates, round.Deletes, round.NextAccountIndex = DiffUserDataSet(base, baseNextAccountIndex, current)
+	}
+
+	keys := make([]int, 0, len(round.Creates))
+	for k := range round.Creates {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	// 填充之前记录每个分组的新建账户数量, 填充账户在其后
+	createCounts := make(map[int]int, len(keys))
+	for _, k := range keys {
+		createCounts[k] = len(round.Creates[k])
+	}
+	paddingStartIndex := round.NextAccountIndex
+	for _, k := range keys {
+		paddingStartIndex, round.Creates[k] = PaddingAccounts(round.Creates[k], k, paddingStartIndex)
+	}
+	if len(shuffleSeed) == 0 {
+		round.NextAccountIndex = paddingStartIndex
+		return round
+	}
+
+	permutation := NewAccountIndexPermutation(shuffleSeed, paddingStartIndex-round.CreateStartIndex)
+	permute := func(accounts []AccountInfo) {
+		for i := range accounts {
+			offset := int(accounts[i].AccountIndex) - round.CreateStartIndex
+			// 上一轮的账户索引小于CreateStartIndex, 保持不变
+			if offset >= 0 && offset < len(permutation) {
+				accounts[i].AccountIndex = uint32(round.CreateStartIndex) + permutation[offset]
+			}
+		}
+	}
+	for _, k := range keys {
+		permute(round.Creates[k])
+		if base == nil {
+			// 完整审计的新建账户就是current中的账户, 填充时数组可能已重新分配, 复制置换后的账户
+			copy(current[k], round.Creates[k][:createCounts[k]])
+		} else {
+			permute(current[k])
+		}
+	}
+	round.NextAccountIndex = paddingStartIndex
+	return round
+}
+
+// PaddingAccountUpdates 填充更新账户操作到批次大小的整数倍
+// 填充操作将最后一个被更新的账户更新为其自身, 不改变账户树和CEX资产
+// 参数:
+//   - updates: 更新账户操作
+//   - assetKey: 资产数量分组
+//
+// 返回:
+//   - []AccountUpdate: 填充后的更新账户操作
+func PaddingAccountUpdates(updates []AccountUpdate, assetKey int) []AccountUpdate {
+	if len(updates) == 0 {
+		return updates
+	}
+	opsPerBatch := BatchUpdateUserOpsCountsTiers[assetKey]
+	batchCounts := (len(updates) + opsPerBatch - 1) / opsPerBatch
+	paddingCounts := batchCounts*opsPerBatch - len(updates)
+	last := updates[len(updates)-1].New
+	for i := 0; i < paddingCounts; i++ {
+		updates = append(updates, AccountUpdate{Old: last, New: last})
+	}
+	return updates
+}
+
+// PaddingAccountDeletes 填充删除账户操作到批次大小的整数倍
+// 填充操作删除账户树最后一个空叶子节点上资产全为0的账户, 不改变账户树和CEX资产
+// 参数:
+//   - deletes: 删除账户操作
+//   - assetKey: 资产数量分组
+//
+// 返回:
+//   - []AccountInfo: 填充后的删除账户操作
+func PaddingAccountDeletes(deletes []AccountInfo, assetKey int) []AccountInfo {
+	if len(deletes) == 0 {
+		return deletes
+	}
+	opsPerBatch := BatchDeleteUserOpsCountsTiers[assetKey]
+	batchCounts := (len(deletes) + opsPerBatch - 1) / opsPerBatch
+	paddingCounts := batchCounts*opsPerBatch - len(deletes)
+	for i := 0; i < paddingCounts; i++ {
+		assets := make([]AccountAsset, assetKey)
+		for j := 0; j < assetKey; j++ {
+			assets[j] = AccountAsset{Index: uint16(j)}
+		}
+		deletes = append(deletes, AccountInfo{
+			AccountIndex:    PaddingDeleteAccountIndex,
+			TotalEquity:     new(big.Int).SetInt64(0),
+			TotalDebt:       new(big.Int).SetInt64(0),
+			TotalCollateral: new(big.Int).SetInt64(0),
+			Assets:          assets,
+		})
+	}
+	return deletes
+}
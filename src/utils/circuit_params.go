@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// circuitParamsKeyNamePrefix 密钥名称中电路参数摘要的前缀, 例如zkpor50_700_cp1a2b3c4d
+const circuitParamsKeyNamePrefix = "_cp"
+
+var (
+	ErrInvalidCircuitParams  = errors.New("invalid circuit params")
+	ErrCircuitParamsMismatch = errors.New("circuit params mismatch")
+
+	circuitParamsKeyNameRegexp = regexp.MustCompile(circuitParamsKeyNamePrefix + "([0-9a-f]{8})")
+
+	// currentCircuitParams 当前进程使用的电路参数, 通过SetCircuitParams修改
+	currentCircuitParams = DefaultCircuitParams()
+)
+
+// CircuitParams 电路参数
+// keygen, witness, prover, userproof和verifier必须使用相同的电路参数, 不同的电路参数生成的密钥和见证数据互不兼容
+type CircuitParams struct {
+	AssetCounts                   int         // 支持的最大资产数量
+	TierCount                     int         // 抵押率分层数量(必须是偶数)
+	AccountTreeDepth              int         // 账户树深度(4的倍数, 不超过32)
+	BatchCreateUserOpsCountsTiers map[int]int // 用户资产数量层级 -> 每批次创建用户数量
+	BatchUpdateUserOpsCountsTiers map[int]int // 用户资产数量层级 -> 每批次更新用户数量
+	BatchDeleteUserOpsCountsTiers map[int]int // 用户资产数量层级 -> 每批次删除用户数量
+}
+
+// DefaultCircuitParams 返回默认的电路参数, 未指定电路参数文件时使用
+func DefaultCircuitParams() *CircuitParams {
+	return &CircuitParams{
+		AssetCounts:      500,
+		TierCount:        12,
+		AccountTreeDepth: 28,
+		// 500种资产的用户每批92个, 50种资产的用户每批700个
+		BatchCreateUserOpsCountsTiers: map[int]int{500: 92, 50: 700},
+		// 更新用户操作需要同时验证旧的账户叶子节点, 每个操作的约束数量约为创建用户操作的两倍
+		BatchUpdateUserOpsCountsTiers: map[int]int{500: 46, 50: 350},
+		// 删除用户操作不需要验证抵押率, 每批次的用户数量与创建用户操作相同
+		BatchDeleteUserOpsCountsTiers: map[int]int{500: 92, 50: 700},
+	}
+}
+
+// LoadCircuitParams 从json文件加载电路参数, 文件名为空时返回默认的电路参数
+// 参数:
+//   - fileName: 电路参数文件
+//
+// 返回:
+//   - *CircuitParams: 电路参数
+//   - error: 错误信息
+func LoadCircuitParams(fileName string) (*CircuitParams, error) {
+	if fileName == "" {
+		return DefaultCircuitParams(), nil
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	params := &CircuitParams{}
+	if err = json.Unmarshal(content, params); err != nil {
+		return nil, err
+	}
+	if err = params.Validate(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// Validate 检查电路参数是否有效
+func (p *CircuitParams) Validate() error {
+	if p.AssetCounts <= 0 {
+		return fmt.Errorf("%w: AssetCounts should be positive", ErrInvalidCircuitParams)
+	}
+	if p.TierCount <= 0 || p.TierCount%2 != 0 {
+		return fmt.Errorf("%w: TierCount should be a positive even number", ErrInvalidCircuitParams)
+	}
+	// 账户索引为uint32, 账户树的存储按4层一组保存节点
+	if p.AccountTreeDepth <= 0 || p.AccountTreeDepth > 32 || p.AccountTreeDepth%4 != 0 {
+		return fmt.Errorf("%w: AccountTreeDepth should be a multiple of 4 and not larger than 32", ErrInvalidCircuitParams)
+	}
+	if len(p.BatchCreateUserOpsCountsTiers) == 0 {
+		return fmt.Errorf("%w: BatchCreateUserOpsCountsTiers is empty", ErrInvalidCircuitParams)
+	}
+	for k, v := range p.BatchCreateUserOpsCountsTiers {
+		if k <= 0 || k > p.AssetCounts || v <= 0 {
+			return fmt.Errorf("%w: invalid batch create user ops counts tier %d: %d", ErrInvalidCircuitParams, k, v)
+		}
+	}
+	// 更新和删除用户的资产数量层级必须与创建用户的相同, 缺省时不支持增量审计
+	for name, tiers := range map[string]map[int]int{
+		"BatchUpdateUserOpsCountsTiers": p.BatchUpdateUserOpsCountsTiers,
+		"BatchDeleteUserOpsCountsTiers": p.BatchDeleteUserOpsCountsTiers,
+	} {
+		if len(tiers) == 0 {
+			continue
+		}
+		if len(tiers) != len(p.BatchCreateUserOpsCountsTiers) {
+			return fmt.Errorf("%w: the tiers of %s are different from BatchCreateUserOpsCountsTiers", ErrInvalidCircuitParams, name)
+		}
+		for k, v := range tiers {
+			if _, ok := p.BatchCreateUserOpsCountsTiers[k]; !ok || v <= 0 {
+				return fmt.Errorf("%w: invalid tier %d of %s", ErrInvalidCircuitParams, k, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Digest 计算电路参数的摘要(8个hex字符), 用于标记密钥文件和见证数据
+func (p *CircuitParams) Digest() string {
+	// json编码map时按键排序, 相同的电路参数得到相同的编码
+	content, err := json.Marshal(p)
+	if err != nil {
+		panic(err.Error())
+	}
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:4])
+}
+
+// KeyNameSuffix 返回keygen生成的密钥名称中的电路参数后缀
+// 默认电路参数的后缀为空, 与之前生成的密钥名称保持一致
+func (p *CircuitParams) KeyNameSuffix() string {
+	if p.Digest() == DefaultCircuitParams().Digest() {
+		return ""
+	}
+	return circuitParamsKeyNamePrefix + p.Digest()
+}
+
+// SetCircuitParams 检查并设置当前进程使用的电路参数
+// 必须在创建账户树, 解析用户数据和编译电路之前调用
+func SetCircuitParams(p *CircuitParams) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	currentCircuitParams = p
+	AssetCounts = p.AssetCounts
+	TierCount = p.TierCount
+	AccountTreeDepth = p.AccountTreeDepth
+	PaddingDeleteAccountIndex = uint32(1<<AccountTreeDepth - 1)
+	BatchCreateUserOpsCountsTiers = p.BatchCreateUserOpsCountsTiers
+	BatchUpdateUserOpsCountsTiers = p.BatchUpdateUserOpsCountsTiers
+	BatchDeleteUserOpsCountsTiers = p.BatchDeleteUserOpsCountsTiers
+	AssetCountsTiers = make([]int, 0, len(BatchCreateUserOpsCountsTiers))
+	for k := range BatchCreateUserOpsCountsTiers {
+		AssetCountsTiers = append(AssetCountsTiers, k)
+	}
+	sort.Ints(AssetCountsTiers)
+	return nil
+}
+
+// InitCircuitParams 加载并设置当前进程使用的电路参数
+// 参数:
+//   - fileName: 电路参数文件, 为空时使用默认的电路参数
+func InitCircuitParams(fileName string) error {
+	params, err := LoadCircuitParams(fileName)
+	if err != nil {
+		return err
+	}
+	return SetCircuitParams(params)
+}
+
+// GetCircuitParams 返回当前进程使用的电路参数
+func GetCircuitParams() *CircuitParams {
+	return currentCircuitParams
+}
+
+// CheckCircuitParamsDigest 检查见证数据中记录的电路参数摘要是否与当前的电路参数一致
+// 空摘要为之前版本生成的见证数据, 视为默认的电路参数
+func CheckCircuitParamsDigest(digest string) error {
+	if digest == "" {
+		digest = DefaultCircuitParams().Digest()
+	}
+	if digest != currentCircuitParams.Digest() {
+		return fmt.Errorf("%w: %s is different from current %s", ErrCircuitParamsMismatch, digest, currentCircuitParams.Digest())
+	}
+	return nil
+}
+
+// CheckKeyNameCircuitParams 检查密钥名称中的电路参数摘要是否与当前的电路参数一致
+// 没有电路参数后缀的密钥视为默认电路参数生成的密钥
+func CheckKeyNameCircuitParams(keyName string) error {
+	digest := ""
+	if m := circuitParamsKeyNameRegexp.FindStringSubmatch(filepath.Base(keyName)); m != nil {
+		digest = m[1]
+	}
+	if err := CheckCircuitParamsDigest(digest); err != nil {
+		return fmt.Errorf("key %s: %w", keyName, err)
+	}
+	return nil
+}
@@ -3,17 +3,27 @@ package utils
 import (
 	// "fmt"
 	"math/big"
-	"sort"
 
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
 )
 
+// 批次见证数据的操作类型
 const (
-	AccountTreeDepth = 28      // Merkle树深度,支持2^28个账户
-	AssetCounts      = 500     // 支持的最大资产数量
-	TierCount        = 12      // 抵押率分层数量(必须是偶数)
-	R1csBatchSize    = 1000000 // R1CS约束系统的批处理大小
+	OpTypeCreateUser = iota // 批量创建用户: 在空叶子节点上插入账户
+	OpTypeUpdateUser        // 批量更新用户: 将已有账户的叶子节点更新为新的账户状态
+	OpTypeDeleteUser        // 批量删除用户: 将已有账户的叶子节点重置为空叶子节点
+)
+
+const (
+	R1csBatchSize = 1000000 // R1CS约束系统的批处理大小
+)
+
+// 电路参数, 默认值见DefaultCircuitParams, 通过SetCircuitParams从电路参数文件设置
+var (
+	AccountTreeDepth = DefaultCircuitParams().AccountTreeDepth // Merkle树深度,默认支持2^28个账户
+	AssetCounts      = DefaultCircuitParams().AssetCounts      // 支持的最大资产数量
+	TierCount        = DefaultCircuitParams().TierCount        // 抵押率分层数量(必须是偶数)
 )
 
 var (
@@ -83,14 +93,12 @@ var (
 		"1mbabydoge": true,
 	}
 	// the key is the number of assets user own
-	// the value is the number of batch create user ops
-	// 500种资产的用户每批92个
-	// 50种资产的用户每批700个
-	BatchCreateUserOpsCountsTiers = map[int]int{
-		500: 92,
-		50:  700,
-	}
-	AssetCountsTiers = make([]int, 0)
+	// the value is the number of batch create/update/delete user ops
+	// 默认值见DefaultCircuitParams
+	BatchCreateUserOpsCountsTiers map[int]int
+	BatchUpdateUserOpsCountsTiers map[int]int
+	BatchDeleteUserOpsCountsTiers map[int]int
+	AssetCountsTiers              []int
 
 	// one Fr element is 252 bits, it contains 16 16-bit elements at most
 	PowersOfSixteenBits [15]fr.Element
@@ -102,10 +110,9 @@ func init() {
 		PowersOfSixteenBits[i].SetBigInt(initValue)
 		initValue.Mul(initValue, big.NewInt(65536))
 	}
-	for k := range BatchCreateUserOpsCountsTiers {
-		AssetCountsTiers = append(AssetCountsTiers, k)
+	if err := SetCircuitParams(DefaultCircuitParams()); err != nil {
+		panic(err.Error())
 	}
-	sort.Ints(AssetCountsTiers)
 
 	zero := &fr.Element{0, 0, 0, 0}
 	tempHash := poseidon.Poseidon(zero, zero, zero, zero, zero).Bytes()
@@ -0,0 +1,24 @@
+//go:build !js
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WriteJSON 以JSON格式写入HTTP响应
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		fmt.Println("write response failed: ", err.Error())
+	}
+}
+
+// WriteJSONError 写入{"Error": 错误信息}格式的错误响应
+func WriteJSONError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"Error": err.Error()})
+}
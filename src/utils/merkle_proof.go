@@ -0,0 +1,48 @@
+package utils
+
+import "github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+
+// NilAccountHash 空账户叶子节点的哈希
+var NilAccountHash []byte
+
+// EmptyAccountTreeRoot 计算深度为AccountTreeDepth的空账户树的根
+func EmptyAccountTreeRoot() []byte {
+	hasher := poseidon.NewPoseidon()
+	node := NilAccountHash
+	for i := 0; i < AccountTreeDepth; i++ {
+		hasher.Write(node)
+		hasher.Write(node)
+		node = hasher.Sum(nil)
+		hasher.Reset()
+	}
+	return node
+}
+
+// VerifyMerkleProof 验证Merkle证明
+func VerifyMerkleProof(root []byte, accountIndex uint32, proof [][]byte, node []byte) bool {
+	// 检查证明长度是否正确
+	if len(proof) != AccountTreeDepth {
+		return false
+	}
+	// 创建Poseidon哈希函数
+	hasher := poseidon.NewPoseidon()
+	// 遍历证明路径
+	for i := 0; i < AccountTreeDepth; i++ {
+		// 检查当前位是否为0
+		bit := accountIndex & (1 << i)
+		if bit == 0 {
+			hasher.Write(node)
+			hasher.Write(proof[i])
+		} else {
+			hasher.Write(proof[i])
+			hasher.Write(node)
+		}
+		node = hasher.Sum(nil)
+		hasher.Reset()
+	}
+	// 检查计算的节点是否等于根节点
+	if string(node) != string(root) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+//go:build !js
+
+package utils
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/solidity"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+// 支持的证明系统
+const (
+	ProvingSystemGroth16 = "groth16" // Groth16, 每个电路需要单独的可信设置
+	ProvingSystemPlonk   = "plonk"   // PLONK over KZG, 使用通用的SRS
+)
+
+var ErrInvalidProvingSystem = errors.New("invalid proving system")
+
+// 批次证明的hash-to-field模式, 由prover的ForAggregation和ForSolidity决定, 验证时必须使用生成证明时的模式
+const (
+	HashToFieldDefault     = "default"     // gnark默认的hash-to-field函数
+	HashToFieldAggregation = "aggregation" // 可被聚合电路递归验证的证明
+	HashToFieldSolidity    = "solidity"    // 可被solidity合约验证的证明, groth16使用keccak256
+)
+
+var ErrInvalidHashToField = errors.New("invalid hash-to-field mode")
+
+// SnarkProof 同时兼容groth16与plonk的证明
+type SnarkProof interface {
+	io.WriterTo
+	io.ReaderFrom
+	WriteRawTo(w io.Writer) (int64, error)
+}
+
+// SnarkProvingKey 同时兼容groth16与plonk的证明密钥
+type SnarkProvingKey interface {
+	io.WriterTo
+	io.ReaderFrom
+	UnsafeReadFrom(r io.Reader) (int64, error)
+}
+
+// SnarkVerifyingKey 同时兼容groth16与plonk的验证密钥
+type SnarkVerifyingKey interface {
+	io.WriterTo
+	io.ReaderFrom
+	solidity.VerifyingKey
+}
+
+// ParseProvingSystem 校验证明系统名称, 空字符串表示默认的groth16
+func ParseProvingSystem(name string) (string, error) {
+	switch name {
+	case "", ProvingSystemGroth16:
+		return ProvingSystemGroth16, nil
+	case ProvingSystemPlonk:
+		return ProvingSystemPlonk, nil
+	default:
+		return "", ErrInvalidProvingSystem
+	}
+}
+
+// ZkKeyName 返回证明系统对应的密钥名称, 与keygen生成的密钥文件名一致
+// plonk的密钥名称以"_plonk"结尾, groth16没有后缀; 名称中已有的"_plonk"后缀会先去掉
+func ZkKeyName(name string, provingSystem string) string {
+	name = strings.TrimSuffix(name, "_"+ProvingSystemPlonk)
+	if provingSystem == ProvingSystemPlonk {
+		name += "_" + ProvingSystemPlonk
+	}
+	return name
+}
+
+// ConstraintSystemFileSuffix 返回约束系统文件的后缀
+// groth16使用R1CS(.r1cs), plonk使用SparseR1CS(.scs)
+func ConstraintSystemFileSuffix(provingSystem string) string {
+	if provingSystem == ProvingSystemPlonk {
+		return ".scs"
+	}
+	return ".r1cs"
+}
+
+// NewCircuitBuilder 返回证明系统对应的电路编译器
+func NewCircuitBuilder(provingSystem string) frontend.NewBuilder {
+	if provingSystem == ProvingSystemPlonk {
+		return scs.NewBuilder
+	}
+	return r1cs.NewBuilder
+}
+
+// NewConstraintSystem 创建用于反序列化的空约束系统
+func NewConstraintSystem(provingSystem string) constraint.ConstraintSystem {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewCS(ecc.BN254)
+	}
+	return groth16.NewCS(ecc.BN254)
+}
+
+// NewSnarkProvingKey 创建用于反序列化的空证明密钥
+func NewSnarkProvingKey(provingSystem string) SnarkProvingKey {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewProvingKey(ecc.BN254)
+	}
+	return groth16.NewProvingKey(ecc.BN254)
+}
+
+// NewSnarkVerifyingKey 创建用于反序列化的空验证密钥
+func NewSnarkVerifyingKey(provingSystem string) SnarkVerifyingKey {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewVerifyingKey(ecc.BN254)
+	}
+	return groth16.NewVerifyingKey(ecc.BN254)
+}
+
+// NewSnarkProof 创建用于反序列化的空证明
+func NewSnarkProof(provingSystem string) SnarkProof {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewProof(ecc.BN254)
+	}
+	return groth16.NewProof(ecc.BN254)
+}
+
+// RecursionProverOptions 返回生成可被聚合电路递归验证的groth16证明所需的选项
+func RecursionProverOptions() backend.ProverOption {
+	return stdgroth16.GetNativeProverOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField())
+}
+
+// RecursionVerifierOptions 返回验证可被聚合电路递归验证的groth16证明所需的选项
+func RecursionVerifierOptions() backend.VerifierOption {
+	return stdgroth16.GetNativeVerifierOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField())
+}
+
+// SolidityProverOptions 返回生成可被keygen导出的solidity合约验证的证明所需的选项
+// groth16的承诺需要使用keccak256作为hash-to-field函数, plonk使用默认选项即可
+func SolidityProverOptions(provingSystem string) backend.ProverOption {
+	if provingSystem == ProvingSystemPlonk {
+		return solidity.WithProverTargetSolidityVerifier(backend.PLONK)
+	}
+	return solidity.WithProverTargetSolidityVerifier(backend.GROTH16)
+}
+
+// SolidityVerifierOptions 返回验证可被solidity合约验证的证明所需的选项
+func SolidityVerifierOptions(provingSystem string) backend.VerifierOption {
+	if provingSystem == ProvingSystemPlonk {
+		return solidity.WithVerifierTargetSolidityVerifier(backend.PLONK)
+	}
+	return solidity.WithVerifierTargetSolidityVerifier(backend.GROTH16)
+}
+
+// HashToFieldMode 返回prover配置的ForAggregation和ForSolidity对应的hash-to-field模式
+func HashToFieldMode(forAggregation bool, forSolidity bool) string {
+	if forAggregation {
+		return HashToFieldAggregation
+	}
+	if forSolidity {
+		return HashToFieldSolidity
+	}
+	return HashToFieldDefault
+}
+
+// ParseHashToField 校验hash-to-field模式名称, 空字符串表示默认的模式
+func ParseHashToField(mode string) (string, error) {
+	switch mode {
+	case "", HashToFieldDefault:
+		return HashToFieldDefault, nil
+	case HashToFieldAggregation, HashToFieldSolidity:
+		return mode, nil
+	default:
+		return "", ErrInvalidHashToField
+	}
+}
+
+// HashToFieldProverOptions 返回按hash-to-field模式生成证明所需的选项
+func HashToFieldProverOptions(mode string, provingSystem string) []backend.ProverOption {
+	switch mode {
+	case HashToFieldAggregation:
+		return []backend.ProverOption{RecursionProverOptions()}
+	case HashToFieldSolidity:
+		return []backend.ProverOption{SolidityProverOptions(provingSystem)}
+	default:
+		return nil
+	}
+}
+
+// HashToFieldVerifierOptions 返回验证按hash-to-field模式生成的证明所需的选项
+func HashToFieldVerifierOptions(mode string, provingSystem string) []backend.VerifierOption {
+	switch mode {
+	case HashToFieldAggregation:
+		return []backend.VerifierOption{RecursionVerifierOptions()}
+	case HashToFieldSolidity:
+		return []backend.VerifierOption{SolidityVerifierOptions(provingSystem)}
+	default:
+		return nil
+	}
+}
+
+// SnarkProve 使用指定的证明系统生成证明
+func SnarkProve(provingSystem string, cs constraint.ConstraintSystem, pk SnarkProvingKey, fullWitness witness.Witness, opts ...backend.ProverOption) (SnarkProof, error) {
+	if provingSystem == ProvingSystemPlonk {
+		plonkPk, ok := pk.(plonk.ProvingKey)
+		if !ok {
+			return nil, ErrInvalidProvingSystem
+		}
+		return plonk.Prove(cs, plonkPk, fullWitness, opts...)
+	}
+	groth16Pk, ok := pk.(groth16.ProvingKey)
+	if !ok {
+		return nil, ErrInvalidProvingSystem
+	}
+	return groth16.Prove(cs, groth16Pk, fullWitness, opts...)
+}
+
+// SnarkVerify 使用指定的证明系统验证证明
+func SnarkVerify(provingSystem string, proof SnarkProof, vk SnarkVerifyingKey, publicWitness witness.Witness, opts ...backend.VerifierOption) error {
+	if provingSystem == ProvingSystemPlonk {
+		plonkProof, ok := proof.(plonk.Proof)
+		if !ok {
+			return ErrInvalidProvingSystem
+		}
+		plonkVk, ok := vk.(plonk.VerifyingKey)
+		if !ok {
+			return ErrInvalidProvingSystem
+		}
+		return plonk.Verify(plonkProof, plonkVk, publicWitness, opts...)
+	}
+	groth16Proof, ok := proof.(groth16.Proof)
+	if !ok {
+		return ErrInvalidProvingSystem
+	}
+	groth16Vk, ok := vk.(groth16.VerifyingKey)
+	if !ok {
+		return ErrInvalidProvingSystem
+	}
+	return groth16.Verify(groth16Proof, groth16Vk, publicWitness, opts...)
+}
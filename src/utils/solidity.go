@@ -0,0 +1,178 @@
+//go:build !js
+
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"golang.org/x/crypto/sha3"
+)
+
+// solidityWordSize ABI编码中每个字的字节数
+const solidityWordSize = 32
+
+var ErrInvalidSolidityProof = errors.New("invalid proof for solidity verifier")
+
+// SolidityCalldata 批次证明在keygen导出的solidity验证合约中的调用参数
+// 所有数值均为0x开头的hex字符串
+type SolidityCalldata struct {
+	BatchNumber   int64
+	ProvingSystem string
+	Method        string   // 合约方法签名
+	Proof         []string // groth16为uint256[8]; plonk只有一个元素, 即MarshalSolidity序列化后的bytes
+	Commitments   []string `json:",omitempty"` // groth16电路的Pedersen承诺, uint256[2*承诺数量]
+	CommitmentPok []string `json:",omitempty"` // groth16电路Pedersen承诺的知识证明, uint256[2]
+	PublicInputs  []string // 公开输入, 依次为批次承诺, 审计轮次编号和快照时间戳
+	Calldata      string   // 包含函数选择器的完整ABI编码调用数据, 可以直接作为交易的data
+}
+
+// NewSolidityCalldata 将证明表中的证明和批次承诺转换为solidity验证合约的调用参数
+// 证明需要由开启ForSolidity的prover生成, 否则groth16证明无法通过合约验证
+// 参数:
+//   - provingSystem: 生成证明的证明系统(groth16/plonk)
+//   - proofInfo: 证明表中base64编码的证明
+//   - batchCommitment: 证明表中base64编码的批次承诺
+//   - roundId: 批次所属的审计轮次编号
+//   - timestamp: 审计快照的时间戳
+//
+// 返回:
+//   - *SolidityCalldata: 合约调用参数
+//   - error: 错误信息
+func NewSolidityCalldata(provingSystem string, proofInfo string, batchCommitment string, roundId uint64, timestamp uint64) (*SolidityCalldata, error) {
+	provingSystem, err := ParseProvingSystem(provingSystem)
+	if err != nil {
+		return nil, err
+	}
+	proofBytes, err := base64.StdEncoding.DecodeString(proofInfo)
+	if err != nil {
+		return nil, err
+	}
+	proof := NewSnarkProof(provingSystem)
+	if _, err = proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return nil, err
+	}
+	commitment, err := base64.StdEncoding.DecodeString(batchCommitment)
+	if err != nil {
+		return nil, err
+	}
+	publicInputs := []*big.Int{
+		new(big.Int).SetBytes(commitment),
+		new(big.Int).SetUint64(roundId),
+		new(big.Int).SetUint64(timestamp),
+	}
+
+	calldata := &SolidityCalldata{
+		ProvingSystem: provingSystem,
+		PublicInputs:  toHexWords(publicInputs),
+	}
+	if provingSystem == ProvingSystemPlonk {
+		plonkProof, ok := proof.(*plonk_bn254.Proof)
+		if !ok {
+			return nil, ErrInvalidSolidityProof
+		}
+		solidityProof := plonkProof.MarshalSolidity()
+		calldata.Method = "Verify(bytes,uint256[])"
+		calldata.Proof = []string{fmt.Sprintf("0x%x", solidityProof)}
+		calldata.Calldata = fmt.Sprintf("0x%x", packPlonkCalldata(calldata.Method, solidityProof, publicInputs))
+		return calldata, nil
+	}
+
+	groth16Proof, ok := proof.(*groth16_bn254.Proof)
+	if !ok {
+		return nil, ErrInvalidSolidityProof
+	}
+	// MarshalSolidity的格式为: Ar | Bs | Krs | 承诺数量(4字节) | Commitments | CommitmentPok
+	solidityProof := groth16Proof.MarshalSolidity()
+	if len(solidityProof) < 8*solidityWordSize {
+		return nil, ErrInvalidSolidityProof
+	}
+	words := toWords(solidityProof[:8*solidityWordSize])
+	calldata.Proof = toHexWords(words)
+	args := append([]*big.Int{}, words...)
+	params := []string{"uint256[8]"}
+	if len(groth16Proof.Commitments) > 0 {
+		rest := solidityProof[8*solidityWordSize:]
+		if len(rest) < 4 || int(binary.BigEndian.Uint32(rest[:4])) != len(groth16Proof.Commitments) {
+			return nil, ErrInvalidSolidityProof
+		}
+		rest = rest[4:]
+		commitmentsSize := 2 * len(groth16Proof.Commitments) * solidityWordSize
+		if len(rest) != commitmentsSize+2*solidityWordSize {
+			return nil, ErrInvalidSolidityProof
+		}
+		commitments := toWords(rest[:commitmentsSize])
+		commitmentPok := toWords(rest[commitmentsSize:])
+		calldata.Commitments = toHexWords(commitments)
+		calldata.CommitmentPok = toHexWords(commitmentPok)
+		args = append(append(args, commitments...), commitmentPok...)
+		params = append(params, fmt.Sprintf("uint256[%d]", len(commitments)), "uint256[2]")
+	}
+	args = append(args, publicInputs...)
+	params = append(params, fmt.Sprintf("uint256[%d]", len(publicInputs)))
+	calldata.Method = "verifyProof(" + strings.Join(params, ",") + ")"
+
+	// groth16合约的参数均为定长数组, ABI编码即为依次排列的字
+	var buf bytes.Buffer
+	buf.Write(methodSelector(calldata.Method))
+	for _, arg := range args {
+		buf.Write(toWord(arg))
+	}
+	calldata.Calldata = fmt.Sprintf("0x%x", buf.Bytes())
+	return calldata, nil
+}
+
+// packPlonkCalldata 按ABI规则编码plonk合约的Verify(bytes,uint256[])调用
+func packPlonkCalldata(method string, proof []byte, publicInputs []*big.Int) []byte {
+	paddedProofSize := (len(proof) + solidityWordSize - 1) / solidityWordSize * solidityWordSize
+	var buf bytes.Buffer
+	buf.Write(methodSelector(method))
+	// 两个动态参数的偏移量
+	buf.Write(toWord(big.NewInt(2 * solidityWordSize)))
+	buf.Write(toWord(big.NewInt(int64(3*solidityWordSize + paddedProofSize))))
+	buf.Write(toWord(big.NewInt(int64(len(proof)))))
+	buf.Write(proof)
+	buf.Write(make([]byte, paddedProofSize-len(proof)))
+	buf.Write(toWord(big.NewInt(int64(len(publicInputs)))))
+	for _, input := range publicInputs {
+		buf.Write(toWord(input))
+	}
+	return buf.Bytes()
+}
+
+// methodSelector 计算合约方法的函数选择器, 即方法签名keccak256哈希的前4个字节
+func methodSelector(method string) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(method))
+	return hasher.Sum(nil)[:4]
+}
+
+// toWord 将数值编码为32字节的大端序字
+func toWord(v *big.Int) []byte {
+	return v.FillBytes(make([]byte, solidityWordSize))
+}
+
+// toWords 将字节数组按32字节切分为数值列表
+func toWords(data []byte) []*big.Int {
+	words := make([]*big.Int, len(data)/solidityWordSize)
+	for i := range words {
+		words[i] = new(big.Int).SetBytes(data[i*solidityWordSize : (i+1)*solidityWordSize])
+	}
+	return words
+}
+
+// toHexWords 将数值列表转换为0x开头的hex字符串列表
+func toHexWords(words []*big.Int) []string {
+	res := make([]string, len(words))
+	for i, w := range words {
+		res[i] = fmt.Sprintf("0x%064x", w)
+	}
+	return res
+}
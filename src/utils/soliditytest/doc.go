@@ -0,0 +1,3 @@
+// Package soliditytest 在模拟的EVM上用keygen导出的合约验证utils生成的calldata
+// 单独作为一个模块, 使主模块不依赖go-ethereum
+package soliditytest
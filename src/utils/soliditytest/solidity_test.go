@@ -0,0 +1,230 @@
+package soliditytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/frontend"
+	stdposeidon "github.com/consensys/gnark/std/hash/poseidon"
+	"github.com/consensys/gnark/std/rangecheck"
+	"github.com/consensys/gnark/test/unsafekzg"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// solidityTestCircuit 与批次电路具有相同形式的公开输入, 并使用rangecheck使groth16证明包含承诺
+type solidityTestCircuit struct {
+	BatchCommitment frontend.Variable `gnark:",public"`
+	RoundId         frontend.Variable `gnark:",public"`
+	Timestamp       frontend.Variable `gnark:",public"`
+	States          [4]frontend.Variable
+}
+
+func (c *solidityTestCircuit) Define(api frontend.API) error {
+	r := rangecheck.New(api)
+	for i := 0; i < len(c.States); i++ {
+		r.Check(c.States[i], 64)
+	}
+	actualBatchCommitment := stdposeidon.Poseidon(api, c.States[0], c.States[1], c.States[2], c.States[3], c.RoundId, c.Timestamp)
+	api.AssertIsEqual(c.BatchCommitment, actualBatchCommitment)
+	return nil
+}
+
+// 测试证明所属的审计轮次编号和快照时间戳
+const (
+	testRoundId   = 1
+	testTimestamp = 1700000000
+)
+
+// solidityWordSize ABI编码中每个字的字节数
+const solidityWordSize = 32
+
+// poseidonOfStates 计算测试电路的批次承诺
+func poseidonOfStates(roundId uint64, states ...int64) []byte {
+	inputs := make([][]byte, len(states))
+	for i, s := range states {
+		inputs[i] = new(big.Int).SetInt64(s).FillBytes(make([]byte, 32))
+	}
+	return utils.ComputeBatchCommitment(inputs[0], inputs[1], inputs[2], inputs[3], roundId, testTimestamp)
+}
+
+// proveForSolidity 为测试电路生成可被solidity合约验证的证明, 返回证明表中对应的ProofInfo, BatchCommitment和导出的合约
+func proveForSolidity(t *testing.T, provingSystem string) (string, string, string) {
+	commitment := poseidonOfStates(testRoundId, 1, 2, 3, 4)
+	assignment := &solidityTestCircuit{
+		BatchCommitment: commitment,
+		RoundId:         testRoundId,
+		Timestamp:       testTimestamp,
+		States:          [4]frontend.Variable{1, 2, 3, 4},
+	}
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), utils.NewCircuitBuilder(provingSystem), &solidityTestCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullWitness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicWitness, err := fullWitness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pk utils.SnarkProvingKey
+	var vk utils.SnarkVerifyingKey
+	if provingSystem == utils.ProvingSystemPlonk {
+		srs, srsLagrange, err := unsafekzg.NewSRS(ccs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, vk, err = plonk.Setup(ccs, srs, srsLagrange)
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		pk, vk, err = groth16.Setup(ccs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	proof, err := utils.SnarkProve(provingSystem, ccs, pk, fullWitness, utils.SolidityProverOptions(provingSystem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.SnarkVerify(provingSystem, proof, vk, publicWitness, utils.SolidityVerifierOptions(provingSystem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var proofBuf bytes.Buffer
+	if _, err = proof.WriteRawTo(&proofBuf); err != nil {
+		t.Fatal(err)
+	}
+	var contract bytes.Buffer
+	if err = vk.ExportSolidity(&contract); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(proofBuf.Bytes()), base64.StdEncoding.EncodeToString(commitment), contract.String()
+}
+
+// deployVerifier 使用solc编译合约并部署到模拟的EVM上
+func deployVerifier(t *testing.T, contract string, contractName string) (*backends.SimulatedBackend, common.Address) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "verifier.sol"), []byte(contract), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("solc", "--optimize", "--bin", "--abi", "-o", dir, filepath.Join(dir, "verifier.sol")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("compile contract failed: %s\n%s", err.Error(), string(out))
+	}
+	bin, err := os.ReadFile(filepath.Join(dir, contractName+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiJson, err := os.ReadFile(filepath.Join(dir, contractName+".abi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedAbi, err := abi.JSON(strings.NewReader(string(abiJson)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, _ := new(big.Int).SetString("1000000000000000000", 10)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{auth.From: {Balance: balance}}, 30_000_000)
+	address, _, _, err := bind.DeployContract(auth, parsedAbi, common.FromHex(strings.TrimSpace(string(bin))), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	return backend, address
+}
+
+// callVerifier 调用合约验证证明, groth16合约验证失败时回滚, plonk合约返回验证结果
+func callVerifier(backend *backends.SimulatedBackend, address common.Address, calldata *utils.SolidityCalldata) bool {
+	res, err := backend.CallContract(context.Background(), ethereum.CallMsg{
+		To:   &address,
+		Data: common.FromHex(calldata.Calldata),
+	}, nil)
+	if err != nil {
+		return false
+	}
+	if calldata.ProvingSystem == utils.ProvingSystemPlonk {
+		return len(res) == solidityWordSize && res[solidityWordSize-1] == 1
+	}
+	return true
+}
+
+func TestSolidityVerifier(t *testing.T) {
+	if _, err := exec.LookPath("solc"); err != nil {
+		// CI安装了solc, 不能跳过
+		if os.Getenv("CI") != "" {
+			t.Fatal("solc is required in CI")
+		}
+		t.Skip("solc not found")
+	}
+	contractNames := map[string]string{
+		utils.ProvingSystemGroth16: "Verifier",
+		utils.ProvingSystemPlonk:   "PlonkVerifier",
+	}
+	for provingSystem, contractName := range contractNames {
+		t.Run(provingSystem, func(t *testing.T) {
+			proofInfo, batchCommitment, contract := proveForSolidity(t, provingSystem)
+			backend, address := deployVerifier(t, contract, contractName)
+			defer backend.Close()
+
+			calldata, err := utils.NewSolidityCalldata(provingSystem, proofInfo, batchCommitment, testRoundId, testTimestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !callVerifier(backend, address, calldata) {
+				t.Fatal("valid proof should pass the solidity verifier")
+			}
+
+			// 批次承诺与证明不一致时合约验证失败
+			invalidCommitment := base64.StdEncoding.EncodeToString(poseidonOfStates(testRoundId, 1, 2, 3, 5))
+			calldata, err = utils.NewSolidityCalldata(provingSystem, proofInfo, invalidCommitment, testRoundId, testTimestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callVerifier(backend, address, calldata) {
+				t.Fatal("proof with invalid batch commitment should not pass the solidity verifier")
+			}
+
+			// 审计轮次与证明不一致时合约验证失败
+			otherRoundCommitment := base64.StdEncoding.EncodeToString(poseidonOfStates(testRoundId+1, 1, 2, 3, 4))
+			calldata, err = utils.NewSolidityCalldata(provingSystem, proofInfo, otherRoundCommitment, testRoundId+1, testTimestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callVerifier(backend, address, calldata) {
+				t.Fatal("proof of another round should not pass the solidity verifier")
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "math/big"
+import (
+	"fmt"
+	"math/big"
+)
 
 // TierRatio 定义了资产的分层抵押率结构
 type TierRatio struct {
@@ -23,9 +26,9 @@ type CexAssetInfo struct {
 	PortfolioMarginCollateral uint64 // 投资组合保证金抵押品数量
 
 	// 三种抵押品类型对应的分层抵押率配置
-	LoanRatios            [TierCount]TierRatio // 贷款抵押率配置
-	MarginRatios          [TierCount]TierRatio // 保证金抵押率配置
-	PortfolioMarginRatios [TierCount]TierRatio // 投资组合保证金抵押率配置
+	LoanRatios            []TierRatio // 贷款抵押率配置, 长度为TierCount
+	MarginRatios          []TierRatio // 保证金抵押率配置, 长度为TierCount
+	PortfolioMarginRatios []TierRatio // 投资组合保证金抵押率配置, 长度为TierCount
 }
 
 // AccountAsset 定义了账户中某个资产的状态
@@ -42,6 +45,7 @@ type AccountAsset struct {
 type AccountInfo struct {
 	AccountIndex    uint32         // 账户索引
 	AccountId       []byte         // 账户ID
+	Salt            []byte         // 本轮审计的盐值, 为空时叶子节点使用账户ID本身, 见ComputeAccountIdHash
 	TotalEquity     *big.Int       // 总权益(所有资产)
 	TotalDebt       *big.Int       // 总债务(所有资产)
 	TotalCollateral *big.Int       // 总抵押品价值
@@ -50,17 +54,19 @@ type AccountInfo struct {
 
 // CreateUserOperation 定义了创建用户的操作数据
 type CreateUserOperation struct {
-	BeforeAccountTreeRoot []byte                   // 操作前的账户树根哈希
-	AfterAccountTreeRoot  []byte                   // 操作后的账户树根哈希
-	Assets                []AccountAsset           // 用户的资产列表
-	AccountIndex          uint32                   // 账户索引
-	AccountIdHash         []byte                   // 账户ID的哈希值
-	AccountProof          [AccountTreeDepth][]byte // 账户在Merkle树中的证明路径
+	BeforeAccountTreeRoot []byte         // 操作前的账户树根哈希
+	AfterAccountTreeRoot  []byte         // 操作后的账户树根哈希
+	Assets                []AccountAsset // 用户的资产列表
+	AccountIndex          uint32         // 账户索引
+	AccountIdHash         []byte         // 账户ID的哈希值
+	AccountProof          [][]byte       // 账户在Merkle树中的证明路径, 长度为AccountTreeDepth
 }
 
 // BatchCreateUserWitness 定义了批量创建用户的见证数据
 type BatchCreateUserWitness struct {
 	BatchCommitment           []byte // 批次承诺值
+	RoundId                   uint64 // 审计轮次编号
+	Timestamp                 uint64 // 审计快照的时间戳(unix秒)
 	BeforeAccountTreeRoot     []byte // 操作前的账户树根
 	AfterAccountTreeRoot      []byte // 操作后的账户树根
 	BeforeCEXAssetsCommitment []byte // 操作前的CEX资产承诺
@@ -69,3 +75,90 @@ type BatchCreateUserWitness struct {
 	BeforeCexAssets []CexAssetInfo        // 操作前的CEX资产状态
 	CreateUserOps   []CreateUserOperation // 批量创建用户的操作列表
 }
+
+// AccountUpdate 定义了账户在上一轮审计和本轮审计之间的变化
+type AccountUpdate struct {
+	Old AccountInfo // 上一轮审计中的账户信息(即账户树中当前的叶子节点)
+	New AccountInfo // 本轮审计中的账户信息
+}
+
+// UpdateUserOperation 定义了更新用户的操作数据
+// 账户索引和账户ID哈希保持不变, 叶子节点从旧账户状态更新为新账户状态
+type UpdateUserOperation struct {
+	BeforeAccountTreeRoot []byte         // 操作前的账户树根哈希
+	AfterAccountTreeRoot  []byte         // 操作后的账户树根哈希
+	OldTotalEquity        *big.Int       // 旧账户的总权益
+	OldTotalDebt          *big.Int       // 旧账户的总债务
+	OldTotalCollateral    *big.Int       // 旧账户的总抵押品价值
+	OldAssets             []AccountAsset // 旧账户的资产列表
+	Assets                []AccountAsset // 新账户的资产列表
+	AccountIndex          uint32         // 账户索引
+	AccountIdHash         []byte         // 账户ID的哈希值
+	AccountProof          [][]byte       // 账户在Merkle树中的证明路径, 长度为AccountTreeDepth
+}
+
+// BatchUpdateUserWitness 定义了批量更新用户的见证数据
+type BatchUpdateUserWitness struct {
+	BatchCommitment           []byte // 批次承诺值
+	RoundId                   uint64 // 审计轮次编号
+	Timestamp                 uint64 // 审计快照的时间戳(unix秒)
+	BeforeAccountTreeRoot     []byte // 操作前的账户树根
+	AfterAccountTreeRoot      []byte // 操作后的账户树根
+	BeforeCEXAssetsCommitment []byte // 操作前的CEX资产承诺
+	AfterCEXAssetsCommitment  []byte // 操作后的CEX资产承诺
+
+	BeforeCexAssets []CexAssetInfo        // 操作前的CEX资产状态
+	UpdateUserOps   []UpdateUserOperation // 批量更新用户的操作列表
+}
+
+// DeleteUserOperation 定义了删除用户的操作数据
+// 账户的叶子节点被重置为空叶子节点
+type DeleteUserOperation struct {
+	BeforeAccountTreeRoot []byte         // 操作前的账户树根哈希
+	AfterAccountTreeRoot  []byte         // 操作后的账户树根哈希
+	TotalEquity           *big.Int       // 被删除账户的总权益
+	TotalDebt             *big.Int       // 被删除账户的总债务
+	TotalCollateral       *big.Int       // 被删除账户的总抵押品价值
+	Assets                []AccountAsset // 被删除账户的资产列表
+	AccountIndex          uint32         // 账户索引
+	AccountIdHash         []byte         // 账户ID的哈希值, 填充操作为空
+	AccountProof          [][]byte       // 账户在Merkle树中的证明路径, 长度为AccountTreeDepth
+}
+
+// BatchDeleteUserWitness 定义了批量删除用户的见证数据
+type BatchDeleteUserWitness struct {
+	BatchCommitment           []byte // 批次承诺值
+	RoundId                   uint64 // 审计轮次编号
+	Timestamp                 uint64 // 审计快照的时间戳(unix秒)
+	BeforeAccountTreeRoot     []byte // 操作前的账户树根
+	AfterAccountTreeRoot      []byte // 操作后的账户树根
+	BeforeCEXAssetsCommitment []byte // 操作前的CEX资产承诺
+	AfterCEXAssetsCommitment  []byte // 操作后的CEX资产承诺
+
+	BeforeCexAssets []CexAssetInfo        // 操作前的CEX资产状态
+	DeleteUserOps   []DeleteUserOperation // 批量删除用户的操作列表
+}
+
+// RoundProof 定义了一轮审计的聚合证明
+// 聚合证明递归验证了该轮所有的批次证明以及批次之间的状态衔接
+type RoundProof struct {
+	ProofInfo                 string // 聚合证明(base64编码)
+	AggregatedCommitment      string // 聚合承诺, 即聚合证明的公开输入(base64编码)
+	BeforeAccountTreeRoot     string // 第一个批次操作前的账户树根(base64编码)
+	AfterAccountTreeRoot      string // 最后一个批次操作后的账户树根(base64编码)
+	BeforeCEXAssetsCommitment string // 第一个批次操作前的CEX资产承诺(base64编码)
+	AfterCEXAssetsCommitment  string // 最后一个批次操作后的CEX资产承诺(base64编码)
+	RoundId                   uint64 // 审计轮次编号
+	Timestamp                 uint64 // 审计快照的时间戳(unix秒)
+	BatchCount                int64  // 聚合的批次数量, 不在聚合承诺中, verifier不检查
+	AggregationLevels         int    // 第一层之上的聚合层数, 决定验证使用的密钥, 旧版本的证明为0, 按1处理
+}
+
+// RoundAggregationKeyName 返回第level层轮次聚合电路的密钥名称
+// 第1层为roundKeyName, 之后的层在roundKeyName后加上层数, 例如zkpor_agg_round2
+func RoundAggregationKeyName(roundKeyName string, level int) string {
+	if level <= 1 {
+		return roundKeyName
+	}
+	return fmt.Sprintf("%s%d", roundKeyName, level)
+}
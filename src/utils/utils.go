@@ -1,10 +1,7 @@
 package utils
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/csv"
-	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,7 +15,6 @@ import (
 
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
-	"github.com/klauspost/compress/s2"
 	"github.com/shopspring/decimal"
 )
 
@@ -402,6 +398,13 @@ func SafeAdd(a uint64, b uint64) (c uint64) {
 	return c
 }
 
+func SafeSub(a uint64, b uint64) (c uint64) {
+	if a < b {
+		panic("underflow for balance")
+	}
+	return a - b
+}
+
 // 从用户文件中解析资产索引
 func ParseAssetIndexFromUserFile(userFilename string) ([]string, error) {
 	f, err := os.Open(userFilename)
@@ -426,10 +429,11 @@ func ParseAssetIndexFromUserFile(userFilename string) ([]string, error) {
 }
 
 // 填充抵押率配置到目标长度
-func PaddingTierRatios(tiersRatio []TierRatio) (res [TierCount]TierRatio) {
+func PaddingTierRatios(tiersRatio []TierRatio) (res []TierRatio) {
 	if len(tiersRatio) > TierCount {
 		panic("the length of tiers ratio is bigger than TierCount")
 	}
+	res = make([]TierRatio, TierCount)
 	for i := 0; i < TierCount; i++ {
 		if i < len(tiersRatio) {
 			res[i] = tiersRatio[i]
@@ -449,7 +453,7 @@ func PaddingTierRatios(tiersRatio []TierRatio) (res [TierCount]TierRatio) {
 	return res
 }
 
-func ParseTiersRatioFromStr(tiersRatioEnc string) ([TierCount]TierRatio, error) {
+func ParseTiersRatioFromStr(tiersRatioEnc string) ([]TierRatio, error) {
 	tiersRatioEnc = strings.Trim(tiersRatioEnc, "[]")
 	if len(tiersRatioEnc) == 0 {
 		return PaddingTierRatios([]TierRatio{}), nil
@@ -802,44 +806,41 @@ func ConvertFloatStrToUint64(f string, multiplier int64) (uint64, error) {
 	return num, nil
 }
 
-func DecodeBatchWitness(data string) *BatchCreateUserWitness {
-	var witnessForCircuit BatchCreateUserWitness
-	b, err := base64.StdEncoding.DecodeString(data)
-	if err != nil {
-		fmt.Println("deserialize batch witness failed: ", err.Error())
-		return nil
+// expandUserAssets 将见证数据中只保存非空资产的用户资产列表展开为按资产索引排列的完整列表
+func expandUserAssets(storeUserAssets []AccountAsset) []AccountAsset {
+	userAssets := make([]AccountAsset, AssetCounts)
+	for p := 0; p < AssetCounts; p++ {
+		userAssets[p] = AccountAsset{
+			Index:           uint16(p),
+			Equity:          0,
+			Debt:            0,
+			Loan:            0,
+			Margin:          0,
+			PortfolioMargin: 0,
+		}
 	}
-	uncompressedData, err := s2.Decode(nil, b)
-	if err != nil {
-		fmt.Println("uncompress batch witness failed: ", err.Error())
-		return nil
+	for p := 0; p < len(storeUserAssets); p++ {
+		userAssets[storeUserAssets[p].Index] = storeUserAssets[p]
 	}
-	unserializeBuf := bytes.NewBuffer(uncompressedData)
-	dec := gob.NewDecoder(unserializeBuf)
-	err = dec.Decode(&witnessForCircuit)
+	return userAssets
+}
+
+// DecodeBatchWitness 解码批量创建用户的见证数据
+// 参数:
+//   - data: EncodeBatchWitness编码的见证数据
+//
+// 返回:
+//   - *BatchCreateUserWitness: 见证数据, 用户资产列表展开为AssetCounts个资产
+//   - error: 错误信息, 见witness_codec.go中定义的错误
+func DecodeBatchWitness(data string) (*BatchCreateUserWitness, error) {
+	witnessForCircuit, err := decodeBatchCreateUserWitness(data)
 	if err != nil {
-		fmt.Println("unmarshal batch witness failed: ", err.Error())
-		return nil
+		return nil, err
 	}
 	for i := 0; i < len(witnessForCircuit.CreateUserOps); i++ {
-		userAssets := make([]AccountAsset, AssetCounts)
-		for p := 0; p < AssetCounts; p++ {
-			userAssets[p] = AccountAsset{
-				Index:           uint16(p),
-				Equity:          0,
-				Debt:            0,
-				Loan:            0,
-				Margin:          0,
-				PortfolioMargin: 0,
-			}
-		}
-		storeUserAssets := witnessForCircuit.CreateUserOps[i].Assets
-		for p := 0; p < len(storeUserAssets); p++ {
-			userAssets[storeUserAssets[p].Index] = storeUserAssets[p]
-		}
-		witnessForCircuit.CreateUserOps[i].Assets = userAssets
+		witnessForCircuit.CreateUserOps[i].Assets = expandUserAssets(witnessForCircuit.CreateUserOps[i].Assets)
 	}
-	return &witnessForCircuit
+	return witnessForCircuit, nil
 }
 
 // AccountInfoToHash 计算账户信息的哈希值
@@ -853,7 +854,7 @@ func AccountInfoToHash(account *AccountInfo, hasher *hash.Hash) []byte {
 	assetCommitment := ComputeUserAssetsCommitment(hasher, account.Assets)
 	(*hasher).Reset()
 	// compute new account leaf node hash
-	accountHash := poseidon.PoseidonBytes(account.AccountId, account.TotalEquity.Bytes(), account.TotalDebt.Bytes(), account.TotalCollateral.Bytes(), assetCommitment)
+	accountHash := poseidon.PoseidonBytes(ComputeAccountIdHash(account.AccountId, account.Salt), account.TotalEquity.Bytes(), account.TotalDebt.Bytes(), account.TotalCollateral.Bytes(), assetCommitment)
 	return accountHash
 }
 
@@ -910,6 +911,26 @@ func ComputeCexAssetsCommitment(cexAssetsInfo []CexAssetInfo) []byte {
 	return hasher.Sum(nil)
 }
 
+// ComputeBatchCommitment 计算批次承诺
+// 批次承诺绑定了审计轮次编号和快照时间戳, 使一轮审计的证明不能被当作另一轮审计的证明重放
+// 参数:
+//   - beforeAccountTreeRoot, afterAccountTreeRoot: 操作前后的账户树根
+//   - beforeCexAssetsCommitment, afterCexAssetsCommitment: 操作前后的CEX资产承诺
+//   - roundId: 审计轮次编号
+//   - timestamp: 审计快照的时间戳
+//
+// 返回:
+//   - []byte: 批次承诺
+func ComputeBatchCommitment(beforeAccountTreeRoot []byte, afterAccountTreeRoot []byte,
+	beforeCexAssetsCommitment []byte, afterCexAssetsCommitment []byte, roundId uint64, timestamp uint64) []byte {
+	return poseidon.PoseidonBytes(beforeAccountTreeRoot,
+		afterAccountTreeRoot,
+		beforeCexAssetsCommitment,
+		afterCexAssetsCommitment,
+		new(big.Int).SetUint64(roundId).Bytes(),
+		new(big.Int).SetUint64(timestamp).Bytes())
+}
+
 func PaddingAccounts(accounts []AccountInfo, assetKey int, paddingStartIndex int) (int, []AccountInfo) {
 	opsPerBatch := BatchCreateUserOpsCountsTiers[assetKey]
 	batchCounts := (len(accounts) + opsPerBatch - 1) / opsPerBatch
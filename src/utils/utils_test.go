@@ -2,6 +2,8 @@ package utils
 
 import (
 	// "encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
@@ -208,3 +210,122 @@ func TestParseCexAssetInfoFromFile(t *testing.T) {
 	// 6. 打印抵押率配置示例
 	fmt.Println("cexAssetsInfo: ", cexAssetsInfo[0].PortfolioMarginRatios)
 }
+
+func TestCircuitParams(t *testing.T) {
+	defer SetCircuitParams(DefaultCircuitParams())
+
+	params, err := LoadCircuitParams("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.KeyNameSuffix() != "" {
+		t.Fatal("default circuit params should not change the key name")
+	}
+
+	params = &CircuitParams{
+		AssetCounts:                   20,
+		TierCount:                     4,
+		AccountTreeDepth:              8,
+		BatchCreateUserOpsCountsTiers: map[int]int{10: 4},
+		BatchUpdateUserOpsCountsTiers: map[int]int{10: 2},
+		BatchDeleteUserOpsCountsTiers: map[int]int{10: 4},
+	}
+	fileName := t.TempDir() + "/circuit_params.json"
+	content, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fileName, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = InitCircuitParams(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if AssetCounts != 20 || TierCount != 4 || AccountTreeDepth != 8 || PaddingDeleteAccountIndex != 255 {
+		t.Fatal("circuit params are not applied")
+	}
+	if len(PaddingTierRatios([]TierRatio{})) != 4 {
+		t.Fatal("tier ratios should be padded to TierCount")
+	}
+	// 默认电路参数生成的密钥和见证数据不能用于当前的电路参数
+	if err = CheckCircuitParamsDigest(""); !errors.Is(err, ErrCircuitParamsMismatch) {
+		t.Fatal("witness of default circuit params should be rejected")
+	}
+	if err = CheckKeyNameCircuitParams("zkpor10_4"); !errors.Is(err, ErrCircuitParamsMismatch) {
+		t.Fatal("key of default circuit params should be rejected")
+	}
+	if err = CheckKeyNameCircuitParams("/data/zkpor10_4" + params.KeyNameSuffix() + "_plonk"); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewAccountTree("memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(tree.Root()) != string(EmptyAccountTreeRoot()) {
+		t.Fatal("empty account tree root mismatch")
+	}
+
+	invalidParams := *params
+	invalidParams.TierCount = 3
+	if err = SetCircuitParams(&invalidParams); !errors.Is(err, ErrInvalidCircuitParams) {
+		t.Fatal("odd TierCount should be rejected")
+	}
+	invalidParams = *params
+	invalidParams.AccountTreeDepth = 10
+	if err = SetCircuitParams(&invalidParams); !errors.Is(err, ErrInvalidCircuitParams) {
+		t.Fatal("AccountTreeDepth which is not a multiple of 4 should be rejected")
+	}
+	invalidParams = *params
+	invalidParams.BatchUpdateUserOpsCountsTiers = map[int]int{20: 2}
+	if err = SetCircuitParams(&invalidParams); !errors.Is(err, ErrInvalidCircuitParams) {
+		t.Fatal("update tiers different from create tiers should be rejected")
+	}
+}
+
+func TestLevelDBAccountTree(t *testing.T) {
+	if _, err := NewAccountTree("rocksdb", ""); !errors.Is(err, ErrUnknownTreeDBDriver) {
+		t.Fatalf("expect ErrUnknownTreeDBDriver but got %v", err)
+	}
+
+	tree, err := NewAccountTree(TreeDBDriverLevelDB, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryTree, err := NewAccountTree(TreeDBDriverMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每个版本写入一个叶子节点, 与witness服务每个批次提交一次的方式相同
+	roots := make([][]byte, 0)
+	for i := 0; i < 3; i++ {
+		hasher := poseidon.NewPoseidon()
+		hasher.Write(big.NewInt(int64(i + 1)).FillBytes(make([]byte, 32)))
+		leaf := hasher.Sum(nil)
+		if err = tree.Set(uint64(i), leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err = memoryTree.Set(uint64(i), leaf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tree.Commit(nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = memoryTree.Commit(nil); err != nil {
+			t.Fatal(err)
+		}
+		if string(tree.Root()) != string(memoryTree.Root()) {
+			t.Fatal("leveldb account tree root is different from memory account tree root")
+		}
+		roots = append(roots, tree.Root())
+	}
+	if tree.LatestVersion() != 3 {
+		t.Fatalf("expect version 3 but got %d", tree.LatestVersion())
+	}
+	if err = tree.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if tree.LatestVersion() != 1 || string(tree.Root()) != string(roots[0]) {
+		t.Fatal("leveldb account tree rollback failed")
+	}
+}
@@ -0,0 +1,595 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 见证数据的编码格式, 详细的字段定义见docs/witness_format.md
+// 见证数据 = base64(头部 || 压缩后的数据体), 头部固定为WitnessHeaderSize字节且不压缩
+const (
+	WitnessFormatVersion = 1  // 当前的见证数据格式版本
+	WitnessHeaderSize    = 24 // 见证数据头部的字节数
+
+	WitnessCompressionNone = 0 // 数据体不压缩
+	WitnessCompressionZstd = 1 // 数据体使用zstd压缩
+)
+
+// witnessMagic 见证数据头部的魔数, 用于区分之前版本使用gob编码的见证数据
+var witnessMagic = []byte("ZKPW")
+
+var (
+	ErrInvalidWitnessEncoding    = errors.New("invalid witness encoding")
+	ErrLegacyWitnessFormat       = errors.New("legacy gob witness format, run dbtool -migrate_witness to migrate it")
+	ErrUnsupportedWitnessVersion = errors.New("unsupported witness format version")
+	ErrWitnessOpTypeMismatch     = errors.New("witness op type mismatch")
+	ErrMalformedWitness          = errors.New("malformed witness data")
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// WitnessHeader 见证数据头部, 记录格式版本和生成见证数据时使用的电路参数
+type WitnessHeader struct {
+	Version          uint16 // 格式版本
+	OpType           int    // 批次的操作类型
+	Compression      uint8  // 数据体的压缩算法
+	AssetCounts      uint32 // 电路参数AssetCounts
+	TierCount        uint32 // 电路参数TierCount
+	AccountTreeDepth uint32 // 电路参数AccountTreeDepth
+	CircuitParams    string // 电路参数摘要, 见CircuitParams.Digest
+}
+
+// CheckCircuitParams 检查头部中的电路参数是否与当前进程使用的电路参数一致
+func (h *WitnessHeader) CheckCircuitParams() error {
+	if err := CheckCircuitParamsDigest(h.CircuitParams); err != nil {
+		return err
+	}
+	if int(h.AssetCounts) != AssetCounts || int(h.TierCount) != TierCount || int(h.AccountTreeDepth) != AccountTreeDepth {
+		return fmt.Errorf("%w: witness header has AssetCounts %d, TierCount %d, AccountTreeDepth %d",
+			ErrCircuitParamsMismatch, h.AssetCounts, h.TierCount, h.AccountTreeDepth)
+	}
+	return nil
+}
+
+// EncodeBatchWitness 将批次见证数据编码为当前版本的格式
+// 参数:
+//   - batchWitness: *BatchCreateUserWitness, *BatchUpdateUserWitness或*BatchDeleteUserWitness
+//
+// 返回:
+//   - string: base64编码的见证数据
+//   - error: 错误信息
+func EncodeBatchWitness(batchWitness interface{}) (string, error) {
+	w := &witnessWriter{}
+	var opType int
+	switch v := batchWitness.(type) {
+	case *BatchCreateUserWitness:
+		opType = OpTypeCreateUser
+		w.writeBatchCreateUserWitness(v)
+	case *BatchUpdateUserWitness:
+		opType = OpTypeUpdateUser
+		w.writeBatchUpdateUserWitness(v)
+	case *BatchDeleteUserWitness:
+		opType = OpTypeDeleteUser
+		w.writeBatchDeleteUserWitness(v)
+	default:
+		return "", fmt.Errorf("%w: unsupported batch witness type %T", ErrInvalidWitnessEncoding, batchWitness)
+	}
+	if w.err != nil {
+		return "", w.err
+	}
+	digest, err := hex.DecodeString(GetCircuitParams().Digest())
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, WitnessHeaderSize)
+	copy(header[0:4], witnessMagic)
+	binary.BigEndian.PutUint16(header[4:6], WitnessFormatVersion)
+	header[6] = uint8(opType)
+	header[7] = WitnessCompressionZstd
+	binary.BigEndian.PutUint32(header[8:12], uint32(AssetCounts))
+	binary.BigEndian.PutUint32(header[12:16], uint32(TierCount))
+	binary.BigEndian.PutUint32(header[16:20], uint32(AccountTreeDepth))
+	copy(header[20:24], digest)
+	data := zstdEncoder.EncodeAll(w.buf.Bytes(), header)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeWitnessHeader 解析见证数据的头部, 不检查电路参数
+// 之前版本使用gob编码的见证数据返回ErrLegacyWitnessFormat
+func DecodeWitnessHeader(data string) (*WitnessHeader, error) {
+	header, _, err := splitWitnessData(data)
+	return header, err
+}
+
+// splitWitnessData 解码base64并解析头部, 返回头部和未解压的数据体
+func splitWitnessData(data string) (*WitnessHeader, []byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidWitnessEncoding, err.Error())
+	}
+	if len(b) < WitnessHeaderSize || !bytes.Equal(b[0:4], witnessMagic) {
+		return nil, nil, ErrLegacyWitnessFormat
+	}
+	header := &WitnessHeader{
+		Version:          binary.BigEndian.Uint16(b[4:6]),
+		OpType:           int(b[6]),
+		Compression:      b[7],
+		AssetCounts:      binary.BigEndian.Uint32(b[8:12]),
+		TierCount:        binary.BigEndian.Uint32(b[12:16]),
+		AccountTreeDepth: binary.BigEndian.Uint32(b[16:20]),
+		CircuitParams:    hex.EncodeToString(b[20:24]),
+	}
+	if header.Version != WitnessFormatVersion {
+		return header, nil, fmt.Errorf("%w: %d", ErrUnsupportedWitnessVersion, header.Version)
+	}
+	return header, b[WitnessHeaderSize:], nil
+}
+
+// decodeWitnessBody 检查头部的操作类型和电路参数, 返回解压后的数据体
+func decodeWitnessBody(data string, opType int) ([]byte, error) {
+	header, body, err := splitWitnessData(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.OpType != opType {
+		return nil, fmt.Errorf("%w: expect %d but got %d", ErrWitnessOpTypeMismatch, opType, header.OpType)
+	}
+	if err = header.CheckCircuitParams(); err != nil {
+		return nil, err
+	}
+	switch header.Compression {
+	case WitnessCompressionNone:
+		return body, nil
+	case WitnessCompressionZstd:
+		body, err = zstdDecoder.DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidWitnessEncoding, err.Error())
+		}
+		return body, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %d", ErrInvalidWitnessEncoding, header.Compression)
+	}
+}
+
+// decodeBatchCreateUserWitness 解码批量创建用户的见证数据, 用户资产列表保持见证数据中的稀疏形式
+func decodeBatchCreateUserWitness(data string) (*BatchCreateUserWitness, error) {
+	body, err := decodeWitnessBody(data, OpTypeCreateUser)
+	if err != nil {
+		return nil, err
+	}
+	r := &witnessReader{data: body}
+	batchWitness := r.readBatchCreateUserWitness()
+	if err = r.finish(); err != nil {
+		return nil, err
+	}
+	return batchWitness, nil
+}
+
+// decodeBatchUpdateUserWitness 解码批量更新用户的见证数据, 用户资产列表保持见证数据中的稀疏形式
+func decodeBatchUpdateUserWitness(data string) (*BatchUpdateUserWitness, error) {
+	body, err := decodeWitnessBody(data, OpTypeUpdateUser)
+	if err != nil {
+		return nil, err
+	}
+	r := &witnessReader{data: body}
+	batchWitness := r.readBatchUpdateUserWitness()
+	if err = r.finish(); err != nil {
+		return nil, err
+	}
+	return batchWitness, nil
+}
+
+// decodeBatchDeleteUserWitness 解码批量删除用户的见证数据, 用户资产列表保持见证数据中的稀疏形式
+func decodeBatchDeleteUserWitness(data string) (*BatchDeleteUserWitness, error) {
+	body, err := decodeWitnessBody(data, OpTypeDeleteUser)
+	if err != nil {
+		return nil, err
+	}
+	r := &witnessReader{data: body}
+	batchWitness := r.readBatchDeleteUserWitness()
+	if err = r.finish(); err != nil {
+		return nil, err
+	}
+	return batchWitness, nil
+}
+
+// witnessWriter 按docs/witness_format.md的定义写入见证数据体, 所有整数均为大端序
+type witnessWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *witnessWriter) writeUint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *witnessWriter) writeUint16(v uint16) {
+	w.buf.Write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+func (w *witnessWriter) writeUint32(v uint32) {
+	w.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (w *witnessWriter) writeUint64(v uint64) {
+	w.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+// writeBytes 写入uint32长度前缀和字节数组
+func (w *witnessWriter) writeBytes(v []byte) {
+	w.writeUint32(uint32(len(v)))
+	w.buf.Write(v)
+}
+
+// writeBigInt 以大端序字节数组写入非负整数, nil视为0
+func (w *witnessWriter) writeBigInt(v *big.Int) {
+	if v == nil {
+		w.writeBytes(nil)
+		return
+	}
+	if v.Sign() < 0 {
+		w.fail("negative integer %s", v.String())
+	}
+	w.writeBytes(v.Bytes())
+}
+
+func (w *witnessWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: %s", ErrInvalidWitnessEncoding, fmt.Sprintf(format, args...))
+	}
+}
+
+func (w *witnessWriter) writeTierRatios(ratios []TierRatio) {
+	if len(ratios) != TierCount {
+		w.fail("the length of tier ratios is %d, it should be %d", len(ratios), TierCount)
+	}
+	w.writeUint32(uint32(len(ratios)))
+	for i := 0; i < len(ratios); i++ {
+		w.writeBigInt(ratios[i].BoundaryValue)
+		w.writeUint8(ratios[i].Ratio)
+		w.writeBigInt(ratios[i].PrecomputedValue)
+	}
+}
+
+func (w *witnessWriter) writeCexAssets(cexAssets []CexAssetInfo) {
+	w.writeUint32(uint32(len(cexAssets)))
+	for i := 0; i < len(cexAssets); i++ {
+		asset := &cexAssets[i]
+		w.writeUint64(asset.TotalEquity)
+		w.writeUint64(asset.TotalDebt)
+		w.writeUint64(asset.BasePrice)
+		w.writeBytes([]byte(asset.Symbol))
+		w.writeUint32(asset.Index)
+		w.writeUint64(asset.LoanCollateral)
+		w.writeUint64(asset.MarginCollateral)
+		w.writeUint64(asset.PortfolioMarginCollateral)
+		w.writeTierRatios(asset.LoanRatios)
+		w.writeTierRatios(asset.MarginRatios)
+		w.writeTierRatios(asset.PortfolioMarginRatios)
+	}
+}
+
+func (w *witnessWriter) writeAccountAssets(assets []AccountAsset) {
+	w.writeUint32(uint32(len(assets)))
+	for i := 0; i < len(assets); i++ {
+		w.writeUint16(assets[i].Index)
+		w.writeUint64(assets[i].Equity)
+		w.writeUint64(assets[i].Debt)
+		w.writeUint64(assets[i].Loan)
+		w.writeUint64(assets[i].Margin)
+		w.writeUint64(assets[i].PortfolioMargin)
+	}
+}
+
+func (w *witnessWriter) writeAccountProof(proof [][]byte) {
+	if len(proof) != AccountTreeDepth {
+		w.fail("the length of account proof is %d, it should be %d", len(proof), AccountTreeDepth)
+	}
+	w.writeUint32(uint32(len(proof)))
+	for i := 0; i < len(proof); i++ {
+		w.writeBytes(proof[i])
+	}
+}
+
+// writeBatchHeader 写入三种批次见证数据共有的字段
+func (w *witnessWriter) writeBatchHeader(batchCommitment []byte, roundId uint64, timestamp uint64,
+	beforeAccountTreeRoot, afterAccountTreeRoot, beforeCexAssetsCommitment, afterCexAssetsCommitment []byte,
+	beforeCexAssets []CexAssetInfo) {
+	w.writeBytes(batchCommitment)
+	w.writeUint64(roundId)
+	w.writeUint64(timestamp)
+	w.writeBytes(beforeAccountTreeRoot)
+	w.writeBytes(afterAccountTreeRoot)
+	w.writeBytes(beforeCexAssetsCommitment)
+	w.writeBytes(afterCexAssetsCommitment)
+	w.writeCexAssets(beforeCexAssets)
+}
+
+func (w *witnessWriter) writeBatchCreateUserWitness(v *BatchCreateUserWitness) {
+	w.writeBatchHeader(v.BatchCommitment, v.RoundId, v.Timestamp, v.BeforeAccountTreeRoot, v.AfterAccountTreeRoot,
+		v.BeforeCEXAssetsCommitment, v.AfterCEXAssetsCommitment, v.BeforeCexAssets)
+	w.writeUint32(uint32(len(v.CreateUserOps)))
+	for i := 0; i < len(v.CreateUserOps); i++ {
+		op := &v.CreateUserOps[i]
+		w.writeBytes(op.BeforeAccountTreeRoot)
+		w.writeBytes(op.AfterAccountTreeRoot)
+		w.writeAccountAssets(op.Assets)
+		w.writeUint32(op.AccountIndex)
+		w.writeBytes(op.AccountIdHash)
+		w.writeAccountProof(op.AccountProof)
+	}
+}
+
+func (w *witnessWriter) writeBatchUpdateUserWitness(v *BatchUpdateUserWitness) {
+	w.writeBatchHeader(v.BatchCommitment, v.RoundId, v.Timestamp, v.BeforeAccountTreeRoot, v.AfterAccountTreeRoot,
+		v.BeforeCEXAssetsCommitment, v.AfterCEXAssetsCommitment, v.BeforeCexAssets)
+	w.writeUint32(uint32(len(v.UpdateUserOps)))
+	for i := 0; i < len(v.UpdateUserOps); i++ {
+		op := &v.UpdateUserOps[i]
+		w.writeBytes(op.BeforeAccountTreeRoot)
+		w.writeBytes(op.AfterAccountTreeRoot)
+		w.writeBigInt(op.OldTotalEquity)
+		w.writeBigInt(op.OldTotalDebt)
+		w.writeBigInt(op.OldTotalCollateral)
+		w.writeAccountAssets(op.OldAssets)
+		w.writeAccountAssets(op.Assets)
+		w.writeUint32(op.AccountIndex)
+		w.writeBytes(op.AccountIdHash)
+		w.writeAccountProof(op.AccountProof)
+	}
+}
+
+func (w *witnessWriter) writeBatchDeleteUserWitness(v *BatchDeleteUserWitness) {
+	w.writeBatchHeader(v.BatchCommitment, v.RoundId, v.Timestamp, v.BeforeAccountTreeRoot, v.AfterAccountTreeRoot,
+		v.BeforeCEXAssetsCommitment, v.AfterCEXAssetsCommitment, v.BeforeCexAssets)
+	w.writeUint32(uint32(len(v.DeleteUserOps)))
+	for i := 0; i < len(v.DeleteUserOps); i++ {
+		op := &v.DeleteUserOps[i]
+		w.writeBytes(op.BeforeAccountTreeRoot)
+		w.writeBytes(op.AfterAccountTreeRoot)
+		w.writeBigInt(op.TotalEquity)
+		w.writeBigInt(op.TotalDebt)
+		w.writeBigInt(op.TotalCollateral)
+		w.writeAccountAssets(op.Assets)
+		w.writeUint32(op.AccountIndex)
+		w.writeBytes(op.AccountIdHash)
+		w.writeAccountProof(op.AccountProof)
+	}
+}
+
+// witnessReader 按docs/witness_format.md的定义读取见证数据体
+// 读取失败后后续的读取均返回零值, 错误通过finish返回
+type witnessReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+// next 返回接下来的n个字节, 数据不足时记录错误
+func (r *witnessReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data)-r.off < n {
+		r.err = fmt.Errorf("%w: unexpected end of data at offset %d", ErrMalformedWitness, r.off)
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *witnessReader) readUint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *witnessReader) readUint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *witnessReader) readUint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *witnessReader) readUint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// readCount 读取列表长度, 每个元素至少占用minSize字节, 避免损坏的数据导致分配过多内存
+func (r *witnessReader) readCount(minSize int) int {
+	n := int(r.readUint32())
+	if r.err == nil && n*minSize > len(r.data)-r.off {
+		r.err = fmt.Errorf("%w: invalid list length %d at offset %d", ErrMalformedWitness, n, r.off)
+		return 0
+	}
+	return n
+}
+
+func (r *witnessReader) readBytes() []byte {
+	n := int(r.readUint32())
+	b := r.next(n)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (r *witnessReader) readBigInt() *big.Int {
+	return new(big.Int).SetBytes(r.readBytes())
+}
+
+// finish 检查数据体是否被完整读取
+func (r *witnessReader) finish() error {
+	if r.err == nil && r.off != len(r.data) {
+		r.err = fmt.Errorf("%w: %d trailing bytes", ErrMalformedWitness, len(r.data)-r.off)
+	}
+	return r.err
+}
+
+func (r *witnessReader) readTierRatios() []TierRatio {
+	n := r.readCount(9)
+	if r.err == nil && n != TierCount {
+		r.err = fmt.Errorf("%w: the length of tier ratios is %d, it should be %d", ErrMalformedWitness, n, TierCount)
+		return nil
+	}
+	ratios := make([]TierRatio, n)
+	for i := 0; i < n; i++ {
+		ratios[i].BoundaryValue = r.readBigInt()
+		ratios[i].Ratio = r.readUint8()
+		ratios[i].PrecomputedValue = r.readBigInt()
+	}
+	return ratios
+}
+
+func (r *witnessReader) readCexAssets() []CexAssetInfo {
+	n := r.readCount(48)
+	cexAssets := make([]CexAssetInfo, n)
+	for i := 0; i < n; i++ {
+		asset := &cexAssets[i]
+		asset.TotalEquity = r.readUint64()
+		asset.TotalDebt = r.readUint64()
+		asset.BasePrice = r.readUint64()
+		asset.Symbol = string(r.readBytes())
+		asset.Index = r.readUint32()
+		asset.LoanCollateral = r.readUint64()
+		asset.MarginCollateral = r.readUint64()
+		asset.PortfolioMarginCollateral = r.readUint64()
+		asset.LoanRatios = r.readTierRatios()
+		asset.MarginRatios = r.readTierRatios()
+		asset.PortfolioMarginRatios = r.readTierRatios()
+	}
+	return cexAssets
+}
+
+func (r *witnessReader) readAccountAssets() []AccountAsset {
+	n := r.readCount(42)
+	assets := make([]AccountAsset, n)
+	for i := 0; i < n; i++ {
+		assets[i].Index = r.readUint16()
+		assets[i].Equity = r.readUint64()
+		assets[i].Debt = r.readUint64()
+		assets[i].Loan = r.readUint64()
+		assets[i].Margin = r.readUint64()
+		assets[i].PortfolioMargin = r.readUint64()
+		if r.err == nil && int(assets[i].Index) >= AssetCounts {
+			r.err = fmt.Errorf("%w: asset index %d out of range", ErrMalformedWitness, assets[i].Index)
+		}
+	}
+	return assets
+}
+
+func (r *witnessReader) readAccountProof() [][]byte {
+	n := r.readCount(4)
+	if r.err == nil && n != AccountTreeDepth {
+		r.err = fmt.Errorf("%w: the length of account proof is %d, it should be %d", ErrMalformedWitness, n, AccountTreeDepth)
+		return nil
+	}
+	proof := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		proof[i] = r.readBytes()
+	}
+	return proof
+}
+
+func (r *witnessReader) readBatchCreateUserWitness() *BatchCreateUserWitness {
+	v := &BatchCreateUserWitness{}
+	v.BatchCommitment = r.readBytes()
+	v.RoundId = r.readUint64()
+	v.Timestamp = r.readUint64()
+	v.BeforeAccountTreeRoot = r.readBytes()
+	v.AfterAccountTreeRoot = r.readBytes()
+	v.BeforeCEXAssetsCommitment = r.readBytes()
+	v.AfterCEXAssetsCommitment = r.readBytes()
+	v.BeforeCexAssets = r.readCexAssets()
+	n := r.readCount(20)
+	v.CreateUserOps = make([]CreateUserOperation, n)
+	for i := 0; i < n; i++ {
+		op := &v.CreateUserOps[i]
+		op.BeforeAccountTreeRoot = r.readBytes()
+		op.AfterAccountTreeRoot = r.readBytes()
+		op.Assets = r.readAccountAssets()
+		op.AccountIndex = r.readUint32()
+		op.AccountIdHash = r.readBytes()
+		op.AccountProof = r.readAccountProof()
+	}
+	return v
+}
+
+func (r *witnessReader) readBatchUpdateUserWitness() *BatchUpdateUserWitness {
+	v := &BatchUpdateUserWitness{}
+	v.BatchCommitment = r.readBytes()
+	v.RoundId = r.readUint64()
+	v.Timestamp = r.readUint64()
+	v.BeforeAccountTreeRoot = r.readBytes()
+	v.AfterAccountTreeRoot = r.readBytes()
+	v.BeforeCEXAssetsCommitment = r.readBytes()
+	v.AfterCEXAssetsCommitment = r.readBytes()
+	v.BeforeCexAssets = r.readCexAssets()
+	n := r.readCount(36)
+	v.UpdateUserOps = make([]UpdateUserOperation, n)
+	for i := 0; i < n; i++ {
+		op := &v.UpdateUserOps[i]
+		op.BeforeAccountTreeRoot = r.readBytes()
+		op.AfterAccountTreeRoot = r.readBytes()
+		op.OldTotalEquity = r.readBigInt()
+		op.OldTotalDebt = r.readBigInt()
+		op.OldTotalCollateral = r.readBigInt()
+		op.OldAssets = r.readAccountAssets()
+		op.Assets = r.readAccountAssets()
+		op.AccountIndex = r.readUint32()
+		op.AccountIdHash = r.readBytes()
+		op.AccountProof = r.readAccountProof()
+	}
+	return v
+}
+
+func (r *witnessReader) readBatchDeleteUserWitness() *BatchDeleteUserWitness {
+	v := &BatchDeleteUserWitness{}
+	v.BatchCommitment = r.readBytes()
+	v.RoundId = r.readUint64()
+	v.Timestamp = r.readUint64()
+	v.BeforeAccountTreeRoot = r.readBytes()
+	v.AfterAccountTreeRoot = r.readBytes()
+	v.BeforeCEXAssetsCommitment = r.readBytes()
+	v.AfterCEXAssetsCommitment = r.readBytes()
+	v.BeforeCexAssets = r.readCexAssets()
+	n := r.readCount(32)
+	v.DeleteUserOps = make([]DeleteUserOperation, n)
+	for i := 0; i < n; i++ {
+		op := &v.DeleteUserOps[i]
+		op.BeforeAccountTreeRoot = r.readBytes()
+		op.AfterAccountTreeRoot = r.readBytes()
+		op.TotalEquity = r.readBigInt()
+		op.TotalDebt = r.readBigInt()
+		op.TotalCollateral = r.readBigInt()
+		op.Assets = r.readAccountAssets()
+		op.AccountIndex = r.readUint32()
+		op.AccountIdHash = r.readBytes()
+		op.AccountProof = r.readAccountProof()
+	}
+	return v
+}
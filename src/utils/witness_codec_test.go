@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// constructTestBatchCreateUserWitness 构建用于测试编码的批量创建用户见证数据, 数据不需要满足电路约束
+func constructTestBatchCreateUserWitness() *BatchCreateUserWitness {
+	cexAssets := make([]CexAssetInfo, 3)
+	for i := 0; i < len(cexAssets); i++ {
+		cexAssets[i] = CexAssetInfo{
+			TotalEquity:           uint64(100 * i),
+			TotalDebt:             uint64(i),
+			BasePrice:             uint64(1000 + i),
+			Symbol:                "asset",
+			Index:                 uint32(i),
+			LoanRatios:            PaddingTierRatios([]TierRatio{{BoundaryValue: big.NewInt(100), Ratio: 80, PrecomputedValue: big.NewInt(80)}}),
+			MarginRatios:          PaddingTierRatios([]TierRatio{}),
+			PortfolioMarginRatios: PaddingTierRatios([]TierRatio{}),
+		}
+	}
+	ops := make([]CreateUserOperation, 2)
+	for i := 0; i < len(ops); i++ {
+		proof := make([][]byte, AccountTreeDepth)
+		for j := 0; j < len(proof); j++ {
+			proof[j] = []byte{byte(i), byte(j)}
+		}
+		ops[i] = CreateUserOperation{
+			BeforeAccountTreeRoot: []byte{1, byte(i)},
+			AfterAccountTreeRoot:  []byte{2, byte(i)},
+			Assets:                []AccountAsset{{Index: 1, Equity: 10, Debt: 1, Loan: 2, Margin: 3, PortfolioMargin: 4}},
+			AccountIndex:          uint32(i),
+			AccountIdHash:         []byte{3, byte(i)},
+			AccountProof:          proof,
+		}
+	}
+	return &BatchCreateUserWitness{
+		BatchCommitment:           []byte{4},
+		RoundId:                   1,
+		Timestamp:                 1700000000,
+		BeforeAccountTreeRoot:     []byte{5},
+		AfterAccountTreeRoot:      []byte{6},
+		BeforeCEXAssetsCommitment: []byte{7},
+		AfterCEXAssetsCommitment:  []byte{8},
+		BeforeCexAssets:           cexAssets,
+		CreateUserOps:             ops,
+	}
+}
+
+func TestWitnessCodec(t *testing.T) {
+	batchWitness := constructTestBatchCreateUserWitness()
+	data, err := EncodeBatchWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := DecodeWitnessHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != WitnessFormatVersion || header.OpType != OpTypeCreateUser ||
+		int(header.AssetCounts) != AssetCounts || header.CircuitParams != GetCircuitParams().Digest() {
+		t.Fatalf("unexpected witness header %+v", header)
+	}
+
+	decoded, err := decodeBatchCreateUserWitness(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reencoded, _ := EncodeBatchWitness(decoded); reencoded != data {
+		t.Fatal("decoded witness is different from the encoded one")
+	}
+	if decoded.CreateUserOps[1].AccountProof[3][1] != 3 || decoded.BeforeCexAssets[2].LoanRatios[0].PrecomputedValue.Int64() != 80 {
+		t.Fatal("unexpected decoded witness")
+	}
+	expanded, err := DecodeBatchWitness(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expanded.CreateUserOps[0].Assets) != AssetCounts || expanded.CreateUserOps[0].Assets[1].Equity != 10 {
+		t.Fatal("user assets should be expanded to AssetCounts")
+	}
+
+	// 操作类型与头部不一致
+	if _, err = DecodeBatchUpdateWitness(data); !errors.Is(err, ErrWitnessOpTypeMismatch) {
+		t.Fatalf("expect ErrWitnessOpTypeMismatch but got %v", err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(data)
+	// 不支持的格式版本
+	unsupported := append([]byte{}, raw...)
+	binary.BigEndian.PutUint16(unsupported[4:6], WitnessFormatVersion+1)
+	if _, err = DecodeBatchWitness(base64.StdEncoding.EncodeToString(unsupported)); !errors.Is(err, ErrUnsupportedWitnessVersion) {
+		t.Fatalf("expect ErrUnsupportedWitnessVersion but got %v", err)
+	}
+	// 被截断的数据体
+	if _, err = DecodeBatchWitness(base64.StdEncoding.EncodeToString(raw[:len(raw)-8])); !errors.Is(err, ErrInvalidWitnessEncoding) {
+		t.Fatalf("expect ErrInvalidWitnessEncoding but got %v", err)
+	}
+	w := &witnessWriter{}
+	w.writeBatchCreateUserWitness(batchWitness)
+	uncompressed := append(append([]byte{}, raw[:WitnessHeaderSize]...), w.buf.Bytes()[:w.buf.Len()-1]...)
+	uncompressed[7] = WitnessCompressionNone
+	if _, err = DecodeBatchWitness(base64.StdEncoding.EncodeToString(uncompressed)); !errors.Is(err, ErrMalformedWitness) {
+		t.Fatalf("expect ErrMalformedWitness but got %v", err)
+	}
+	if _, err = DecodeBatchWitness("not base64"); !errors.Is(err, ErrInvalidWitnessEncoding) {
+		t.Fatalf("expect ErrInvalidWitnessEncoding but got %v", err)
+	}
+
+	// 电路参数与生成见证数据时不一致
+	params := *DefaultCircuitParams()
+	params.AssetCounts = 600
+	if err = SetCircuitParams(&params); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCircuitParams(DefaultCircuitParams())
+	if _, err = DecodeBatchWitness(data); !errors.Is(err, ErrCircuitParamsMismatch) {
+		t.Fatalf("expect ErrCircuitParamsMismatch but got %v", err)
+	}
+}
+
+func TestMigrateLegacyWitnessData(t *testing.T) {
+	batchWitness := constructTestBatchCreateUserWitness()
+	batchWitness.RoundId = 0
+	batchWitness.Timestamp = 0
+
+	// 按之前版本的格式编码: 定长数组, gob, s2, base64
+	legacy := legacyBatchWitness{
+		BatchCommitment:           batchWitness.BatchCommitment,
+		BeforeAccountTreeRoot:     batchWitness.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      batchWitness.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: batchWitness.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  batchWitness.AfterCEXAssetsCommitment,
+	}
+	for _, asset := range batchWitness.BeforeCexAssets {
+		l := legacyCexAssetInfo{
+			TotalEquity: asset.TotalEquity,
+			TotalDebt:   asset.TotalDebt,
+			BasePrice:   asset.BasePrice,
+			Symbol:      asset.Symbol,
+			Index:       asset.Index,
+		}
+		copy(l.LoanRatios[:], asset.LoanRatios)
+		copy(l.MarginRatios[:], asset.MarginRatios)
+		copy(l.PortfolioMarginRatios[:], asset.PortfolioMarginRatios)
+		legacy.BeforeCexAssets = append(legacy.BeforeCexAssets, l)
+	}
+	for _, op := range batchWitness.CreateUserOps {
+		l := legacyCreateUserOperation{
+			BeforeAccountTreeRoot: op.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:  op.AfterAccountTreeRoot,
+			Assets:                op.Assets,
+			AccountIndex:          op.AccountIndex,
+			AccountIdHash:         op.AccountIdHash,
+		}
+		copy(l.AccountProof[:], op.AccountProof)
+		legacy.CreateUserOps = append(legacy.CreateUserOps, l)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	legacyData := base64.StdEncoding.EncodeToString(s2.Encode(nil, buf.Bytes()))
+
+	if _, err := DecodeBatchWitness(legacyData); !errors.Is(err, ErrLegacyWitnessFormat) {
+		t.Fatalf("expect ErrLegacyWitnessFormat but got %v", err)
+	}
+	data, err := MigrateLegacyWitnessData(OpTypeCreateUser, legacyData, 2, 1700000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 迁移后的见证数据使用给定的审计轮次并重新计算批次承诺
+	batchWitness.RoundId = 2
+	batchWitness.Timestamp = 1700000001
+	batchWitness.BatchCommitment = ComputeBatchCommitment(batchWitness.BeforeAccountTreeRoot, batchWitness.AfterAccountTreeRoot,
+		batchWitness.BeforeCEXAssetsCommitment, batchWitness.AfterCEXAssetsCommitment, 2, 1700000001)
+	expected, err := EncodeBatchWitness(batchWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != expected {
+		t.Fatal("migrated witness is different from the legacy one")
+	}
+
+	// 之前版本只有创建用户的批次
+	if _, err = MigrateLegacyWitnessData(OpTypeUpdateUser, legacyData, 2, 1700000001); !errors.Is(err, ErrMalformedWitness) {
+		t.Fatalf("expect ErrMalformedWitness but got %v", err)
+	}
+	// 不是之前版本格式的gob数据
+	buf.Reset()
+	if err = gob.NewEncoder(&buf).Encode(struct{ Height int64 }{1}); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := base64.StdEncoding.EncodeToString(s2.Encode(nil, buf.Bytes()))
+	if _, err = MigrateLegacyWitnessData(OpTypeCreateUser, corrupt, 2, 1700000001); !errors.Is(err, ErrMalformedWitness) {
+		t.Fatalf("expect ErrMalformedWitness but got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// 之前版本的见证数据为base64(s2(gob(BatchCreateUserWitness))), 没有头部
+// 分层抵押率和账户证明为定长数组, 长度为当时的TierCount和AccountTreeDepth常量
+const (
+	legacyTierCount        = 12
+	legacyAccountTreeDepth = 28
+)
+
+// legacyCexAssetInfo 之前版本的CEX资产信息
+type legacyCexAssetInfo struct {
+	TotalEquity               uint64
+	TotalDebt                 uint64
+	BasePrice                 uint64
+	Symbol                    string
+	Index                     uint32
+	LoanCollateral            uint64
+	MarginCollateral          uint64
+	PortfolioMarginCollateral uint64
+	LoanRatios                [legacyTierCount]TierRatio
+	MarginRatios              [legacyTierCount]TierRatio
+	PortfolioMarginRatios     [legacyTierCount]TierRatio
+}
+
+// legacyCreateUserOperation 之前版本的创建用户操作
+type legacyCreateUserOperation struct {
+	BeforeAccountTreeRoot []byte
+	AfterAccountTreeRoot  []byte
+	Assets                []AccountAsset
+	AccountIndex          uint32
+	AccountIdHash         []byte
+	AccountProof          [legacyAccountTreeDepth][]byte
+}
+
+// legacyBatchWitness 之前版本的批次见证数据, 只有创建用户的批次, 不包含审计轮次
+type legacyBatchWitness struct {
+	BatchCommitment           []byte
+	BeforeAccountTreeRoot     []byte
+	AfterAccountTreeRoot      []byte
+	BeforeCEXAssetsCommitment []byte
+	AfterCEXAssetsCommitment  []byte
+
+	BeforeCexAssets []legacyCexAssetInfo
+	CreateUserOps   []legacyCreateUserOperation
+}
+
+// MigrateLegacyWitnessData 将之前版本gob编码的见证数据转换为当前版本的格式
+// 之前版本的见证数据不包含审计轮次, 使用参数中的roundId和timestamp并重新计算批次承诺
+// 当前进程的电路参数必须与生成旧见证数据时的电路参数一致
+// 参数:
+//   - opType: 批次的操作类型, 之前版本只有创建用户的批次
+//   - data: 之前版本的见证数据
+//   - roundId: 审计轮次编号
+//   - timestamp: 审计快照时间戳
+//
+// 返回:
+//   - string: 当前版本的见证数据
+//   - error: 数据无法按之前版本的格式解码时返回ErrMalformedWitness
+func MigrateLegacyWitnessData(opType int64, data string, roundId uint64, timestamp uint64) (string, error) {
+	if opType != OpTypeCreateUser {
+		return "", fmt.Errorf("%w: legacy witness data can only be a create user batch, got op type %d", ErrMalformedWitness, opType)
+	}
+	legacy, err := decodeLegacyWitnessData(data)
+	if err != nil {
+		return "", err
+	}
+	batchWitness := legacy.convert()
+	batchWitness.RoundId = roundId
+	batchWitness.Timestamp = timestamp
+	batchWitness.BatchCommitment = ComputeBatchCommitment(batchWitness.BeforeAccountTreeRoot, batchWitness.AfterAccountTreeRoot,
+		batchWitness.BeforeCEXAssetsCommitment, batchWitness.AfterCEXAssetsCommitment, roundId, timestamp)
+	return EncodeBatchWitness(batchWitness)
+}
+
+// decodeLegacyWitnessData 解码之前版本的见证数据
+func decodeLegacyWitnessData(data string) (*legacyBatchWitness, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWitnessEncoding, err.Error())
+	}
+	uncompressedData, err := s2.Decode(nil, b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWitnessEncoding, err.Error())
+	}
+	var legacy legacyBatchWitness
+	if err = gob.NewDecoder(bytes.NewReader(uncompressedData)).Decode(&legacy); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedWitness, err.Error())
+	}
+	if len(legacy.CreateUserOps) == 0 {
+		return nil, fmt.Errorf("%w: legacy witness data has no create user operation", ErrMalformedWitness)
+	}
+	return &legacy, nil
+}
+
+// convert 将之前版本的批次见证数据转换为当前的类型
+func (l *legacyBatchWitness) convert() *BatchCreateUserWitness {
+	cexAssets := make([]CexAssetInfo, len(l.BeforeCexAssets))
+	for i := 0; i < len(l.BeforeCexAssets); i++ {
+		asset := &l.BeforeCexAssets[i]
+		cexAssets[i] = CexAssetInfo{
+			TotalEquity:               asset.TotalEquity,
+			TotalDebt:                 asset.TotalDebt,
+			BasePrice:                 asset.BasePrice,
+			Symbol:                    asset.Symbol,
+			Index:                     asset.Index,
+			LoanCollateral:            asset.LoanCollateral,
+			MarginCollateral:          asset.MarginCollateral,
+			PortfolioMarginCollateral: asset.PortfolioMarginCollateral,
+			LoanRatios:                append([]TierRatio{}, asset.LoanRatios[:]...),
+			MarginRatios:              append([]TierRatio{}, asset.MarginRatios[:]...),
+			PortfolioMarginRatios:     append([]TierRatio{}, asset.PortfolioMarginRatios[:]...),
+		}
+	}
+	v := &BatchCreateUserWitness{
+		BatchCommitment:           l.BatchCommitment,
+		BeforeAccountTreeRoot:     l.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      l.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: l.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  l.AfterCEXAssetsCommitment,
+		BeforeCexAssets:           cexAssets,
+		CreateUserOps:             make([]CreateUserOperation, len(l.CreateUserOps)),
+	}
+	for i, op := range l.CreateUserOps {
+		v.CreateUserOps[i] = CreateUserOperation{
+			BeforeAccountTreeRoot: op.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:  op.AfterAccountTreeRoot,
+			Assets:                op.Assets,
+			AccountIndex:          op.AccountIndex,
+			AccountIdHash:         op.AccountIdHash,
+			AccountProof:          append([][]byte{}, op.AccountProof[:]...),
+		}
+	}
+	return v
+}
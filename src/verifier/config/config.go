@@ -1,29 +1,32 @@
 package config
 
-import (
-	"math/big"
-
-	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
-)
+import "github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 
 // Config 验证器配置结构
 // 用于存储验证系统所需的全局配置信息
 type Config struct {
-	ProofTable       string               // 证明表名称
+	ProofTable       string               // 证明文件, ProofSource为csv, jsonl或bundle时使用
+	ProofSource      string               // 批次证明的来源(csv/jsonl/bundle/db), 为空时为csv
+	BundlePublicKey  string               // CEX公布的证明包签名公钥(hex编码), ProofSource为bundle时使用
+	MysqlDataSource  string               // 数据库连接, ProofSource为db时使用
+	DbSuffix         string               // 证明表后缀, ProofSource为db时使用, 与prover配置相同
 	ZkKeyName        []string             // 零知识证明密钥名称列表
+	UpdateZkKeyName  []string             // 更新用户电路的密钥名称列表, 与AssetsCountTiers一一对应
+	DeleteZkKeyName  []string             // 删除用户电路的密钥名称列表, 与AssetsCountTiers一一对应
 	AssetsCountTiers []int                // 资产数量层级配置
 	CexAssetsInfo    []utils.CexAssetInfo // CEX资产信息列表
-}
+	ProvingSystem    string               // 证明表未记录证明系统时使用的默认证明系统(groth16/plonk)
+	ForAggregation   bool                 // 证明表未记录hash-to-field模式时, 批次证明是否按可被递归聚合的方式生成
+	ForSolidity      bool                 // 证明表未记录hash-to-field模式时, 批次证明是否按可被solidity合约验证的方式生成
+	RoundKeyName     string               // 第1层轮次聚合电路的密钥名称, 之后的层在名称后加上层数
+	RoundProofFile   string               // 轮次聚合证明文件
+	RoundId          uint64               // 期望的审计轮次编号, 属于其他轮次的证明验证失败
+	Timestamp        uint64               // 期望的审计快照时间戳(unix秒)
+
+	// 电路参数文件, 为空时使用默认的电路参数, 必须与keygen和witness使用的相同
+	CircuitParamsFile string
 
-// UserConfig 用户配置结构
-// 用于存储单个用户的验证相关信息
-type UserConfig struct {
-	AccountIndex    uint32               // 账户索引
-	AccountIdHash   string               // 账户ID哈希值
-	TotalEquity     big.Int              // 总权益(精确计算)
-	TotalDebt       big.Int              // 总债务(精确计算)
-	TotalCollateral big.Int              // 总抵押品(精确计算)
-	Root            string               // Merkle树根哈希
-	Assets          []utils.AccountAsset // 用户资产列表
-	Proof           []string             // Merkle证明路径
+	// 增量审计时本轮的初始状态, 即上一轮审计的最终状态(hex编码), 为空时从空账户树和空CEX资产开始
+	BaseAccountTreeRoot     string
+	BaseCexAssetsCommitment string
 }
@@ -1,50 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"runtime"
-	"strconv"
-	"sync"
+	"path/filepath"
 
-	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
-	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/frontend"
-	"github.com/gocarina/gocsv"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/userverifier"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
 )
 
-// LoadVerifyingKey 加载验证密钥
-// 参数:
-//   - vkFileName: 验证密钥文件名
-//
-// 返回:
-//   - groth16.VerifyingKey: 验证密钥
-//   - error: 错误信息
-func LoadVerifyingKey(vkFileName string) (groth16.VerifyingKey, error) {
-	vkFile, err := os.ReadFile(vkFileName)
-	if err != nil {
-		return nil, err
-	}
-	buf := bytes.NewBuffer(vkFile)
-	vk := groth16.NewVerifyingKey(ecc.BN254)
-	_, err = vk.ReadFrom(buf)
-	if err != nil {
-		return nil, err
-	}
-	return vk, nil
-}
-
-// main 函数实现了两种验证模式:
+// main 函数实现了四种验证模式:
 // 1. 用户证明验证模式(-user): 验证单个用户的资产证明
 //   - 验证用户的Merkle树证明
 //   - 验证用户资产承诺
@@ -56,10 +28,19 @@ func LoadVerifyingKey(vkFileName string) (groth16.VerifyingKey, error) {
 //   - 验证账户树根链
 //   - 验证最终状态一致性
 //
+// 3. 轮次证明验证模式(-round): 验证聚合了一轮所有批次的单个聚合证明
+//
+// 4. 批量用户证明验证模式(-users): 在发布用户证明前并行验证所有用户证明
+//   - 用户证明来自userproof -export的导出目录, 目录下的.json文件, JSON lines文件或用户证明表
+//   - 导出目录的清单中每个分片的sha256哈希和用户证明数量必须与分片相同
+//   - 所有用户证明必须属于CEX公布的账户树根(-root)
+//   - 输出所有叶子节点哈希或Merkle证明验证失败的账户
+//   - 审计结束后公开置换种子(-shuffle_seed)时, 检查种子与清单中的承诺一致, 并重新计算导出的用户证明的账户索引置换
+//
 // 工作流程:
 // 用户模式:
 //  1. 加载用户配置(user_config.json)
-//  2. 验证Merkle树根的有效性
+//  2. 验证Merkle树根的有效性, 填写了账户ID(-account_id)时检查加盐的账户ID哈希
 //  3. 解码并验证证明路径
 //  4. 计算用户资产承诺(使用Poseidon哈希)
 //  5. 计算并验证账户叶子节点哈希
@@ -67,7 +48,7 @@ func LoadVerifyingKey(vkFileName string) (groth16.VerifyingKey, error) {
 //
 // 批量模式:
 //  1. 加载验证器配置(config.json)
-//  2. 读取并解析证明CSV文件
+//  2. 按ProofSource读取证明(csv文件, JSON lines文件, 签名的证明包或数据库中的证明表)
 //  3. 初始化验证状态:
 //     - 空账户树根
 //     - CEX资产初始状态
@@ -84,278 +65,234 @@ func LoadVerifyingKey(vkFileName string) (groth16.VerifyingKey, error) {
 // - 密码学验证: 使用零知识证明和Merkle树
 // - 状态完整性: 验证状态转换链
 // - 并发安全: 使用线程安全的数据结构
-// - 错误处理: 严格的错误检查和panic处理
+// - 错误处理: 验证失败时输出所有失败的批次和原因, 以状态码1退出; 无法完成验证(例如配置错误)时panic, 以状态码2退出
+// - 验证报告: 批量模式下通过-report输出JSON格式的验证报告, 无论验证是否通过
 func main() {
 	// 解析命令行参数
 	userFlag := flag.Bool("user", false, "flag which indicates user proof verification")
+	roundFlag := flag.Bool("round", false, "flag which indicates round aggregated proof verification")
+	circuitParamsFile := flag.String("circuit_params", "", "circuit params file used by user proof verification, default circuit params if empty")
+	accountId := flag.String("account_id", "", "hex encoded account id of the user, checks the salted AccountIdHash of user proof verification")
+	reportFile := flag.String("report", "", "write the json report of batch proof verification to file")
+	usersPath := flag.String("users", "", "verify all user proofs in the directory exported by userproof -export, the directory of user config files, the json lines file, or the userproof table if it is db")
+	rootFlag := flag.String("root", "", "the published account tree root (hex) used by -users, the root of the first user proof if empty")
+	workersFlag := flag.Int("workers", 0, "number of workers used by -users, number of cpus if 0")
+	shuffleSeedFile := flag.String("shuffle_seed", "", "the revealed account index shuffle seed file used by -users, checks it and the account indexes against the manifest of the export")
 	flag.Parse()
 
-	if *userFlag {
-		// 用户证明验证模式
-		// 1. 加载用户配置
-		userConfig := &config.UserConfig{}
-		content, err := ioutil.ReadFile("config/user_config.json")
+	if *usersPath != "" {
+		// 批量用户证明验证模式
+		err := utils.InitCircuitParams(*circuitParamsFile)
 		if err != nil {
 			panic(err.Error())
 		}
-		err = json.Unmarshal(content, userConfig)
+		root := *rootFlag
+		manifest, err := loadExportManifest(*usersPath, root)
+		if errors.Is(err, verifier.ErrCommitmentMismatch) || errors.Is(err, verifier.ErrMalformedInput) {
+			fmt.Println("manifest verify failed:", err.Error())
+			os.Exit(1)
+		}
 		if err != nil {
 			panic(err.Error())
 		}
-
-		// 2. 验证Merkle树根
-		root, err := hex.DecodeString(userConfig.Root)
-		if err != nil || len(root) != 32 {
-			panic("invalid account tree root")
+		if *shuffleSeedFile != "" && manifest == nil {
+			panic("-shuffle_seed needs a directory exported by userproof -export")
 		}
-
-		// 3. 解码证明路径
-		var proof [][]byte
-		for i := 0; i < len(userConfig.Proof); i++ {
-			p, err := base64.StdEncoding.DecodeString(userConfig.Proof[i])
-			if err != nil || len(p) != 32 {
-				panic("invalid proof")
+		var reader verifier.UserProofReader
+		if manifest != nil {
+			// 所有分片中的用户证明必须属于清单中的账户树根
+			root = manifest.Root
+			reader = verifier.NewUserProofManifestReader(*usersPath, manifest)
+		} else {
+			reader, err = openUserProofs(*usersPath)
+			if err != nil {
+				panic(err.Error())
 			}
-			proof = append(proof, p)
 		}
-
-		// 4. 计算用户资产承诺
-		hasher := poseidon.NewPoseidon()
-		assetCommitment := utils.ComputeUserAssetsCommitment(&hasher, userConfig.Assets)
-		hasher.Reset()
-
-		// 5. 计算账户叶子节点哈希
-		accountIdHash, err := hex.DecodeString(userConfig.AccountIdHash)
-		if err != nil || len(accountIdHash) != 32 {
-			panic("the AccountIdHash is invalid")
+		defer reader.Close()
+		result, err := verifier.VerifyUserProofs(reader, root, *workersFlag)
+		if err != nil {
+			panic(err.Error())
 		}
-		accountHash := poseidon.PoseidonBytes(accountIdHash,
-			userConfig.TotalEquity.Bytes(),
-			userConfig.TotalDebt.Bytes(),
-			userConfig.TotalCollateral.Bytes(),
-			assetCommitment)
-		fmt.Printf("merkle leave hash: %x\n", accountHash)
-
-		// 6. 验证Merkle证明
-		verifyFlag := utils.VerifyMerkleProof(root, userConfig.AccountIndex, proof, accountHash)
-		if verifyFlag {
-			fmt.Println("verify pass!!!")
-		} else {
-			fmt.Println("verify failed...")
+		fmt.Println("account tree root is", result.Root)
+		if !result.Passed() {
+			for _, failure := range result.Failures {
+				fmt.Println(failure.Error())
+			}
+			fmt.Println(len(result.Failures), "of", result.UserCount, "user proofs verify failed")
+			reader.Close()
+			os.Exit(1)
 		}
-	} else {
-		// 批量证明验证模式
-		// 1. 加载验证器配置
-		verifierConfig := &config.Config{}
-		content, err := ioutil.ReadFile("config/config.json")
+		fmt.Println(result.UserCount, "user proofs verify passed!!!")
+		if *shuffleSeedFile != "" {
+			shuffleSeed, err := utils.LoadShuffleSeed(*shuffleSeedFile)
+			if err != nil {
+				panic(err.Error())
+			}
+			shuffleReader := verifier.NewUserProofManifestReader(*usersPath, manifest)
+			err = verifier.VerifyShuffleSeed(manifest, shuffleReader, shuffleSeed)
+			shuffleReader.Close()
+			if errors.Is(err, verifier.ErrCommitmentMismatch) || errors.Is(err, verifier.ErrMalformedInput) {
+				fmt.Println("shuffle seed verify failed:", err.Error())
+				os.Exit(1)
+			}
+			if err != nil {
+				panic(err.Error())
+			}
+			fmt.Println("shuffle seed and account indexes verify passed!!!")
+		}
+		return
+	}
+
+	if *userFlag {
+		// 用户证明验证模式
+		err := utils.InitCircuitParams(*circuitParamsFile)
 		if err != nil {
 			panic(err.Error())
 		}
-		err = json.Unmarshal(content, verifierConfig)
+		userConfig := &userverifier.UserConfig{}
+		content, err := ioutil.ReadFile("config/user_config.json")
 		if err != nil {
 			panic(err.Error())
 		}
-
-		// 2. 读取证明文件
-		f, err := os.Open(verifierConfig.ProofTable)
+		err = json.Unmarshal(content, userConfig)
 		if err != nil {
 			panic(err.Error())
 		}
-		defer f.Close()
-
-		// 3. 解析证明数据
-		// index 4: proof_info, index 5: cex_asset_list_commitments
-		// index 6: account_tree_roots, index 7: batch_commitment
-		// index 8: batch_number
-		type Proof struct {
-			BatchNumber        int64    `csv:"batch_number"`
-			ZkProof            string   `csv:"proof_info"`
-			CexAssetCommitment []string `csv:"cex_asset_list_commitments"`
-			AccountTreeRoots   []string `csv:"account_tree_roots"`
-			BatchCommitment    string   `csv:"batch_commitment"`
-			AssetsCount        int      `csv:"assets_count"`
+		if *accountId != "" {
+			userConfig.AccountId = *accountId
+		}
+		accountHash, err := userverifier.VerifyUserProof(userConfig)
+		if accountHash != nil {
+			fmt.Printf("merkle leave hash: %x\n", accountHash)
 		}
-		tmpProofs := []*Proof{}
-
-		err = gocsv.UnmarshalFile(f, &tmpProofs)
 		if err != nil {
-			panic(err.Error())
+			fmt.Println("verify failed...", err.Error())
+			os.Exit(1)
 		}
+		fmt.Println("verify pass!!!")
+		return
+	}
 
-		proofs := make([]Proof, len(tmpProofs))
-		for i := 0; i < len(tmpProofs); i++ {
-			proofs[tmpProofs[i].BatchNumber] = *tmpProofs[i]
+	// 批量证明验证模式
+	verifierConfig := &config.Config{}
+	content, err := ioutil.ReadFile("config/config.json")
+	if err != nil {
+		panic(err.Error())
+	}
+	err = json.Unmarshal(content, verifierConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = verifier.InitCircuitParams(verifierConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	if *roundFlag {
+		result, err := verifier.VerifyRoundProof(verifierConfig)
+		if err != nil {
+			fmt.Println("round proof verify failed:", err.Error())
+			os.Exit(1)
 		}
+		fmt.Printf("account merkle tree root is %x\n", result.AccountTreeRoot)
+		fmt.Println("round proof verify passed!!!")
+		return
+	}
 
-		// 4. 初始化验证状态
-		prevCexAssetListCommitments := make([][]byte, 2)
-		prevAccountTreeRoots := make([][]byte, 2)
-		// depth-28 empty account tree root
-		emptyAccountTreeRoot, err := hex.DecodeString("08696bfcb563a2ee4dde9e1dbd34f68d3f4643df6e3709cdb1855c9f886240c7")
+	proofs, err := verifier.LoadProofs(verifierConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	result, err := verifier.VerifyBatchProofs(verifierConfig, proofs)
+	if err != nil {
+		panic(err.Error())
+	}
+	if *reportFile != "" {
+		err = verifier.NewReport(verifierConfig, result).WriteFile(*reportFile)
 		if err != nil {
-			fmt.Println("wrong empty empty account tree root")
-			return
-		}
-		prevAccountTreeRoots[1] = emptyAccountTreeRoot
-		// according to asset price info to compute
-		cexAssetsInfo := make([]utils.CexAssetInfo, len(verifierConfig.CexAssetsInfo))
-		for i := 0; i < len(verifierConfig.CexAssetsInfo); i++ {
-			cexAssetsInfo[verifierConfig.CexAssetsInfo[i].Index] = verifierConfig.CexAssetsInfo[i]
-			if verifierConfig.CexAssetsInfo[i].TotalEquity < verifierConfig.CexAssetsInfo[i].TotalDebt {
-				fmt.Printf("%s asset equity %d less then debt %d\n", verifierConfig.CexAssetsInfo[i].Symbol, verifierConfig.CexAssetsInfo[i].TotalEquity, verifierConfig.CexAssetsInfo[i].TotalDebt)
-				panic("invalid cex asset info")
-			}
+			panic(err.Error())
 		}
-		emptyCexAssetsInfo := make([]utils.CexAssetInfo, len(cexAssetsInfo))
-		copy(emptyCexAssetsInfo, cexAssetsInfo)
-		for i := 0; i < len(emptyCexAssetsInfo); i++ {
-			emptyCexAssetsInfo[i].TotalDebt = 0
-			emptyCexAssetsInfo[i].TotalEquity = 0
-			emptyCexAssetsInfo[i].LoanCollateral = 0
-			emptyCexAssetsInfo[i].MarginCollateral = 0
-			emptyCexAssetsInfo[i].PortfolioMarginCollateral = 0
+	}
+	if !result.Passed() {
+		for _, failure := range result.Failures {
+			fmt.Println(failure.Error())
 		}
-		emptyCexAssetListCommitment := utils.ComputeCexAssetsCommitment(emptyCexAssetsInfo)
-		expectFinalCexAssetsInfoComm := utils.ComputeCexAssetsCommitment(cexAssetsInfo)
-		prevCexAssetListCommitments[1] = emptyCexAssetListCommitment
-		var finalCexAssetsInfoComm []byte
-		var accountTreeRoot []byte
+		fmt.Println(len(result.Failures), "failures in", result.BatchCount, "batches:", summarize(result.Failures))
+		os.Exit(1)
+	}
+	fmt.Printf("account merkle tree root is %x\n", result.AccountTreeRoot)
+	fmt.Println("All proofs verify passed!!!")
+}
 
-		// 5. 并行验证证明
-		workersNum := 16
-		if runtime.NumCPU() > workersNum {
-			workersNum = runtime.NumCPU()
-		}
-		averageProofCount := (len(proofs) + workersNum - 1) / workersNum
+// loadExportManifest 读取并检查userproof -export导出目录中的清单, path不是导出目录时返回nil
+func loadExportManifest(path string, root string) (*model.ExportManifest, error) {
+	if path == "db" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(path, model.ExportManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return verifier.LoadExportManifest(path, root)
+}
 
-		type ProofMetaData struct {
-			accountTreeRoots        [][]byte
-			cexAssetListCommitments [][]byte
+// openUserProofs 打开批量验证的用户证明
+// path为db时读取config/config.json中MysqlDataSource的用户证明表, 为目录时读取目录下的所有.json文件, 否则为JSON lines文件
+func openUserProofs(path string) (verifier.UserProofReader, error) {
+	if path == "db" {
+		verifierConfig := &config.Config{}
+		content, err := ioutil.ReadFile("config/config.json")
+		if err != nil {
+			return nil, err
 		}
-		type SafeProofMap struct {
-			sync.Mutex
-			proofMap map[int]ProofMetaData
+		err = json.Unmarshal(content, verifierConfig)
+		if err != nil {
+			return nil, err
 		}
-		safeProofMap := &SafeProofMap{proofMap: make(map[int]ProofMetaData)}
-		var wg sync.WaitGroup
-		for i := 0; i < workersNum; i++ {
-			wg.Add(1)
-			go func(index int) {
-				defer wg.Done()
-				var vk groth16.VerifyingKey
-				currentAssetCountsTier := 0
-				startIndex := index * averageProofCount
-				endIndex := (index + 1) * averageProofCount
-				if endIndex > len(proofs) {
-					endIndex = len(proofs)
-				}
-				for j := startIndex; j < endIndex; j++ {
-					batchNumber := int(proofs[j].BatchNumber)
-					// first deserialize proof
-					proof := groth16.NewProof(ecc.BN254)
-					var bufRaw bytes.Buffer
-					proofRaw, err := base64.StdEncoding.DecodeString(proofs[j].ZkProof)
-					if err != nil {
-						fmt.Println("decode proof failed:", batchNumber)
-						panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
-					}
-					bufRaw.Write(proofRaw)
-					proof.ReadFrom(&bufRaw)
-					// deserialize cex asset list commitment and account tree root
-					cexAssetListCommitments := make([][]byte, 2)
-					accountTreeRoots := make([][]byte, 2)
-
-					for p := 0; p < len(proofs[j].CexAssetCommitment); p++ {
-						cexAssetListCommitments[p], err = base64.StdEncoding.DecodeString(proofs[j].CexAssetCommitment[p])
-						if err != nil {
-							fmt.Println("decode cex asset commitment failed")
-							panic(err.Error())
-						}
-					}
-					for p := 0; p < len(proofs[j].AccountTreeRoots); p++ {
-						accountTreeRoots[p], err = base64.StdEncoding.DecodeString(proofs[j].AccountTreeRoots[p])
-						if err != nil {
-							fmt.Println("decode account tree root failed")
-							panic(err.Error())
-						}
-					}
-					// verify the public input is correctly computed by cex asset list and account tree root
-					poseidonHasher := poseidon.NewPoseidon()
-					poseidonHasher.Write(accountTreeRoots[0])
-					poseidonHasher.Write(accountTreeRoots[1])
-					poseidonHasher.Write(cexAssetListCommitments[0])
-					poseidonHasher.Write(cexAssetListCommitments[1])
-					expectHash := poseidonHasher.Sum(nil)
-					actualHash, err := base64.StdEncoding.DecodeString(proofs[j].BatchCommitment)
-					if err != nil {
-						fmt.Println("decode batch commitment failed", batchNumber)
-						panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
-					}
-					if string(expectHash) != string(actualHash) {
-						fmt.Println("public input verify failed ", batchNumber)
-						fmt.Printf("%x:%x\n", expectHash, actualHash)
-						panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
-					}
-					safeProofMap.Lock()
-					safeProofMap.proofMap[int(batchNumber)] = ProofMetaData{accountTreeRoots: accountTreeRoots, cexAssetListCommitments: cexAssetListCommitments}
-					safeProofMap.Unlock()
-					verifyWitness := circuit.NewVerifyBatchCreateUserCircuit(actualHash)
-					vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
-					if err != nil {
-						panic(err.Error())
-					}
-					if proofs[j].AssetsCount != currentAssetCountsTier {
-						index := -1
-						for p := 0; p < len(verifierConfig.AssetsCountTiers); p++ {
-							if verifierConfig.AssetsCountTiers[p] == proofs[j].AssetsCount {
-								index = p
-								break
-							}
-						}
-						if index == -1 {
-							panic("invalid asset counts tier")
-						}
-						vk, err = LoadVerifyingKey(verifierConfig.ZkKeyName[index] + ".vk")
-						if err != nil {
-							panic(err.Error())
-						}
-						currentAssetCountsTier = proofs[j].AssetsCount
-					}
-					err = groth16.Verify(proof, vk, vWitness)
-					if err != nil {
-						fmt.Println("proof verify failed:", batchNumber, err.Error())
-						return
-					} else {
-						fmt.Println("proof verify success", batchNumber)
-					}
-				}
-
-			}(i)
+		db, err := utils.OpenDatabase(verifierConfig.MysqlDataSource)
+		if err != nil {
+			return nil, err
 		}
+		return verifier.NewUserProofDbReader(model.NewUserProofModel(db, verifierConfig.DbSuffix))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return verifier.NewUserProofDirReader(path)
+	}
+	return verifier.NewUserProofJsonlReader(path)
+}
 
-		wg.Wait()
-		for batchNumber := 0; batchNumber < len(proofs); batchNumber++ {
-			proofData, ok := safeProofMap.proofMap[batchNumber]
-			if !ok {
-				panic("proof data not found: " + strconv.Itoa(batchNumber))
+// summarize 按失败原因统计失败次数
+func summarize(failures []*verifier.BatchError) string {
+	kinds := []error{verifier.ErrProofInvalid, verifier.ErrCommitmentMismatch, verifier.ErrMissingBatch, verifier.ErrDuplicateBatch,
+		verifier.ErrChainBreak, verifier.ErrRoundMismatch, verifier.ErrMalformedInput}
+	counts := make([]int, len(kinds))
+	for _, failure := range failures {
+		for i, kind := range kinds {
+			if errors.Is(failure, kind) {
+				counts[i]++
+				break
 			}
-			if string(proofData.accountTreeRoots[0]) != string(prevAccountTreeRoots[1]) {
-				panic("account tree root not match: " + strconv.Itoa(batchNumber))
-			}
-			if string(proofData.cexAssetListCommitments[0]) != string(prevCexAssetListCommitments[1]) {
-				panic("cex asset list commitment not match: " + strconv.Itoa(batchNumber))
-			}
-			prevAccountTreeRoots = proofData.accountTreeRoots
-			prevCexAssetListCommitments = proofData.cexAssetListCommitments
-			accountTreeRoot = proofData.accountTreeRoots[1]
-			finalCexAssetsInfoComm = proofData.cexAssetListCommitments[1]
 		}
-
-		// 6. 验证最终状态
-		if string(finalCexAssetsInfoComm) != string(expectFinalCexAssetsInfoComm) {
-			panic("Final Cex Assets Info Not Match")
+	}
+	summary := ""
+	for i, kind := range kinds {
+		if counts[i] > 0 {
+			if summary != "" {
+				summary += ", "
+			}
+			summary += fmt.Sprintf("%s %d", kind.Error(), counts[i])
 		}
-		fmt.Printf("account merkle tree root is %x\n", accountTreeRoot)
-		fmt.Println("All proofs verify passed!!!")
 	}
+	return summary
 }
@@ -0,0 +1,116 @@
+// Package userverifier 验证单个用户的包含证明
+// 只依赖utils和gnark-crypto, 命令行验证器和WebAssembly验证器共用, 不引入数据库和证明相关的依赖
+package userverifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// 用户证明验证失败的原因, 与verifier包中的同名错误相同
+var (
+	// ErrCommitmentMismatch AccountIdHash不是由填写的AccountId和Salt计算得到
+	ErrCommitmentMismatch = errors.New("commitment mismatch")
+	// ErrProofInvalid Merkle证明没有通过验证
+	ErrProofInvalid = errors.New("proof invalid")
+	// ErrMalformedInput 用户证明无法解码或资产不符合电路参数
+	ErrMalformedInput = errors.New("malformed input")
+)
+
+// UserConfig 用户配置结构
+// 用于存储单个用户的验证相关信息
+type UserConfig struct {
+	AccountIndex    uint32               // 账户索引
+	AccountIdHash   string               // 账户ID哈希值, 有盐值时为Poseidon(AccountId, Salt)
+	Salt            string               // 本轮审计的盐值(hex编码), 可选
+	AccountId       string               // 用户自己的账户ID(hex编码), 可选, 填写后检查AccountIdHash由AccountId和Salt计算得到
+	TotalEquity     big.Int              // 总权益(精确计算)
+	TotalDebt       big.Int              // 总债务(精确计算)
+	TotalCollateral big.Int              // 总抵押品(精确计算)
+	Root            string               // Merkle树根哈希
+	Assets          []utils.AccountAsset // 用户资产列表
+	Proof           []string             // Merkle证明路径
+}
+
+// VerifyUserProof 验证单个用户的Merkle证明
+// 根据用户的资产计算账户叶子节点哈希, 再验证叶子节点在账户树中的Merkle证明
+// 调用前需要通过utils.InitCircuitParams加载电路参数
+// 参数:
+//   - userConfig: 用户证明
+//
+// 返回:
+//   - []byte: 账户叶子节点哈希, 用户证明无法解码时为nil
+//   - error: 用户证明无法解码或资产不符合电路参数时为ErrMalformedInput, AccountIdHash与填写的AccountId和Salt不一致时为ErrCommitmentMismatch,
+//     Merkle证明验证失败时为ErrProofInvalid
+func VerifyUserProof(userConfig *UserConfig) ([]byte, error) {
+	root, err := hex.DecodeString(userConfig.Root)
+	if err != nil || len(root) != 32 {
+		return nil, fmt.Errorf("%w: invalid account tree root", ErrMalformedInput)
+	}
+	var proof [][]byte
+	for i := 0; i < len(userConfig.Proof); i++ {
+		p, err := base64.StdEncoding.DecodeString(userConfig.Proof[i])
+		if err != nil || len(p) != 32 {
+			return nil, fmt.Errorf("%w: invalid proof", ErrMalformedInput)
+		}
+		proof = append(proof, p)
+	}
+	accountIdHash, err := hex.DecodeString(userConfig.AccountIdHash)
+	if err != nil || len(accountIdHash) != 32 {
+		return nil, fmt.Errorf("%w: the AccountIdHash is invalid", ErrMalformedInput)
+	}
+	// 用户填写了自己的账户ID时, 检查叶子节点中的账户ID哈希属于该用户
+	if userConfig.AccountId != "" {
+		accountId, err := utils.ParseAccountId(userConfig.AccountId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedInput, err.Error())
+		}
+		salt, err := hex.DecodeString(userConfig.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the Salt is invalid", ErrMalformedInput)
+		}
+		if !bytes.Equal(utils.ComputeAccountIdHash(accountId, salt), accountIdHash) {
+			return nil, fmt.Errorf("%w: the AccountIdHash is not derived from the AccountId and Salt", ErrCommitmentMismatch)
+		}
+	}
+
+	if err := checkUserAssets(userConfig.Assets); err != nil {
+		return nil, err
+	}
+
+	hasher := poseidon.NewPoseidon()
+	assetCommitment := utils.ComputeUserAssetsCommitment(&hasher, userConfig.Assets)
+	accountHash := poseidon.PoseidonBytes(accountIdHash,
+		userConfig.TotalEquity.Bytes(),
+		userConfig.TotalDebt.Bytes(),
+		userConfig.TotalCollateral.Bytes(),
+		assetCommitment)
+	if !utils.VerifyMerkleProof(root, userConfig.AccountIndex, proof, accountHash) {
+		return accountHash, fmt.Errorf("%w: merkle proof verify failed", ErrProofInvalid)
+	}
+	return accountHash, nil
+}
+
+// checkUserAssets 检查用户资产可以按电路参数填充并计算资产承诺
+// 资产数量不能超过最大的资产档位, 资产索引必须小于AssetCounts并严格递增
+func checkUserAssets(assets []utils.AccountAsset) error {
+	if len(utils.AssetCountsTiers) == 0 || len(assets) > utils.AssetCountsTiers[len(utils.AssetCountsTiers)-1] {
+		return fmt.Errorf("%w: the user has %d assets which exceeds the largest assets count tier", ErrMalformedInput, len(assets))
+	}
+	for i, asset := range assets {
+		if int(asset.Index) >= utils.AssetCounts {
+			return fmt.Errorf("%w: the asset index %d is not less than AssetCounts %d", ErrMalformedInput, asset.Index, utils.AssetCounts)
+		}
+		if i > 0 && asset.Index <= assets[i-1].Index {
+			return fmt.Errorf("%w: the asset indexes are not strictly increasing", ErrMalformedInput)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+//go:build !js
+
+package userverifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+// TestVerifyUserProofSalt 测试用户填写账户ID后检查加盐的账户ID哈希
+func TestVerifyUserProofSalt(t *testing.T) {
+	if err := utils.InitCircuitParams(""); err != nil {
+		t.Fatal(err)
+	}
+	accountTree, err := utils.NewAccountTree(utils.TreeDBDriverMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountIdHex := strings.Repeat("0a", 32)
+	accountId, err := utils.ParseAccountId(accountIdHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := utils.AccountInfo{
+		AccountIndex:    3,
+		AccountId:       accountId,
+		Salt:            utils.ComputeAccountSalt(bytes.Repeat([]byte{1}, utils.AccountSaltKeySize), 5, accountId),
+		TotalEquity:     big.NewInt(100),
+		TotalDebt:       big.NewInt(0),
+		TotalCollateral: big.NewInt(0),
+		Assets:          []utils.AccountAsset{{Index: 0, Equity: 100}},
+	}
+	hasher := poseidon.NewPoseidon()
+	leaf := utils.AccountInfoToHash(&account, &hasher)
+	if err = accountTree.Set(uint64(account.AccountIndex), leaf); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := accountTree.GetProof(uint64(account.AccountIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userConfig := &UserConfig{
+		AccountIndex:  account.AccountIndex,
+		AccountIdHash: hex.EncodeToString(utils.ComputeAccountIdHash(account.AccountId, account.Salt)),
+		Salt:          hex.EncodeToString(account.Salt),
+		TotalEquity:   *account.TotalEquity,
+		Root:          hex.EncodeToString(accountTree.Root()),
+		Assets:        account.Assets,
+	}
+	for _, p := range proof {
+		userConfig.Proof = append(userConfig.Proof, base64.StdEncoding.EncodeToString(p))
+	}
+
+	// 不填写账户ID时只验证Merkle证明
+	leafHash, err := VerifyUserProof(userConfig)
+	if err != nil || !bytes.Equal(leafHash, leaf) {
+		t.Fatalf("verify salted user proof failed: %v", err)
+	}
+	userConfig.AccountId = accountIdHex
+	if _, err = VerifyUserProof(userConfig); err != nil {
+		t.Fatalf("verify salted user proof with account id failed: %v", err)
+	}
+	userConfig.AccountId = strings.Repeat("0b", 32)
+	if _, err = VerifyUserProof(userConfig); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Fatalf("expect %v but got %v", ErrCommitmentMismatch, err)
+	}
+	userConfig.AccountId = accountIdHex
+	userConfig.Salt = ""
+	if _, err = VerifyUserProof(userConfig); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Fatalf("expect %v without salt but got %v", ErrCommitmentMismatch, err)
+	}
+}
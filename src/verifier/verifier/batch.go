@@ -0,0 +1,365 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/gocarina/gocsv"
+)
+
+// BatchProof 一个批次的证明, 对应dbtool导出的证明表csv文件的一行
+// proving_system为空时(旧版本导出的证明表)使用配置中的证明系统
+// hash_to_field为空时(旧版本导出的证明表)使用配置中ForAggregation和ForSolidity对应的hash-to-field模式
+// op_type为空时(旧版本导出的证明表)为创建用户批次
+// round_id和timestamp必须与配置中的审计轮次一致
+type BatchProof struct {
+	BatchNumber        int64    `csv:"batch_number"`
+	ZkProof            string   `csv:"proof_info"`
+	CexAssetCommitment []string `csv:"cex_asset_list_commitments"`
+	AccountTreeRoots   []string `csv:"account_tree_roots"`
+	BatchCommitment    string   `csv:"batch_commitment"`
+	AssetsCount        int      `csv:"assets_count"`
+	ProvingSystem      string   `csv:"proving_system"`
+	HashToField        string   `csv:"hash_to_field"`
+	OpType             int64    `csv:"op_type"`
+	RoundId            uint64   `csv:"round_id"`
+	Timestamp          uint64   `csv:"timestamp"`
+}
+
+// LoadBatchProofs 读取dbtool导出的证明表csv文件
+func LoadBatchProofs(fileName string) ([]*BatchProof, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	proofs := []*BatchProof{}
+	err = gocsv.UnmarshalFile(f, &proofs)
+	if err != nil {
+		return nil, err
+	}
+	return proofs, nil
+}
+
+// batchState 一个批次证明的初始和最终状态, 无法解码时为nil
+type batchState struct {
+	accountTreeRoots        [][]byte
+	cexAssetListCommitments [][]byte
+}
+
+// verifyingKeyId 验证密钥由证明系统, 操作类型和资产数量层级决定
+type verifyingKeyId struct {
+	provingSystem string
+	opType        int64
+	assetsCount   int
+}
+
+// VerifyBatchProofs 验证一轮审计的所有批次证明
+// 每个批次并行验证证明和批次承诺, 再按批次号检查批次之间的状态衔接, 以及初始和最终状态与配置一致
+// 批次验证失败不会中止验证, 所有的失败都记录在BatchResult.Failures中
+// 参数:
+//   - verifierConfig: 验证器配置, 调用前需要通过InitCircuitParams加载电路参数
+//   - proofs: 所有批次的证明
+//
+// 返回:
+//   - *BatchResult: 验证结果, 只有Failures为空时验证通过
+//   - error: 配置无效或验证密钥无法加载时返回错误, 此时没有验证结果
+func VerifyBatchProofs(verifierConfig *config.Config, proofs []*BatchProof) (*BatchResult, error) {
+	defaultProvingSystem, err := utils.ParseProvingSystem(verifierConfig.ProvingSystem)
+	if err != nil {
+		return nil, err
+	}
+	emptyCexAssetListCommitment, expectFinalCexAssetsInfoComm, err := ComputeCexAssetsCommitments(verifierConfig)
+	if err != nil {
+		return nil, err
+	}
+	startAccountTreeRoot, startCexAssetsCommitment, err := GetStartingState(verifierConfig, emptyCexAssetListCommitment)
+	if err != nil {
+		return nil, err
+	}
+	if verifierConfig.ForAggregation && verifierConfig.ForSolidity {
+		return nil, errors.New("ForAggregation and ForSolidity can not be enabled at the same time")
+	}
+	defaultHashToField := utils.HashToFieldMode(verifierConfig.ForAggregation, verifierConfig.ForSolidity)
+	// 一轮审计的所有批次必须使用同一个证明系统和hash-to-field模式
+	for i := 1; i < len(proofs); i++ {
+		first, provingSystem := proofProvingSystem(defaultProvingSystem, proofs[0]), proofProvingSystem(defaultProvingSystem, proofs[i])
+		if provingSystem != first {
+			return nil, fmt.Errorf("%w: the round mixes proving systems %s and %s", ErrMalformedInput, first, provingSystem)
+		}
+		first, hashToField := proofHashToField(defaultHashToField, proofs[0]), proofHashToField(defaultHashToField, proofs[i])
+		if hashToField != first {
+			return nil, fmt.Errorf("%w: the round mixes hash-to-field modes %s and %s", ErrMalformedInput, first, hashToField)
+		}
+	}
+	sorted := make([]*BatchProof, len(proofs))
+	copy(sorted, proofs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].BatchNumber < sorted[j].BatchNumber })
+
+	// 并行验证证明, 每个线程验证连续的批次, 资产数量层级相同时复用验证密钥
+	workersNum := 16
+	if runtime.NumCPU() > workersNum {
+		workersNum = runtime.NumCPU()
+	}
+	averageProofCount := (len(sorted) + workersNum - 1) / workersNum
+	states := make([]batchState, len(sorted))
+	failures := make([]*BatchError, len(sorted))
+	var fatalErr error
+	var fatalLock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		startIndex := i * averageProofCount
+		endIndex := (i + 1) * averageProofCount
+		if endIndex > len(sorted) {
+			endIndex = len(sorted)
+		}
+		if startIndex >= endIndex {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vks := make(map[verifyingKeyId]utils.SnarkVerifyingKey)
+			for j := startIndex; j < endIndex; j++ {
+				var err error
+				states[j], failures[j], err = verifyBatchProof(verifierConfig, defaultProvingSystem, defaultHashToField, sorted[j], vks)
+				if err != nil {
+					fatalLock.Lock()
+					if fatalErr == nil {
+						fatalErr = err
+					}
+					fatalLock.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if fatalErr != nil {
+		return nil, fatalErr
+	}
+
+	// 按批次号检查状态衔接, 批次号必须从0开始连续
+	result := &BatchResult{BatchCount: len(sorted), HashToField: defaultHashToField, Tiers: countTiers(verifierConfig, defaultProvingSystem, sorted)}
+	if len(sorted) > 0 {
+		result.HashToField = proofHashToField(defaultHashToField, sorted[0])
+	}
+	prevAccountTreeRoot, prevCexAssetsCommitment := startAccountTreeRoot, startCexAssetsCommitment
+	expectBatchNumber := int64(0)
+	for j, proof := range sorted {
+		if proof.BatchNumber > expectBatchNumber {
+			result.Failures = append(result.Failures, &BatchError{expectBatchNumber, ErrMissingBatch,
+				fmt.Sprintf("batches %d to %d are missing", expectBatchNumber, proof.BatchNumber-1)})
+			prevAccountTreeRoot, prevCexAssetsCommitment = nil, nil
+		}
+		if failures[j] != nil {
+			result.Failures = append(result.Failures, failures[j])
+		}
+		if proof.BatchNumber < expectBatchNumber {
+			result.Failures = append(result.Failures, &BatchError{proof.BatchNumber, ErrDuplicateBatch, "there are more than one proof of the batch"})
+			continue
+		}
+		expectBatchNumber = proof.BatchNumber + 1
+		if states[j].accountTreeRoots == nil {
+			// 无法解码的批次不能检查与下一个批次的衔接
+			prevAccountTreeRoot, prevCexAssetsCommitment = nil, nil
+			continue
+		}
+		if prevAccountTreeRoot != nil && !bytes.Equal(states[j].accountTreeRoots[0], prevAccountTreeRoot) {
+			result.Failures = append(result.Failures, &BatchError{proof.BatchNumber, ErrChainBreak, "account tree root not match the previous batch"})
+		}
+		if prevCexAssetsCommitment != nil && !bytes.Equal(states[j].cexAssetListCommitments[0], prevCexAssetsCommitment) {
+			result.Failures = append(result.Failures, &BatchError{proof.BatchNumber, ErrChainBreak, "cex asset list commitment not match the previous batch"})
+		}
+		prevAccountTreeRoot = states[j].accountTreeRoots[1]
+		prevCexAssetsCommitment = states[j].cexAssetListCommitments[1]
+		result.AccountTreeRoot = prevAccountTreeRoot
+		result.CexAssetsCommitment = prevCexAssetsCommitment
+	}
+
+	// 验证最终状态
+	if len(sorted) == 0 {
+		result.Failures = append(result.Failures, &BatchError{0, ErrMissingBatch, "there is no batch proof"})
+	} else if !bytes.Equal(result.CexAssetsCommitment, expectFinalCexAssetsInfoComm) {
+		result.Failures = append(result.Failures, &BatchError{-1, ErrCommitmentMismatch, "final cex assets info not match the config"})
+	}
+	return result, nil
+}
+
+// countTiers 统计每种验证密钥验证的批次数量
+func countTiers(verifierConfig *config.Config, defaultProvingSystem string, proofs []*BatchProof) []TierResult {
+	counts := make(map[verifyingKeyId]int)
+	for _, proof := range proofs {
+		provingSystem := proofProvingSystem(defaultProvingSystem, proof)
+		counts[verifyingKeyId{provingSystem: provingSystem, opType: proof.OpType, assetsCount: proof.AssetsCount}]++
+	}
+	tiers := make([]TierResult, 0, len(counts))
+	for id, count := range counts {
+		vkFileName, _ := verifyingKeyFile(verifierConfig, id.provingSystem, id.opType, id.assetsCount)
+		tiers = append(tiers, TierResult{
+			ProvingSystem: id.provingSystem,
+			OpType:        id.opType,
+			AssetsCount:   id.assetsCount,
+			VerifyingKey:  vkFileName,
+			BatchCount:    count,
+		})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		if tiers[i].ProvingSystem != tiers[j].ProvingSystem {
+			return tiers[i].ProvingSystem < tiers[j].ProvingSystem
+		}
+		if tiers[i].OpType != tiers[j].OpType {
+			return tiers[i].OpType < tiers[j].OpType
+		}
+		return tiers[i].AssetsCount < tiers[j].AssetsCount
+	})
+	return tiers
+}
+
+// verifyBatchProof 验证一个批次的证明和批次承诺
+// 返回:
+//   - batchState: 批次的初始和最终状态, 无法解码时为空
+//   - *BatchError: 批次验证失败的原因, 通过时为nil
+//   - error: 验证密钥无法加载时返回错误
+func verifyBatchProof(verifierConfig *config.Config, defaultProvingSystem string, defaultHashToField string, proof *BatchProof,
+	vks map[verifyingKeyId]utils.SnarkVerifyingKey) (batchState, *BatchError, error) {
+	var state batchState
+	fail := func(kind error, format string, a ...interface{}) (batchState, *BatchError, error) {
+		return state, &BatchError{BatchNumber: proof.BatchNumber, Kind: kind, Detail: fmt.Sprintf(format, a...)}, nil
+	}
+	provingSystem := proofProvingSystem(defaultProvingSystem, proof)
+	if _, err := utils.ParseProvingSystem(provingSystem); err != nil {
+		return fail(ErrMalformedInput, "invalid proving system %s", provingSystem)
+	}
+	hashToField := proofHashToField(defaultHashToField, proof)
+	if _, err := utils.ParseHashToField(hashToField); err != nil {
+		return fail(ErrMalformedInput, "invalid hash-to-field mode %s", hashToField)
+	}
+	if hashToField == utils.HashToFieldAggregation && provingSystem != utils.ProvingSystemGroth16 {
+		return fail(ErrMalformedInput, "only groth16 proofs can be aggregated")
+	}
+
+	// deserialize cex asset list commitment and account tree root
+	if len(proof.CexAssetCommitment) != 2 || len(proof.AccountTreeRoots) != 2 {
+		return fail(ErrMalformedInput, "there should be 2 cex asset list commitments and 2 account tree roots")
+	}
+	cexAssetListCommitments := make([][]byte, 2)
+	accountTreeRoots := make([][]byte, 2)
+	var err error
+	for p := 0; p < 2; p++ {
+		cexAssetListCommitments[p], err = base64.StdEncoding.DecodeString(proof.CexAssetCommitment[p])
+		if err != nil {
+			return fail(ErrMalformedInput, "decode cex asset commitment failed: %s", err.Error())
+		}
+		accountTreeRoots[p], err = base64.StdEncoding.DecodeString(proof.AccountTreeRoots[p])
+		if err != nil {
+			return fail(ErrMalformedInput, "decode account tree root failed: %s", err.Error())
+		}
+	}
+	state = batchState{accountTreeRoots: accountTreeRoots, cexAssetListCommitments: cexAssetListCommitments}
+
+	// the proof must belong to the expected round
+	if proof.RoundId != verifierConfig.RoundId || proof.Timestamp != verifierConfig.Timestamp {
+		return fail(ErrRoundMismatch, "the proof belongs to round %d at %d", proof.RoundId, proof.Timestamp)
+	}
+	// verify the public input is correctly computed by cex asset list, account tree root and round
+	expectHash := utils.ComputeBatchCommitment(accountTreeRoots[0], accountTreeRoots[1],
+		cexAssetListCommitments[0], cexAssetListCommitments[1],
+		proof.RoundId, proof.Timestamp)
+	actualHash, err := base64.StdEncoding.DecodeString(proof.BatchCommitment)
+	if err != nil {
+		return fail(ErrMalformedInput, "decode batch commitment failed: %s", err.Error())
+	}
+	if !bytes.Equal(expectHash, actualHash) {
+		return fail(ErrCommitmentMismatch, "expect batch commitment %x but got %x", expectHash, actualHash)
+	}
+
+	// deserialize proof
+	proofRaw, err := base64.StdEncoding.DecodeString(proof.ZkProof)
+	if err != nil {
+		return fail(ErrProofInvalid, "decode proof failed: %s", err.Error())
+	}
+	snarkProof := utils.NewSnarkProof(provingSystem)
+	_, err = snarkProof.ReadFrom(bytes.NewBuffer(proofRaw))
+	if err != nil {
+		return fail(ErrProofInvalid, "deserialize proof failed: %s", err.Error())
+	}
+
+	var verifyWitness frontend.Circuit
+	switch proof.OpType {
+	case utils.OpTypeUpdateUser:
+		verifyWitness = circuit.NewVerifyBatchUpdateUserCircuit(actualHash, proof.RoundId, proof.Timestamp)
+	case utils.OpTypeDeleteUser:
+		verifyWitness = circuit.NewVerifyBatchDeleteUserCircuit(actualHash, proof.RoundId, proof.Timestamp)
+	default:
+		verifyWitness = circuit.NewVerifyBatchCreateUserCircuit(actualHash, proof.RoundId, proof.Timestamp)
+	}
+	vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fail(ErrMalformedInput, "create public witness failed: %s", err.Error())
+	}
+	keyId := verifyingKeyId{provingSystem: provingSystem, opType: proof.OpType, assetsCount: proof.AssetsCount}
+	vk, ok := vks[keyId]
+	if !ok {
+		vkFileName, ok := verifyingKeyFile(verifierConfig, provingSystem, proof.OpType, proof.AssetsCount)
+		if !ok {
+			return fail(ErrProofInvalid, "there is no verifying key of op type %d and assets count tier %d", proof.OpType, proof.AssetsCount)
+		}
+		vk, err = LoadVerifyingKey(provingSystem, vkFileName)
+		if err != nil {
+			return state, nil, err
+		}
+		vks[keyId] = vk
+	}
+	verifierOpts := utils.HashToFieldVerifierOptions(hashToField, provingSystem)
+	err = utils.SnarkVerify(provingSystem, snarkProof, vk, vWitness, verifierOpts...)
+	if err != nil {
+		return fail(ErrProofInvalid, "%s", err.Error())
+	}
+	return state, nil, nil
+}
+
+// proofProvingSystem 返回批次证明使用的证明系统, 证明表未记录时为默认的证明系统
+func proofProvingSystem(defaultProvingSystem string, proof *BatchProof) string {
+	if proof.ProvingSystem != "" {
+		return proof.ProvingSystem
+	}
+	return defaultProvingSystem
+}
+
+// proofHashToField 返回批次证明使用的hash-to-field模式, 证明表未记录时为配置对应的模式
+func proofHashToField(defaultHashToField string, proof *BatchProof) string {
+	if proof.HashToField != "" {
+		return proof.HashToField
+	}
+	return defaultHashToField
+}
+
+// verifyingKeyFile 获取证明系统, 操作类型和资产数量层级对应的验证密钥文件名, 配置中没有对应的密钥时返回false
+// 密钥名称按keygen的规则加上证明系统的后缀, 见utils.ZkKeyName
+func verifyingKeyFile(verifierConfig *config.Config, provingSystem string, opType int64, assetsCount int) (string, bool) {
+	zkKeyName := verifierConfig.ZkKeyName
+	switch opType {
+	case utils.OpTypeUpdateUser:
+		zkKeyName = verifierConfig.UpdateZkKeyName
+	case utils.OpTypeDeleteUser:
+		zkKeyName = verifierConfig.DeleteZkKeyName
+	}
+	for p := 0; p < len(verifierConfig.AssetsCountTiers) && p < len(zkKeyName); p++ {
+		if verifierConfig.AssetsCountTiers[p] == assetsCount {
+			return utils.ZkKeyName(zkKeyName[p], provingSystem) + ".vk", true
+		}
+	}
+	return "", false
+}
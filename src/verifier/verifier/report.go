@@ -0,0 +1,126 @@
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+)
+
+// Report 批次证明验证报告, 用于存档每轮审计的验证结果
+// 所有的哈希, 账户树根和承诺都是hex编码
+type Report struct {
+	Passed                  bool   // 所有批次是否通过验证
+	VerifiedAt              string // 验证时间(RFC3339)
+	RoundId                 uint64 // 审计轮次编号
+	Timestamp               uint64 // 审计快照的时间戳(unix秒)
+	ProofSource             string // 批次证明的来源
+	ProvingSystem           string // 默认的证明系统
+	HashToField             string // 批次证明的hash-to-field模式, 证明表未记录时由配置的ForAggregation和ForSolidity决定
+	BaseAccountTreeRoot     string // 增量审计的初始账户树根, 全量审计时为空
+	BaseCexAssetsCommitment string // 增量审计的初始CEX资产承诺, 全量审计时为空
+	BatchCount              int    // 批次数量
+	Tiers                   []*TierReport
+	AccountTreeRoot         string // 最后一个批次之后的账户树根
+	CexAssetsCommitment     string // 最后一个批次之后的CEX资产承诺
+	Assets                  []*AssetReport
+	Failures                []*FailureReport
+}
+
+// TierReport 每种验证密钥验证的批次数量
+type TierReport struct {
+	ProvingSystem      string
+	OpType             int64
+	AssetsCount        int
+	VerifyingKey       string // 验证密钥文件名
+	VerifyingKeySha256 string // 验证密钥文件的sha256哈希, 文件不存在时为空
+	BatchCount         int
+}
+
+// AssetReport 配置中公布的CEX资产总量, 最终CEX资产承诺与之一致时才有效
+type AssetReport struct {
+	Index                     uint32
+	Symbol                    string
+	BasePrice                 uint64
+	TotalEquity               uint64
+	TotalDebt                 uint64
+	LoanCollateral            uint64
+	MarginCollateral          uint64
+	PortfolioMarginCollateral uint64
+}
+
+// FailureReport 一个批次验证失败的原因, 不属于某个批次的失败BatchNumber为-1
+type FailureReport struct {
+	BatchNumber int64
+	Kind        string
+	Detail      string
+}
+
+// NewReport 根据验证器配置和批次证明的验证结果生成验证报告
+func NewReport(verifierConfig *config.Config, result *BatchResult) *Report {
+	report := &Report{
+		Passed:                  result.Passed(),
+		VerifiedAt:              time.Now().UTC().Format(time.RFC3339),
+		RoundId:                 verifierConfig.RoundId,
+		Timestamp:               verifierConfig.Timestamp,
+		ProofSource:             verifierConfig.ProofSource,
+		ProvingSystem:           verifierConfig.ProvingSystem,
+		HashToField:             result.HashToField,
+		BaseAccountTreeRoot:     verifierConfig.BaseAccountTreeRoot,
+		BaseCexAssetsCommitment: verifierConfig.BaseCexAssetsCommitment,
+		BatchCount:              result.BatchCount,
+		AccountTreeRoot:         hex.EncodeToString(result.AccountTreeRoot),
+		CexAssetsCommitment:     hex.EncodeToString(result.CexAssetsCommitment),
+		Tiers:                   make([]*TierReport, len(result.Tiers)),
+		Assets:                  make([]*AssetReport, len(verifierConfig.CexAssetsInfo)),
+		Failures:                make([]*FailureReport, len(result.Failures)),
+	}
+	if report.ProofSource == "" {
+		report.ProofSource = ProofSourceCsv
+	}
+	for i, tier := range result.Tiers {
+		report.Tiers[i] = &TierReport{
+			ProvingSystem: tier.ProvingSystem,
+			OpType:        tier.OpType,
+			AssetsCount:   tier.AssetsCount,
+			VerifyingKey:  tier.VerifyingKey,
+			BatchCount:    tier.BatchCount,
+		}
+		if tier.VerifyingKey != "" {
+			if content, err := os.ReadFile(tier.VerifyingKey); err == nil {
+				hash := sha256.Sum256(content)
+				report.Tiers[i].VerifyingKeySha256 = hex.EncodeToString(hash[:])
+			}
+		}
+	}
+	for i, info := range verifierConfig.CexAssetsInfo {
+		report.Assets[i] = &AssetReport{
+			Index:                     info.Index,
+			Symbol:                    info.Symbol,
+			BasePrice:                 info.BasePrice,
+			TotalEquity:               info.TotalEquity,
+			TotalDebt:                 info.TotalDebt,
+			LoanCollateral:            info.LoanCollateral,
+			MarginCollateral:          info.MarginCollateral,
+			PortfolioMarginCollateral: info.PortfolioMarginCollateral,
+		}
+	}
+	sort.Slice(report.Assets, func(i, j int) bool { return report.Assets[i].Index < report.Assets[j].Index })
+	for i, failure := range result.Failures {
+		report.Failures[i] = &FailureReport{BatchNumber: failure.BatchNumber, Kind: failure.Kind.Error(), Detail: failure.Detail}
+	}
+	return report
+}
+
+// WriteFile 将验证报告以JSON格式写入文件
+func (r *Report) WriteFile(fileName string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, content, 0644)
+}
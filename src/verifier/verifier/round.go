@@ -0,0 +1,107 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+)
+
+// RoundResult 轮次聚合证明的验证结果
+type RoundResult struct {
+	AccountTreeRoot []byte // 最后一个批次之后的账户树根
+}
+
+// VerifyRoundProof 验证一轮审计的聚合证明
+// 聚合证明已经在电路中验证了所有批次证明及其状态衔接, 这里只需要:
+//  1. 验证聚合证明属于配置中的审计轮次, 且聚合承诺由账户树根, CEX资产承诺, 审计轮次编号和快照时间戳正确计算
+//  2. 使用证明记录的聚合层数对应的验证密钥验证聚合证明
+//  3. 验证初始状态为空账户树和空CEX资产(增量审计时为上一轮的最终状态), 最终状态与配置中的CEX资产一致
+//
+// 参数:
+//   - verifierConfig: 验证器配置, 调用前需要通过InitCircuitParams加载电路参数
+//
+// 返回:
+//   - *RoundResult: 验证通过时的结果
+//   - error: 验证失败时可以通过errors.Is判断失败的原因
+func VerifyRoundProof(verifierConfig *config.Config) (*RoundResult, error) {
+	content, err := os.ReadFile(verifierConfig.RoundProofFile)
+	if err != nil {
+		return nil, err
+	}
+	roundProof := &utils.RoundProof{}
+	err = json.Unmarshal(content, roundProof)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	fields := []string{roundProof.BeforeAccountTreeRoot, roundProof.AfterAccountTreeRoot,
+		roundProof.BeforeCEXAssetsCommitment, roundProof.AfterCEXAssetsCommitment,
+		roundProof.AggregatedCommitment, roundProof.ProofInfo}
+	decoded := make([][]byte, len(fields))
+	for i, field := range fields {
+		decoded[i], err = base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+		}
+	}
+	beforeAccountTreeRoot, afterAccountTreeRoot := decoded[0], decoded[1]
+	beforeCexAssetsCommitment, afterCexAssetsCommitment := decoded[2], decoded[3]
+	aggregatedCommitment, proofInfo := decoded[4], decoded[5]
+
+	if roundProof.RoundId != verifierConfig.RoundId || roundProof.Timestamp != verifierConfig.Timestamp {
+		return nil, fmt.Errorf("%w: round proof belongs to round %d at %d", ErrRoundMismatch, roundProof.RoundId, roundProof.Timestamp)
+	}
+	expectHash := utils.ComputeBatchCommitment(beforeAccountTreeRoot, afterAccountTreeRoot, beforeCexAssetsCommitment, afterCexAssetsCommitment,
+		roundProof.RoundId, roundProof.Timestamp)
+	if !bytes.Equal(expectHash, aggregatedCommitment) {
+		return nil, fmt.Errorf("%w: expect aggregated commitment %x but got %x", ErrCommitmentMismatch, expectHash, aggregatedCommitment)
+	}
+
+	// 每一层聚合电路都验证了完整的批次衔接, 按证明记录的层数选择验证密钥
+	if roundProof.AggregationLevels < 0 {
+		return nil, fmt.Errorf("%w: invalid aggregation levels %d", ErrMalformedInput, roundProof.AggregationLevels)
+	}
+	vk, err := LoadVerifyingKey(utils.ProvingSystemGroth16, utils.RoundAggregationKeyName(verifierConfig.RoundKeyName, roundProof.AggregationLevels)+".vk")
+	if err != nil {
+		return nil, err
+	}
+	proof := utils.NewSnarkProof(utils.ProvingSystemGroth16)
+	_, err = proof.ReadFrom(bytes.NewBuffer(proofInfo))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProofInvalid, err)
+	}
+	vWitness, err := frontend.NewWitness(circuit.NewVerifyAggregationCircuit(aggregatedCommitment, roundProof.RoundId, roundProof.Timestamp), ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	err = utils.SnarkVerify(utils.ProvingSystemGroth16, proof, vk, vWitness)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProofInvalid, err)
+	}
+
+	emptyCexAssetListCommitment, expectFinalCexAssetsInfoComm, err := ComputeCexAssetsCommitments(verifierConfig)
+	if err != nil {
+		return nil, err
+	}
+	startAccountTreeRoot, startCexAssetsCommitment, err := GetStartingState(verifierConfig, emptyCexAssetListCommitment)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(beforeAccountTreeRoot, startAccountTreeRoot) {
+		return nil, fmt.Errorf("%w: the round should start from empty account tree or base account tree", ErrChainBreak)
+	}
+	if !bytes.Equal(beforeCexAssetsCommitment, startCexAssetsCommitment) {
+		return nil, fmt.Errorf("%w: the round should start from empty cex assets or base cex assets", ErrChainBreak)
+	}
+	if !bytes.Equal(afterCexAssetsCommitment, expectFinalCexAssetsInfoComm) {
+		return nil, fmt.Errorf("%w: final cex assets info not match the config", ErrCommitmentMismatch)
+	}
+	return &RoundResult{AccountTreeRoot: afterAccountTreeRoot}, nil
+}
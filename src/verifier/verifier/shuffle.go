@@ -0,0 +1,78 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+// VerifyShuffleSeed 用公开的置换种子检查导出的用户证明的账户索引
+// 检查种子与清单中的ShuffleSeedCommitment一致, 然后在清单的索引范围[CreateStartIndex, NextAccountIndex)上重新计算置换:
+// 置换前本轮新建的用户在范围的最前面, 填充账户在其后, 所以范围内的用户证明的账户索引逆置换后必须恰好是前面的位置;
+// 范围之前的账户索引属于上一轮审计的用户, 不检查
+// 只能证明账户索引和填充账户的位置由承诺的种子决定, 不能证明置换前用户在用户数据中的顺序
+// 参数:
+//   - manifest: 通过LoadExportManifest检查的清单, 必须包括所有用户证明
+//   - reader: 清单中的所有用户证明, 无法解码的用户证明由VerifyUserProofs报告, 这里跳过
+//   - seed: 公开的置换种子
+//
+// 返回:
+//   - error: 种子或账户索引与清单不一致时返回ErrCommitmentMismatch, 清单没有置换种子承诺或索引范围时返回ErrMalformedInput
+func VerifyShuffleSeed(manifest *model.ExportManifest, reader UserProofReader, seed []byte) error {
+	if manifest.ShuffleSeedCommitment == "" {
+		return fmt.Errorf("%w: the round does not shuffle the account indexes", ErrMalformedInput)
+	}
+	commitment := hex.EncodeToString(utils.ComputeShuffleSeedCommitment(seed))
+	if commitment != strings.ToLower(manifest.ShuffleSeedCommitment) {
+		return fmt.Errorf("%w: the commitment of the seed is %s instead of %s", ErrCommitmentMismatch, commitment, manifest.ShuffleSeedCommitment)
+	}
+	// 之前版本导出的清单没有索引范围
+	if manifest.NextAccountIndex == 0 {
+		return fmt.Errorf("%w: the manifest has no account index range", ErrMalformedInput)
+	}
+	if manifest.CreateStartIndex < 0 || manifest.NextAccountIndex < manifest.CreateStartIndex {
+		return fmt.Errorf("%w: invalid account index range [%d, %d)", ErrMalformedInput, manifest.CreateStartIndex, manifest.NextAccountIndex)
+	}
+
+	permutation := utils.NewAccountIndexPermutation(seed, int(manifest.NextAccountIndex-manifest.CreateStartIndex))
+	inverse := make([]uint32, len(permutation))
+	for i, v := range permutation {
+		inverse[v] = uint32(i)
+	}
+	// 范围内每个用户置换前的位置
+	offsets := make(map[uint32]string)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if record.Config == nil || int64(record.Config.AccountIndex) < manifest.CreateStartIndex {
+			continue
+		}
+		if int64(record.Config.AccountIndex) >= manifest.NextAccountIndex {
+			return fmt.Errorf("%w: %s: the account index %d is out of the range [%d, %d)", ErrCommitmentMismatch,
+				record.Source, record.Config.AccountIndex, manifest.CreateStartIndex, manifest.NextAccountIndex)
+		}
+		offset := inverse[int64(record.Config.AccountIndex)-manifest.CreateStartIndex]
+		if source, ok := offsets[offset]; ok {
+			return fmt.Errorf("%w: %s: the account index %d is also used by %s", ErrCommitmentMismatch, record.Source, record.Config.AccountIndex, source)
+		}
+		offsets[offset] = record.Source
+	}
+	// 用户互不相同, 所以最大的位置小于用户数量时恰好占满前面的位置
+	last := uint32(0)
+	for offset := range offsets {
+		last = max(last, offset)
+	}
+	if len(offsets) > 0 && int(last) >= len(offsets) {
+		return fmt.Errorf("%w: %s: the account index is a padding account index of the shuffle", ErrCommitmentMismatch, offsets[last])
+	}
+	return nil
+}
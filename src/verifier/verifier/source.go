@@ -0,0 +1,198 @@
+package verifier
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+)
+
+// 批次证明的来源
+const (
+	ProofSourceCsv    = "csv"    // dbtool导出的证明表csv文件
+	ProofSourceJsonl  = "jsonl"  // 每行一个批次证明的JSON lines文件
+	ProofSourceBundle = "bundle" // CEX签名发布的证明包
+	ProofSourceDb     = "db"     // 直接读取数据库中的证明表
+)
+
+// ErrSignatureInvalid 证明包的签名无法通过验证或者不是由配置中的公钥签名
+var ErrSignatureInvalid = errors.New("signature invalid")
+
+// ProofBundle CEX发布的一轮审计的所有批次证明
+// Signature是PublicKey对应的ed25519私钥对Signature为空时的证明包JSON编码的签名
+type ProofBundle struct {
+	RoundId   uint64        // 审计轮次编号
+	Timestamp uint64        // 审计快照的时间戳(unix秒)
+	Proofs    []*BatchProof // 所有批次的证明
+	PublicKey string        // 签名公钥(hex编码)
+	Signature string        // 签名(hex编码)
+}
+
+// signedMessage 证明包中被签名的内容
+func (b *ProofBundle) signedMessage() ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = ""
+	return json.Marshal(&unsigned)
+}
+
+// SignProofBundle 使用ed25519私钥签名证明包, 并设置证明包的公钥和签名
+func SignProofBundle(bundle *ProofBundle, privateKey ed25519.PrivateKey) error {
+	bundle.PublicKey = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+	message, err := bundle.signedMessage()
+	if err != nil {
+		return err
+	}
+	bundle.Signature = hex.EncodeToString(ed25519.Sign(privateKey, message))
+	return nil
+}
+
+// VerifyProofBundle 验证证明包由指定的公钥签名
+// 参数:
+//   - bundle: 证明包
+//   - publicKey: CEX公布的签名公钥(hex编码)
+func VerifyProofBundle(bundle *ProofBundle, publicKey string) error {
+	expectKey, err := hex.DecodeString(publicKey)
+	if err != nil || len(expectKey) != ed25519.PublicKeySize {
+		return errors.New("invalid bundle public key")
+	}
+	if bundle.PublicKey != hex.EncodeToString(expectKey) {
+		return fmt.Errorf("%w: the bundle is signed by %s", ErrSignatureInvalid, bundle.PublicKey)
+	}
+	signature, err := hex.DecodeString(bundle.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	message, err := bundle.signedMessage()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(expectKey, message, signature) {
+		return fmt.Errorf("%w: the bundle has been modified", ErrSignatureInvalid)
+	}
+	return nil
+}
+
+// LoadProofBundle 读取证明包, 验证签名并检查证明包属于配置中的审计轮次
+func LoadProofBundle(fileName string, publicKey string, roundId uint64, timestamp uint64) ([]*BatchProof, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &ProofBundle{}
+	err = json.Unmarshal(content, bundle)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	err = VerifyProofBundle(bundle, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.RoundId != roundId || bundle.Timestamp != timestamp {
+		return nil, fmt.Errorf("%w: the bundle belongs to round %d at %d", ErrRoundMismatch, bundle.RoundId, bundle.Timestamp)
+	}
+	return bundle.Proofs, nil
+}
+
+// LoadBatchProofsJsonl 读取每行一个批次证明的JSON lines文件, 忽略空行
+func LoadBatchProofsJsonl(fileName string) ([]*BatchProof, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	proofs := []*BatchProof{}
+	scanner := bufio.NewScanner(f)
+	// 证明和承诺都很短, 但plonk证明的一行可能超过默认的64KB
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		proof := &BatchProof{}
+		err = json.Unmarshal(scanner.Bytes(), proof)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrMalformedInput, line, err)
+		}
+		proofs = append(proofs, proof)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return proofs, nil
+}
+
+// NewBatchProof 将数据库中的证明记录转换为批次证明
+// 账户树根和CEX资产承诺无法解码时保留为空, 由VerifyBatchProofs报告该批次的输入错误
+func NewBatchProof(row *prover.Proof) *BatchProof {
+	proof := &BatchProof{
+		BatchNumber:     row.BatchNumber,
+		ZkProof:         row.ProofInfo,
+		BatchCommitment: row.BatchCommitment,
+		AssetsCount:     row.AssetsCount,
+		ProvingSystem:   row.ProvingSystem,
+		HashToField:     row.HashToField,
+		OpType:          row.OpType,
+		RoundId:         row.RoundId,
+		Timestamp:       row.Timestamp,
+	}
+	json.Unmarshal([]byte(row.CexAssetListCommitments), &proof.CexAssetCommitment)
+	json.Unmarshal([]byte(row.AccountTreeRoots), &proof.AccountTreeRoots)
+	return proof
+}
+
+// LoadBatchProofsFromDb 读取证明表中的所有批次证明
+// 按批次号分页读取到最大的批次号, 缺少的批次由VerifyBatchProofs报告
+func LoadBatchProofsFromDb(proofModel prover.ProofModel) ([]*BatchProof, error) {
+	latest, err := proofModel.GetLatestProof()
+	if err != nil {
+		if errors.Is(err, utils.DbErrNotFound) {
+			return []*BatchProof{}, nil
+		}
+		return nil, err
+	}
+	proofs := make([]*BatchProof, 0, latest.BatchNumber+1)
+	limit := int64(1024)
+	for start := int64(0); start <= latest.BatchNumber; start += limit {
+		rows, err := proofModel.GetProofsBetween(start, start+limit-1)
+		if err != nil {
+			if errors.Is(err, utils.DbErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		for _, row := range rows {
+			proofs = append(proofs, NewBatchProof(row))
+		}
+	}
+	return proofs, nil
+}
+
+// LoadProofs 根据配置中的ProofSource读取所有批次证明
+func LoadProofs(verifierConfig *config.Config) ([]*BatchProof, error) {
+	switch verifierConfig.ProofSource {
+	case "", ProofSourceCsv:
+		return LoadBatchProofs(verifierConfig.ProofTable)
+	case ProofSourceJsonl:
+		return LoadBatchProofsJsonl(verifierConfig.ProofTable)
+	case ProofSourceBundle:
+		return LoadProofBundle(verifierConfig.ProofTable, verifierConfig.BundlePublicKey, verifierConfig.RoundId, verifierConfig.Timestamp)
+	case ProofSourceDb:
+		db, err := utils.OpenDatabase(verifierConfig.MysqlDataSource)
+		if err != nil {
+			return nil, err
+		}
+		return LoadBatchProofsFromDb(prover.NewProofModel(db, verifierConfig.DbSuffix))
+	default:
+		return nil, fmt.Errorf("unknown proof source %s", verifierConfig.ProofSource)
+	}
+}
@@ -0,0 +1,441 @@
+package verifier
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/userverifier"
+)
+
+// UserProofRecord 批量验证中的一个用户证明
+type UserProofRecord struct {
+	Source   string                   // 用户证明的来源(文件名, 行号或账户索引), 用于报告
+	Config   *userverifier.UserConfig // 用户证明, 无法解码时为nil
+	LeafHash string                   // 用户证明表中记录的账户叶子节点哈希(hex编码), 为空时不检查
+	Err      error                    // 用户证明无法解码的原因
+}
+
+// UserProofReader 逐个读取用户证明, 全部读完时返回io.EOF
+// 单个用户证明无法解码时通过UserProofRecord.Err报告, 不会中止读取
+type UserProofReader interface {
+	Next() (*UserProofRecord, error)
+	Close() error
+}
+
+// decodeUserConfig 解码JSON编码的用户证明
+func decodeUserConfig(source string, content []byte) *UserProofRecord {
+	userConfig := &userverifier.UserConfig{}
+	err := json.Unmarshal(content, userConfig)
+	if err != nil {
+		return &UserProofRecord{Source: source, Err: err}
+	}
+	return &UserProofRecord{Source: source, Config: userConfig}
+}
+
+// dirUserProofReader 读取目录下每个文件一个用户证明的.json文件
+type dirUserProofReader struct {
+	files []string
+}
+
+// NewUserProofDirReader 读取目录下所有的.json文件, 每个文件是一个user_config.json格式的用户证明
+func NewUserProofDirReader(dir string) (UserProofReader, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return &dirUserProofReader{files: files}, nil
+}
+
+func (r *dirUserProofReader) Next() (*UserProofRecord, error) {
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	file := r.files[0]
+	r.files = r.files[1:]
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUserConfig(file, content), nil
+}
+
+func (r *dirUserProofReader) Close() error {
+	return nil
+}
+
+// jsonlUserProofReader 读取每行一个用户证明的JSON lines文件
+type jsonlUserProofReader struct {
+	file    *os.File
+	gzip    *gzip.Reader
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewUserProofJsonlReader 读取每行一个user_config.json格式的用户证明的JSON lines文件, 忽略空行
+// 以.gz结尾的文件按gzip解压, 例如userproof -export导出的压缩分片
+func NewUserProofJsonlReader(fileName string) (UserProofReader, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	r := &jsonlUserProofReader{file: f}
+	var content io.Reader = f
+	if strings.HasSuffix(fileName, ".gz") {
+		r.gzip, err = gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		content = r.gzip
+	}
+	r.scanner = bufio.NewScanner(content)
+	r.scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return r, nil
+}
+
+func (r *jsonlUserProofReader) Next() (*UserProofRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(bytes.TrimSpace(r.scanner.Bytes())) == 0 {
+			continue
+		}
+		return decodeUserConfig(fmt.Sprintf("%s:%d", r.file.Name(), r.line), r.scanner.Bytes()), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *jsonlUserProofReader) Close() error {
+	var err error
+	if r.gzip != nil {
+		err = r.gzip.Close()
+	}
+	return errors.Join(err, r.file.Close())
+}
+
+// LoadExportManifest 读取userproof -export导出目录中的清单, 并检查清单与分片一致
+// 检查每个分片文件的sha256哈希和用户证明数量, 以及清单中的账户树根与CEX公布的账户树根相同
+// 参数:
+//   - dir: 导出目录
+//   - root: CEX公布的账户树根(hex编码), 为空时不检查
+//
+// 返回:
+//   - *model.ExportManifest: 导出的清单
+//   - error: 清单无法读取或与分片不一致时返回错误, ErrMalformedInput或ErrCommitmentMismatch
+func LoadExportManifest(dir string, root string) (*model.ExportManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, model.ExportManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &model.ExportManifest{}
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	if root != "" && strings.ToLower(root) != strings.ToLower(manifest.Root) {
+		return nil, fmt.Errorf("%w: the manifest is against root %s instead of %s", ErrCommitmentMismatch, manifest.Root, root)
+	}
+	userCount := 0
+	for _, shard := range manifest.Shards {
+		// 分片文件必须在导出目录中
+		if shard.File == "" || filepath.Base(shard.File) != shard.File {
+			return nil, fmt.Errorf("%w: invalid shard file name %q", ErrMalformedInput, shard.File)
+		}
+		hash, count, err := hashShard(filepath.Join(dir, shard.File))
+		if err != nil {
+			return nil, err
+		}
+		if hash != strings.ToLower(shard.Sha256) {
+			return nil, fmt.Errorf("%w: expect sha256 %s of shard %s but got %s", ErrCommitmentMismatch, shard.Sha256, shard.File, hash)
+		}
+		if count != shard.UserCount {
+			return nil, fmt.Errorf("%w: expect %d user proofs in shard %s but got %d", ErrCommitmentMismatch, shard.UserCount, shard.File, count)
+		}
+		userCount += count
+	}
+	if userCount != manifest.UserCount {
+		return nil, fmt.Errorf("%w: expect %d user proofs in the manifest but got %d in shards", ErrCommitmentMismatch, manifest.UserCount, userCount)
+	}
+	return manifest, nil
+}
+
+// hashShard 计算分片文件的sha256哈希, 并按NewUserProofJsonlReader的方式统计其中的用户证明数量
+func hashShard(fileName string) (string, int, error) {
+	hasher := sha256.New()
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	var content io.Reader = io.TeeReader(f, hasher)
+	if strings.HasSuffix(fileName, ".gz") {
+		gz, err := gzip.NewReader(content)
+		if err != nil {
+			return "", 0, fmt.Errorf("%w: %s: %w", ErrMalformedInput, fileName, err)
+		}
+		defer gz.Close()
+		content = gz
+	}
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	count := 0
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) != 0 {
+			count++
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", 0, err
+	}
+	// gzip流结束后文件中剩余的内容也计入哈希
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), count, nil
+}
+
+// manifestUserProofReader 依次读取导出清单中的所有分片
+type manifestUserProofReader struct {
+	dir    string
+	shards []model.ExportShard
+	reader UserProofReader
+}
+
+// NewUserProofManifestReader 按清单的顺序依次读取导出目录中的所有分片, 同一时间只打开一个分片
+// 清单应先通过LoadExportManifest检查
+func NewUserProofManifestReader(dir string, manifest *model.ExportManifest) UserProofReader {
+	return &manifestUserProofReader{dir: dir, shards: manifest.Shards}
+}
+
+func (r *manifestUserProofReader) Next() (*UserProofRecord, error) {
+	for {
+		if r.reader == nil {
+			if len(r.shards) == 0 {
+				return nil, io.EOF
+			}
+			reader, err := NewUserProofJsonlReader(filepath.Join(r.dir, r.shards[0].File))
+			if err != nil {
+				return nil, err
+			}
+			r.shards = r.shards[1:]
+			r.reader = reader
+		}
+		record, err := r.reader.Next()
+		if err != io.EOF {
+			return record, err
+		}
+		err = r.reader.Close()
+		r.reader = nil
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *manifestUserProofReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
+
+// dbUserProofReader 按账户索引分页读取用户证明表
+type dbUserProofReader struct {
+	userProofModel model.UserProofModel
+	latestIndex    uint32
+	nextIndex      uint64
+	rows           []model.UserProof
+}
+
+// NewUserProofDbReader 读取用户证明表中的所有用户证明, 并检查表中记录的账户叶子节点哈希
+func NewUserProofDbReader(userProofModel model.UserProofModel) (UserProofReader, error) {
+	latestIndex, err := userProofModel.GetLatestAccountIndex()
+	if err != nil {
+		if errors.Is(err, utils.DbErrNotFound) {
+			return &dbUserProofReader{userProofModel: userProofModel, nextIndex: 1}, nil
+		}
+		return nil, err
+	}
+	return &dbUserProofReader{userProofModel: userProofModel, latestIndex: latestIndex}, nil
+}
+
+func (r *dbUserProofReader) Next() (*UserProofRecord, error) {
+	limit := uint64(1024)
+	for len(r.rows) == 0 {
+		if r.nextIndex > uint64(r.latestIndex) {
+			return nil, io.EOF
+		}
+		end := r.nextIndex + limit - 1
+		if end > uint64(r.latestIndex) {
+			end = uint64(r.latestIndex)
+		}
+		rows, err := r.userProofModel.GetUserProofsBetween(uint32(r.nextIndex), uint32(end))
+		if err != nil && !errors.Is(err, utils.DbErrNotFound) {
+			return nil, err
+		}
+		r.rows = rows
+		r.nextIndex = end + 1
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	record := decodeUserConfig(fmt.Sprintf("account %d", row.AccountIndex), []byte(row.Config))
+	record.LeafHash = row.AccountLeafHash
+	return record, nil
+}
+
+func (r *dbUserProofReader) Close() error {
+	return nil
+}
+
+// UserProofError 一个用户证明验证失败的原因
+// Kind为ErrMalformedInput, ErrProofInvalid或ErrCommitmentMismatch
+type UserProofError struct {
+	AccountIndex uint32 // 账户索引, 用户证明无法解码时为0
+	Source       string // 用户证明的来源
+	Kind         error
+	Detail       string
+}
+
+func (e *UserProofError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Source, e.Kind.Error(), e.Detail)
+}
+
+func (e *UserProofError) Unwrap() error {
+	return e.Kind
+}
+
+// UserResult 批量用户证明的验证结果
+type UserResult struct {
+	Root      string            // 验证使用的账户树根(hex编码)
+	UserCount int               // 验证的用户证明数量
+	Failures  []*UserProofError // 验证失败的用户证明, 按账户索引排序
+}
+
+// Passed 所有用户证明都通过验证时返回true
+func (r *UserResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// VerifyUserProofs 并行验证所有用户证明的账户叶子节点哈希和Merkle证明
+// 调用前需要通过utils.InitCircuitParams加载电路参数
+// 参数:
+//   - reader: 用户证明的来源
+//   - root: CEX公布的账户树根(hex编码), 为空时使用第一个账户树根不为空的用户证明中的账户树根, 所有用户证明必须使用同一个账户树根
+//   - workersNum: 验证线程数, 小于等于0时为CPU核数
+//
+// 返回:
+//   - *UserResult: 验证结果, 只有Failures为空时验证通过
+//   - error: 读取用户证明失败时返回错误, 此时没有验证结果
+func VerifyUserProofs(reader UserProofReader, root string, workersNum int) (*UserResult, error) {
+	if workersNum <= 0 {
+		workersNum = runtime.NumCPU()
+	}
+	result := &UserResult{Root: strings.ToLower(root)}
+	var lock sync.Mutex
+	addFailure := func(record *UserProofRecord, kind error, detail string) {
+		failure := &UserProofError{Source: record.Source, Kind: kind, Detail: detail}
+		if record.Config != nil {
+			failure.AccountIndex = record.Config.AccountIndex
+		}
+		lock.Lock()
+		result.Failures = append(result.Failures, failure)
+		lock.Unlock()
+	}
+
+	// 在启动验证线程之前确定账户树根, 账户树根之前的用户证明先缓存起来
+	var pending []*UserProofRecord
+	var err error
+	for result.Root == "" {
+		var record *UserProofRecord
+		record, err = reader.Next()
+		if err != nil {
+			break
+		}
+		if record.Config != nil {
+			result.Root = strings.ToLower(record.Config.Root)
+		}
+		pending = append(pending, record)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	records := make(chan *UserProofRecord, workersNum*4)
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range records {
+				if record.Err != nil {
+					addFailure(record, ErrMalformedInput, record.Err.Error())
+					continue
+				}
+				if strings.ToLower(record.Config.Root) != result.Root {
+					addFailure(record, ErrCommitmentMismatch, fmt.Sprintf("the proof is against root %s instead of %s", record.Config.Root, result.Root))
+					continue
+				}
+				leafHash, err := userverifier.VerifyUserProof(record.Config)
+				if err != nil {
+					kind := ErrProofInvalid
+					if errors.Is(err, ErrMalformedInput) {
+						kind = ErrMalformedInput
+					} else if errors.Is(err, ErrCommitmentMismatch) {
+						kind = ErrCommitmentMismatch
+					}
+					addFailure(record, kind, strings.TrimPrefix(err.Error(), kind.Error()+": "))
+					continue
+				}
+				if record.LeafHash != "" && strings.ToLower(record.LeafHash) != hex.EncodeToString(leafHash) {
+					addFailure(record, ErrCommitmentMismatch, fmt.Sprintf("expect leaf hash %x but got %s", leafHash, record.LeafHash))
+				}
+			}
+		}()
+	}
+
+	for _, record := range pending {
+		result.UserCount++
+		records <- record
+	}
+	for err == nil {
+		var record *UserProofRecord
+		record, err = reader.Next()
+		if err != nil {
+			break
+		}
+		result.UserCount++
+		records <- record
+	}
+	close(records)
+	wg.Wait()
+	if err != io.EOF {
+		return nil, err
+	}
+	sort.SliceStable(result.Failures, func(i, j int) bool {
+		if result.Failures[i].AccountIndex != result.Failures[j].AccountIndex {
+			return result.Failures[i].AccountIndex < result.Failures[j].AccountIndex
+		}
+		return result.Failures[i].Source < result.Failures[j].Source
+	})
+	return result, nil
+}
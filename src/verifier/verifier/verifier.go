@@ -0,0 +1,180 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/userverifier"
+)
+
+// 验证失败的原因, 可以通过errors.Is判断BatchError和其他返回的错误属于哪一种
+var (
+	// ErrCommitmentMismatch 批次承诺不是由账户树根, CEX资产承诺和审计轮次计算得到, 或者最终的CEX资产与配置不一致
+	ErrCommitmentMismatch = userverifier.ErrCommitmentMismatch
+	// ErrChainBreak 批次的初始状态与上一个批次的最终状态不衔接, 或者缺少批次
+	ErrChainBreak = errors.New("chain break")
+	// ErrMissingBatch 缺少批次, 属于ErrChainBreak
+	ErrMissingBatch = fmt.Errorf("%w: missing batch", ErrChainBreak)
+	// ErrDuplicateBatch 批次号重复, 属于ErrChainBreak
+	ErrDuplicateBatch = fmt.Errorf("%w: duplicate batch", ErrChainBreak)
+	// ErrProofInvalid 证明无法解码或者没有通过验证
+	ErrProofInvalid = userverifier.ErrProofInvalid
+	// ErrRoundMismatch 证明不属于配置中的审计轮次
+	ErrRoundMismatch = errors.New("round mismatch")
+	// ErrMalformedInput 证明表或用户配置中的数据无法解码
+	ErrMalformedInput = userverifier.ErrMalformedInput
+)
+
+// BatchError 一个批次验证失败的原因
+// Kind为ErrCommitmentMismatch, ErrChainBreak(包括ErrMissingBatch和ErrDuplicateBatch), ErrProofInvalid, ErrRoundMismatch或ErrMalformedInput,
+// 不属于某个批次的失败(例如最终状态不一致)BatchNumber为-1
+type BatchError struct {
+	BatchNumber int64
+	Kind        error
+	Detail      string
+}
+
+func (e *BatchError) Error() string {
+	if e.BatchNumber < 0 {
+		return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Detail)
+	}
+	return fmt.Sprintf("batch %d: %s: %s", e.BatchNumber, e.Kind.Error(), e.Detail)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Kind
+}
+
+// BatchResult 批次证明的验证结果
+type BatchResult struct {
+	BatchCount          int           // 验证的批次数量
+	AccountTreeRoot     []byte        // 最后一个批次之后的账户树根
+	CexAssetsCommitment []byte        // 最后一个批次之后的CEX资产承诺
+	Failures            []*BatchError // 验证失败的批次, 按批次号排序
+	HashToField         string        // 批次证明的hash-to-field模式
+	Tiers               []TierResult  // 每种验证密钥验证的批次数量
+}
+
+// TierResult 使用同一个验证密钥的批次, 由证明系统, 操作类型和资产数量层级决定
+type TierResult struct {
+	ProvingSystem string // 证明系统(groth16/plonk)
+	OpType        int64  // 操作类型(创建用户/更新用户/删除用户)
+	AssetsCount   int    // 资产数量层级
+	VerifyingKey  string // 验证密钥文件名, 配置中没有对应的密钥时为空
+	BatchCount    int    // 批次数量
+}
+
+// Passed 所有批次都通过验证时返回true
+func (r *BatchResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Err 返回包含所有失败原因的错误, 全部通过时返回nil
+func (r *BatchResult) Err() error {
+	errs := make([]error, len(r.Failures))
+	for i, failure := range r.Failures {
+		errs[i] = failure
+	}
+	return errors.Join(errs...)
+}
+
+// LoadVerifyingKey 加载验证密钥
+// 参数:
+//   - provingSystem: 证明系统(groth16/plonk)
+//   - vkFileName: 验证密钥文件名
+//
+// 返回:
+//   - utils.SnarkVerifyingKey: 验证密钥
+//   - error: 错误信息
+func LoadVerifyingKey(provingSystem string, vkFileName string) (utils.SnarkVerifyingKey, error) {
+	vkFile, err := os.ReadFile(vkFileName)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(vkFile)
+	vk := utils.NewSnarkVerifyingKey(provingSystem)
+	_, err = vk.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return vk, nil
+}
+
+// InitCircuitParams 加载电路参数, 并检查配置中的密钥和资产数量层级与电路参数一致
+func InitCircuitParams(verifierConfig *config.Config) error {
+	err := utils.InitCircuitParams(verifierConfig.CircuitParamsFile)
+	if err != nil {
+		return err
+	}
+	for _, keyNames := range [][]string{verifierConfig.ZkKeyName, verifierConfig.UpdateZkKeyName, verifierConfig.DeleteZkKeyName} {
+		for _, keyName := range keyNames {
+			if err := utils.CheckKeyNameCircuitParams(keyName); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tier := range verifierConfig.AssetsCountTiers {
+		if _, ok := utils.BatchCreateUserOpsCountsTiers[tier]; !ok {
+			return fmt.Errorf("assets count tier %d is not in the circuit params", tier)
+		}
+	}
+	return nil
+}
+
+// ComputeCexAssetsCommitments 根据配置中的CEX资产信息计算初始(空)和最终的CEX资产承诺
+// 参数:
+//   - verifierConfig: 验证器配置
+//
+// 返回:
+//   - []byte: 所有资产总量为0时的CEX资产承诺
+//   - []byte: 配置中CEX资产信息的承诺
+//   - error: 资产的权益小于负债或索引越界时返回错误
+func ComputeCexAssetsCommitments(verifierConfig *config.Config) ([]byte, []byte, error) {
+	// according to asset price info to compute
+	cexAssetsInfo := make([]utils.CexAssetInfo, len(verifierConfig.CexAssetsInfo))
+	for i := 0; i < len(verifierConfig.CexAssetsInfo); i++ {
+		info := verifierConfig.CexAssetsInfo[i]
+		if int(info.Index) >= len(cexAssetsInfo) {
+			return nil, nil, fmt.Errorf("invalid cex asset info: index %d of %s out of range", info.Index, info.Symbol)
+		}
+		if info.TotalEquity < info.TotalDebt {
+			return nil, nil, fmt.Errorf("invalid cex asset info: %s asset equity %d less then debt %d", info.Symbol, info.TotalEquity, info.TotalDebt)
+		}
+		cexAssetsInfo[info.Index] = info
+	}
+	emptyCexAssetsInfo := make([]utils.CexAssetInfo, len(cexAssetsInfo))
+	copy(emptyCexAssetsInfo, cexAssetsInfo)
+	for i := 0; i < len(emptyCexAssetsInfo); i++ {
+		emptyCexAssetsInfo[i].TotalDebt = 0
+		emptyCexAssetsInfo[i].TotalEquity = 0
+		emptyCexAssetsInfo[i].LoanCollateral = 0
+		emptyCexAssetsInfo[i].MarginCollateral = 0
+		emptyCexAssetsInfo[i].PortfolioMarginCollateral = 0
+	}
+	return utils.ComputeCexAssetsCommitment(emptyCexAssetsInfo), utils.ComputeCexAssetsCommitment(cexAssetsInfo), nil
+}
+
+// GetStartingState 获取本轮审计的初始账户树根和CEX资产承诺
+// 全量审计从空账户树和空CEX资产开始, 增量审计从配置中上一轮审计的最终状态开始
+// 参数:
+//   - verifierConfig: 验证器配置
+//   - emptyCexAssetListCommitment: 所有资产总量为0时的CEX资产承诺
+func GetStartingState(verifierConfig *config.Config, emptyCexAssetListCommitment []byte) ([]byte, []byte, error) {
+	if verifierConfig.BaseAccountTreeRoot == "" && verifierConfig.BaseCexAssetsCommitment == "" {
+		return utils.EmptyAccountTreeRoot(), emptyCexAssetListCommitment, nil
+	}
+	baseAccountTreeRoot, err := hex.DecodeString(verifierConfig.BaseAccountTreeRoot)
+	if err != nil || len(baseAccountTreeRoot) != 32 {
+		return nil, nil, errors.New("invalid base account tree root")
+	}
+	baseCexAssetsCommitment, err := hex.DecodeString(verifierConfig.BaseCexAssetsCommitment)
+	if err != nil || len(baseCexAssetsCommitment) != 32 {
+		return nil, nil, errors.New("invalid base cex assets commitment")
+	}
+	return baseAccountTreeRoot, baseCexAssetsCommitment, nil
+}
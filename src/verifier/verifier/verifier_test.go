@@ -0,0 +1,500 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/userverifier"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestVerifyBatchProofsFailures 测试批次验证失败时记录所有失败的批次和原因, 而不是在第一个失败时中止
+func TestVerifyBatchProofsFailures(t *testing.T) {
+	verifierConfig := &config.Config{
+		CexAssetsInfo:    []utils.CexAssetInfo{{Symbol: "btc", Index: 0, TotalEquity: 10, BasePrice: 1}},
+		AssetsCountTiers: []int{50},
+		ZkKeyName:        []string{"zkpor50"},
+		ProvingSystem:    utils.ProvingSystemGroth16,
+		RoundId:          1,
+		Timestamp:        100,
+	}
+	emptyCexAssetsCommitment, _, err := ComputeCexAssetsCommitments(verifierConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+	encode := base64.StdEncoding.EncodeToString
+	newProof := func(batchNumber int64, beforeRoot, afterRoot, beforeCex, afterCex []byte, roundId uint64) *BatchProof {
+		return &BatchProof{
+			BatchNumber:        batchNumber,
+			AccountTreeRoots:   []string{encode(beforeRoot), encode(afterRoot)},
+			CexAssetCommitment: []string{encode(beforeCex), encode(afterCex)},
+			BatchCommitment:    encode(root(0xff)),
+			AssetsCount:        50,
+			RoundId:            roundId,
+			Timestamp:          100,
+		}
+	}
+	proofs := []*BatchProof{
+		newProof(3, root(9), root(3), root(2), root(3), 1),
+		newProof(0, utils.EmptyAccountTreeRoot(), root(1), emptyCexAssetsCommitment, root(1), 2),
+		newProof(1, root(8), root(2), root(1), root(2), 1),
+	}
+	result, err := VerifyBatchProofs(verifierConfig, proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expects := []struct {
+		batchNumber int64
+		kind        error
+	}{
+		{0, ErrRoundMismatch},
+		{1, ErrCommitmentMismatch},
+		{1, ErrChainBreak},
+		{2, ErrMissingBatch},
+		{3, ErrCommitmentMismatch},
+		{-1, ErrCommitmentMismatch},
+	}
+	if result.Passed() || len(result.Failures) != len(expects) {
+		t.Fatalf("expect %d failures but got %v", len(expects), result.Err())
+	}
+	for i, expect := range expects {
+		failure := result.Failures[i]
+		if failure.BatchNumber != expect.batchNumber || !errors.Is(failure, expect.kind) {
+			t.Fatalf("expect failure %d to be batch %d %v but got %v", i, expect.batchNumber, expect.kind, failure)
+		}
+	}
+	if result.BatchCount != 3 || !bytes.Equal(result.AccountTreeRoot, root(3)) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !errors.Is(result.Err(), ErrChainBreak) {
+		t.Fatal("expect the joined error to contain chain break")
+	}
+	if len(result.Tiers) != 1 || result.Tiers[0].BatchCount != 3 || result.Tiers[0].VerifyingKey != "zkpor50.vk" {
+		t.Fatalf("unexpected tiers %+v", result.Tiers)
+	}
+	report := NewReport(verifierConfig, result)
+	if report.Passed || report.BatchCount != 3 || len(report.Failures) != len(expects) || report.Failures[3].Kind != ErrMissingBatch.Error() ||
+		len(report.Assets) != 1 || report.Assets[0].TotalEquity != 10 || len(report.Tiers) != 1 || report.Tiers[0].VerifyingKeySha256 != "" || report.HashToField != utils.HashToFieldDefault {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// plonk证明使用keygen生成的"_plonk"密钥, 一轮审计不能混用证明系统
+	for _, proof := range proofs {
+		proof.ProvingSystem = utils.ProvingSystemPlonk
+	}
+	result, err = VerifyBatchProofs(verifierConfig, proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tiers) != 1 || result.Tiers[0].VerifyingKey != "zkpor50_plonk.vk" {
+		t.Fatalf("unexpected plonk tiers %+v", result.Tiers)
+	}
+	proofs[1].ProvingSystem = ""
+	if _, err = VerifyBatchProofs(verifierConfig, proofs); !errors.Is(err, ErrMalformedInput) {
+		t.Fatalf("expect mixed proving systems to be rejected but got %v", err)
+	}
+
+	// 一轮审计不能混用hash-to-field模式, 证明表未记录时使用配置对应的模式
+	proofs[1].ProvingSystem = utils.ProvingSystemPlonk
+	proofs[0].HashToField = utils.HashToFieldSolidity
+	if _, err = VerifyBatchProofs(verifierConfig, proofs); !errors.Is(err, ErrMalformedInput) || !strings.Contains(err.Error(), "hash-to-field") {
+		t.Fatalf("expect mixed hash-to-field modes to be rejected but got %v", err)
+	}
+	verifierConfig.ForSolidity = true
+	result, err = VerifyBatchProofs(verifierConfig, proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.HashToField != utils.HashToFieldSolidity {
+		t.Fatalf("expect hash-to-field mode %s but got %s", utils.HashToFieldSolidity, result.HashToField)
+	}
+	verifierConfig.ForSolidity = false
+
+	// 无法解码的用户证明
+	_, err = userverifier.VerifyUserProof(&userverifier.UserConfig{Root: "invalid"})
+	if !errors.Is(err, ErrMalformedInput) {
+		t.Fatalf("expect malformed input but got %v", err)
+	}
+}
+
+// TestProofSources 测试从JSON lines文件, 签名的证明包和数据库读取批次证明
+func TestProofSources(t *testing.T) {
+	dir := t.TempDir()
+	proofs := []*BatchProof{
+		{BatchNumber: 0, ZkProof: "proof0", AccountTreeRoots: []string{"a", "b"}, CexAssetCommitment: []string{"c", "d"}, HashToField: utils.HashToFieldSolidity, RoundId: 1, Timestamp: 100},
+		{BatchNumber: 2, ZkProof: "proof2", AccountTreeRoots: []string{"b", "e"}, CexAssetCommitment: []string{"d", "f"}, HashToField: utils.HashToFieldSolidity, RoundId: 1, Timestamp: 100},
+	}
+	check := func(name string, loaded []*BatchProof) {
+		if len(loaded) != len(proofs) {
+			t.Fatalf("%s: expect %d proofs but got %d", name, len(proofs), len(loaded))
+		}
+		for i := range proofs {
+			if loaded[i].BatchNumber != proofs[i].BatchNumber || loaded[i].ZkProof != proofs[i].ZkProof ||
+				len(loaded[i].AccountTreeRoots) != 2 || loaded[i].AccountTreeRoots[1] != proofs[i].AccountTreeRoots[1] ||
+				len(loaded[i].CexAssetCommitment) != 2 || loaded[i].CexAssetCommitment[1] != proofs[i].CexAssetCommitment[1] ||
+				loaded[i].HashToField != proofs[i].HashToField {
+				t.Fatalf("%s: unexpected proof %+v", name, loaded[i])
+			}
+		}
+	}
+
+	// JSON lines
+	var buf bytes.Buffer
+	for _, proof := range proofs {
+		line, _ := json.Marshal(proof)
+		buf.Write(line)
+		buf.WriteString("\n\n")
+	}
+	jsonlFile := filepath.Join(dir, "proofs.jsonl")
+	if err := os.WriteFile(jsonlFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBatchProofsJsonl(jsonlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("jsonl", loaded)
+
+	// 签名的证明包, 篡改后签名验证失败
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &ProofBundle{RoundId: 1, Timestamp: 100, Proofs: proofs}
+	if err = SignProofBundle(bundle, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	bundleFile := filepath.Join(dir, "bundle.json")
+	writeBundle := func() {
+		content, _ := json.Marshal(bundle)
+		if err := os.WriteFile(bundleFile, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBundle()
+	loaded, err = LoadProofBundle(bundleFile, bundle.PublicKey, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("bundle", loaded)
+	if _, err = LoadProofBundle(bundleFile, bundle.PublicKey, 2, 100); !errors.Is(err, ErrRoundMismatch) {
+		t.Fatalf("expect round mismatch but got %v", err)
+	}
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	if _, err = LoadProofBundle(bundleFile, hex.EncodeToString(otherKey), 1, 100); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("expect signature invalid but got %v", err)
+	}
+	bundle.Proofs[0].ZkProof = "modified"
+	writeBundle()
+	if _, err = LoadProofBundle(bundleFile, hex.EncodeToString(publicKey), 1, 100); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("expect signature invalid but got %v", err)
+	}
+	bundle.Proofs[0].ZkProof = "proof0"
+
+	// 数据库中的证明表, 缺少批次1
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(dir, "zkpos.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofModel := prover.NewProofModel(db, "")
+	if err = proofModel.CreateProofTable(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadBatchProofsFromDb(proofModel)
+	if err != nil || len(loaded) != 0 {
+		t.Fatalf("expect no proof but got %d: %v", len(loaded), err)
+	}
+	for _, proof := range proofs {
+		roots, _ := json.Marshal(proof.AccountTreeRoots)
+		commitments, _ := json.Marshal(proof.CexAssetCommitment)
+		err = proofModel.CreateProof(&prover.Proof{
+			BatchNumber:             proof.BatchNumber,
+			ProofInfo:               proof.ZkProof,
+			AccountTreeRoots:        string(roots),
+			CexAssetListCommitments: string(commitments),
+			HashToField:             proof.HashToField,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, err = LoadBatchProofsFromDb(proofModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("db", loaded)
+}
+
+// TestVerifyUserProofs 测试批量验证用户证明并报告所有验证失败的账户
+func TestVerifyUserProofs(t *testing.T) {
+	if err := utils.InitCircuitParams(""); err != nil {
+		t.Fatal(err)
+	}
+	accountTree, err := utils.NewAccountTree(utils.TreeDBDriverMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := poseidon.NewPoseidon()
+	accounts := make([]utils.AccountInfo, 3)
+	for i := range accounts {
+		accounts[i] = utils.AccountInfo{
+			AccountIndex:    uint32(i),
+			AccountId:       bytes.Repeat([]byte{byte(i + 1)}, 32),
+			TotalEquity:     big.NewInt(int64(100 * (i + 1))),
+			TotalDebt:       big.NewInt(int64(i)),
+			TotalCollateral: big.NewInt(0),
+			Assets:          []utils.AccountAsset{{Index: 0, Equity: uint64(100 * (i + 1)), Debt: uint64(i)}},
+		}
+		if err = accountTree.Set(uint64(i), utils.AccountInfoToHash(&accounts[i], &hasher)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := hex.EncodeToString(accountTree.Root())
+	rows := make([]model.UserProof, len(accounts))
+	for i := range accounts {
+		proof, err := accountTree.GetProof(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		userConfig := model.UserConfig{
+			AccountIndex:    accounts[i].AccountIndex,
+			AccountIdHash:   hex.EncodeToString(accounts[i].AccountId),
+			TotalEquity:     accounts[i].TotalEquity,
+			TotalDebt:       accounts[i].TotalDebt,
+			TotalCollateral: accounts[i].TotalCollateral,
+			Assets:          accounts[i].Assets,
+			Root:            root,
+			Proof:           proof,
+		}
+		if i == 2 {
+			// 篡改用户资产后Merkle证明验证失败
+			userConfig.TotalEquity = big.NewInt(1)
+		}
+		content, _ := json.Marshal(userConfig)
+		rows[i] = model.UserProof{
+			AccountIndex:    uint32(i),
+			AccountId:       userConfig.AccountIdHash,
+			AccountLeafHash: hex.EncodeToString(utils.AccountInfoToHash(&accounts[i], &hasher)),
+			Config:          string(content),
+		}
+	}
+
+	// 资产不符合电路参数的用户证明返回ErrMalformedInput
+	maxTier := utils.AssetCountsTiers[len(utils.AssetCountsTiers)-1]
+	tooManyAssets := make([]utils.AccountAsset, maxTier+1)
+	for i := range tooManyAssets {
+		tooManyAssets[i].Index = uint16(i)
+	}
+	for _, c := range []struct {
+		name   string
+		assets []utils.AccountAsset
+	}{
+		{"too many assets", tooManyAssets},
+		{"index out of range", []utils.AccountAsset{{Index: uint16(utils.AssetCounts)}}},
+		{"duplicate index", []utils.AccountAsset{{Index: 1}, {Index: 1}}},
+		{"decreasing index", []utils.AccountAsset{{Index: 2}, {Index: 1}}},
+	} {
+		var userConfig userverifier.UserConfig
+		if err = json.Unmarshal([]byte(rows[0].Config), &userConfig); err != nil {
+			t.Fatal(err)
+		}
+		userConfig.Assets = c.assets
+		if _, err = userverifier.VerifyUserProof(&userConfig); !errors.Is(err, ErrMalformedInput) {
+			t.Fatalf("%s: expect ErrMalformedInput but got %v", c.name, err)
+		}
+	}
+
+	// JSON lines, 包括无法解码的行
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.WriteString(row.Config + "\n")
+	}
+	buf.WriteString("{invalid\n")
+	// 资产数量超过最大档位的用户证明作为验证失败的账户报告, 而不是中止批量验证
+	var oversized userverifier.UserConfig
+	if err = json.Unmarshal([]byte(rows[1].Config), &oversized); err != nil {
+		t.Fatal(err)
+	}
+	oversized.Assets = tooManyAssets
+	line, _ := json.Marshal(&oversized)
+	buf.Write(line)
+	buf.WriteString("\n")
+	jsonlFile := filepath.Join(t.TempDir(), "users.jsonl")
+	if err = os.WriteFile(jsonlFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewUserProofJsonlReader(jsonlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	result, err := VerifyUserProofs(reader, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Root != root || result.UserCount != 5 || len(result.Failures) != 3 ||
+		!errors.Is(result.Failures[0], ErrMalformedInput) ||
+		result.Failures[1].AccountIndex != 1 || result.Failures[1].Kind != ErrMalformedInput ||
+		result.Failures[2].AccountIndex != 2 || !errors.Is(result.Failures[2], ErrProofInvalid) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// 第一个用户证明的账户树根为空时使用之后的账户树根, 需要在go test -race下运行以检查数据竞争
+	var emptyRoot userverifier.UserConfig
+	if err = json.Unmarshal([]byte(rows[0].Config), &emptyRoot); err != nil {
+		t.Fatal(err)
+	}
+	emptyRoot.Root = ""
+	line, _ = json.Marshal(&emptyRoot)
+	buf.Reset()
+	buf.Write(line)
+	buf.WriteString("\n")
+	for i := 0; i < 16; i++ {
+		buf.WriteString(rows[i%2].Config + "\n")
+	}
+	if err = os.WriteFile(jsonlFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	emptyRootReader, err := NewUserProofJsonlReader(jsonlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emptyRootReader.Close()
+	result, err = VerifyUserProofs(emptyRootReader, "", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Root != root || result.UserCount != 17 || len(result.Failures) != 1 ||
+		result.Failures[0].AccountIndex != 0 || !errors.Is(result.Failures[0], ErrCommitmentMismatch) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// 用户证明表, 检查表中记录的叶子节点哈希和公布的账户树根
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userProofModel := model.NewUserProofModel(db, "")
+	if err = userProofModel.CreateUserProofTable(); err != nil {
+		t.Fatal(err)
+	}
+	rows[1].AccountLeafHash = rows[0].AccountLeafHash
+	if err = userProofModel.CreateUserProofs(rows); err != nil {
+		t.Fatal(err)
+	}
+	dbReader, err := NewUserProofDbReader(userProofModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = VerifyUserProofs(dbReader, root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.UserCount != 3 || len(result.Failures) != 2 ||
+		result.Failures[0].AccountIndex != 1 || !errors.Is(result.Failures[0], ErrCommitmentMismatch) ||
+		result.Failures[1].AccountIndex != 2 || !errors.Is(result.Failures[1], ErrProofInvalid) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	dbReader, _ = NewUserProofDbReader(userProofModel)
+	result, err = VerifyUserProofs(dbReader, hex.EncodeToString(bytes.Repeat([]byte{1}, 32)), 0)
+	if err != nil || len(result.Failures) != 3 || !errors.Is(result.Failures[0], ErrCommitmentMismatch) {
+		t.Fatalf("expect all proofs against another root to fail but got %+v: %v", result, err)
+	}
+}
+
+// TestVerifyShuffleSeed 测试用公开的置换种子检查导出的用户证明的账户索引
+func TestVerifyShuffleSeed(t *testing.T) {
+	if err := utils.InitCircuitParams(""); err != nil {
+		t.Fatal(err)
+	}
+	tier := utils.AssetCountsTiers[0]
+	users := make([]utils.AccountInfo, 5)
+	for i := range users {
+		users[i] = utils.AccountInfo{
+			AccountIndex:    uint32(i),
+			AccountId:       bytes.Repeat([]byte{byte(i + 1)}, 32),
+			TotalEquity:     big.NewInt(0),
+			TotalDebt:       big.NewInt(0),
+			TotalCollateral: big.NewInt(0),
+		}
+	}
+	seed := bytes.Repeat([]byte{9}, utils.ShuffleSeedSize)
+	round := utils.PrepareRoundAccounts(nil, 0, map[int][]utils.AccountInfo{tier: users}, seed)
+	manifest := &model.ExportManifest{
+		ShuffleSeedCommitment: hex.EncodeToString(utils.ComputeShuffleSeedCommitment(seed)),
+		CreateStartIndex:      int64(round.CreateStartIndex),
+		NextAccountIndex:      int64(round.NextAccountIndex),
+	}
+	indexes := make([]uint32, len(users))
+	for i := range users {
+		indexes[i] = users[i].AccountIndex
+	}
+	paddingIndex := round.Creates[tier][len(users)].AccountIndex
+	openRecords := func(indexes []uint32) UserProofReader {
+		var content bytes.Buffer
+		for _, index := range indexes {
+			line, _ := json.Marshal(&userverifier.UserConfig{AccountIndex: index})
+			content.Write(append(line, '\n'))
+		}
+		fileName := filepath.Join(t.TempDir(), "users.jsonl")
+		if err := os.WriteFile(fileName, content.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewUserProofJsonlReader(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { reader.Close() })
+		return reader
+	}
+
+	if err := VerifyShuffleSeed(manifest, openRecords(indexes), seed); err != nil {
+		t.Fatalf("verify shuffle seed failed: %v", err)
+	}
+	cases := []struct {
+		name     string
+		manifest model.ExportManifest
+		indexes  []uint32
+		seed     []byte
+		expect   error
+	}{
+		{"another seed", *manifest, indexes, bytes.Repeat([]byte{8}, utils.ShuffleSeedSize), ErrCommitmentMismatch},
+		{"user at a padding index", *manifest, append([]uint32{paddingIndex}, indexes[1:]...), seed, ErrCommitmentMismatch},
+		{"duplicate index", *manifest, append([]uint32{indexes[1]}, indexes[1:]...), seed, ErrCommitmentMismatch},
+		{"index out of range", *manifest, append([]uint32{uint32(round.NextAccountIndex)}, indexes[1:]...), seed, ErrCommitmentMismatch},
+		{"not shuffled", model.ExportManifest{NextAccountIndex: manifest.NextAccountIndex}, indexes, seed, ErrMalformedInput},
+		{"no index range", model.ExportManifest{ShuffleSeedCommitment: manifest.ShuffleSeedCommitment}, indexes, seed, ErrMalformedInput},
+	}
+	for _, c := range cases {
+		if err := VerifyShuffleSeed(&c.manifest, openRecords(c.indexes), c.seed); !errors.Is(err, c.expect) {
+			t.Fatalf("%s: expect %v but got %v", c.name, c.expect, err)
+		}
+	}
+
+	// 索引范围之前的账户属于上一轮审计, 不检查
+	previous := *manifest
+	previous.CreateStartIndex, previous.NextAccountIndex = 10, 10+manifest.NextAccountIndex
+	shifted := []uint32{3}
+	for _, index := range indexes {
+		shifted = append(shifted, index+10)
+	}
+	if err := VerifyShuffleSeed(&previous, openRecords(shifted), seed); err != nil {
+		t.Fatalf("verify shuffle seed of an incremental round failed: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build js && wasm
+
+// wasm 将用户证明验证编译为WebAssembly, 供网页和Node.js调用
+// 编译:
+//
+//	GOOS=js GOARCH=wasm go build -o verifier.wasm ./src/verifier/wasm
+//
+// 加载Go的wasm_exec.js并运行verifier.wasm后, 全局对象上注册以下函数:
+//   - zkposVerifyUserProof(userConfig, circuitParams): 验证user_config.json格式的用户证明,
+//     circuitParams为电路参数文件的JSON内容, 省略或为空时使用默认的电路参数.
+//     userConfig中填写了用户自己的AccountId时, 检查AccountIdHash由AccountId和Salt计算得到.
+//     返回{passed, leafHash, error}, leafHash为hex编码的账户叶子节点哈希, 用户证明无法解码时为空
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/userverifier"
+)
+
+// verifyUserProof 使用电路参数解码用户证明, 并通过userverifier.VerifyUserProof验证, 与verifier -user的检查相同
+// 返回:
+//   - []byte: 账户叶子节点哈希, 用户证明无法解码时为nil
+//   - bool: Merkle证明是否通过验证
+//   - error: 用户证明或电路参数无法解码, 或AccountIdHash与填写的AccountId和Salt不一致时返回错误
+func verifyUserProof(userConfigJson string, circuitParamsJson string) ([]byte, bool, error) {
+	params := utils.DefaultCircuitParams()
+	if circuitParamsJson != "" {
+		params = &utils.CircuitParams{}
+		if err := json.Unmarshal([]byte(circuitParamsJson), params); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := utils.SetCircuitParams(params); err != nil {
+		return nil, false, err
+	}
+
+	userConfig := &userverifier.UserConfig{}
+	if err := json.Unmarshal([]byte(userConfigJson), userConfig); err != nil {
+		return nil, false, err
+	}
+	accountHash, err := userverifier.VerifyUserProof(userConfig)
+	if errors.Is(err, userverifier.ErrProofInvalid) {
+		return accountHash, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return accountHash, true, nil
+}
+
+func main() {
+	js.Global().Set("zkposVerifyUserProof", js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 1 || args[0].Type() != js.TypeString {
+			return map[string]any{"passed": false, "leafHash": "", "error": "the user config should be a json string"}
+		}
+		circuitParams := ""
+		if len(args) > 1 && args[1].Type() == js.TypeString {
+			circuitParams = args[1].String()
+		}
+		leafHash, passed, err := verifyUserProof(args[0].String(), circuitParams)
+		if err != nil {
+			return map[string]any{"passed": false, "leafHash": "", "error": err.Error()}
+		}
+		return map[string]any{"passed": passed, "leafHash": hex.EncodeToString(leafHash), "error": ""}
+	}))
+	// 保持运行, 以便JS多次调用
+	select {}
+}
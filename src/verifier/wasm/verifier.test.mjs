@@ -0,0 +1,109 @@
+// Node.js tests of the WebAssembly user proof verifier, run from the repository root with:
+//   node --test src/verifier/wasm/
+// The test builds verifier.wasm with the go command in PATH.
+import { test, before } from "node:test";
+import assert from "node:assert/strict";
+import { execFileSync } from "node:child_process";
+import { existsSync, mkdtempSync, readFileSync } from "node:fs";
+import { tmpdir } from "node:os";
+import path from "node:path";
+import { fileURLToPath } from "node:url";
+
+const wasmDir = path.dirname(fileURLToPath(import.meta.url));
+const repoRoot = path.resolve(wasmDir, "../../..");
+// generated by the go account tree, the leaf hash is computed by utils.AccountInfoToHash
+const userConfig = JSON.parse(readFileSync(path.join(wasmDir, "testdata/user_config.json"), "utf8"));
+const expectLeafHash = "228fdc00da3c222579d4ae9ad74280d87c4071d5b9ecc6a5595d8ee5b7514bf9";
+
+before(async () => {
+  const wasmFile = path.join(mkdtempSync(path.join(tmpdir(), "zkpos-wasm-")), "verifier.wasm");
+  execFileSync("go", ["build", "-o", wasmFile, "./src/verifier/wasm"], {
+    cwd: repoRoot,
+    env: { ...process.env, GOOS: "js", GOARCH: "wasm" },
+    stdio: "inherit",
+  });
+  // wasm_exec.js is in lib/wasm since go 1.24 and in misc/wasm before
+  const goroot = execFileSync("go", ["env", "GOROOT"], { encoding: "utf8" }).trim();
+  const wasmExec = ["lib/wasm/wasm_exec.js", "misc/wasm/wasm_exec.js"]
+    .map((p) => path.join(goroot, p))
+    .find((p) => existsSync(p));
+  await import(wasmExec);
+  // the go program keeps running to serve the calls, and the ants pool imported by the account tree
+  // keeps a ticker in it, so its timers must not keep node alive after the tests
+  const setTimeoutRef = globalThis.setTimeout;
+  globalThis.setTimeout = (...args) => setTimeoutRef(...args).unref();
+  const go = new globalThis.Go();
+  const { instance } = await WebAssembly.instantiate(readFileSync(wasmFile), go.importObject);
+  go.run(instance);
+});
+
+test("valid user proof passes", () => {
+  const result = globalThis.zkposVerifyUserProof(JSON.stringify(userConfig));
+  assert.equal(result.error, "");
+  assert.equal(result.passed, true);
+  assert.equal(result.leafHash, expectLeafHash);
+});
+
+test("tampered user proof fails with a different leaf hash", () => {
+  const tampered = { ...userConfig, Assets: userConfig.Assets.map((a, i) => (i === 0 ? { ...a, Equity: a.Equity + 1 } : a)) };
+  const result = globalThis.zkposVerifyUserProof(JSON.stringify(tampered));
+  assert.equal(result.error, "");
+  assert.equal(result.passed, false);
+  assert.match(result.leafHash, /^[0-9a-f]{64}$/);
+  assert.notEqual(result.leafHash, expectLeafHash);
+});
+
+test("wrong root fails", () => {
+  const result = globalThis.zkposVerifyUserProof(JSON.stringify({ ...userConfig, Root: "00".repeat(32) }));
+  assert.equal(result.error, "");
+  assert.equal(result.passed, false);
+});
+
+test("malformed input reports an error", () => {
+  let result = globalThis.zkposVerifyUserProof("{invalid");
+  assert.equal(result.passed, false);
+  assert.notEqual(result.error, "");
+
+  result = globalThis.zkposVerifyUserProof(JSON.stringify({ ...userConfig, Root: "invalid" }));
+  assert.equal(result.error, "malformed input: invalid account tree root");
+  assert.equal(result.leafHash, "");
+
+  result = globalThis.zkposVerifyUserProof(JSON.stringify(userConfig), "{invalid");
+  assert.equal(result.passed, false);
+  assert.notEqual(result.error, "");
+});
+
+test("too many assets reports an error", () => {
+  // the largest assets count tier of the default circuit params is 500
+  const assets = Array.from({ length: 501 }, (_, i) => ({ Index: i, Equity: 0, Debt: 0, Loan: 0, Margin: 0, PortfolioMargin: 0 }));
+  const result = globalThis.zkposVerifyUserProof(JSON.stringify({ ...userConfig, Assets: assets }));
+  assert.equal(result.passed, false);
+  assert.equal(result.leafHash, "");
+  assert.match(result.error, /^malformed input: the user has 501 assets/);
+});
+
+test("account id must match the AccountIdHash", () => {
+  // the fixture is not salted, so its AccountIdHash is the account id itself
+  let result = globalThis.zkposVerifyUserProof(JSON.stringify({ ...userConfig, AccountId: userConfig.AccountIdHash }));
+  assert.equal(result.error, "");
+  assert.equal(result.passed, true);
+
+  result = globalThis.zkposVerifyUserProof(JSON.stringify({ ...userConfig, AccountId: "01".repeat(32) }));
+  assert.equal(result.passed, false);
+  assert.equal(result.error, "commitment mismatch: the AccountIdHash is not derived from the AccountId and Salt");
+});
+
+test("circuit params must match the account tree", () => {
+  const params = JSON.stringify({
+    AssetCounts: 500,
+    TierCount: 12,
+    AccountTreeDepth: 32,
+    BatchCreateUserOpsCountsTiers: { 500: 92, 50: 700 },
+    BatchUpdateUserOpsCountsTiers: { 500: 46, 50: 350 },
+    BatchDeleteUserOpsCountsTiers: { 500: 92, 50: 700 },
+  });
+  const result = globalThis.zkposVerifyUserProof(JSON.stringify(userConfig), params);
+  assert.equal(result.passed, false);
+  // the default circuit params are used again when omitted
+  assert.equal(globalThis.zkposVerifyUserProof(JSON.stringify(userConfig)).passed, true);
+});
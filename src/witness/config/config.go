@@ -4,6 +4,21 @@ type Config struct {
 	MysqlDataSource string
 	UserDataFile    string
 	DbSuffix        string
+	// 电路参数文件, 为空时使用默认的电路参数, 必须与keygen使用的相同
+	CircuitParamsFile string
+	// 增量审计: 上一轮审计的用户数据目录, 为空时对所有用户生成创建操作
+	BaseUserDataFile string
+	// 增量审计: 上一轮审计witness的DbSuffix, 上一轮的账户索引从该轮保存的账户索引表中读取
+	BaseDbSuffix string
+	// 增量审计: 上一轮审计结束时账户树的版本
+	BaseTreeVersion int64
+	// 审计轮次编号和快照时间戳(unix秒), 会绑定到每个批次的承诺中
+	RoundId   uint64
+	Timestamp uint64
+	// 账户盐值密钥文件, 为空时叶子节点使用账户ID本身, 必须与userproof使用的相同
+	AccountSaltKeyFile string
+	// 账户索引置换种子文件, 为空时按用户数据的顺序分配账户索引, 必须与userproof使用的相同
+	ShuffleSeedFile string
 	TreeDB          struct {
 		Driver string
 		Option struct {
@@ -31,11 +31,37 @@ func main() {
 		}
 		witnessConfig.MysqlDataSource = s
 	}
+	err = utils.InitCircuitParams(witnessConfig.CircuitParamsFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("circuit params digest is", utils.GetCircuitParams().Digest())
 	// 2. 加载用户数据
 	accounts, cexAssetsInfo, err := utils.ParseUserDataSet(witnessConfig.UserDataFile)
 	if err != nil {
 		panic(err.Error())
 	}
+	// 每轮审计每个账户使用不同的盐值, 同一账户在不同轮次的叶子节点无法关联
+	// 盐值每轮都变化, 增量审计会在原索引更新所有账户, 叶子节点的位置仍然可以关联, 所以加盐的轮次只能是完整审计
+	if witnessConfig.AccountSaltKeyFile != "" && witnessConfig.BaseUserDataFile != "" {
+		panic("the salted round should be a full round, AccountSaltKeyFile and BaseUserDataFile can not be set together")
+	}
+	if witnessConfig.AccountSaltKeyFile != "" {
+		saltKey, err := utils.LoadAccountSaltKey(witnessConfig.AccountSaltKeyFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		utils.SaltAccounts(accounts, saltKey, witnessConfig.RoundId)
+	}
+	// 账户索引置换种子, 种子承诺与审计轮次一起公布
+	var shuffleSeed []byte
+	if witnessConfig.ShuffleSeedFile != "" {
+		shuffleSeed, err = utils.LoadShuffleSeed(witnessConfig.ShuffleSeedFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("account index shuffle seed commitment is %x\n", utils.ComputeShuffleSeedCommitment(shuffleSeed))
+	}
 	// 3. 加载账户树
 	accountTree, err := utils.NewAccountTree(witnessConfig.TreeDB.Driver, witnessConfig.TreeDB.Option.Addr)
 	if err != nil {
@@ -44,13 +70,53 @@ func main() {
 	fmt.Println("account tree init height is ", accountTree.LatestVersion())
 	fmt.Printf("account tree root is %x\n", accountTree.Root())
 
-	totalAccountNum := 0
-	for k, v := range accounts {
-		totalAccountNum += len(v)
+	db, err := witness.OpenWitnessDatabase(witnessConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	// 增量审计: 账户树中已经包含上一轮审计的账户, 只对新增和变化的账户生成操作
+	// 上一轮的用户数据必须是构建当前账户树时使用的用户数据, 账户索引从上一轮witness保存的账户索引表中读取
+	var baseAccounts map[int][]utils.AccountInfo
+	baseNextAccountIndex := 0
+	if witnessConfig.BaseUserDataFile != "" {
+		var baseCexAssetsInfo []utils.CexAssetInfo
+		baseAccounts, baseCexAssetsInfo, baseNextAccountIndex, err = witness.LoadBaseAccounts(db, witnessConfig.BaseUserDataFile,
+			witnessConfig.BaseDbSuffix)
+		if err != nil {
+			panic(err.Error())
+		}
+		if len(baseCexAssetsInfo) != len(cexAssetsInfo) {
+			panic("the cex assets of base user data set are different from current user data set")
+		}
+		for i := 0; i < len(cexAssetsInfo); i++ {
+			if baseCexAssetsInfo[i].Symbol != cexAssetsInfo[i].Symbol {
+				panic("the cex assets of base user data set are different from current user data set")
+			}
+		}
+		utils.AccumulateCexAssets(cexAssetsInfo, baseAccounts)
+	}
+	round := utils.PrepareRoundAccounts(baseAccounts, baseNextAccountIndex, accounts, shuffleSeed)
+	for k, v := range round.Creates {
 		fmt.Println("the asset counts of user is ", k, "total ops number is ", len(v))
 	}
+	for k, v := range round.Updates {
+		fmt.Println("the asset counts of user is ", k, "total update ops number is ", len(v))
+	}
+	for k, v := range round.Deletes {
+		fmt.Println("the asset counts of user is ", k, "total delete ops number is ", len(v))
+	}
+	// 保存本轮审计的账户索引和置换信息, 供userproof和下一轮增量审计使用
+	accountIndexModel := witness.NewAccountIndexModel(db, witnessConfig.DbSuffix)
+	err = accountIndexModel.CreateAccountIndexTable()
+	if err != nil {
+		panic(err.Error())
+	}
+	err = accountIndexModel.SaveAccountIndexes(accounts, witness.NewAccountRound(accounts, round, shuffleSeed))
+	if err != nil {
+		panic(err.Error())
+	}
 	// 4. 创建见证服务
-	witnessService := witness.NewWitness(accountTree, uint32(totalAccountNum), accounts, cexAssetsInfo, witnessConfig)
+	witnessService := witness.NewWitness(accountTree, round, cexAssetsInfo, witnessConfig)
 	// 5. 运行见证服务
 	witnessService.Run()
 	fmt.Println("witness service run finished...")
@@ -0,0 +1,236 @@
+package witness
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+
+	"gorm.io/gorm"
+)
+
+// 账户索引表名前缀
+const (
+	AccountIndexTableNamePrefix = `accountindex`
+	AccountRoundTableNamePrefix = `accountround`
+)
+
+// AccountIndexModel 保存每轮审计账户在账户树中的索引
+// 下一轮增量审计和userproof从这里读取账户索引, 而不是根据用户数据的顺序重新计算
+type AccountIndexModel interface {
+	CreateAccountIndexTable() error                                                           // 创建账户索引表
+	DropAccountIndexTable() error                                                             // 删除账户索引表
+	SaveAccountIndexes(accounts map[int][]utils.AccountInfo, round *AccountRound) error       // 保存本轮审计的账户索引
+	GetAccountRound() (round *AccountRound, err error)                                        // 获取本轮审计的账户索引信息
+	LoadAccountIndexes(accounts map[int][]utils.AccountInfo) (round *AccountRound, err error) // 把保存的账户索引设置到账户中
+}
+
+// AccountIndex 账户在账户树中的索引, 不包括填充账户
+type AccountIndex struct {
+	AccountId    string `gorm:"index:idx_account_id,unique"`    // hex编码的账户ID(不加盐)
+	AccountIndex uint32 `gorm:"index:idx_account_index,unique"` // 账户在账户树中的索引
+	AssetsCount  int64  // 账户的资产数量分组
+}
+
+// AccountRound 本轮审计的账户索引信息, 在所有账户索引之后写入, 存在时说明账户索引已经完整保存
+type AccountRound struct {
+	gorm.Model
+	AccountCount     int64 // 账户数量, 不包括填充账户
+	CreateStartIndex int64 // 本轮新建账户和填充账户的第一个索引, 账户索引置换的起始索引
+	NextAccountIndex int64 // 本轮审计结束后已使用的索引数量(包括填充账户), 下一轮新建账户从该索引开始
+	// 账户索引置换种子的承诺SHA256(seed)(hex编码), 没有置换账户索引时为空, 与审计轮次一起公布
+	ShuffleSeedCommitment string
+	Salted                bool // 账户ID是否加盐, 加盐的轮次不能作为增量审计的上一轮
+}
+
+// NewAccountRound 根据PrepareRoundAccounts的结果创建本轮审计的账户索引信息
+// 参数:
+//   - accounts: 本轮审计的账户, 不包括填充账户
+//   - round: PrepareRoundAccounts返回的账户操作
+//   - shuffleSeed: 账户索引置换种子, 为空时没有置换
+func NewAccountRound(accounts map[int][]utils.AccountInfo, round *utils.RoundAccounts, shuffleSeed []byte) *AccountRound {
+	accountRound := &AccountRound{
+		CreateStartIndex: int64(round.CreateStartIndex),
+		NextAccountIndex: int64(round.NextAccountIndex),
+	}
+	for _, v := range accounts {
+		accountRound.AccountCount += int64(len(v))
+		for i := range v {
+			accountRound.Salted = accountRound.Salted || len(v[i].Salt) > 0
+		}
+	}
+	if len(shuffleSeed) > 0 {
+		accountRound.ShuffleSeedCommitment = hex.EncodeToString(utils.ComputeShuffleSeedCommitment(shuffleSeed))
+	}
+	return accountRound
+}
+
+// SameIndexes 检查两个账户索引信息分配的账户索引是否相同
+func (r *AccountRound) SameIndexes(other *AccountRound) bool {
+	return r.AccountCount == other.AccountCount &&
+		r.CreateStartIndex == other.CreateStartIndex &&
+		r.NextAccountIndex == other.NextAccountIndex &&
+		r.ShuffleSeedCommitment == other.ShuffleSeedCommitment &&
+		r.Salted == other.Salted
+}
+
+// defaultAccountIndexModel 默认账户索引模型实现
+type defaultAccountIndexModel struct {
+	indexTable string   // 账户索引表名
+	roundTable string   // 账户索引信息表名
+	DB         *gorm.DB // 数据库连接
+}
+
+// NewAccountIndexModel 创建新的账户索引模型, suffix与该轮审计witness的DbSuffix相同
+func NewAccountIndexModel(db *gorm.DB, suffix string) AccountIndexModel {
+	return &defaultAccountIndexModel{
+		indexTable: AccountIndexTableNamePrefix + suffix,
+		roundTable: AccountRoundTableNamePrefix + suffix,
+		DB:         db,
+	}
+}
+
+// CreateAccountIndexTable 创建账户索引表
+func (m *defaultAccountIndexModel) CreateAccountIndexTable() error {
+	err := m.DB.Table(m.indexTable).AutoMigrate(AccountIndex{})
+	if err != nil {
+		return err
+	}
+	return m.DB.Table(m.roundTable).AutoMigrate(AccountRound{})
+}
+
+// DropAccountIndexTable 删除账户索引表
+func (m *defaultAccountIndexModel) DropAccountIndexTable() error {
+	err := m.DB.Migrator().DropTable(m.indexTable)
+	if err != nil {
+		return err
+	}
+	return m.DB.Migrator().DropTable(m.roundTable)
+}
+
+// SaveAccountIndexes 在一个事务中保存本轮审计的账户索引
+// 已经保存过时检查账户索引信息是否相同, witness重启时可以重复调用
+// 参数:
+//   - accounts: 本轮审计的账户, 账户索引为PrepareRoundAccounts分配的索引, 不包括填充账户
+//   - round: 本轮审计的账户索引信息, 见NewAccountRound
+func (m *defaultAccountIndexModel) SaveAccountIndexes(accounts map[int][]utils.AccountInfo, round *AccountRound) error {
+	saved, err := m.GetAccountRound()
+	if err == nil {
+		if !saved.SameIndexes(round) {
+			return errors.New("the saved account indexes are different from current round")
+		}
+		return nil
+	}
+	if err != utils.DbErrNotFound {
+		return err
+	}
+	rows := make([]AccountIndex, 0, round.AccountCount)
+	for k, v := range accounts {
+		for i := range v {
+			rows = append(rows, AccountIndex{
+				AccountId:    hex.EncodeToString(v[i].AccountId),
+				AccountIndex: v[i].AccountIndex,
+				AssetsCount:  int64(k),
+			})
+		}
+	}
+	if int64(len(rows)) != round.AccountCount {
+		return fmt.Errorf("expect %d accounts but got %d", round.AccountCount, len(rows))
+	}
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			dbTx := tx.Table(m.indexTable).CreateInBatches(rows, 1000)
+			if dbTx.Error != nil {
+				return dbTx.Error
+			}
+		}
+		return tx.Table(m.roundTable).Create(round).Error
+	})
+}
+
+// GetAccountRound 获取本轮审计的账户索引信息, 账户索引没有保存时返回DbErrNotFound
+func (m *defaultAccountIndexModel) GetAccountRound() (round *AccountRound, err error) {
+	if !m.DB.Migrator().HasTable(m.roundTable) {
+		return nil, utils.DbErrNotFound
+	}
+	dbTx := m.DB.Table(m.roundTable).Limit(1).Find(&round)
+	if dbTx.Error != nil {
+		return nil, utils.DbErrSqlOperation
+	} else if dbTx.RowsAffected == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return round, nil
+}
+
+// LoadAccountIndexes 把保存的账户索引设置到账户中
+// 账户必须与保存时的账户完全相同(账户ID和资产数量分组), 否则返回错误
+// 参数:
+//   - accounts: 该轮审计的用户数据, 原地设置账户索引
+//
+// 返回:
+//   - round: 该轮审计的账户索引信息
+func (m *defaultAccountIndexModel) LoadAccountIndexes(accounts map[int][]utils.AccountInfo) (round *AccountRound, err error) {
+	round, err = m.GetAccountRound()
+	if err != nil {
+		return nil, err
+	}
+	count := int64(0)
+	for _, v := range accounts {
+		count += int64(len(v))
+	}
+	if count != round.AccountCount {
+		return nil, fmt.Errorf("expect %d accounts but got %d", round.AccountCount, count)
+	}
+	indexes := make(map[string]AccountIndex, count)
+	var rows []AccountIndex
+	dbTx := m.DB.Table(m.indexTable).FindInBatches(&rows, 10000, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			indexes[row.AccountId] = row
+		}
+		return nil
+	})
+	if dbTx.Error != nil {
+		return nil, dbTx.Error
+	}
+	for k, v := range accounts {
+		for i := range v {
+			row, ok := indexes[hex.EncodeToString(v[i].AccountId)]
+			if !ok || row.AssetsCount != int64(k) {
+				return nil, fmt.Errorf("the account %x is not saved with assets count %d", v[i].AccountId, k)
+			}
+			v[i].AccountIndex = row.AccountIndex
+		}
+	}
+	return round, nil
+}
+
+// LoadBaseAccounts 加载增量审计的上一轮账户
+// 上一轮审计的账户索引必须已经由witness保存, 无论上一轮是完整审计还是增量审计
+// 参数:
+//   - db: 数据库连接
+//   - baseUserDataFile: 上一轮审计的用户数据目录
+//   - baseDbSuffix: 上一轮审计witness的DbSuffix, 上一轮不能加盐
+//
+// 返回:
+//   - map[int][]utils.AccountInfo: 上一轮审计的账户, 账户索引为账户树中的索引
+//   - []utils.CexAssetInfo: 上一轮审计的CEX资产信息
+//   - int: 上一轮审计结束后已使用的索引数量
+func LoadBaseAccounts(db *gorm.DB, baseUserDataFile string, baseDbSuffix string) (map[int][]utils.AccountInfo, []utils.CexAssetInfo, int, error) {
+	accounts, cexAssets, err := utils.ParseUserDataSet(baseUserDataFile)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	round, err := NewAccountIndexModel(db, baseDbSuffix).LoadAccountIndexes(accounts)
+	if err == utils.DbErrNotFound {
+		return nil, nil, 0, fmt.Errorf("the account indexes of base round %s are not saved", baseDbSuffix)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// 账户树中上一轮的叶子节点是加盐的账户ID哈希, 不能在其上增量审计
+	if round.Salted {
+		return nil, nil, 0, fmt.Errorf("the base round %s is salted and can not be the base of an incremental round", baseDbSuffix)
+	}
+	return accounts, cexAssets, int(round.NextAccountIndex), nil
+}
@@ -0,0 +1,90 @@
+package witness
+
+import (
+	"encoding/hex"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestAccountIndexModelSqlite 测试保存的账户索引可以被下一轮重新加载, 以及用户数据不一致时被拒绝
+func TestAccountIndexModelSqlite(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAccounts := func() map[int][]utils.AccountInfo {
+		accounts := make(map[int][]utils.AccountInfo)
+		for i := 0; i < 4; i++ {
+			accounts[i%2+1] = append(accounts[i%2+1], utils.AccountInfo{
+				AccountIndex: uint32(i),
+				AccountId:    big.NewInt(int64(i + 1)).FillBytes(make([]byte, 32)),
+			})
+		}
+		return accounts
+	}
+	seed := []byte("seed")
+	expected := &AccountRound{AccountCount: 4, CreateStartIndex: 4, NextAccountIndex: 12, ShuffleSeedCommitment: hex.EncodeToString(utils.ComputeShuffleSeedCommitment(seed))}
+	if !NewAccountRound(newAccounts(), &utils.RoundAccounts{CreateStartIndex: 4, NextAccountIndex: 12}, seed).SameIndexes(expected) {
+		t.Fatal("unexpected account round")
+	}
+	if NewAccountRound(newAccounts(), &utils.RoundAccounts{CreateStartIndex: 4, NextAccountIndex: 12}, nil).SameIndexes(expected) {
+		t.Fatal("expect the account round without shuffle seed to be different")
+	}
+	salted := newAccounts()
+	salted[1][0].Salt = []byte{1}
+	if !NewAccountRound(salted, &utils.RoundAccounts{}, nil).Salted {
+		t.Fatal("expect the round with salted accounts to be salted")
+	}
+
+	accountIndexModel := NewAccountIndexModel(db, "0")
+	if _, err = accountIndexModel.GetAccountRound(); err != utils.DbErrNotFound {
+		t.Fatalf("expect DbErrNotFound before the table is created but got %v", err)
+	}
+	if err = accountIndexModel.CreateAccountIndexTable(); err != nil {
+		t.Fatal(err)
+	}
+	// 账户索引与用户数据的顺序不同
+	accounts := newAccounts()
+	accounts[1][0].AccountIndex, accounts[2][1].AccountIndex = 3, 0
+	round := &AccountRound{AccountCount: 4, NextAccountIndex: 8, ShuffleSeedCommitment: "ab"}
+	if err = accountIndexModel.SaveAccountIndexes(accounts, round); err != nil {
+		t.Fatal(err)
+	}
+	// witness重启时重复保存
+	if err = accountIndexModel.SaveAccountIndexes(accounts, &AccountRound{AccountCount: 4, NextAccountIndex: 8, ShuffleSeedCommitment: "ab"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = accountIndexModel.SaveAccountIndexes(accounts, &AccountRound{AccountCount: 4, NextAccountIndex: 16, ShuffleSeedCommitment: "ab"}); err == nil {
+		t.Fatal("expect different account indexes of the same round to be rejected")
+	}
+	if err = accountIndexModel.SaveAccountIndexes(accounts, &AccountRound{AccountCount: 4, NextAccountIndex: 8}); err == nil {
+		t.Fatal("expect a different shuffle seed of the same round to be rejected")
+	}
+
+	loaded := newAccounts()
+	saved, err := accountIndexModel.LoadAccountIndexes(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.NextAccountIndex != 8 || saved.ShuffleSeedCommitment != "ab" || loaded[1][0].AccountIndex != 3 || loaded[2][1].AccountIndex != 0 || loaded[1][1].AccountIndex != 2 {
+		t.Fatal("expect the saved account indexes")
+	}
+	moved := newAccounts()
+	moved[2] = append(moved[2], moved[1][0])
+	moved[1] = moved[1][1:]
+	if _, err = accountIndexModel.LoadAccountIndexes(moved); err == nil {
+		t.Fatal("expect the account in another assets count tier to be rejected")
+	}
+	if _, err = accountIndexModel.LoadAccountIndexes(map[int][]utils.AccountInfo{1: newAccounts()[1]}); err == nil {
+		t.Fatal("expect the missing accounts to be rejected")
+	}
+	if _, err = NewAccountIndexModel(db, "1").LoadAccountIndexes(newAccounts()); err != utils.DbErrNotFound {
+		t.Fatalf("expect DbErrNotFound for the round without saved indexes but got %v", err)
+	}
+}
@@ -1,10 +1,8 @@
 package witness
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/gob"
 	"fmt"
+	"hash"
 	"log"
 	"os"
 	"runtime"
@@ -18,33 +16,34 @@ import (
 	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/config"
 	bsmt "github.com/bnb-chain/zkbnb-smt"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
-	"github.com/klauspost/compress/s2"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 // Witness 结构体定义了见证数据生成器
 type Witness struct {
-	accountTree        bsmt.SparseMerkleTree       // 账户Merkle树
-	totalOpsNumber     uint32                      // 总操作数
-	witnessModel       WitnessModel                // 数据库模型
-	ops                map[int][]utils.AccountInfo // 用户账户信息(按资产数量分组)
-	cexAssets          []utils.CexAssetInfo        // CEX资产信息
-	db                 *gorm.DB                    // 数据库连接
-	ch                 chan BatchWitness           // 批次见证数据通道
-	quit               chan int                    // 退出信号通道
-	accountHashChan    map[int][]chan []byte       // 账户哈希通道(按资产组分类)
-	currentBatchNumber int64                       // 当前批次号
+	accountTree        bsmt.SparseMerkleTree         // 账户Merkle树
+	witnessModel       WitnessModel                  // 数据库模型
+	ops                map[int][]utils.AccountInfo   // 用户账户信息(按资产数量分组)
+	updateOps          map[int][]utils.AccountUpdate // 需要更新的用户账户信息(按资产数量分组)
+	deleteOps          map[int][]utils.AccountInfo   // 需要删除的用户账户信息(按资产数量分组)
+	cexAssets          []utils.CexAssetInfo          // CEX资产信息
+	db                 *gorm.DB                      // 数据库连接
+	ch                 chan BatchWitness             // 批次见证数据通道
+	quit               chan int                      // 退出信号通道
+	accountHashChan    map[int][]chan []byte         // 账户哈希通道(按资产组分类)
+	currentBatchNumber int64                         // 当前批次号
+	baseTreeVersion    int64                         // 增量审计时上一轮审计结束时账户树的版本
+	roundId            uint64                        // 审计轮次编号
+	timestamp          uint64                        // 审计快照的时间戳(unix秒)
 	// 批次号映射
-	batchNumberMappingKeys   []int // 资产数量键
-	batchNumberMappingValues []int // 对应的批次值
+	batchNumberMappingKeys    []int // 资产数量键
+	batchNumberMappingValues  []int // 对应的批次值
+	batchNumberMappingOpTypes []int // 对应的操作类型
 }
 
-// NewWitness 创建新的见证数据生成器
-func NewWitness(accountTree bsmt.SparseMerkleTree, totalOpsNumber uint32,
-	ops map[int][]utils.AccountInfo, cexAssets []utils.CexAssetInfo,
-	config *config.Config) *Witness {
+// OpenWitnessDatabase 打开witness使用的数据库, 不输出SQL日志
+func OpenWitnessDatabase(dataSource string) (*gorm.DB, error) {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		logger.Config{
@@ -54,22 +53,34 @@ func NewWitness(accountTree bsmt.SparseMerkleTree, totalOpsNumber uint32,
 			Colorful:                  false,            // Disable color
 		},
 	)
-	db, err := gorm.Open(mysql.Open(config.MysqlDataSource), &gorm.Config{
+	return utils.OpenDatabase(dataSource, &gorm.Config{
 		Logger: newLogger,
 	})
+}
+
+// NewWitness 创建新的见证数据生成器
+// 参数:
+//   - round: 本轮审计的账户操作, 由utils.PrepareRoundAccounts生成, 需要创建的账户已经填充并分配了账户索引
+func NewWitness(accountTree bsmt.SparseMerkleTree, round *utils.RoundAccounts,
+	cexAssets []utils.CexAssetInfo, config *config.Config) *Witness {
+	db, err := OpenWitnessDatabase(config.MysqlDataSource)
 	if err != nil {
 		panic(err.Error())
 	}
 
 	return &Witness{
 		accountTree:        accountTree,
-		totalOpsNumber:     totalOpsNumber,
 		witnessModel:       NewWitnessModel(db, config.DbSuffix),
-		ops:                ops,
+		ops:                round.Creates,
+		updateOps:          round.Updates,
+		deleteOps:          round.Deletes,
 		cexAssets:          cexAssets,
 		ch:                 make(chan BatchWitness, 100),
 		quit:               make(chan int, 1),
 		currentBatchNumber: 0,
+		baseTreeVersion:    config.BaseTreeVersion,
+		roundId:            config.RoundId,
+		timestamp:          config.Timestamp,
 		accountHashChan:    make(map[int][]chan []byte),
 	}
 }
@@ -105,9 +116,9 @@ func (w *Witness) Run() {
 	fmt.Println("latest height is ", height)
 
 	// 2. 验证和回滚树状态
-	if w.accountTree.LatestVersion() > bsmt.Version(height+1) {
+	if w.accountTree.LatestVersion() > bsmt.Version(w.baseTreeVersion+height+1) {
 		// 如果树的版本号过高，需要回滚
-		rollbackVersion := bsmt.Version(height + 1)
+		rollbackVersion := bsmt.Version(w.baseTreeVersion + height + 1)
 		err = w.accountTree.Rollback(rollbackVersion)
 		if err != nil {
 			fmt.Println("rollback failed ", rollbackVersion, err.Error())
@@ -115,7 +126,7 @@ func (w *Witness) Run() {
 		} else {
 			fmt.Printf("rollback to %x\n", w.accountTree.Root())
 		}
-	} else if w.accountTree.LatestVersion() < bsmt.Version(height+1) {
+	} else if w.accountTree.LatestVersion() < bsmt.Version(w.baseTreeVersion+height+1) {
 		panic("account tree version is less than current height")
 	}
 
@@ -150,8 +161,18 @@ func (w *Witness) Run() {
 
 	// 遍历每个资产组
 	for p, k := range w.batchNumberMappingKeys {
-		var wg sync.WaitGroup
 		endBatchNum := w.batchNumberMappingValues[p]
+		if w.batchNumberMappingOpTypes[p] == utils.OpTypeUpdateUser {
+			w.RunBatchUpdateUser(k, startBatchNum, endBatchNum, recoveredBatchNum)
+			startBatchNum = endBatchNum
+			continue
+		}
+		if w.batchNumberMappingOpTypes[p] == utils.OpTypeDeleteUser {
+			w.RunBatchDeleteUser(k, startBatchNum, endBatchNum, recoveredBatchNum)
+			startBatchNum = endBatchNum
+			continue
+		}
+		var wg sync.WaitGroup
 		userOpsPerBatch = utils.BatchCreateUserOpsCountsTiers[k]
 		averageCount := userOpsPerBatch/workersNum + 1
 
@@ -195,62 +216,26 @@ func (w *Witness) Run() {
 
 			// 计算CEX资产承诺
 			copy(batchCreateUserWit.BeforeCexAssets[:], w.cexAssets[:])
-			for j := 0; j < len(w.cexAssets); j++ {
-				commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
-				for p := 0; p < len(commitments); p++ {
-					poseidonHasher.Write(commitments[p])
-				}
-			}
-			batchCreateUserWit.BeforeCEXAssetsCommitment = poseidonHasher.Sum(nil)
-			poseidonHasher.Reset()
+			batchCreateUserWit.BeforeCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
 
 			// 执行用户创建操作
 			relativeBatchNum := i - startBatchNum
 			for j := relativeBatchNum * userOpsPerBatch; j < (relativeBatchNum+1)*userOpsPerBatch; j++ {
 				w.ExecuteBatchCreateUser(k, uint32(j), uint32(relativeBatchNum*userOpsPerBatch), batchCreateUserWit)
 			}
-			for j := 0; j < len(w.cexAssets); j++ {
-				commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
-				for p := 0; p < len(commitments); p++ {
-					poseidonHasher.Write(commitments[p])
-				}
-			}
-			batchCreateUserWit.AfterCEXAssetsCommitment = poseidonHasher.Sum(nil)
-			poseidonHasher.Reset()
+			batchCreateUserWit.AfterCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
 			batchCreateUserWit.AfterAccountTreeRoot = w.accountTree.Root()
 
 			// compute batch commitment
-			batchCreateUserWit.BatchCommitment = poseidon.PoseidonBytes(batchCreateUserWit.BeforeAccountTreeRoot,
+			batchCreateUserWit.RoundId = w.roundId
+			batchCreateUserWit.Timestamp = w.timestamp
+			batchCreateUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchCreateUserWit.BeforeAccountTreeRoot,
 				batchCreateUserWit.AfterAccountTreeRoot,
 				batchCreateUserWit.BeforeCEXAssetsCommitment,
-				batchCreateUserWit.AfterCEXAssetsCommitment)
-			// bz, err := json.Marshal(batchCreateUserWit)
-			var serializeBuf bytes.Buffer
-			enc := gob.NewEncoder(&serializeBuf)
-			err := enc.Encode(batchCreateUserWit)
-			if err != nil {
-				panic(err.Error())
-			}
-			// startTime := time.Now()
-			buf := serializeBuf.Bytes()
-			compressedBuf := s2.Encode(nil, buf)
-			// endTime := time.Now()
-			// fmt.Println("compress time is ", endTime.Sub(startTime), " len of compressed buf is ", len(buf), len(compressedBuf))
-			witness := BatchWitness{
-				Height:      int64(i),
-				WitnessData: base64.StdEncoding.EncodeToString(compressedBuf),
-				Status:      StatusPublished,
-			}
-
-			// 提交树状态
-			accPrunedVersion := bsmt.Version(atomic.LoadInt64(&w.currentBatchNumber) + 1)
-			ver, err := w.accountTree.Commit(&accPrunedVersion)
-			if err != nil {
-				fmt.Println("ver is ", ver)
-				panic(err.Error())
-			}
-			// fmt.Printf("ver is %d account tree root is %x\n", ver, w.accountTree.Root())
-			w.ch <- witness
+				batchCreateUserWit.AfterCEXAssetsCommitment,
+				batchCreateUserWit.RoundId,
+				batchCreateUserWit.Timestamp)
+			w.PublishBatchWitness(int64(i), utils.OpTypeCreateUser, k, batchCreateUserWit)
 		}
 		wg.Wait()
 		startBatchNum = endBatchNum
@@ -263,13 +248,55 @@ func (w *Witness) Run() {
 	fmt.Printf("witness run finished, the account tree root is %x\n", w.accountTree.Root())
 }
 
+// ComputeCexAssetsCommitment 计算当前CEX资产状态的承诺
+func (w *Witness) ComputeCexAssetsCommitment(hasher *hash.Hash) []byte {
+	for j := 0; j < len(w.cexAssets); j++ {
+		commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
+		for p := 0; p < len(commitments); p++ {
+			(*hasher).Write(commitments[p])
+		}
+	}
+	commitment := (*hasher).Sum(nil)
+	(*hasher).Reset()
+	return commitment
+}
+
+// PublishBatchWitness 序列化批次见证数据, 提交账户树状态并发送到数据库写入协程
+// 参数:
+//   - height: 批次高度
+//   - opType: 批次的操作类型
+//   - assetsCount: 批次的资产数量层级
+//   - batchWitness: 批次见证数据(BatchCreateUserWitness, BatchUpdateUserWitness或BatchDeleteUserWitness)
+func (w *Witness) PublishBatchWitness(height int64, opType int, assetsCount int, batchWitness interface{}) {
+	witnessData, err := utils.EncodeBatchWitness(batchWitness)
+	if err != nil {
+		panic(err.Error())
+	}
+	witness := BatchWitness{
+		Height:      height,
+		WitnessData: witnessData,
+		OpType:      int64(opType),
+		AssetsCount: int64(assetsCount),
+		Status:      StatusPublished,
+	}
+
+	// 提交树状态
+	accPrunedVersion := bsmt.Version(w.baseTreeVersion + atomic.LoadInt64(&w.currentBatchNumber) + 1)
+	ver, err := w.accountTree.Commit(&accPrunedVersion)
+	if err != nil {
+		fmt.Println("ver is ", ver)
+		panic(err.Error())
+	}
+	// fmt.Printf("ver is %d account tree root is %x\n", ver, w.accountTree.Root())
+	w.ch <- witness
+}
+
 // GetCexAssets 从见证数据中恢复CEX资产状态
 func (w *Witness) GetCexAssets(wit *BatchWitness) []utils.CexAssetInfo {
-	witness := utils.DecodeBatchWitness(wit.WitnessData)
-	if witness == nil {
-		panic("decode invalid witness data")
+	cexAssetsInfo, err := utils.RecoverAfterCexAssetsByOpType(wit.OpType, wit.WitnessData)
+	if err != nil {
+		panic("decode invalid witness data: " + err.Error())
 	}
-	cexAssetsInfo := utils.RecoverAfterCexAssets(witness)
 	fmt.Println("recover cex assets successfully")
 	return cexAssetsInfo
 }
@@ -308,7 +335,7 @@ func (w *Witness) ExecuteBatchCreateUser(assetKey int, accountIndex uint32, curr
 	if err != nil {
 		panic(err.Error())
 	}
-	copy(batchCreateUserWit.CreateUserOps[index].AccountProof[:], accountProof[:])
+	batchCreateUserWit.CreateUserOps[index].AccountProof = accountProof
 	for p := 0; p < len(account.Assets); p++ {
 		// update cexAssetInfo
 		w.cexAssets[account.Assets[p].Index].TotalEquity = utils.SafeAdd(w.cexAssets[account.Assets[p].Index].TotalEquity, account.Assets[p].Equity)
@@ -326,11 +353,12 @@ func (w *Witness) ExecuteBatchCreateUser(assetKey int, accountIndex uint32, curr
 	}
 	batchCreateUserWit.CreateUserOps[index].AfterAccountTreeRoot = w.accountTree.Root()
 	batchCreateUserWit.CreateUserOps[index].AccountIndex = account.AccountIndex
-	batchCreateUserWit.CreateUserOps[index].AccountIdHash = account.AccountId
+	batchCreateUserWit.CreateUserOps[index].AccountIdHash = utils.ComputeAccountIdHash(account.AccountId, account.Salt)
 	batchCreateUserWit.CreateUserOps[index].Assets = account.Assets
 }
 
 // GetBatchNumber 获取总批次数
+// 先按资产数量从小到大处理所有创建用户的批次, 再处理所有更新用户的批次, 最后处理所有删除用户的批次
 func (w *Witness) GetBatchNumber() int {
 	b := 0
 	keys := make([]int, 0)
@@ -338,25 +366,207 @@ func (w *Witness) GetBatchNumber() int {
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
-	w.batchNumberMappingKeys = keys
-	w.batchNumberMappingValues = make([]int, len(keys))
+	updateKeys := make([]int, 0)
+	for k := range w.updateOps {
+		if len(w.updateOps[k]) > 0 {
+			updateKeys = append(updateKeys, k)
+		}
+	}
+	sort.Ints(updateKeys)
+	deleteKeys := make([]int, 0)
+	for k := range w.deleteOps {
+		if len(w.deleteOps[k]) > 0 {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	sort.Ints(deleteKeys)
+	w.batchNumberMappingKeys = append(append(keys, updateKeys...), deleteKeys...)
+	w.batchNumberMappingValues = make([]int, len(w.batchNumberMappingKeys))
+	w.batchNumberMappingOpTypes = make([]int, len(w.batchNumberMappingKeys))
 	for i, k := range keys {
 		opsPerBatch := utils.BatchCreateUserOpsCountsTiers[k]
 		b += (len(w.ops[k]) + opsPerBatch - 1) / opsPerBatch
 		w.batchNumberMappingValues[i] = b
+		w.batchNumberMappingOpTypes[i] = utils.OpTypeCreateUser
+	}
+	for i, k := range updateKeys {
+		opsPerBatch := utils.BatchUpdateUserOpsCountsTiers[k]
+		b += (len(w.updateOps[k]) + opsPerBatch - 1) / opsPerBatch
+		w.batchNumberMappingValues[len(keys)+i] = b
+		w.batchNumberMappingOpTypes[len(keys)+i] = utils.OpTypeUpdateUser
+	}
+	for i, k := range deleteKeys {
+		opsPerBatch := utils.BatchDeleteUserOpsCountsTiers[k]
+		b += (len(w.deleteOps[k]) + opsPerBatch - 1) / opsPerBatch
+		w.batchNumberMappingValues[len(keys)+len(updateKeys)+i] = b
+		w.batchNumberMappingOpTypes[len(keys)+len(updateKeys)+i] = utils.OpTypeDeleteUser
 	}
 	return b
 }
 
 // PaddingAccounts 填充账户数据
+// 需要创建的账户已经由PrepareRoundAccounts填充并分配了账户索引, 这里只填充更新和删除操作
 func (w *Witness) PaddingAccounts() {
-	keys := make([]int, 0)
-	for k := range w.ops {
-		keys = append(keys, k)
+	for k := range w.updateOps {
+		w.updateOps[k] = utils.PaddingAccountUpdates(w.updateOps[k], k)
 	}
-	sort.Ints(keys)
-	paddingStartIndex := int(w.totalOpsNumber)
-	for _, k := range keys {
-		paddingStartIndex, w.ops[k] = utils.PaddingAccounts(w.ops[k], k, paddingStartIndex)
+	for k := range w.deleteOps {
+		w.deleteOps[k] = utils.PaddingAccountDeletes(w.deleteOps[k], k)
+	}
+}
+
+// RunBatchUpdateUser 生成资产数量分组中所有批量更新用户的见证数据
+// 参数:
+//   - assetKey: 资产数量分组
+//   - startBatchNum: 该分组的起始批次号
+//   - endBatchNum: 该分组的结束批次号(不包含)
+//   - recoveredBatchNum: 已经生成的最新批次号
+func (w *Witness) RunBatchUpdateUser(assetKey int, startBatchNum int, endBatchNum int, recoveredBatchNum int) {
+	poseidonHasher := poseidon.NewPoseidon()
+	userOpsPerBatch := utils.BatchUpdateUserOpsCountsTiers[assetKey]
+	for i := startBatchNum; i < endBatchNum; i++ {
+		if i <= recoveredBatchNum {
+			continue // 跳过已处理的批次
+		}
+		batchUpdateUserWit := &utils.BatchUpdateUserWitness{
+			BeforeAccountTreeRoot: w.accountTree.Root(),
+			BeforeCexAssets:       make([]utils.CexAssetInfo, utils.AssetCounts),
+			UpdateUserOps:         make([]utils.UpdateUserOperation, userOpsPerBatch),
+		}
+		copy(batchUpdateUserWit.BeforeCexAssets[:], w.cexAssets[:])
+		batchUpdateUserWit.BeforeCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
+
+		relativeBatchNum := i - startBatchNum
+		for j := relativeBatchNum * userOpsPerBatch; j < (relativeBatchNum+1)*userOpsPerBatch; j++ {
+			w.ExecuteBatchUpdateUser(assetKey, uint32(j), uint32(relativeBatchNum*userOpsPerBatch), batchUpdateUserWit, &poseidonHasher)
+		}
+		batchUpdateUserWit.AfterCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
+		batchUpdateUserWit.AfterAccountTreeRoot = w.accountTree.Root()
+
+		// compute batch commitment
+		batchUpdateUserWit.RoundId = w.roundId
+		batchUpdateUserWit.Timestamp = w.timestamp
+		batchUpdateUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchUpdateUserWit.BeforeAccountTreeRoot,
+			batchUpdateUserWit.AfterAccountTreeRoot,
+			batchUpdateUserWit.BeforeCEXAssetsCommitment,
+			batchUpdateUserWit.AfterCEXAssetsCommitment,
+			batchUpdateUserWit.RoundId,
+			batchUpdateUserWit.Timestamp)
+		w.PublishBatchWitness(int64(i), utils.OpTypeUpdateUser, assetKey, batchUpdateUserWit)
+	}
+}
+
+// ExecuteBatchUpdateUser 执行批量更新用户操作
+// 从CEX资产中扣除旧账户的资产, 加上新账户的资产, 并将账户叶子节点更新为新账户的哈希
+func (w *Witness) ExecuteBatchUpdateUser(assetKey int, updateIndex uint32, currentUpdateIndex uint32, batchUpdateUserWit *utils.BatchUpdateUserWitness, hasher *hash.Hash) {
+	index := updateIndex - currentUpdateIndex
+	update := w.updateOps[assetKey][updateIndex]
+	op := &batchUpdateUserWit.UpdateUserOps[index]
+	op.BeforeAccountTreeRoot = w.accountTree.Root()
+	accountProof, err := w.accountTree.GetProof(uint64(update.New.AccountIndex))
+	if err != nil {
+		panic(err.Error())
+	}
+	op.AccountProof = accountProof
+	w.subtractAccountAssets(update.Old.Assets)
+	for _, asset := range update.New.Assets {
+		w.cexAssets[asset.Index].TotalEquity = utils.SafeAdd(w.cexAssets[asset.Index].TotalEquity, asset.Equity)
+		w.cexAssets[asset.Index].TotalDebt = utils.SafeAdd(w.cexAssets[asset.Index].TotalDebt, asset.Debt)
+		w.cexAssets[asset.Index].LoanCollateral = utils.SafeAdd(w.cexAssets[asset.Index].LoanCollateral, asset.Loan)
+		w.cexAssets[asset.Index].MarginCollateral = utils.SafeAdd(w.cexAssets[asset.Index].MarginCollateral, asset.Margin)
+		w.cexAssets[asset.Index].PortfolioMarginCollateral = utils.SafeAdd(w.cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+	}
+	// update account tree
+	accountHash := utils.AccountInfoToHash(&update.New, hasher)
+	err = w.accountTree.Set(uint64(update.New.AccountIndex), accountHash)
+	if err != nil {
+		panic(err.Error())
+	}
+	op.AfterAccountTreeRoot = w.accountTree.Root()
+	op.OldTotalEquity = update.Old.TotalEquity
+	op.OldTotalDebt = update.Old.TotalDebt
+	op.OldTotalCollateral = update.Old.TotalCollateral
+	op.OldAssets = update.Old.Assets
+	op.Assets = update.New.Assets
+	op.AccountIndex = update.New.AccountIndex
+	op.AccountIdHash = utils.ComputeAccountIdHash(update.New.AccountId, update.New.Salt)
+}
+
+// subtractAccountAssets 从CEX资产中扣除账户的资产
+func (w *Witness) subtractAccountAssets(assets []utils.AccountAsset) {
+	for _, asset := range assets {
+		w.cexAssets[asset.Index].TotalEquity = utils.SafeSub(w.cexAssets[asset.Index].TotalEquity, asset.Equity)
+		w.cexAssets[asset.Index].TotalDebt = utils.SafeSub(w.cexAssets[asset.Index].TotalDebt, asset.Debt)
+		w.cexAssets[asset.Index].LoanCollateral = utils.SafeSub(w.cexAssets[asset.Index].LoanCollateral, asset.Loan)
+		w.cexAssets[asset.Index].MarginCollateral = utils.SafeSub(w.cexAssets[asset.Index].MarginCollateral, asset.Margin)
+		w.cexAssets[asset.Index].PortfolioMarginCollateral = utils.SafeSub(w.cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+	}
+}
+
+// RunBatchDeleteUser 生成资产数量分组中所有批量删除用户的见证数据
+// 参数:
+//   - assetKey: 资产数量分组
+//   - startBatchNum: 该分组的起始批次号
+//   - endBatchNum: 该分组的结束批次号(不包含)
+//   - recoveredBatchNum: 已经生成的最新批次号
+func (w *Witness) RunBatchDeleteUser(assetKey int, startBatchNum int, endBatchNum int, recoveredBatchNum int) {
+	poseidonHasher := poseidon.NewPoseidon()
+	userOpsPerBatch := utils.BatchDeleteUserOpsCountsTiers[assetKey]
+	for i := startBatchNum; i < endBatchNum; i++ {
+		if i <= recoveredBatchNum {
+			continue // 跳过已处理的批次
+		}
+		batchDeleteUserWit := &utils.BatchDeleteUserWitness{
+			BeforeAccountTreeRoot: w.accountTree.Root(),
+			BeforeCexAssets:       make([]utils.CexAssetInfo, utils.AssetCounts),
+			DeleteUserOps:         make([]utils.DeleteUserOperation, userOpsPerBatch),
+		}
+		copy(batchDeleteUserWit.BeforeCexAssets[:], w.cexAssets[:])
+		batchDeleteUserWit.BeforeCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
+
+		relativeBatchNum := i - startBatchNum
+		for j := relativeBatchNum * userOpsPerBatch; j < (relativeBatchNum+1)*userOpsPerBatch; j++ {
+			w.ExecuteBatchDeleteUser(assetKey, uint32(j), uint32(relativeBatchNum*userOpsPerBatch), batchDeleteUserWit)
+		}
+		batchDeleteUserWit.AfterCEXAssetsCommitment = w.ComputeCexAssetsCommitment(&poseidonHasher)
+		batchDeleteUserWit.AfterAccountTreeRoot = w.accountTree.Root()
+
+		// compute batch commitment
+		batchDeleteUserWit.RoundId = w.roundId
+		batchDeleteUserWit.Timestamp = w.timestamp
+		batchDeleteUserWit.BatchCommitment = utils.ComputeBatchCommitment(batchDeleteUserWit.BeforeAccountTreeRoot,
+			batchDeleteUserWit.AfterAccountTreeRoot,
+			batchDeleteUserWit.BeforeCEXAssetsCommitment,
+			batchDeleteUserWit.AfterCEXAssetsCommitment,
+			batchDeleteUserWit.RoundId,
+			batchDeleteUserWit.Timestamp)
+		w.PublishBatchWitness(int64(i), utils.OpTypeDeleteUser, assetKey, batchDeleteUserWit)
+	}
+}
+
+// ExecuteBatchDeleteUser 执行批量删除用户操作
+// 从CEX资产中扣除账户的资产, 并将账户叶子节点重置为空叶子节点
+func (w *Witness) ExecuteBatchDeleteUser(assetKey int, deleteIndex uint32, currentDeleteIndex uint32, batchDeleteUserWit *utils.BatchDeleteUserWitness) {
+	index := deleteIndex - currentDeleteIndex
+	account := w.deleteOps[assetKey][deleteIndex]
+	op := &batchDeleteUserWit.DeleteUserOps[index]
+	op.BeforeAccountTreeRoot = w.accountTree.Root()
+	accountProof, err := w.accountTree.GetProof(uint64(account.AccountIndex))
+	if err != nil {
+		panic(err.Error())
+	}
+	op.AccountProof = accountProof
+	w.subtractAccountAssets(account.Assets)
+	// update account tree
+	err = w.accountTree.Set(uint64(account.AccountIndex), utils.NilAccountHash)
+	if err != nil {
+		panic(err.Error())
 	}
+	op.AfterAccountTreeRoot = w.accountTree.Root()
+	op.TotalEquity = account.TotalEquity
+	op.TotalDebt = account.TotalDebt
+	op.TotalCollateral = account.TotalCollateral
+	op.Assets = account.Assets
+	op.AccountIndex = account.AccountIndex
+	op.AccountIdHash = utils.ComputeAccountIdHash(account.AccountId, account.Salt)
 }
@@ -1,12 +1,13 @@
 package witness
 
 import (
+	"errors"
+	"slices"
 	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // 状态常量定义
@@ -14,8 +15,12 @@ const (
 	StatusPublished = iota // 已发布
 	StatusReceived         // 已接收
 	StatusFinished         // 已完成
+	StatusFailed           // 重试次数用完, 需要人工处理
 )
 
+// ErrLeaseLost 租约已过期并被其他prover回收, 或者批次已不是已接收状态
+var ErrLeaseLost = errors.New("witness lease lost")
+
 // 表名前缀
 const (
 	TableNamePrefix = `witness`
@@ -28,6 +33,7 @@ type WitnessModel interface {
 	GetLatestBatchWitnessHeight() (height int64, err error)                                                                 // 获取最新批次高度
 	GetBatchWitnessByHeight(height int64) (witness *BatchWitness, err error)                                                // 按高度获取批次见证数据
 	UpdateBatchWitnessStatus(witness *BatchWitness, status int64) error                                                     // 更新批次状态
+	UpdateBatchWitnessData(height int64, witnessData string) error                                                          // 更新批次见证数据
 	GetLatestBatchWitness() (witness *BatchWitness, err error)                                                              // 获取最新批次见证数据
 	GetLatestBatchWitnessByStatus(status int64) (witness *BatchWitness, err error)                                          // 按状态获取最新批次
 	GetAllBatchHeightsByStatus(status int64, limit int, offset int) (witnessHeights []int64, err error)                     // 按状态获取所有批次高度
@@ -35,6 +41,20 @@ type WitnessModel interface {
 	GetAndUpdateBatchesWitnessByHeight(height int, beforeStatus, afterStatus int64) (witness [](*BatchWitness), err error)  // 按高度获取并更新批次
 	CreateBatchWitness(witness []BatchWitness) error                                                                        // 创建批次见证数据
 	GetRowCounts() (count []int64, err error)                                                                               // 获取行数统计
+	AcquireBatchWitnessLease(height int64, owner string, expiredAt int64) (witness *BatchWitness, err error)                // 领取批次并获得租约
+	AcquireNextBatchWitnessLease(owner string, expiredAt int64, preferred []BatchTier) (witness *BatchWitness, err error)   // 优先领取preferred中的批次并获得租约
+	GetBatchTiersByHeights(heights []int64) (tiers map[int64]BatchTier, err error)                                          // 按高度获取批次的电路
+	RenewBatchWitnessLease(height int64, owner string, expiredAt int64) error                                               // 续约
+	FailBatchWitness(height int64, owner string, maxRetries int64) (status int64, err error)                                // 记录失败并释放租约
+	ReleaseBatchWitnessLease(height int64, owner string) error                                                              // 释放租约, 不计入重试次数
+	FinishBatchWitness(height int64, owner string) error                                                                    // 证明保存后标记为已完成
+	RequeueExpiredBatchWitness(now int64, maxRetries int64) (requeued []int64, failed []int64, err error)                   // 回收租约过期的批次
+}
+
+// BatchTier 批次使用的电路, 同一个BatchTier的批次使用相同的密钥
+type BatchTier struct {
+	OpType      int64
+	AssetsCount int64
 }
 
 // defaultWitnessModel 默认见证数据模型实现
@@ -48,7 +68,13 @@ type BatchWitness struct {
 	gorm.Model
 	Height      int64  `gorm:"index:idx_height,unique"` // 批次高度
 	WitnessData string // 见证数据
+	OpType      int64  // 操作类型(创建用户/更新用户/删除用户)
+	AssetsCount int64  // 批次的资产数量层级, 与OpType一起决定prover使用的密钥, 之前版本生成的批次为0
 	Status      int64  `gorm:"index"` // 状态
+	// 租约: 已接收的批次由LeaseOwner持有, 超过LeaseExpiredAt(unix秒)没有续约时会被重新发布
+	LeaseOwner     string
+	LeaseExpiredAt int64 `gorm:"index"`
+	RetryCount     int64 // 证明生成失败或租约过期的次数
 }
 
 // NewWitnessModel 创建新的见证数据模型
@@ -126,10 +152,9 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, a
 		// 查询指定状态的批次数据
 		// 使用 FOR UPDATE 锁定选中的行，防止并发更新
 		// 按高度升序排序，限制处理数量
-		dbTx := tx.Debug().Where("status = ?", beforeStatus).
+		dbTx := utils.LockForUpdate(tx.Debug().Where("status = ?", beforeStatus).
 			Order("height asc").
-			Limit(int(count)).
-			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Limit(int(count))).
 			Find(&witness)
 
 		// 检查查询错误
@@ -149,12 +174,16 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, a
 			// 设置新状态
 			updateObject["Status"] = afterStatus
 			// 更新数据库中的状态
-			dbTx := tx.Debug().Where("height = ?", w.Height).Updates(&updateObject)
+			// 更新条件中包含原状态, 不支持行锁的数据库也不会被重复领取
+			dbTx := tx.Debug().Where("height = ? and status = ?", w.Height, beforeStatus).Updates(&updateObject)
 
 			// 检查更新错误
 			if dbTx.Error != nil {
 				return dbTx.Error
 			}
+			if dbTx.RowsAffected == 0 {
+				return utils.DbErrNotFound
+			}
 		}
 		return nil
 	})
@@ -163,6 +192,7 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, a
 }
 
 // GetAndUpdateBatchesWitnessByHeight 按高度获取并更新批次
+// 只有状态为beforeStatus的批次会被更新, 多个prover并发领取同一批次时只有一个成功, 其余返回DbErrNotFound
 func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByHeight(height int, beforeStatus, afterStatus int64) (witness [](*BatchWitness), err error) {
 	err = m.DB.Table(m.table).Transaction(func(tx *gorm.DB) error {
 		// dbTx := tx.Where("status = ?", beforeStatus).Limit(int(count)).Clauses(clause.Locking{Strength: "UPDATE",  Options: "SKIP LOCKED"}).Find(&witness)
@@ -177,10 +207,12 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByHeight(height int, bef
 		updateObject := make(map[string]interface{})
 		for _, w := range witness {
 			updateObject["Status"] = afterStatus
-			dbTx := tx.Debug().Where("height = ?", w.Height).Updates(&updateObject)
+			dbTx := tx.Debug().Where("height = ? and status = ?", w.Height, beforeStatus).Updates(&updateObject)
 
 			if dbTx.Error != nil {
 				return dbTx.Error
+			} else if dbTx.RowsAffected == 0 {
+				return utils.DbErrNotFound
 			}
 		}
 		return nil
@@ -237,6 +269,22 @@ func (m *defaultWitnessModel) UpdateBatchWitnessStatus(witness *BatchWitness, st
 	return dbTx.Error
 }
 
+// UpdateBatchWitnessData 更新批次见证数据, 用于将之前版本的见证数据迁移为当前的格式
+func (m *defaultWitnessModel) UpdateBatchWitnessData(height int64, witnessData string) error {
+	dbTx := m.DB.Table(m.table).Where("height = ?", height).Updates(BatchWitness{
+		Model: gorm.Model{
+			UpdatedAt: time.Now(),
+		},
+		WitnessData: witnessData,
+	})
+	if dbTx.Error != nil {
+		return dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return utils.DbErrNotFound
+	}
+	return nil
+}
+
 // GetRowCounts 获取行数统计
 func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 	var count int64
@@ -265,5 +313,231 @@ func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 		return nil, dbTx.Error
 	}
 	counts = append(counts, finishedCount)
+
+	var failedCount int64
+	dbTx = m.DB.Table(m.table).Where("status = ?", StatusFailed).Count(&failedCount)
+	if dbTx.Error != nil {
+		return nil, dbTx.Error
+	}
+	counts = append(counts, failedCount)
 	return counts, nil
 }
+
+// AcquireBatchWitnessLease 领取已发布的批次, 状态更新为已接收并记录租约
+// 参数:
+//   - height: 批次高度
+//   - owner: prover标识
+//   - expiredAt: 租约过期时间(unix秒)
+//
+// 返回:
+//   - witness: 领取的批次见证数据
+//   - err: 错误信息, 批次不存在或已被领取时返回DbErrNotFound
+func (m *defaultWitnessModel) AcquireBatchWitnessLease(height int64, owner string, expiredAt int64) (witness *BatchWitness, err error) {
+	err = m.DB.Table(m.table).Transaction(func(tx *gorm.DB) error {
+		dbTx := tx.Where("height = ? and status = ?", height, StatusPublished).Limit(1).Find(&witness)
+		if dbTx.Error != nil {
+			return dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			return utils.DbErrNotFound
+		}
+		dbTx = tx.Where("height = ? and status = ?", height, StatusPublished).Updates(map[string]interface{}{
+			"status":           StatusReceived,
+			"lease_owner":      owner,
+			"lease_expired_at": expiredAt,
+		})
+		if dbTx.Error != nil {
+			return dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			return utils.DbErrNotFound
+		}
+		witness.Status = StatusReceived
+		witness.LeaseOwner = owner
+		witness.LeaseExpiredAt = expiredAt
+		return nil
+	})
+	return witness, err
+}
+
+// AcquireNextBatchWitnessLease 领取已发布的批次, 状态更新为已接收并记录租约
+// 按顺序优先领取preferred中高度最小的批次, 都没有时领取高度最小的批次, 使prover尽量少切换密钥
+// 查询和更新在同一个事务中, 使用SKIP LOCKED跳过其他prover正在领取的批次, 多个prover并发领取时不会互相等待
+// 返回:
+//   - witness: 领取的批次见证数据
+//   - err: 错误信息, 没有已发布的批次时返回DbErrNotFound
+func (m *defaultWitnessModel) AcquireNextBatchWitnessLease(owner string, expiredAt int64, preferred []BatchTier) (witness *BatchWitness, err error) {
+	err = m.DB.Table(m.table).Transaction(func(tx *gorm.DB) error {
+		var dbTx *gorm.DB
+		// 复制preferred, 避免append写入调用者切片的底层数组
+		tiers := append(slices.Clone(preferred), BatchTier{OpType: -1})
+		for _, tier := range tiers {
+			query := tx.Where("status = ?", StatusPublished)
+			if tier.OpType != -1 {
+				query = query.Where("op_type = ? and assets_count = ?", tier.OpType, tier.AssetsCount)
+			}
+			dbTx = utils.LockForUpdateSkipLocked(query.Order("height asc").Limit(1)).Find(&witness)
+			if dbTx.Error != nil {
+				return dbTx.Error
+			} else if dbTx.RowsAffected != 0 {
+				break
+			}
+		}
+		if dbTx.RowsAffected == 0 {
+			return utils.DbErrNotFound
+		}
+		dbTx = tx.Where("height = ? and status = ?", witness.Height, StatusPublished).Updates(map[string]interface{}{
+			"status":           StatusReceived,
+			"lease_owner":      owner,
+			"lease_expired_at": expiredAt,
+		})
+		if dbTx.Error != nil {
+			return dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			return utils.DbErrNotFound
+		}
+		witness.Status = StatusReceived
+		witness.LeaseOwner = owner
+		witness.LeaseExpiredAt = expiredAt
+		return nil
+	})
+	return witness, err
+}
+
+// GetBatchTiersByHeights 按高度获取批次的操作类型和资产数量层级, 不读取见证数据
+func (m *defaultWitnessModel) GetBatchTiersByHeights(heights []int64) (tiers map[int64]BatchTier, err error) {
+	var witnesses []BatchWitness
+	dbTx := m.DB.Table(m.table).Select("height", "op_type", "assets_count").Where("height in ?", heights).Find(&witnesses)
+	if dbTx.Error != nil {
+		return nil, dbTx.Error
+	}
+	tiers = make(map[int64]BatchTier, len(witnesses))
+	for _, w := range witnesses {
+		tiers[w.Height] = BatchTier{OpType: w.OpType, AssetsCount: w.AssetsCount}
+	}
+	return tiers, nil
+}
+
+// RenewBatchWitnessLease 延长租约, 租约已被回收时返回ErrLeaseLost
+func (m *defaultWitnessModel) RenewBatchWitnessLease(height int64, owner string, expiredAt int64) error {
+	dbTx := m.DB.Table(m.table).Where("height = ? and status = ? and lease_owner = ?", height, StatusReceived, owner).
+		Update("lease_expired_at", expiredAt)
+	if dbTx.Error != nil {
+		return dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// FailBatchWitness 记录一次证明生成失败并释放租约
+// 重试次数小于maxRetries时批次重新发布, 否则标记为失败
+// 返回:
+//   - status: 批次的新状态, StatusPublished或StatusFailed
+//   - err: 错误信息, 租约已被回收时返回ErrLeaseLost
+func (m *defaultWitnessModel) FailBatchWitness(height int64, owner string, maxRetries int64) (status int64, err error) {
+	err = m.DB.Table(m.table).Transaction(func(tx *gorm.DB) error {
+		var witness BatchWitness
+		dbTx := tx.Where("height = ? and status = ? and lease_owner = ?", height, StatusReceived, owner).Limit(1).Find(&witness)
+		if dbTx.Error != nil {
+			return dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+		status = nextStatusAfterRetry(witness.RetryCount, maxRetries)
+		dbTx = tx.Where("height = ? and status = ? and lease_owner = ? and retry_count = ?", height, StatusReceived, owner, witness.RetryCount).
+			Updates(releaseLeaseObject(status, witness.RetryCount))
+		if dbTx.Error != nil {
+			return dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+		return nil
+	})
+	return status, err
+}
+
+// ReleaseBatchWitnessLease 释放租约并重新发布批次, 不增加重试次数
+// 用于与批次无关的失败, 例如prover退出时正在处理的批次
+// 返回:
+//   - error: 错误信息, 租约已被回收时返回ErrLeaseLost
+func (m *defaultWitnessModel) ReleaseBatchWitnessLease(height int64, owner string) error {
+	dbTx := m.DB.Table(m.table).Where("height = ? and status = ? and lease_owner = ?", height, StatusReceived, owner).
+		Updates(map[string]interface{}{
+			"status":           StatusPublished,
+			"lease_owner":      "",
+			"lease_expired_at": 0,
+		})
+	if dbTx.Error != nil {
+		return dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// FinishBatchWitness 证明保存后将批次标记为已完成, 只有租约的持有者可以标记
+// 返回:
+//   - error: 错误信息, 租约已被回收时返回ErrLeaseLost
+func (m *defaultWitnessModel) FinishBatchWitness(height int64, owner string) error {
+	dbTx := m.DB.Table(m.table).Where("height = ? and status = ? and lease_owner = ?", height, StatusReceived, owner).
+		Updates(map[string]interface{}{
+			"status":           StatusFinished,
+			"lease_expired_at": 0,
+		})
+	if dbTx.Error != nil {
+		return dbTx.Error
+	} else if dbTx.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredBatchWitness 回收租约过期的已接收批次
+// 每次回收计为一次重试, 重试次数用完的批次标记为失败
+// 更新条件中包含读取到的租约过期时间, 多个prover同时回收时每个批次只会被一个prover回收
+// 返回:
+//   - requeued: 重新发布的批次高度, 需要由调用方重新加入任务队列
+//   - failed: 标记为失败的批次高度
+//   - err: 错误信息
+func (m *defaultWitnessModel) RequeueExpiredBatchWitness(now int64, maxRetries int64) (requeued []int64, failed []int64, err error) {
+	var witnesses []BatchWitness
+	dbTx := m.DB.Table(m.table).Select("height", "lease_expired_at", "retry_count").
+		Where("status = ? and lease_expired_at < ?", StatusReceived, now).Find(&witnesses)
+	if dbTx.Error != nil {
+		return nil, nil, dbTx.Error
+	}
+	for _, w := range witnesses {
+		status := nextStatusAfterRetry(w.RetryCount, maxRetries)
+		dbTx = m.DB.Table(m.table).Where("height = ? and status = ? and lease_expired_at = ? and retry_count = ?",
+			w.Height, StatusReceived, w.LeaseExpiredAt, w.RetryCount).Updates(releaseLeaseObject(status, w.RetryCount))
+		if dbTx.Error != nil {
+			return requeued, failed, dbTx.Error
+		} else if dbTx.RowsAffected == 0 {
+			// 已被其他prover回收或者已续约
+			continue
+		}
+		if status == StatusFailed {
+			failed = append(failed, w.Height)
+		} else {
+			requeued = append(requeued, w.Height)
+		}
+	}
+	return requeued, failed, nil
+}
+
+// nextStatusAfterRetry 增加一次重试后批次的状态
+func nextStatusAfterRetry(retryCount int64, maxRetries int64) int64 {
+	if retryCount+1 >= maxRetries {
+		return StatusFailed
+	}
+	return StatusPublished
+}
+
+// releaseLeaseObject 释放租约并增加重试次数的更新内容
+func releaseLeaseObject(status int64, retryCount int64) map[string]interface{} {
+	return map[string]interface{}{
+		"status":           status,
+		"lease_owner":      "",
+		"lease_expired_at": 0,
+		"retry_count":      retryCount + 1,
+	}
+}
@@ -0,0 +1,194 @@
+package witness
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestWitnessModelSqlite 测试sqlite后端下批次的领取语义: 同一批次只能被一个prover领取
+func TestWitnessModelSqlite(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	witnesses := make([]BatchWitness, 4)
+	for i := 0; i < len(witnesses); i++ {
+		witnesses[i] = BatchWitness{Height: int64(i), WitnessData: "data", Status: StatusPublished}
+	}
+	if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+		t.Fatal(err)
+	}
+
+	// 多个prover并发领取同一批次
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	received := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := witnessModel.GetAndUpdateBatchesWitnessByHeight(1, StatusPublished, StatusReceived)
+			if err == utils.DbErrNotFound {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			received += len(w)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	if received != 1 {
+		t.Fatalf("batch 1 is received %d times", received)
+	}
+
+	w, err := witnessModel.GetAndUpdateBatchesWitnessByStatus(StatusPublished, StatusReceived, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) != 2 || w[0].Height != 0 || w[1].Height != 2 {
+		t.Fatal("unexpected batches received by status")
+	}
+	counts, err := witnessModel.GetRowCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[0] != 4 || counts[1] != 1 || counts[2] != 3 {
+		t.Fatalf("unexpected row counts %v", counts)
+	}
+}
+
+// TestWitnessModelLease 测试租约的续约, 过期回收, 失败重试和终止状态
+func TestWitnessModelLease(t *testing.T) {
+	db, err := utils.OpenDatabase("sqlite://"+filepath.Join(t.TempDir(), "zkpos.db"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := NewWitnessModel(db, "0")
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.CreateBatchWitness([]BatchWitness{{Height: 0, Status: StatusPublished}, {Height: 1, Status: StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	const maxRetries = 2
+
+	w, err := witnessModel.AcquireBatchWitnessLease(0, "prover-a", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != StatusReceived || w.LeaseOwner != "prover-a" {
+		t.Fatalf("unexpected leased witness %+v", w)
+	}
+	if _, err = witnessModel.AcquireBatchWitnessLease(0, "prover-b", 100); err != utils.DbErrNotFound {
+		t.Fatalf("expect DbErrNotFound but got %v", err)
+	}
+	if err = witnessModel.RenewBatchWitnessLease(0, "prover-b", 200); err != ErrLeaseLost {
+		t.Fatalf("expect ErrLeaseLost but got %v", err)
+	}
+	if err = witnessModel.RenewBatchWitnessLease(0, "prover-a", 200); err != nil {
+		t.Fatal(err)
+	}
+
+	// 租约未过期时不会被回收
+	requeued, failed, err := witnessModel.RequeueExpiredBatchWitness(150, maxRetries)
+	if err != nil || len(requeued) != 0 || len(failed) != 0 {
+		t.Fatalf("unexpected requeue result %v %v %v", requeued, failed, err)
+	}
+	// 租约过期后重新发布, 原持有者不能再续约
+	requeued, failed, err = witnessModel.RequeueExpiredBatchWitness(250, maxRetries)
+	if err != nil || len(requeued) != 1 || requeued[0] != 0 || len(failed) != 0 {
+		t.Fatalf("unexpected requeue result %v %v %v", requeued, failed, err)
+	}
+	if err = witnessModel.RenewBatchWitnessLease(0, "prover-a", 300); err != ErrLeaseLost {
+		t.Fatalf("expect ErrLeaseLost but got %v", err)
+	}
+
+	// 第二次失败后重试次数用完, 标记为失败
+	if _, err = witnessModel.AcquireBatchWitnessLease(0, "prover-b", 400); err != nil {
+		t.Fatal(err)
+	}
+	status, err := witnessModel.FailBatchWitness(0, "prover-b", maxRetries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusFailed {
+		t.Fatalf("expect StatusFailed but got %d", status)
+	}
+	w, err = witnessModel.GetBatchWitnessByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != StatusFailed || w.RetryCount != maxRetries || w.LeaseOwner != "" {
+		t.Fatalf("unexpected failed witness %+v", w)
+	}
+
+	// 第一次失败后重新发布
+	if _, err = witnessModel.AcquireBatchWitnessLease(1, "prover-a", 400); err != nil {
+		t.Fatal(err)
+	}
+	status, err = witnessModel.FailBatchWitness(1, "prover-a", maxRetries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusPublished {
+		t.Fatalf("expect StatusPublished but got %d", status)
+	}
+
+	// 释放租约不计入重试次数
+	if _, err = witnessModel.AcquireBatchWitnessLease(1, "prover-a", 400); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.ReleaseBatchWitnessLease(1, "prover-b"); err != ErrLeaseLost {
+		t.Fatalf("expect ErrLeaseLost but got %v", err)
+	}
+	if err = witnessModel.ReleaseBatchWitnessLease(1, "prover-a"); err != nil {
+		t.Fatal(err)
+	}
+	w, err = witnessModel.GetBatchWitnessByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != StatusPublished || w.RetryCount != 1 || w.LeaseOwner != "" {
+		t.Fatalf("unexpected released witness %+v", w)
+	}
+	counts, err := witnessModel.GetRowCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[1] != 1 || counts[2] != 0 || counts[4] != 1 {
+		t.Fatalf("unexpected row counts %v", counts)
+	}
+
+	// 只有租约的持有者可以标记为已完成
+	if _, err = witnessModel.AcquireBatchWitnessLease(1, "prover-a", 400); err != nil {
+		t.Fatal(err)
+	}
+	if err = witnessModel.FinishBatchWitness(1, "prover-b"); err != ErrLeaseLost {
+		t.Fatalf("expect ErrLeaseLost but got %v", err)
+	}
+	if err = witnessModel.FinishBatchWitness(1, "prover-a"); err != nil {
+		t.Fatal(err)
+	}
+	w, err = witnessModel.GetBatchWitnessByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != StatusFinished {
+		t.Fatalf("unexpected finished witness %+v", w)
+	}
+}